@@ -0,0 +1,310 @@
+// Stops short URLs from redirecting when they are visited suspiciously often, or when
+// an admin disables them, so that a link being abused doesn't keep forwarding traffic.
+package main
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Who disabled a short URL
+const (
+	linkDisabledByAdmin    = "admin"
+	linkDisabledByDetector = "abuse_detector"
+)
+
+// The longest reason that an admin can give for disabling a short URL
+const maxDisabledReasonLength = 200
+
+var (
+	errDisabledReasonTooLong = newErrorMessage(http.StatusBadRequest, "reason can be at most " + strconv.Itoa(maxDisabledReasonLength) + " characters")
+	errInvalidVisitLimit     = newErrorMessage(http.StatusBadRequest, "visits_per_minute must be a positive integer")
+)
+
+var linksDisabledTotal = newCounterVec("links_disabled_total",
+	"Total number of short URLs disabled, by who disabled them.", "by")
+
+// Why a short URL stopped redirecting.
+type LinkDisabled struct {
+	Reason     string    `json:"reason" xml:"reason" bson:"reason"`
+	// Either "admin" or "abuse_detector"
+	By         string    `json:"by" xml:"by" bson:"by"`
+	DisabledAt time.Time `json:"disabled_at" xml:"disabled_at" bson:"disabled_at"`
+}
+
+// A disabled short URL, as sent to admins.
+type DisabledLink struct {
+	ShortURL string `json:"short_url"`
+	LinkDisabled
+}
+
+// The visit limit of a short URL, as sent to admins.
+type LinkVisitLimit struct {
+	ShortURL        string `json:"short_url"`
+	VisitsPerMinute int64  `json:"visits_per_minute"`
+}
+
+// Counts the visits to each short URL in the current minute, disabling those
+// that go over their limit. Each instance of the server counts only the visits it handles.
+type abuseDetector struct {
+	// The visits a minute allowed to short URLs without their own limit, or 0 for no limit
+	defaultLimit int64
+	// The limit of each short URL that has its own. Instances of the server only
+	// learn of each other's changes when they reload them, so the map is replaced whole.
+	limits atomic.Pointer[map[string]int64]
+
+	mu sync.Mutex
+	// The start of the minute being counted
+	window time.Time
+	visits map[string]int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// The detector, set by initAbuseDetection
+var abuse *abuseDetector
+
+
+// Starts counting visits to short URLs, disabling any that has more in a minute than
+// its own limit or, if it has none, ABUSE_VISIT_LIMIT (default 0, no limit).
+// The limits of short URLs are reloaded every ABUSE_REFRESH_INTERVAL (default 1m).
+func initAbuseDetection() {
+	detector := &abuseDetector{
+		defaultLimit: getEnvInt("ABUSE_VISIT_LIMIT", 0),
+		visits:       make(map[string]int64),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	if detector.defaultLimit < 0 {
+		fatal("ABUSE_VISIT_LIMIT must not be negative.", "value", detector.defaultLimit)
+	}
+	detector.limits.Store(&map[string]int64{})
+	if detector.defaultLimit > 0 {
+		slog.Info("Disabling short URLs with too many visits.", "visits_per_minute", detector.defaultLimit)
+	}
+	go detector.reloadPeriodically(getEnvDuration("ABUSE_REFRESH_INTERVAL", time.Minute))
+	abuse = detector
+}
+
+
+// Stops reloading the limits of short URLs.
+func closeAbuseDetection() {
+	if abuse == nil {
+		return
+	}
+	close(abuse.stop)
+	<-abuse.done
+}
+
+
+// Returns the error for a visit to a disabled short URL, which explains why it was disabled.
+func disabledLinkError(disabled *LinkDisabled) error {
+	return newStoreError(ErrDisabled, "short url has been disabled: " + disabled.Reason)
+}
+
+
+// Counts a visit to the short URL, and fails with ErrDisabled if the visit
+// takes it over its limit, disabling it for every visit after this one.
+func checkVisitRate(ctx context.Context, shortURL string) error {
+	if abuse == nil {
+		return nil
+	}
+	limit, ok := (*abuse.limits.Load())[shortURL]
+	if !ok {
+		limit = abuse.defaultLimit
+	}
+	if limit <= 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	abuse.mu.Lock()
+	if window := now.Truncate(time.Minute); !window.Equal(abuse.window) {
+		abuse.window = window
+		clear(abuse.visits)
+	}
+	abuse.visits[shortURL]++
+	visits := abuse.visits[shortURL]
+	abuse.mu.Unlock()
+	if visits <= limit {
+		return nil
+	}
+
+	disabled := &LinkDisabled{
+		Reason:     "more than " + strconv.FormatInt(limit, 10) + " visits in a minute",
+		By:         linkDisabledByDetector,
+		DisabledAt: now,
+	}
+	// Only the visit that goes over the limit disables the short URL, and the rest
+	// of the minute's visits are refused even if that failed
+	if visits == limit+1 {
+		logger := loggerFrom(ctx)
+		if err := urlStore.SetURLDisabled(ctx, shortURL, disabled); err != nil {
+			logger.Error("Unable to disable short URL with too many visits.", "short_url", shortURL, "err", err)
+		} else {
+			linksDisabledTotal.inc(linkDisabledByDetector)
+			logger.Warn("Disabled short URL with too many visits.", "short_url", shortURL, "visits_per_minute", limit)
+		}
+	}
+	return disabledLinkError(disabled)
+}
+
+
+// Reloads the limits of short URLs every interval, so that changes made through
+// other instances of the server are picked up. Until they are first loaded,
+// they are tried again every second.
+func (d *abuseDetector) reloadPeriodically(interval time.Duration) {
+	defer close(d.done)
+	loaded := false
+	for {
+		if storageAvailable() {
+			ctx, cancel := context.WithTimeout(context.Background(), dbPingTimeout)
+			limits, err := urlStore.GetURLVisitLimits(ctx)
+			cancel()
+			if err == nil {
+				d.limits.Store(&limits)
+				loaded = true
+			}
+		}
+		wait := unhealthyCheckInterval
+		if loaded {
+			if interval <= 0 {
+				return
+			}
+			wait = interval
+		}
+		select {
+		case <-time.After(wait):
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+
+// Records a change to the limit of a short URL straight away, rather than when the limits
+// are next reloaded, and forgets its visits so far. A limit of 0 removes it.
+func (d *abuseDetector) setLimit(shortURL string, limit int64) {
+	limits := maps.Clone(*d.limits.Load())
+	if limit <= 0 {
+		delete(limits, shortURL)
+	} else {
+		limits[shortURL] = limit
+	}
+	d.limits.Store(&limits)
+	d.forgetVisits(shortURL)
+}
+
+
+// Forgets the visits to a short URL in the current minute, so that
+// one that was enabled again isn't disabled by them straight away.
+func (d *abuseDetector) forgetVisits(shortURL string) {
+	d.mu.Lock()
+	delete(d.visits, shortURL)
+	d.mu.Unlock()
+}
+
+
+// Forgets the limit of a short URL that was deleted, so that
+// a new short URL with the same code doesn't have it.
+func forgetLinkVisitLimit(shortURL string) {
+	if abuse != nil {
+		abuse.setLimit(shortURL, 0)
+	}
+}
+
+
+// Stops the short URL in the path from redirecting, for the "reason" in the form data.
+// Visits to it get a 410 explaining why until it is enabled again.
+func putAdminURLDisabled(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFrom(r.Context())
+	funcName := "putAdminURLDisabled"
+
+	if err := r.ParseForm(); err != nil {
+		logger.Error("Request.ParseForm failed", "func", funcName, "err", err)
+		writeError(w, r, formError(err))
+		return
+	}
+	reason := strings.TrimSpace(r.Form.Get("reason"))
+	if len(reason) == 0 {
+		reason = "disabled by an admin"
+	} else if len(reason) > maxDisabledReasonLength {
+		writeError(w, r, errDisabledReasonTooLong)
+		return
+	}
+
+	shortURL := r.PathValue("code")
+	disabled := LinkDisabled{Reason: reason, By: linkDisabledByAdmin, DisabledAt: time.Now().UTC().Truncate(time.Millisecond)}
+	if err := urlStore.SetURLDisabled(r.Context(), shortURL, &disabled); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	linksDisabledTotal.inc(linkDisabledByAdmin)
+	logger.Info("Short URL disabled.", "short_url", shortURL, "reason", reason)
+	writeJSON(w, http.StatusOK, DisabledLink{ShortURL: shortURL, LinkDisabled: disabled})
+}
+
+
+// Lets the short URL in the path redirect again, whoever disabled it.
+func deleteAdminURLDisabled(w http.ResponseWriter, r *http.Request) {
+	shortURL := r.PathValue("code")
+	if err := urlStore.SetURLDisabled(r.Context(), shortURL, nil); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if abuse != nil {
+		abuse.forgetVisits(shortURL)
+	}
+	loggerFrom(r.Context()).Info("Short URL enabled.", "short_url", shortURL)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+
+// Sets how many visits a minute the short URL in the path may have before it is disabled
+// to "visits_per_minute" in the form data, in place of ABUSE_VISIT_LIMIT.
+func putAdminURLVisitLimit(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFrom(r.Context())
+	funcName := "putAdminURLVisitLimit"
+
+	if err := r.ParseForm(); err != nil {
+		logger.Error("Request.ParseForm failed", "func", funcName, "err", err)
+		writeError(w, r, formError(err))
+		return
+	}
+	limit, err := strconv.ParseInt(r.Form.Get("visits_per_minute"), 10, 64)
+	if err != nil || limit < 1 {
+		writeError(w, r, errInvalidVisitLimit)
+		return
+	}
+
+	shortURL := r.PathValue("code")
+	if err := urlStore.SetURLVisitLimit(r.Context(), shortURL, limit); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if abuse != nil {
+		abuse.setLimit(shortURL, limit)
+	}
+	logger.Info("Visit limit set.", "short_url", shortURL, "visits_per_minute", limit)
+	writeJSON(w, http.StatusOK, LinkVisitLimit{ShortURL: shortURL, VisitsPerMinute: limit})
+}
+
+
+// Gives the short URL in the path the default visit limit again.
+func deleteAdminURLVisitLimit(w http.ResponseWriter, r *http.Request) {
+	shortURL := r.PathValue("code")
+	if err := urlStore.SetURLVisitLimit(r.Context(), shortURL, 0); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	forgetLinkVisitLimit(shortURL)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,142 @@
+// Writes an access log entry for every request the app handles.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A single entry in the access log when using the JSON format.
+type accessLogEntry struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remote_addr"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Proto      string    `json:"proto"`
+	Status     int       `json:"status"`
+	Size       int       `json:"size"`
+	LatencyMS  float64   `json:"latency_ms"`
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+}
+
+type accessLogger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	asJSON bool
+}
+
+
+// Returns middleware that writes an access log to the file named in ACCESS_LOG_FILE
+// ("-" for standard output) in either Apache Combined Log Format or JSON,
+// depending on ACCESS_LOG_FORMAT. Returns nil if no file was configured.
+func newAccessLogMiddleware() Middleware {
+	path := os.Getenv("ACCESS_LOG_FILE")
+	if len(path) == 0 {
+		return nil
+	}
+
+	var out io.Writer = os.Stdout
+	if path != "-" {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			fatal("Error when opening access log.", "path", path, "err", err)
+		}
+		out = file
+	}
+
+	logger := &accessLogger{
+		out:    out,
+		asJSON: strings.EqualFold(getEnv("ACCESS_LOG_FORMAT", "combined"), "json"),
+	}
+	slog.Info("Writing access log.", "path", path, "json", logger.asJSON)
+	return logger.middleware
+}
+
+
+func (logger *accessLogger) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		entry := accessLogEntry{
+			Time:       start,
+			RemoteAddr: clientIP(r),
+			Method:     r.Method,
+			Path:       r.URL.RequestURI(),
+			Proto:      r.Proto,
+			Status:     rec.status,
+			Size:       rec.size,
+			LatencyMS:  float64(time.Since(start).Microseconds()) / 1000,
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+			RequestID:  requestIDFrom(r.Context()),
+		}
+		logger.write(entry)
+	})
+}
+
+
+// Writes one entry to the log in the configured format.
+func (logger *accessLogger) write(entry accessLogEntry) {
+	var line []byte
+	if logger.asJSON {
+		var err error
+		line, err = json.Marshal(entry)
+		if err != nil {
+			slog.Error("json.Marshal failed", "func", "accessLogger.write", "err", err)
+			return
+		}
+		line = append(line, '\n')
+	} else {
+		line = []byte(formatCombined(entry))
+	}
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if _, err := logger.out.Write(line); err != nil {
+		slog.Error("Error when writing access log.", "err", err)
+	}
+}
+
+
+// Formats an entry in Apache Combined Log Format,
+// with the latency in microseconds appended to the end, e.g.:
+// 127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /date/ HTTP/1.1" 200 52 "-" "curl/8.0" 1234
+func formatCombined(entry accessLogEntry) string {
+	size := "-"
+	if entry.Size > 0 {
+		size = fmt.Sprint(entry.Size)
+	}
+	return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s %q %q %d\n",
+		entry.RemoteAddr,
+		entry.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		entry.Method, entry.Path, entry.Proto,
+		entry.Status,
+		size,
+		orDash(entry.Referer),
+		orDash(entry.UserAgent),
+		int64(entry.LatencyMS*1000),
+	)
+}
+
+
+// Returns "-" in place of an empty string, as the log format requires.
+func orDash(s string) string {
+	if len(s) == 0 {
+		return "-"
+	}
+	return s
+}
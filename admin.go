@@ -0,0 +1,157 @@
+// An authenticated JSON API for operating the app, under /admin/api/.
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Totals of the records in the database.
+type AdminStats struct {
+	URLs          int64 `json:"urls"`
+	ExerciseUsers int64 `json:"exercise_users"`
+}
+
+// The number of requests handled for a route.
+type RouteCount struct {
+	Route    string `json:"route"`
+	Requests int64  `json:"requests"`
+}
+
+
+// The credentials that admin requests must carry, read once at startup.
+// Both are empty when the admin API is disabled.
+var adminCredentials struct {
+	token    string
+	// Only set if both the username and password are
+	username string
+	password string
+}
+
+
+// Returns a middleware that only lets through requests that carry the shared secret
+// in ADMIN_TOKEN as a bearer token, or the username and password in
+// ADMIN_USERNAME and ADMIN_PASSWORD via basic auth.
+// Returns nil if neither was configured, in which case the admin API is disabled.
+func newAdminAuthMiddleware() Middleware {
+	adminCredentials.token = os.Getenv("ADMIN_TOKEN")
+	username := os.Getenv("ADMIN_USERNAME")
+	password := os.Getenv("ADMIN_PASSWORD")
+	useBasic := len(username) > 0 && len(password) > 0
+	if useBasic {
+		adminCredentials.username, adminCredentials.password = username, password
+	}
+	if len(adminCredentials.token) == 0 && !useBasic {
+		return nil
+	}
+	slog.Info("Enabling admin API.", "token", len(adminCredentials.token) > 0, "basic_auth", useBasic)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isAdminRequest(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if useBasic {
+				w.Header().Set("WWW-Authenticate", `Basic realm="admin", charset="UTF-8"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			}
+			loggerFrom(r.Context()).Warn("Rejected admin request.", "path", r.URL.Path, "client_ip", clientIP(r))
+			writeJSON(w, http.StatusUnauthorized, newErrorMessage(http.StatusUnauthorized, "unauthorized"))
+		})
+	}
+}
+
+
+// Reports whether the request carries the admin credentials,
+// which lets it act on any short URL, whoever owns it.
+func isAdminRequest(r *http.Request) bool {
+	if len(adminCredentials.token) > 0 {
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && secretsEqual(bearer, adminCredentials.token) {
+			return true
+		}
+	}
+	if len(adminCredentials.username) > 0 {
+		if u, p, ok := r.BasicAuth(); ok && secretsEqual(u, adminCredentials.username) && secretsEqual(p, adminCredentials.password) {
+			return true
+		}
+	}
+	return false
+}
+
+
+// Compares two secrets in constant time.
+// Hashing them first means that not even their lengths are revealed.
+func secretsEqual(given string, expected string) bool {
+	a := sha256.Sum256([]byte(given))
+	b := sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+
+// Returns the number of short URLs and exercise users.
+func getAdminStats(w http.ResponseWriter, r *http.Request) {
+	var stats AdminStats
+	var err error
+	if stats.URLs, err = urlStore.CountURLs(r.Context()); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if stats.ExerciseUsers, err = exerciseStore.CountUsers(r.Context()); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+
+// Returns the most recently logged errors, newest first.
+func getAdminErrors(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, getRecentErrors())
+}
+
+
+// Returns the number of requests handled for each route since the app started,
+// busiest first.
+func getAdminRoutes(w http.ResponseWriter, r *http.Request) {
+	counts := []RouteCount{}
+	for route, total := range httpRequestsTotal.sumBy("route") {
+		counts = append(counts, RouteCount{Route: route, Requests: int64(total)})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Requests != counts[j].Requests {
+			return counts[i].Requests > counts[j].Requests
+		}
+		return counts[i].Route < counts[j].Route
+	})
+	writeJSON(w, http.StatusOK, counts)
+}
+
+
+// Deletes the short URL whose code is in the path.
+func deleteAdminURL(w http.ResponseWriter, r *http.Request) {
+	deleted, err := urlStore.DeleteURL(r.Context(), r.PathValue("code"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	forgetLinkWebhook(deleted.ShortURL)
+	forgetLinkVisitLimit(deleted.ShortURL)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+
+// Deletes the exercise user whose ID is in the path.
+func deleteAdminUser(w http.ResponseWriter, r *http.Request) {
+	if _, err := exerciseStore.DeleteUser(r.Context(), r.PathValue("id")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
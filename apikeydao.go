@@ -0,0 +1,230 @@
+// Handles the MongoDB operations for API keys.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	"time"
+)
+
+// Every key starts with this, so that leaked keys are easy to recognise.
+const apiKeyPrefix = "fcc_"
+
+// An API key as stored in the database.
+// Only a hash of the key itself is kept, so the key can't be recovered from a database dump.
+type APIKey struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name       string             `json:"name" bson:"name"`
+	KeyHash    string             `json:"-" bson:"key_hash"`
+	Hint       string             `json:"hint" bson:"hint"`
+	Scopes     []string           `json:"scopes" bson:"scopes"`
+	DailyQuota int                `json:"daily_quota" bson:"daily_quota"`
+	UsageDay   string             `json:"usage_day,omitempty" bson:"usage_day"`
+	UsageCount int                `json:"usage_count" bson:"usage_count"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	RevokedAt  *time.Time         `json:"revoked_at,omitempty" bson:"revoked_at"`
+}
+
+// The response to creating a key, which is the only time the key itself is shown.
+type NewAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+
+// Keeps API keys in a MongoDB collection.
+type mongoAPIKeyStore struct {
+	collection *mongo.Collection
+}
+
+
+// Get a pointer to the API key collection, which is named by COLLECTION_K
+// (default "api_keys"), and make sure keys can be looked up by their hash.
+func newMongoAPIKeyStore(db *mongo.Database) *mongoAPIKeyStore {
+	slog.Info("Getting reference to API key collection.")
+	collection := db.Collection(getEnv("COLLECTION_K", "api_keys"))
+	if err := ensureUniqueIndexes(collection, "key_hash"); err != nil {
+		fatal("Failed to create index on API key collection.", "err", err)
+	}
+	return &mongoAPIKeyStore{collection: collection}
+}
+
+
+// Returns the hash under which a key is stored.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+
+// Returns the current day in UTC, which is when quotas reset.
+func quotaDay(now time.Time) string {
+	return now.UTC().Format("2006-01-02")
+}
+
+
+// Generates a new key with the given scopes and daily quota (0 for unlimited)
+// and returns it along with the record to store, which holds only its hash.
+func generateAPIKey(ctx context.Context, name string, scopes []string, dailyQuota int) (NewAPIKey, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		loggerFrom(ctx).Error("rand.Read failed", "func", "generateAPIKey", "err", err)
+		return NewAPIKey{}, newStoreError(ErrStorage, "failed to generate key")
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	record := APIKey{
+		Name:       name,
+		KeyHash:    hashAPIKey(key),
+		Hint:       key[:len(apiKeyPrefix)+4],
+		Scopes:     scopes,
+		DailyQuota: dailyQuota,
+		CreatedAt:  time.Now().UTC(),
+	}
+	return NewAPIKey{APIKey: record, Key: key}, nil
+}
+
+
+// Generates a new key and stores its hash.
+func (store *mongoAPIKeyStore) CreateAPIKey(ctx context.Context, name string, scopes []string, dailyQuota int) (NewAPIKey, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	logger := loggerFrom(ctx)
+	funcName := "CreateAPIKey"
+
+	newKey, err := generateAPIKey(ctx, name, scopes, dailyQuota)
+	if err != nil {
+		return NewAPIKey{}, err
+	}
+	var result *mongo.InsertOneResult
+	err = retryDB(ctx, funcName, false, func() error {
+		var err error
+		result, err = store.collection.InsertOne(ctx, newKey.APIKey)
+		return err
+	})
+	if err != nil {
+		logger.Error("Collection.InsertOne failed", "func", funcName, "err", err)
+		return NewAPIKey{}, newStoreError(ErrStorage, "failed when inserting into database")
+	}
+	newKey.ID = result.InsertedID.(primitive.ObjectID)
+	logger.Info("API key created.", "id", newKey.ID, "name", name, "scopes", scopes)
+	return newKey, nil
+}
+
+
+// Returns every key, newest first.
+func (store *mongoAPIKeyStore) GetAllAPIKeys(ctx context.Context) ([]APIKey, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	logger := loggerFrom(ctx)
+	funcName := "GetAllAPIKeys"
+
+	keys := []APIKey{}
+	err := retryDB(ctx, funcName, true, func() error {
+		cursor, err := store.collection.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}))
+		if err != nil {
+			return err
+		}
+		keys = []APIKey{}
+		return cursor.All(ctx, &keys)
+	})
+	if err != nil {
+		logger.Error("Collection.Find failed", "func", funcName, "err", err)
+		return nil, newStoreError(ErrStorage, "failed when reading from database")
+	}
+	return keys, nil
+}
+
+
+// Marks a key as revoked so that it is no longer accepted.
+func (store *mongoAPIKeyStore) RevokeAPIKey(ctx context.Context, id string) error {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	logger := loggerFrom(ctx)
+
+	idObject, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return newStoreError(ErrInvalidInput, "invalid id")
+	}
+	filter := bson.M{"_id": idObject, "revoked_at": nil}
+	update := bson.M{"$set": bson.M{"revoked_at": time.Now().UTC()}}
+	var result *mongo.UpdateResult
+	err = retryDB(ctx, "RevokeAPIKey", false, func() error {
+		var err error
+		result, err = store.collection.UpdateOne(ctx, filter, update)
+		return err
+	})
+	if err != nil {
+		logger.Error("Collection.UpdateOne failed", "func", "RevokeAPIKey", "err", err)
+		return newStoreError(ErrStorage, "failed when updating database")
+	}
+	if result.MatchedCount == 0 {
+		return newStoreError(ErrNotFound, "no such active key")
+	}
+	logger.Info("API key revoked.", "id", id)
+	return nil
+}
+
+
+// Looks up a key and, if it is active and hasn't used up today's quota,
+// counts one more use of it. Returns the key's record after the update.
+func (store *mongoAPIKeyStore) UseAPIKey(ctx context.Context, key string) (APIKey, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	logger := loggerFrom(ctx)
+	funcName := "UseAPIKey"
+	today := quotaDay(time.Now())
+	keyHash := hashAPIKey(key)
+
+	// Match the key only if it still has some of today's quota left,
+	// and reset the count on the first use of each day.
+	filter := bson.M{
+		"key_hash":   keyHash,
+		"revoked_at": nil,
+		"$or": bson.A{
+			bson.M{"daily_quota": 0},
+			bson.M{"usage_day": bson.M{"$ne": today}},
+			bson.M{"$expr": bson.M{"$lt": bson.A{"$usage_count", "$daily_quota"}}},
+		},
+	}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"usage_count": bson.M{"$cond": bson.A{
+			bson.M{"$eq": bson.A{"$usage_day", today}},
+			bson.M{"$add": bson.A{"$usage_count", 1}},
+			1,
+		}},
+		"usage_day": today,
+	}}}}
+	var record APIKey
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := retryDB(ctx, funcName, false, func() error {
+		return store.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&record)
+	})
+	if err == nil {
+		return record, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		logger.Error("Collection.FindOneAndUpdate failed", "func", funcName, "err", err)
+		return APIKey{}, newStoreError(ErrStorage, "failed when updating database")
+	}
+
+	// Work out whether the key is unknown or just out of quota
+	err = retryDB(ctx, funcName, true, func() error {
+		return store.collection.FindOne(ctx, bson.M{"key_hash": keyHash, "revoked_at": nil}).Decode(&record)
+	})
+	if err == mongo.ErrNoDocuments {
+		return APIKey{}, errInvalidAPIKey
+	} else if err != nil {
+		logger.Error("Collection.FindOne failed", "func", funcName, "err", err)
+		return APIKey{}, newStoreError(ErrStorage, "failed when searching database")
+	}
+	return record, errQuotaExceeded
+}
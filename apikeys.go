@@ -0,0 +1,174 @@
+// Lets the APIs be limited to clients with an API key,
+// each of which has its own scopes and daily quota.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// The scopes that a key can be granted, one per API.
+const (
+	scopeShortURL = "shorturl"
+	scopeExercise = "exercise"
+)
+
+var apiKeyScopes = []string{scopeShortURL, scopeExercise}
+
+var (
+	errInvalidAPIKey = newErrorMessage(http.StatusUnauthorized, "invalid api key")
+	errMissingAPIKey = newErrorMessage(http.StatusUnauthorized, "api key required")
+	errQuotaExceeded = newErrorMessage(http.StatusTooManyRequests, "daily quota exceeded")
+)
+
+// The body of a request to create an API key.
+type APIKeyRequest struct {
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	DailyQuota int      `json:"daily_quota"`
+}
+
+
+// Reports whether API keys are required, i.e. whether API_KEYS_REQUIRED is true.
+func apiKeysRequired() bool {
+	return getEnvBool("API_KEYS_REQUIRED", false)
+}
+
+
+// Returns a function that makes middleware requiring an API key with the given scope
+// in the X-API-Key header, or a function that returns nil if API keys aren't required.
+// Every request counts towards the key's daily quota, which resets at midnight UTC.
+func newAPIKeyMiddleware() func(scope string) Middleware {
+	if !apiKeysRequired() {
+		return func(string) Middleware { return nil }
+	}
+	slog.Info("Requiring API keys.")
+
+	return func(scope string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				record, err := authorizeAPIKey(r.Context(), r.Header.Get("X-API-Key"), scope)
+				if err == errQuotaExceeded {
+					w.Header().Set("Retry-After", strconv.Itoa(secondsUntilQuotaReset(time.Now())))
+				}
+				setQuotaHeaders(w, record)
+				if err != nil {
+					writeError(w, r, err)
+					return
+				}
+				ctx := withLoggerAttrs(r.Context(), "api_key", record.ID.Hex())
+				ctx = context.WithValue(ctx, apiKeyIDKey, record.ID.Hex())
+				next.ServeHTTP(w, r.WithContext(ctx))
+			})
+		}
+	}
+}
+
+
+// Checks that the key exists, has the scope, and has some of today's quota left,
+// then counts one use of it. The key's record is returned whenever it was found.
+func authorizeAPIKey(ctx context.Context, key string, scope string) (APIKey, error) {
+	if len(key) == 0 {
+		return APIKey{}, errMissingAPIKey
+	}
+	record, err := apiKeyStore.UseAPIKey(ctx, key)
+	if err != nil {
+		return record, err
+	}
+	if !slices.Contains(record.Scopes, scope) {
+		return record, newErrorMessage(http.StatusForbidden, "api key lacks the "+scope+" scope")
+	}
+	return record, nil
+}
+
+
+// Returns the ID of the API key that the request was made with,
+// or "" if API keys aren't required.
+func apiKeyIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(apiKeyIDKey).(string)
+	return id
+}
+
+
+// Tells the client how much of its quota it has left.
+func setQuotaHeaders(w http.ResponseWriter, record APIKey) {
+	if record.DailyQuota == 0 || len(record.UsageDay) == 0 {
+		return
+	}
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(record.DailyQuota))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(max(record.DailyQuota-record.UsageCount, 0)))
+}
+
+
+// Returns the number of seconds until the next midnight UTC.
+func secondsUntilQuotaReset(now time.Time) int {
+	now = now.UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return int(midnight.Sub(now).Seconds()) + 1
+}
+
+
+// Creates an API key from a JSON body such as
+// { "name": "dashboard", "scopes": ["shorturl"], "daily_quota": 1000 }.
+// The response contains the key itself, which can't be retrieved again.
+func postAdminAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req APIKeyRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, r, newErrorMessage(http.StatusBadRequest, "invalid json body"))
+		return
+	}
+	if len(req.Name) == 0 {
+		writeError(w, r, newErrorMessage(http.StatusBadRequest, "name is required"))
+		return
+	}
+	if len(req.Scopes) == 0 {
+		writeError(w, r, newErrorMessage(http.StatusBadRequest, "at least one scope is required"))
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(apiKeyScopes, scope) {
+			writeError(w, r, newErrorMessage(http.StatusBadRequest, "unknown scope "+scope))
+			return
+		}
+	}
+	if req.DailyQuota < 0 {
+		writeError(w, r, newErrorMessage(http.StatusBadRequest, "daily_quota must not be negative"))
+		return
+	}
+
+	key, err := apiKeyStore.CreateAPIKey(r.Context(), req.Name, req.Scopes, req.DailyQuota)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusCreated, key)
+}
+
+
+// Returns every API key, without the keys themselves.
+func getAdminAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := apiKeyStore.GetAllAPIKeys(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+
+// Revokes the API key whose ID is in the path.
+func deleteAdminAPIKey(w http.ResponseWriter, r *http.Request) {
+	if err := apiKeyStore.RevokeAPIKey(r.Context(), r.PathValue("id")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
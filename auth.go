@@ -0,0 +1,245 @@
+// Issues and verifies JSON Web Tokens so that the endpoints that write
+// to the database can be limited to known clients.
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// How far a token's timestamps may be off to allow for clock skew.
+const tokenLeeway = 30 * time.Second
+
+// The issuer recorded in and required of every token.
+const tokenIssuer = "fcc-go"
+
+// The response to a successful token request, as in RFC 6749.
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// The claims carried by a token.
+type tokenClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+var (
+	errInvalidToken = newErrorMessage(http.StatusUnauthorized, "invalid token")
+	errExpiredToken = newErrorMessage(http.StatusUnauthorized, "token expired")
+	errMissingToken = newErrorMessage(http.StatusUnauthorized, "bearer token required")
+)
+
+// The header of every token, which is always signed with HMAC-SHA256.
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Settings for issuing and verifying tokens, read once at startup.
+// The secret is empty when JWT authentication is disabled.
+var jwtConfig struct {
+	secret  []byte
+	ttl     time.Duration
+	clients map[string]string
+}
+
+
+// Reads the JWT settings from the environment.
+// Tokens are only issued and required if JWT_SECRET is set.
+// JWT_CLIENTS lists the clients allowed to request tokens as id:secret pairs,
+// and JWT_TTL sets how long tokens last (default 1h).
+func initJWTAuth() {
+	jwtConfig.secret = []byte(os.Getenv("JWT_SECRET"))
+	if len(jwtConfig.secret) == 0 {
+		return
+	}
+	if len(jwtConfig.secret) < 32 {
+		slog.Warn("JWT_SECRET is shorter than 32 bytes, which makes tokens easier to forge.")
+	}
+	jwtConfig.ttl = getEnvDuration("JWT_TTL", time.Hour)
+	jwtConfig.clients = make(map[string]string)
+	for _, pair := range getEnvList("JWT_CLIENTS") {
+		id, secret, ok := strings.Cut(pair, ":")
+		if !ok || len(id) == 0 || len(secret) == 0 {
+			slog.Warn("Ignoring malformed entry in JWT_CLIENTS.", "entry", id)
+			continue
+		}
+		jwtConfig.clients[id] = secret
+	}
+	slog.Info("Requiring tokens for writes.", "clients", len(jwtConfig.clients), "ttl", jwtConfig.ttl)
+}
+
+
+// Reports whether JWT authentication is enabled.
+func jwtEnabled() bool {
+	return len(jwtConfig.secret) > 0
+}
+
+
+// Returns a signed token for the subject that expires after the configured TTL.
+func issueToken(subject string, now time.Time) (string, error) {
+	claims, err := json.Marshal(tokenClaims{
+		Issuer:    tokenIssuer,
+		Subject:   subject,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(jwtConfig.ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	signingInput := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return signingInput + "." + signToken(signingInput), nil
+}
+
+
+func signToken(signingInput string) string {
+	mac := hmac.New(sha256.New, jwtConfig.secret)
+	mac.Write([]byte(signingInput))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+
+// Checks a token's signature, issuer, and expiry, and returns its claims.
+// Only HS256 tokens are accepted, whatever their header says.
+func verifyToken(token string, now time.Time) (tokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenHeader {
+		return tokenClaims{}, errInvalidToken
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(signToken(parts[0]+"."+parts[1]))) {
+		return tokenClaims{}, errInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return tokenClaims{}, errInvalidToken
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return tokenClaims{}, errInvalidToken
+	}
+	if claims.Issuer != tokenIssuer || len(claims.Subject) == 0 {
+		return tokenClaims{}, errInvalidToken
+	}
+	if now.Add(-tokenLeeway).Unix() >= claims.ExpiresAt {
+		return tokenClaims{}, errExpiredToken
+	}
+	if now.Add(tokenLeeway).Unix() < claims.IssuedAt {
+		return tokenClaims{}, errInvalidToken
+	}
+	return claims, nil
+}
+
+
+// Issues a token to a client that presents its ID and secret,
+// either as client_id and client_secret form fields or via basic auth.
+func postAuthToken(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFrom(r.Context())
+
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		if err := r.ParseForm(); err != nil {
+			writeError(w, r, formError(err))
+			return
+		}
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	expected, known := jwtConfig.clients[id]
+	// Compare even for unknown clients so that the timing doesn't reveal which IDs exist
+	if !secretsEqual(secret, expected) || !known || len(id) == 0 {
+		logger.Warn("Rejected token request.", "client_id", id, "client_ip", clientIP(r))
+		writeError(w, r, newErrorMessage(http.StatusUnauthorized, "invalid client credentials"))
+		return
+	}
+
+	token, err := issueToken(id, time.Now())
+	if err != nil {
+		logger.Error("issueToken failed", "func", "postAuthToken", "err", err)
+		writeError(w, r, err)
+		return
+	}
+	logger.Info("Issued token.", "client_id", id)
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, TokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(jwtConfig.ttl.Seconds()),
+	})
+}
+
+
+// Returns the bearer token in an Authorization header value, if there is one.
+func bearerToken(authorization string) (string, bool) {
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || len(token) == 0 {
+		return "", false
+	}
+	return token, true
+}
+
+
+// Returns a middleware that rejects requests without a valid bearer token,
+// or nil if JWT authentication is disabled.
+func newJWTMiddleware() Middleware {
+	if !jwtEnabled() {
+		return nil
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(r.Header.Get("Authorization"))
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="fcc-go"`)
+				writeError(w, r, errMissingToken)
+				return
+			}
+			claims, err := verifyToken(token, time.Now())
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="fcc-go", error="invalid_token"`)
+				writeError(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(withSubject(r.Context(), claims.Subject)))
+		})
+	}
+}
+
+
+// Checks the token in the authorization metadata of a gRPC call.
+// Returns the context to use for the rest of the call.
+func authenticateGRPC(ctx context.Context, authorization string) (context.Context, error) {
+	token, ok := bearerToken(authorization)
+	if !ok {
+		return ctx, errMissingToken
+	}
+	claims, err := verifyToken(token, time.Now())
+	if err != nil {
+		return ctx, err
+	}
+	return withSubject(ctx, claims.Subject), nil
+}
+
+
+// Returns a copy of the context that carries the subject of the request's token.
+func withSubject(ctx context.Context, subject string) context.Context {
+	ctx = withLoggerAttrs(ctx, "subject", subject)
+	return context.WithValue(ctx, subjectKey, subject)
+}
+
+
+// Returns the subject of the token that the request was made with,
+// or "" if tokens aren't required.
+func subjectFrom(ctx context.Context) string {
+	subject, _ := ctx.Value(subjectKey).(string)
+	return subject
+}
+
@@ -0,0 +1,85 @@
+// Tests how the tokens that clients present are verified.
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Signs a token with the given header and claims, which needn't be ones issueToken would make.
+func signTestToken(t *testing.T, header string, claims tokenClaims) string {
+	t.Helper()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingInput := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + signToken(signingInput)
+}
+
+
+func TestVerifyToken(t *testing.T) {
+	previous := jwtConfig.secret
+	jwtConfig.secret = []byte("0123456789abcdef0123456789abcdef")
+	defer func() { jwtConfig.secret = previous }()
+
+	now := time.Unix(1700000000, 0)
+	valid := tokenClaims{Issuer: tokenIssuer, Subject: "client", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Hour).Unix()}
+	withClaims := func(change func(*tokenClaims)) tokenClaims {
+		claims := valid
+		change(&claims)
+		return claims
+	}
+	validToken := signTestToken(t, tokenHeader, valid)
+
+	// The same claims signed with another secret
+	jwtConfig.secret = []byte("another secret that is long enough")
+	otherSecretToken := signTestToken(t, tokenHeader, valid)
+	jwtConfig.secret = []byte("0123456789abcdef0123456789abcdef")
+
+	noneHeader := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	hs512Header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS512","typ":"JWT"}`))
+	parts := strings.Split(validToken, ".")
+	forged, _ := json.Marshal(withClaims(func(c *tokenClaims) { c.Subject = "admin" }))
+	forgedPayload := base64.RawURLEncoding.EncodeToString(forged)
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"valid", validToken, nil},
+		{"not three parts", parts[0] + "." + parts[1], errInvalidToken},
+		{"empty", "", errInvalidToken},
+		{"signed with another secret", otherSecretToken, errInvalidToken},
+		{"signature of another payload", parts[0] + "." + forgedPayload + "." + parts[2], errInvalidToken},
+		{"no signature", parts[0] + "." + parts[1] + ".", errInvalidToken},
+		{"alg none", noneHeader + "." + parts[1] + ".", errInvalidToken},
+		{"alg none, signed", signTestToken(t, noneHeader, valid), errInvalidToken},
+		{"another alg", signTestToken(t, hs512Header, valid), errInvalidToken},
+		{"payload not base64", tokenHeader + ".!!!." + signToken(tokenHeader + ".!!!"), errInvalidToken},
+		{"wrong issuer", signTestToken(t, tokenHeader, withClaims(func(c *tokenClaims) { c.Issuer = "someone-else" })), errInvalidToken},
+		{"no subject", signTestToken(t, tokenHeader, withClaims(func(c *tokenClaims) { c.Subject = "" })), errInvalidToken},
+		{"expired long ago", signTestToken(t, tokenHeader, withClaims(func(c *tokenClaims) { c.ExpiresAt = now.Add(-time.Hour).Unix() })), errExpiredToken},
+		{"expired beyond the leeway", signTestToken(t, tokenHeader, withClaims(func(c *tokenClaims) { c.ExpiresAt = now.Add(-tokenLeeway).Unix() })), errExpiredToken},
+		{"expired within the leeway", signTestToken(t, tokenHeader, withClaims(func(c *tokenClaims) { c.ExpiresAt = now.Add(-tokenLeeway + time.Second).Unix() })), nil},
+		{"issued within the leeway", signTestToken(t, tokenHeader, withClaims(func(c *tokenClaims) { c.IssuedAt = now.Add(tokenLeeway).Unix() })), nil},
+		{"issued beyond the leeway", signTestToken(t, tokenHeader, withClaims(func(c *tokenClaims) { c.IssuedAt = now.Add(tokenLeeway + time.Second).Unix() })), errInvalidToken},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := verifyToken(test.token, now)
+			if err != test.wantErr {
+				t.Fatalf("err = %v, want %v", err, test.wantErr)
+			}
+			if err == nil && claims.Subject != valid.Subject {
+				t.Errorf("subject = %q, want %q", claims.Subject, valid.Subject)
+			}
+		})
+	}
+}
+
@@ -0,0 +1,137 @@
+// Keeps the URL shortener from being used to mask links to blocked domains,
+// such as phishing sites, both when links are created and when they are followed.
+package main
+
+import (
+	"bufio"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var blockedURLsTotal = newCounterVec("blocked_urls_total",
+	"Total number of URLs refused because their domain is on the blocklist.", "stage")
+
+var (
+	errBlockedURL  = newErrorMessage(http.StatusBadRequest, "url is not allowed")
+	errBlockedLink = newErrorMessage(http.StatusForbidden, "this link has been blocked")
+)
+
+// The entries of the blocklist file as they were when it was last read.
+// Each is a domain, which also covers its subdomains, or a pattern with "*" wildcards.
+type urlBlocklist struct {
+	domains  []string
+	patterns []string
+	modTime  time.Time
+}
+
+// The current blocklist, which is nil if there is none
+var blocklist atomic.Pointer[urlBlocklist]
+
+
+// Loads the blocklist from URL_BLOCKLIST_FILE, if set, and reloads it whenever
+// the file changes, checking every URL_BLOCKLIST_RELOAD_INTERVAL (default 1m).
+// The file has one domain or pattern per line, e.g. "evil.example" or "*.phish.*",
+// and anything after a "#" is a comment.
+func initURLBlocklist() {
+	filename := getEnv("URL_BLOCKLIST_FILE", "")
+	if len(filename) == 0 {
+		return
+	}
+	list, err := loadURLBlocklist(filename)
+	if err != nil {
+		fatal("Unable to read URL_BLOCKLIST_FILE.", "file", filename, "err", err)
+	}
+	blocklist.Store(list)
+	slog.Info("Loaded URL blocklist.", "file", filename, "entries", len(list.domains)+len(list.patterns))
+
+	interval := getEnvDuration("URL_BLOCKLIST_RELOAD_INTERVAL", time.Minute)
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			info, err := os.Stat(filename)
+			if err != nil {
+				slog.Warn("Unable to check URL blocklist, so keeping the current one.", "file", filename, "err", err)
+				continue
+			}
+			if info.ModTime().Equal(blocklist.Load().modTime) {
+				continue
+			}
+			list, err := loadURLBlocklist(filename)
+			if err != nil {
+				slog.Warn("Unable to reload URL blocklist, so keeping the current one.", "file", filename, "err", err)
+				continue
+			}
+			blocklist.Store(list)
+			slog.Info("Reloaded URL blocklist.", "file", filename, "entries", len(list.domains)+len(list.patterns))
+		}
+	}()
+}
+
+
+// Reads a blocklist file.
+func loadURLBlocklist(filename string) (*urlBlocklist, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	list := &urlBlocklist{modTime: info.ModTime()}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry, _, _ := strings.Cut(scanner.Text(), "#")
+		entry = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(entry)), ".")
+		if len(entry) == 0 {
+			continue
+		}
+		if strings.Contains(entry, "*") {
+			if _, err := path.Match(entry, ""); err != nil {
+				slog.Warn("Ignoring invalid pattern in URL blocklist.", "pattern", entry)
+				continue
+			}
+			list.patterns = append(list.patterns, entry)
+		} else {
+			list.domains = append(list.domains, entry)
+		}
+	}
+	return list, scanner.Err()
+}
+
+
+// Reports whether the URL's host is on the blocklist.
+func urlBlocked(rawURL string) bool {
+	list := blocklist.Load()
+	if list == nil {
+		return false
+	}
+	urlObject, err := url.Parse(withScheme(rawURL))
+	if err != nil {
+		return false
+	}
+	host := strings.TrimSuffix(strings.ToLower(urlObject.Hostname()), ".")
+	for _, domain := range list.domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	for _, pattern := range list.patterns {
+		if matched, _ := path.Match(pattern, host); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+// Limits how much data visitors can send in a request body.
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// Returns middleware that caps the size of every request body at
+// MAX_BODY_BYTES (default 64 KiB), except for the routes in overrides,
+// which are keyed by the pattern they were registered on the mux with.
+// Reading past the limit fails with an *http.MaxBytesError.
+func newBodyLimitMiddleware(mux *http.ServeMux, overrides map[string]int64) Middleware {
+	defaultLimit := getEnvInt("MAX_BODY_BYTES", 64<<10)
+	slog.Info("Limiting request body size.", "default", defaultLimit, "overrides", overrides)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit := defaultLimit
+			if _, pattern := mux.Handler(r); len(pattern) > 0 {
+				if override, ok := overrides[pattern]; ok {
+					limit = override
+				}
+			}
+
+			// Reject the request right away if it says it is too large
+			if r.ContentLength > limit {
+				writeError(w, r, errBodyTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+
+var errBodyTooLarge = newErrorMessage(http.StatusRequestEntityTooLarge, "request body too large")
+
+
+// Converts an error from parsing the request body into one for the visitor,
+// which is a 413 if the body was too large and a 400 otherwise.
+func formError(err error) *ErrorMessage {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return errBodyTooLarge
+	}
+	return newErrorMessage(http.StatusBadRequest, "unable to parse form")
+}
@@ -0,0 +1,141 @@
+// Manages the embedded bbolt database, which keeps everything in a single file
+// so that the app can persist its data on hosts without a database server.
+package main
+
+import (
+	"context"
+	"errors"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"log/slog"
+	"time"
+)
+
+var boltDB *bolt.DB
+
+// The buckets in the bbolt file. Records are encoded as BSON,
+// so the same struct tags serve both backends.
+var (
+	// Short URL -> urlDBRecord
+	boltURLsBucket = []byte("urls")
+	// Original URL -> short URL
+	boltOriginalURLsBucket = []byte("original_urls")
+	// User ID -> ExerciseUserRecord
+	boltUsersBucket = []byte("exercise_users")
+	// Username -> user ID
+	boltUsernamesBucket = []byte("exercise_usernames")
+	// Key ID -> APIKey
+	boltAPIKeysBucket = []byte("api_keys")
+	// Key hash -> key ID
+	boltAPIKeyHashesBucket = []byte("api_key_hashes")
+	// Short URL -> a bucket of sequence number -> ClickEvent, so clicks are kept in order
+	boltClicksBucket = []byte("clicks")
+)
+
+
+// Opens the bbolt file at BOLT_PATH (default "fcc-go.db"), creating it if needed.
+// Only one process can have the file open at a time.
+func initBolt() {
+	path := getEnv("BOLT_PATH", "fcc-go.db")
+	slog.Info("Opening bbolt database.", "path", path)
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		fatal("Error when opening bbolt database.", "path", path, "err", err)
+	}
+	if err := createBoltBuckets(db); err != nil {
+		fatal("Error when creating bbolt buckets.", "err", err)
+	}
+	if err := migrateBolt(db); err != nil {
+		fatal("Failed to migrate bbolt database.", "err", err)
+	}
+
+	boltDB = db
+	setStores(&boltURLStore{db: db}, &boltExerciseStore{db: db}, &boltAPIKeyStore{db: db}, &boltClickStore{db: db})
+	markStorageReady()
+	go sweepExpiredBoltURLs(db, getEnvDuration("BOLT_EXPIRY_SWEEP_INTERVAL", time.Minute))
+}
+
+
+// Creates the buckets that the stores keep their records in, unless they already exist.
+func createBoltBuckets(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		buckets := [][]byte{
+			boltURLsBucket, boltOriginalURLsBucket,
+			boltUsersBucket, boltUsernamesBucket,
+			boltAPIKeysBucket, boltAPIKeyHashesBucket,
+			boltClicksBucket,
+		}
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+
+// Checks that the bbolt file can still be read.
+func pingBolt(ctx context.Context) error {
+	return boltDB.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltURLsBucket) == nil {
+			return errors.New("bbolt buckets are missing")
+		}
+		return ctx.Err()
+	})
+}
+
+
+// Closes the bbolt file, if it was opened.
+func closeBolt() {
+	if boltDB == nil {
+		return
+	}
+	slog.Info("Closing bbolt database.")
+	if err := boltDB.Close(); err != nil {
+		slog.Error("Error when closing bbolt database.", "err", err)
+	}
+}
+
+
+// Decodes a record from a bucket, returning false if the key doesn't exist.
+func getBoltRecord(bucket *bolt.Bucket, key []byte, record any) (bool, error) {
+	data := bucket.Get(key)
+	if data == nil {
+		return false, nil
+	}
+	return true, bson.Unmarshal(data, record)
+}
+
+
+// Encodes a record and puts it into a bucket.
+func putBoltRecord(bucket *bolt.Bucket, key []byte, record any) error {
+	data, err := bson.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, data)
+}
+
+
+// Fails with ErrConflict unless a record read inside a transaction
+// is still at the version that the client last saw.
+func checkBoltVersion(current, expected int64) error {
+	if current != expected {
+		return newVersionConflict(expected)
+	}
+	return nil
+}
+
+
+// Passes on errors meant for the client, such as ErrNotFound from inside a transaction,
+// and logs any others before reporting them as ErrStorage with the given message.
+func boltError(ctx context.Context, funcName string, err error, msg string) error {
+	var storeErr *StoreError
+	var errMsg *ErrorMessage
+	if errors.As(err, &storeErr) || errors.As(err, &errMsg) {
+		return err
+	}
+	loggerFrom(ctx).Error("bbolt transaction failed", "func", funcName, "err", err)
+	return newStoreError(ErrStorage, msg)
+}
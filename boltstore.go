@@ -0,0 +1,1579 @@
+// Implements the URL, exercise, API key, and click stores on top of bbolt.
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"maps"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"strconv"
+	"time"
+)
+
+// Keeps short URLs in bbolt.
+type boltURLStore struct {
+	db *bolt.DB
+}
+
+// Keeps exercise users and their logs in bbolt, with one record per user
+// that holds their whole log, as in MongoDB.
+type boltExerciseStore struct {
+	db *bolt.DB
+}
+
+// Keeps API keys in bbolt.
+type boltAPIKeyStore struct {
+	db *bolt.DB
+}
+
+// Keeps clicks in bbolt, with a bucket for each short URL.
+type boltClickStore struct {
+	db *bolt.DB
+}
+
+
+// Stores a URL under the next short URL in sequence,
+// or returns the existing receipt if the URL was already stored.
+func (store *boltURLStore) InsertURL(ctx context.Context, newURL NewURL) (urlReceipt, error) {
+	var receipt urlReceipt
+	err := boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		var err error
+		receipt, err = insertBoltURL(tx, newURL)
+		return err
+	})
+	if err != nil {
+		return urlReceipt{}, boltError(ctx, "InsertURL", err, "failed when inserting into database")
+	}
+	return receipt, nil
+}
+
+
+// Stores several URLs in one transaction, so either all of them are stored or none are.
+func (store *boltURLStore) InsertURLs(ctx context.Context, newURLs []NewURL) ([]urlReceipt, []error, error) {
+	receipts := make([]urlReceipt, len(newURLs))
+	results := make([]error, len(newURLs))
+	err := boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		for i, newURL := range newURLs {
+			var err error
+			receipts[i], err = insertBoltURL(tx, newURL)
+			// A duplicate is found before anything is written, so the others can still be stored
+			if errors.Is(err, ErrDuplicate) {
+				results[i] = err
+			} else if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, boltError(ctx, "InsertURLs", err, "failed when inserting into database")
+	}
+	return receipts, results, nil
+}
+
+
+// Stores a URL under a new short URL within a transaction,
+// or returns the existing receipt if the URL was already stored.
+func insertBoltURL(tx *bolt.Tx, newURL NewURL) (urlReceipt, error) {
+	urls := tx.Bucket(boltURLsBucket)
+	originals := tx.Bucket(boltOriginalURLsBucket)
+	if shortURL := originals.Get([]byte(newURL.OriginalURL)); shortURL != nil {
+		var existing urlDBRecord
+		if _, err := getBoltRecord(urls, shortURL, &existing); err != nil {
+			return urlReceipt{}, err
+		}
+		if !isExpired(existing.ExpiresAt) {
+			if len(newURL.Alias) > 0 && existing.ShortURL != newURL.Alias {
+				return urlReceipt{}, newStoreError(ErrDuplicate, "url already has the short url " + existing.ShortURL)
+			}
+			if err := existing.checkDuplicate(newURL); err != nil {
+				return urlReceipt{}, err
+			}
+			return existing.receipt(), nil
+		}
+		// The sweep hasn't removed it yet, so do that now and shorten the URL afresh
+		if err := urls.Delete(shortURL); err != nil {
+			return urlReceipt{}, err
+		}
+	}
+
+	shortURL := newURL.Alias
+	if len(shortURL) > 0 {
+		if urls.Get([]byte(shortURL)) != nil {
+			return urlReceipt{}, newStoreError(ErrDuplicate, "short url " + shortURL + " is already taken")
+		}
+	} else {
+		var err error
+		if shortURL, err = newBoltShortURL(urls); err != nil {
+			return urlReceipt{}, err
+		}
+	}
+	record := urlDBRecord{
+		OriginalURL: newURL.OriginalURL,
+		ShortURL: shortURL,
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+		// Stored dates have millisecond precision, as in MongoDB
+		ExpiresAt: newURL.ExpiresAt.UTC().Truncate(time.Millisecond),
+		ProbeStatus: newURL.ProbeStatus,
+		ProbedAt: newURL.ProbedAt.UTC().Truncate(time.Millisecond),
+		Owner: newURL.Owner,
+		RedirectStatus: newURL.RedirectStatus,
+	}
+	if err := putBoltRecord(urls, []byte(shortURL), record); err != nil {
+		return urlReceipt{}, err
+	}
+	return record.receipt(), originals.Put([]byte(newURL.OriginalURL), []byte(shortURL))
+}
+
+
+// Returns a random short URL that isn't taken or reserved, or, if SHORT_URL_STYLE is "sequential",
+// the next number in the bucket's sequence in base 36 that isn't reserved.
+func newBoltShortURL(urls *bolt.Bucket) (string, error) {
+	if sequentialShortURLs {
+		for {
+			// The sequence starts at 1, but short URLs start at 0 as in MongoDB
+			seq, err := urls.NextSequence()
+			if err != nil {
+				return "", err
+			}
+			// Reserved words are skipped, e.g. "nev" is followed by "nex" rather than "new"
+			if shortURL := strconv.FormatUint(seq-1, 36); !isReservedShortURL(shortURL) {
+				return shortURL, nil
+			}
+		}
+	}
+	for attempt := 1; attempt <= maxShortURLAttempts; attempt++ {
+		shortURL := randomShortURL()
+		if urls.Get([]byte(shortURL)) == nil && !isReservedShortURL(shortURL) {
+			return shortURL, nil
+		}
+	}
+	return "", newStoreError(ErrDuplicate, "unable to find a free short url")
+}
+
+
+// Returns the original URL for a short URL, along with the status code
+// to redirect with.
+func (store *boltURLStore) ResolveURL(ctx context.Context, sURL string) (string, int, error) {
+	var record urlDBRecord
+	err := boltView(ctx, store.db, func(tx *bolt.Tx) error {
+		found, err := getBoltRecord(tx.Bucket(boltURLsBucket), []byte(sURL), &record)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return newStoreError(ErrNotFound, "no such short url")
+		}
+		// The sweep may not have removed it yet
+		if isExpired(record.ExpiresAt) {
+			return newStoreError(ErrExpired, "short url has expired")
+		}
+		if record.Disabled != nil {
+			return disabledLinkError(record.Disabled)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", 0, boltError(ctx, "ResolveURL", err, "failed when searching database")
+	}
+	return withUTMParams(record.OriginalURL, record.UTM), record.RedirectStatus, nil
+}
+
+
+// Returns what is stored about a short URL without counting a visit.
+func (store *boltURLStore) LookupURL(ctx context.Context, sURL string) (StoredURL, error) {
+	var record urlDBRecord
+	err := boltView(ctx, store.db, func(tx *bolt.Tx) error {
+		found, err := getBoltRecord(tx.Bucket(boltURLsBucket), []byte(sURL), &record)
+		if err == nil && !found {
+			return newStoreError(ErrNotFound, "no such short url")
+		} else if err == nil && isExpired(record.ExpiresAt) {
+			return newStoreError(ErrExpired, "short url has expired")
+		}
+		return err
+	})
+	if err != nil {
+		return StoredURL{}, boltError(ctx, "LookupURL", err, "failed when searching database")
+	}
+	return record.stored(), nil
+}
+
+
+// Sets or, given a zero time, clears the time at which a short URL expires.
+func (store *boltURLStore) SetURLExpiry(ctx context.Context, sURL string, expiresAt time.Time) error {
+	err := boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		urls := tx.Bucket(boltURLsBucket)
+		var record urlDBRecord
+		found, err := getBoltRecord(urls, []byte(sURL), &record)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return newStoreError(ErrNotFound, "no such short url")
+		}
+		// Stored dates have millisecond precision, as in MongoDB
+		record.ExpiresAt = expiresAt.UTC().Truncate(time.Millisecond)
+		record.Version++
+		return putBoltRecord(urls, []byte(sURL), record)
+	})
+	if err != nil {
+		return boltError(ctx, "SetURLExpiry", err, "failed when updating database")
+	}
+	return nil
+}
+
+
+// Sets or, given nil, removes the campaign parameters added to visits to a short URL.
+func (store *boltURLStore) SetURLUTM(ctx context.Context, sURL string, utm *UTMParams) error {
+	err := boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		urls := tx.Bucket(boltURLsBucket)
+		var record urlDBRecord
+		found, err := getBoltRecord(urls, []byte(sURL), &record)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return newStoreError(ErrNotFound, "no such short url")
+		}
+		record.UTM = utm
+		record.Version++
+		return putBoltRecord(urls, []byte(sURL), record)
+	})
+	if err != nil {
+		return boltError(ctx, "SetURLUTM", err, "failed when updating database")
+	}
+	return nil
+}
+
+
+// Sets or, given nil, removes the webhook that visits to a short URL are reported to.
+func (store *boltURLStore) SetURLWebhook(ctx context.Context, sURL string, webhook *Webhook) error {
+	err := boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		urls := tx.Bucket(boltURLsBucket)
+		var record urlDBRecord
+		found, err := getBoltRecord(urls, []byte(sURL), &record)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return newStoreError(ErrNotFound, "no such short url")
+		}
+		record.Webhook = webhook
+		return putBoltRecord(urls, []byte(sURL), record)
+	})
+	if err != nil {
+		return boltError(ctx, "SetURLWebhook", err, "failed when updating database")
+	}
+	return nil
+}
+
+
+// Returns the webhooks of the short URLs that have one.
+// bbolt has no indexes, so every record is read.
+func (store *boltURLStore) GetURLWebhooks(ctx context.Context) (map[string]Webhook, error) {
+	webhooks := make(map[string]Webhook)
+	err := boltView(ctx, store.db, func(tx *bolt.Tx) error {
+		return tx.Bucket(boltURLsBucket).ForEach(func(k, v []byte) error {
+			var record urlDBRecord
+			if err := bson.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			if record.Webhook != nil && !isExpired(record.ExpiresAt) {
+				webhooks[record.ShortURL] = *record.Webhook
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, boltError(ctx, "GetURLWebhooks", err, "failed when reading from database")
+	}
+	return webhooks, nil
+}
+
+// Stops a short URL from redirecting, recording why, or lets it redirect again given nil.
+func (store *boltURLStore) SetURLDisabled(ctx context.Context, sURL string, disabled *LinkDisabled) error {
+	err := boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		urls := tx.Bucket(boltURLsBucket)
+		var record urlDBRecord
+		found, err := getBoltRecord(urls, []byte(sURL), &record)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return newStoreError(ErrNotFound, "no such short url")
+		}
+		if disabled != nil {
+			// Stored dates have millisecond precision, as in MongoDB
+			stored := *disabled
+			stored.DisabledAt = stored.DisabledAt.UTC().Truncate(time.Millisecond)
+			disabled = &stored
+		}
+		record.Disabled = disabled
+		return putBoltRecord(urls, []byte(sURL), record)
+	})
+	if err != nil {
+		return boltError(ctx, "SetURLDisabled", err, "failed when updating database")
+	}
+	return nil
+}
+
+
+// Sets how many visits a minute a short URL may have before it is disabled,
+// or, given 0, removes its own limit so that it has the default.
+func (store *boltURLStore) SetURLVisitLimit(ctx context.Context, sURL string, limit int64) error {
+	err := boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		urls := tx.Bucket(boltURLsBucket)
+		var record urlDBRecord
+		found, err := getBoltRecord(urls, []byte(sURL), &record)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return newStoreError(ErrNotFound, "no such short url")
+		}
+		record.VisitLimit = max(limit, 0)
+		return putBoltRecord(urls, []byte(sURL), record)
+	})
+	if err != nil {
+		return boltError(ctx, "SetURLVisitLimit", err, "failed when updating database")
+	}
+	return nil
+}
+
+
+// Returns the visit limits of the short URLs that have their own.
+// bbolt has no indexes, so every record is read.
+func (store *boltURLStore) GetURLVisitLimits(ctx context.Context) (map[string]int64, error) {
+	limits := make(map[string]int64)
+	err := boltView(ctx, store.db, func(tx *bolt.Tx) error {
+		return tx.Bucket(boltURLsBucket).ForEach(func(k, v []byte) error {
+			var record urlDBRecord
+			if err := bson.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			if record.VisitLimit > 0 && !isExpired(record.ExpiresAt) {
+				limits[record.ShortURL] = record.VisitLimit
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, boltError(ctx, "GetURLVisitLimits", err, "failed when reading from database")
+	}
+	return limits, nil
+}
+
+
+
+// Points a short URL at a different original URL and returns the updated record.
+func (store *boltURLStore) UpdateURL(ctx context.Context, sURL string, newURL string, version int64) (URLExport, error) {
+	var record urlDBRecord
+	err := boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		urls := tx.Bucket(boltURLsBucket)
+		originals := tx.Bucket(boltOriginalURLsBucket)
+		found, err := getBoltRecord(urls, []byte(sURL), &record)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return newStoreError(ErrNotFound, "no such short url")
+		}
+		if version != anyVersion {
+			if err := checkBoltVersion(record.Version, version); err != nil {
+				return err
+			}
+		}
+		if newURL != record.OriginalURL {
+			if originals.Get([]byte(newURL)) != nil {
+				return newStoreError(ErrDuplicate, "original url " + newURL + " already has a short url")
+			}
+			if err := originals.Delete([]byte(record.OriginalURL)); err != nil {
+				return err
+			}
+			if err := originals.Put([]byte(newURL), []byte(sURL)); err != nil {
+				return err
+			}
+		}
+		record.OriginalURL = newURL
+		record.Version++
+		return putBoltRecord(urls, []byte(sURL), record)
+	})
+	if err != nil {
+		return URLExport{}, boltError(ctx, "UpdateURL", err, "failed when updating database")
+	}
+	loggerFrom(ctx).Info("URL updated.", "short_url", sURL, "original_url", newURL)
+	return exportURL(record), nil
+}
+
+
+// Adds to the visit counts of several short URLs in one transaction.
+// Short URLs that have since been deleted are skipped.
+func (store *boltURLStore) AddVisits(ctx context.Context, visits map[string]int64) error {
+	if len(visits) == 0 {
+		return nil
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	err := boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		urls := tx.Bucket(boltURLsBucket)
+		for sURL, count := range visits {
+			var record urlDBRecord
+			found, err := getBoltRecord(urls, []byte(sURL), &record)
+			if err != nil {
+				return err
+			}
+			if !found {
+				continue
+			}
+			record.TimesVisited += int(count)
+			record.LastVisitedAt = now
+			if err := putBoltRecord(urls, []byte(sURL), record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return boltError(ctx, "AddVisits", err, "failed when updating database")
+	}
+	return nil
+}
+
+
+// Returns a page of the short URLs that haven't expired, sorted as the query asks,
+// with ties broken by the creation time and then the short URL.
+// bbolt can only iterate in key order, so every record is read and sorted.
+func (store *boltURLStore) ListURLs(ctx context.Context, query URLListQuery) ([]StoredURL, int64, error) {
+	var records []urlDBRecord
+	err := boltView(ctx, store.db, func(tx *bolt.Tx) error {
+		return tx.Bucket(boltURLsBucket).ForEach(func(k, v []byte) error {
+			var record urlDBRecord
+			if err := bson.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			// The sweep may not have removed it yet
+			if isExpired(record.ExpiresAt) {
+				return nil
+			}
+			if query.AnyOwner || len(record.Owner) == 0 || record.Owner == query.Owner {
+				records = append(records, record)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, 0, boltError(ctx, "ListURLs", err, "failed when reading from database")
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if query.Ascending {
+			a, b = b, a
+		}
+		if query.Sort == sortURLsByVisits && a.TimesVisited != b.TimesVisited {
+			return a.TimesVisited > b.TimesVisited
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ShortURL > b.ShortURL
+	})
+
+	total := int64(len(records))
+	start := min(query.Offset, total)
+	end := min(start+query.Limit, total)
+	urls := make([]StoredURL, 0, end-start)
+	for _, record := range records[start:end] {
+		urls = append(urls, record.stored())
+	}
+	return urls, total, nil
+}
+
+
+// Returns the number of short URLs in the database.
+func (store *boltURLStore) CountURLs(ctx context.Context) (int64, error) {
+	var count int
+	boltView(ctx, store.db, func(tx *bolt.Tx) error {
+		count = tx.Bucket(boltURLsBucket).Stats().KeyN
+		return nil
+	})
+	return int64(count), nil
+}
+
+
+// Deletes the record of a short URL and returns it.
+func (store *boltURLStore) DeleteURL(ctx context.Context, sURL string) (URLExport, error) {
+	var record urlDBRecord
+	err := boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		urls := tx.Bucket(boltURLsBucket)
+		found, err := getBoltRecord(urls, []byte(sURL), &record)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return newStoreError(ErrNotFound, "no such short url")
+		}
+		if err := tx.Bucket(boltOriginalURLsBucket).Delete([]byte(record.OriginalURL)); err != nil {
+			return err
+		}
+		return urls.Delete([]byte(sURL))
+	})
+	if err != nil {
+		return URLExport{}, boltError(ctx, "DeleteURL", err, "failed when deleting from database")
+	}
+	loggerFrom(ctx).Info("URL deleted.", "short_url", sURL)
+	return exportURL(record), nil
+}
+
+
+// Creates a user with an ID in the same format as MongoDB's,
+// or fails with ErrDuplicate while returning the existing user with the same username.
+func (store *boltExerciseStore) CreateUser(ctx context.Context, uname string) (ExerciseUser, error) {
+	user := ExerciseUser{Username: uname}
+	taken := false
+	err := boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		usernames := tx.Bucket(boltUsernamesBucket)
+		if id := usernames.Get([]byte(uname)); id != nil {
+			user.ID = string(id)
+			taken = true
+			return nil
+		}
+		user.ID = primitive.NewObjectID().Hex()
+		record := ExerciseUserRecord{ID: user.ID, Username: uname, Log: []ExerciseRecord{}}
+		if err := putBoltRecord(tx.Bucket(boltUsersBucket), []byte(user.ID), record); err != nil {
+			return err
+		}
+		return usernames.Put([]byte(uname), []byte(user.ID))
+	})
+	if err != nil {
+		return ExerciseUser{}, boltError(ctx, "CreateUser", err, "unable to create or find user with username " + uname)
+	}
+	if taken {
+		return user, newStoreError(ErrDuplicate, "username " + uname + " is taken")
+	}
+	return user, nil
+}
+
+
+// Finds a user, leaving out their log.
+func (store *boltExerciseStore) GetUser(ctx context.Context, userID string) (ExerciseUser, error) {
+	if !primitive.IsValidObjectID(userID) {
+		return ExerciseUser{}, newStoreError(ErrInvalidInput, "invalid id")
+	}
+	var record ExerciseUserRecord
+	err := boltView(ctx, store.db, func(tx *bolt.Tx) error {
+		found, err := getBoltRecord(tx.Bucket(boltUsersBucket), []byte(userID), &record)
+		if err == nil && !found {
+			return newStoreError(ErrNotFound, "invalid user")
+		}
+		return err
+	})
+	if err != nil {
+		return ExerciseUser{}, boltError(ctx, "GetUser", err, "failed when searching database")
+	}
+	return ExerciseUser{ID: record.ID, Username: record.Username, ExerciseProfile: record.ExerciseProfile, Version: record.Version}, nil
+}
+
+
+// Reads the usernames bucket, which is sorted by username, seeking to the first that has the prefix
+// or scanning all of them for the substring, and only reads the records of the users that match.
+func (store *boltExerciseStore) SearchUsers(ctx context.Context, search string, substring bool, limit int) ([]ExerciseUser, error) {
+	var users []ExerciseUser
+	err := boltView(ctx, store.db, func(tx *bolt.Tx) error {
+		records := tx.Bucket(boltUsersBucket)
+		cursor := tx.Bucket(boltUsernamesBucket).Cursor()
+		k, id := cursor.Seek([]byte(search))
+		if substring {
+			k, id = cursor.First()
+		}
+		for ; k != nil && len(users) < limit; k, id = cursor.Next() {
+			if !substring && !strings.HasPrefix(string(k), search) {
+				break
+			}
+			if substring && !strings.Contains(string(k), search) {
+				continue
+			}
+			var record ExerciseUserRecord
+			found, err := getBoltRecord(records, id, &record)
+			if err != nil {
+				return err
+			}
+			if found {
+				users = append(users, ExerciseUser{ID: record.ID, Username: record.Username, ExerciseProfile: record.ExerciseProfile})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, boltError(ctx, "SearchUsers", err, "failed when searching database")
+	}
+	return users, nil
+}
+
+
+// Return the records of every user, oldest first.
+func (store *boltExerciseStore) GetAllUsers(ctx context.Context) (ExerciseUserList, error) {
+	var users ExerciseUserList
+	err := boltView(ctx, store.db, func(tx *bolt.Tx) error {
+		return tx.Bucket(boltUsersBucket).ForEach(func(k, v []byte) error {
+			var record ExerciseUserRecord
+			if err := bson.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			users = append(users, record)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, boltError(ctx, "GetAllUsers", err, "failed when reading from database")
+	}
+	return users, nil
+}
+
+
+// Passes users to fn from within a single read transaction, as ExportUsers does.
+func (store *boltExerciseStore) StreamUsers(ctx context.Context, fn func(ExerciseUserRecord) error) error {
+	var fnErr error
+	err := boltView(ctx, store.db, func(tx *bolt.Tx) error {
+		return tx.Bucket(boltUsersBucket).ForEach(func(k, v []byte) error {
+			var record ExerciseUserRecord
+			if err := bson.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			fnErr = fn(record)
+			return fnErr
+		})
+	})
+	if fnErr != nil {
+		return fnErr
+	} else if err != nil {
+		return boltError(ctx, "StreamUsers", err, "failed when reading from database")
+	}
+	return nil
+}
+
+
+// Add a single exercise to an existing user's log
+func (store *boltExerciseStore) AddExercise(ctx context.Context, userID string, newExercise ExerciseRecord) (ExerciseAddedReceipt, error) {
+	if !primitive.IsValidObjectID(userID) {
+		return ExerciseAddedReceipt{}, newStoreError(ErrInvalidInput, "invalid id")
+	}
+	// Stored dates have millisecond precision, as in MongoDB
+	newExercise.Date = newExercise.Date.Truncate(time.Millisecond)
+	newExercise.ID = primitive.NewObjectID().Hex()
+
+	var record ExerciseUserRecord
+	err := boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		users := tx.Bucket(boltUsersBucket)
+		found, err := getBoltRecord(users, []byte(userID), &record)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return newStoreError(ErrNotFound, "unknown user " + userID)
+		}
+		record.Log = append(record.Log, newExercise)
+		record.Version++
+		return putBoltRecord(users, []byte(userID), record)
+	})
+	if err != nil {
+		return ExerciseAddedReceipt{}, boltError(ctx, "AddExercise", err, "unable to add exercise to " + userID)
+	}
+
+	receipt := ExerciseAddedReceipt{
+		ID:          record.ID,
+		Username:    record.Username,
+		ExerciseID:  newExercise.ID,
+		Description: newExercise.Description,
+		Duration:    newExercise.Duration,
+		Date:        newExercise.Date,
+		Category:    newExercise.Category,
+		Tags:        newExercise.Tags,
+	}
+	return receipt, nil
+}
+
+
+// Appends exercises to a user's log in a single transaction.
+func (store *boltExerciseStore) AddExercises(ctx context.Context, userID string, exercises []ExerciseRecord) error {
+	if !primitive.IsValidObjectID(userID) {
+		return newStoreError(ErrInvalidInput, "invalid id")
+	}
+	for i := range exercises {
+		// Stored dates have millisecond precision, as in MongoDB
+		exercises[i].Date = exercises[i].Date.Truncate(time.Millisecond)
+		exercises[i].ID = primitive.NewObjectID().Hex()
+	}
+
+	err := boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		users := tx.Bucket(boltUsersBucket)
+		var record ExerciseUserRecord
+		found, err := getBoltRecord(users, []byte(userID), &record)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return newStoreError(ErrNotFound, "unknown user " + userID)
+		}
+		record.Log = append(record.Log, exercises...)
+		record.Version++
+		return putBoltRecord(users, []byte(userID), record)
+	})
+	if err != nil {
+		return boltError(ctx, "AddExercises", err, "unable to add exercises to " + userID)
+	}
+	return nil
+}
+
+
+// Return all the exercises for a specific user matching the given search criteria.
+// As with MongoDB, the log is sorted only if the filter narrows it down or sorts it.
+func (store *boltExerciseStore) GetExerciseLog(ctx context.Context, userID string, filter ExerciseLogFilter) (ExerciseUserRecord, error) {
+	if !primitive.IsValidObjectID(userID) {
+		return ExerciseUserRecord{}, newStoreError(ErrInvalidInput, "invalid id")
+	}
+	var record ExerciseUserRecord
+	err := boltView(ctx, store.db, func(tx *bolt.Tx) error {
+		found, err := getBoltRecord(tx.Bucket(boltUsersBucket), []byte(userID), &record)
+		if err == nil && !found {
+			return newStoreError(ErrNotFound, "invalid user")
+		}
+		return err
+	})
+	if err != nil {
+		return ExerciseUserRecord{}, boltError(ctx, "GetExerciseLog", err, "failed when searching database")
+	}
+
+	if !filter.narrows() {
+		count := len(record.Log)
+		record.Count, record.Total = &count, &count
+		return record, nil
+	}
+	sort.SliceStable(record.Log, func(i, j int) bool {
+		a, b := record.Log[i], record.Log[j]
+		if filter.Descending {
+			a, b = b, a
+		}
+		if filter.Sort == sortExercisesByDuration && a.Duration != b.Duration {
+			return a.Duration < b.Duration
+		}
+		return a.Date.Before(b.Date)
+	})
+	var description *regexp.Regexp
+	if len(filter.Description) > 0 {
+		if description, err = regexp.Compile("(?i)" + filter.Description); err != nil {
+			return ExerciseUserRecord{}, newStoreError(ErrInvalidInput, "invalid description search")
+		}
+	}
+	log := []ExerciseRecord{}
+	for _, exercise := range record.Log {
+		if len(filter.Category) > 0 && exercise.Category != filter.Category {
+			continue
+		}
+		if slices.ContainsFunc(filter.Tags, func(tag string) bool { return !slices.Contains(exercise.Tags, tag) }) {
+			continue
+		}
+		if description != nil && !description.MatchString(exercise.Description) {
+			continue
+		}
+		if !filter.From.IsZero() && exercise.Date.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && exercise.Date.After(filter.To) {
+			continue
+		}
+		log = append(log, exercise)
+	}
+	// Every exercise that matched counts towards the total, not only those on the page
+	total := len(log)
+	log = log[min(filter.Offset, total):]
+	if filter.Limit > 0 && len(log) > filter.Limit {
+		log = log[:filter.Limit]
+	}
+	record.Log = log
+	count := len(log)
+	record.Count, record.Total = &count, &total
+	return record, nil
+}
+
+
+// Summarizes a user's log, grouping their exercises by day and week as MongoDB does.
+func (store *boltExerciseStore) GetExerciseStats(ctx context.Context, userID string, loc *time.Location) (ExerciseStats, error) {
+	if !primitive.IsValidObjectID(userID) {
+		return ExerciseStats{}, newStoreError(ErrInvalidInput, "invalid id")
+	}
+	var record ExerciseUserRecord
+	err := boltView(ctx, store.db, func(tx *bolt.Tx) error {
+		found, err := getBoltRecord(tx.Bucket(boltUsersBucket), []byte(userID), &record)
+		if err == nil && !found {
+			return newStoreError(ErrNotFound, "invalid user")
+		}
+		return err
+	})
+	if err != nil {
+		return ExerciseStats{}, boltError(ctx, "GetExerciseStats", err, "failed when searching database")
+	}
+
+	stats := ExerciseStats{ID: record.ID, Username: record.Username}
+	weeks := make(map[string]*WeeklyExercise)
+	days := make(map[string]bool)
+	tags := make(map[string]int)
+	for _, exercise := range record.Log {
+		for _, tag := range exercise.Tags {
+			tags[tag]++
+		}
+		stats.TotalExercises++
+		stats.TotalMinutes += exercise.Duration
+		day := exerciseDay(exercise.Date, loc)
+		days[day.Format("2006-01-02")] = true
+		name := exerciseWeek(day)
+		week, ok := weeks[name]
+		if !ok {
+			week = &WeeklyExercise{Week: name}
+			weeks[name] = week
+		}
+		week.Exercises++
+		week.Minutes += exercise.Duration
+	}
+	if stats.TotalExercises > 0 {
+		stats.AverageDuration = float64(stats.TotalMinutes) / float64(stats.TotalExercises)
+	}
+	for _, name := range slices.Sorted(maps.Keys(weeks)) {
+		stats.Weeks = append(stats.Weeks, *weeks[name])
+	}
+	stats.CurrentStreak, stats.LongestStreak = exerciseStreaks(slices.Sorted(maps.Keys(days)), loc)
+	for tag, count := range tags {
+		stats.Tags = append(stats.Tags, TagCount{Tag: tag, Count: count})
+	}
+	// The most used first, as MongoDB sorts them
+	slices.SortFunc(stats.Tags, func(a, b TagCount) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return strings.Compare(a.Tag, b.Tag)
+	})
+	return stats, nil
+}
+
+
+// Ranks users by reading every log, as MongoDB does.
+func (store *boltExerciseStore) GetLeaderboard(ctx context.Context, since time.Time, by string, limit int) ([]LeaderboardEntry, error) {
+	var users []LeaderboardEntry
+	err := boltView(ctx, store.db, func(tx *bolt.Tx) error {
+		return tx.Bucket(boltUsersBucket).ForEach(func(k, v []byte) error {
+			var record ExerciseUserRecord
+			if err := bson.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			entry := LeaderboardEntry{ID: record.ID, Username: record.Username, DisplayName: record.DisplayName}
+			for _, exercise := range record.Log {
+				if !exercise.Date.Before(since) {
+					entry.Minutes += exercise.Duration
+					entry.Exercises++
+				}
+			}
+			if entry.Exercises > 0 {
+				users = append(users, entry)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, boltError(ctx, "GetLeaderboard", err, "failed when reading from database")
+	}
+
+	slices.SortFunc(users, func(a, b LeaderboardEntry) int {
+		first, second := []int{a.Minutes, a.Exercises}, []int{b.Minutes, b.Exercises}
+		if by == leaderboardByExercises {
+			first, second = []int{a.Exercises, a.Minutes}, []int{b.Exercises, b.Minutes}
+		}
+		// The most first, then by ID
+		if order := slices.Compare(second, first); order != 0 {
+			return order
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return users[:min(limit, len(users))], nil
+}
+
+
+// Returns the number of exercise users in the database.
+func (store *boltExerciseStore) CountUsers(ctx context.Context) (int64, error) {
+	var count int
+	boltView(ctx, store.db, func(tx *bolt.Tx) error {
+		count = tx.Bucket(boltUsersBucket).Stats().KeyN
+		return nil
+	})
+	return int64(count), nil
+}
+
+
+// Deletes a user along with their exercise log, returning both.
+func (store *boltExerciseStore) DeleteUser(ctx context.Context, userID string) (ExerciseUserRecord, error) {
+	if !primitive.IsValidObjectID(userID) {
+		return ExerciseUserRecord{}, newStoreError(ErrInvalidInput, "invalid id")
+	}
+	var record ExerciseUserRecord
+	err := boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		users := tx.Bucket(boltUsersBucket)
+		found, err := getBoltRecord(users, []byte(userID), &record)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return newStoreError(ErrNotFound, "unknown user " + userID)
+		}
+		if err := tx.Bucket(boltUsernamesBucket).Delete([]byte(record.Username)); err != nil {
+			return err
+		}
+		return users.Delete([]byte(userID))
+	})
+	if err != nil {
+		return ExerciseUserRecord{}, boltError(ctx, "DeleteUser", err, "failed when deleting from database")
+	}
+	loggerFrom(ctx).Info("Exercise user deleted.", "id", userID)
+	return record, nil
+}
+
+// Removes a single exercise from a user's log.
+func (store *boltExerciseStore) DeleteExercise(ctx context.Context, userID string, exerciseID string) error {
+	if !primitive.IsValidObjectID(userID) {
+		return newStoreError(ErrInvalidInput, "invalid id")
+	}
+	if !primitive.IsValidObjectID(exerciseID) {
+		return newStoreError(ErrInvalidInput, "invalid exercise id")
+	}
+	err := boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		users := tx.Bucket(boltUsersBucket)
+		var record ExerciseUserRecord
+		found, err := getBoltRecord(users, []byte(userID), &record)
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(record.Log, func(exercise ExerciseRecord) bool {
+			return exercise.ID == exerciseID
+		})
+		if !found || i < 0 {
+			return newStoreError(ErrNotFound, "unknown exercise " + exerciseID + " for user " + userID)
+		}
+		record.Log = slices.Delete(record.Log, i, i+1)
+		record.Version++
+		return putBoltRecord(users, []byte(userID), record)
+	})
+	if err != nil {
+		return boltError(ctx, "DeleteExercise", err, "failed when updating database")
+	}
+	loggerFrom(ctx).Info("Exercise deleted.", "id", userID, "exercise_id", exerciseID)
+	return nil
+}
+
+// Changes a single exercise in a user's log and returns it as it now is.
+func (store *boltExerciseStore) UpdateExercise(ctx context.Context, userID string, exerciseID string, update ExerciseUpdate) (ExerciseRecord, error) {
+	if !primitive.IsValidObjectID(userID) {
+		return ExerciseRecord{}, newStoreError(ErrInvalidInput, "invalid id")
+	}
+	if !primitive.IsValidObjectID(exerciseID) {
+		return ExerciseRecord{}, newStoreError(ErrInvalidInput, "invalid exercise id")
+	}
+	var exercise ExerciseRecord
+	err := boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		users := tx.Bucket(boltUsersBucket)
+		var record ExerciseUserRecord
+		found, err := getBoltRecord(users, []byte(userID), &record)
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(record.Log, func(exercise ExerciseRecord) bool {
+			return exercise.ID == exerciseID
+		})
+		if !found || i < 0 {
+			return newStoreError(ErrNotFound, "unknown exercise " + exerciseID + " for user " + userID)
+		}
+		if update.Description != nil {
+			record.Log[i].Description = *update.Description
+		}
+		if update.Duration != nil {
+			record.Log[i].Duration = *update.Duration
+		}
+		if update.Date != nil {
+			// Stored dates have millisecond precision, as in MongoDB
+			record.Log[i].Date = update.Date.Truncate(time.Millisecond)
+		}
+		exercise = record.Log[i]
+		record.Version++
+		return putBoltRecord(users, []byte(userID), record)
+	})
+	if err != nil {
+		return ExerciseRecord{}, boltError(ctx, "UpdateExercise", err, "failed when updating database")
+	}
+	return exercise, nil
+}
+
+
+// Changes a user's profile, returning the user without their log.
+func (store *boltExerciseStore) UpdateUserProfile(ctx context.Context, userID string, update ExerciseProfileUpdate, version int64) (ExerciseUser, error) {
+	if !primitive.IsValidObjectID(userID) {
+		return ExerciseUser{}, newStoreError(ErrInvalidInput, "invalid id")
+	}
+	var user ExerciseUser
+	err := boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		users := tx.Bucket(boltUsersBucket)
+		var record ExerciseUserRecord
+		found, err := getBoltRecord(users, []byte(userID), &record)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return newStoreError(ErrNotFound, "unknown user " + userID)
+		}
+		if version != anyVersion {
+			if err := checkBoltVersion(record.Version, version); err != nil {
+				return err
+			}
+		}
+		record.ExerciseProfile.apply(update)
+		record.Version++
+		user = ExerciseUser{ID: record.ID, Username: record.Username, ExerciseProfile: record.ExerciseProfile, Version: record.Version}
+		return putBoltRecord(users, []byte(userID), record)
+	})
+	if err != nil {
+		return ExerciseUser{}, boltError(ctx, "UpdateUserProfile", err, "failed when updating database")
+	}
+	return user, nil
+}
+
+
+// Moves the user's entry in the usernames bucket to the new username along with the record.
+func (store *boltExerciseStore) RenameUser(ctx context.Context, userID string, username string, version int64) (ExerciseUser, error) {
+	if !primitive.IsValidObjectID(userID) {
+		return ExerciseUser{}, newStoreError(ErrInvalidInput, "invalid id")
+	}
+	var user ExerciseUser
+	err := boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		users := tx.Bucket(boltUsersBucket)
+		usernames := tx.Bucket(boltUsernamesBucket)
+		var record ExerciseUserRecord
+		found, err := getBoltRecord(users, []byte(userID), &record)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return newStoreError(ErrNotFound, "unknown user " + userID)
+		}
+		if version != anyVersion {
+			if err := checkBoltVersion(record.Version, version); err != nil {
+				return err
+			}
+		}
+		if id := usernames.Get([]byte(username)); id != nil && string(id) != userID {
+			return newStoreError(ErrDuplicate, "username " + username + " is taken")
+		}
+		if err := usernames.Delete([]byte(record.Username)); err != nil {
+			return err
+		}
+		if err := usernames.Put([]byte(username), []byte(userID)); err != nil {
+			return err
+		}
+		record.Username = username
+		record.Version++
+		user = ExerciseUser{ID: record.ID, Username: record.Username, ExerciseProfile: record.ExerciseProfile, Version: record.Version}
+		return putBoltRecord(users, []byte(userID), record)
+	})
+	if err != nil {
+		return ExerciseUser{}, boltError(ctx, "RenameUser", err, "failed when updating database")
+	}
+	return user, nil
+}
+
+
+// Generates a new key and stores its hash.
+func (store *boltAPIKeyStore) CreateAPIKey(ctx context.Context, name string, scopes []string, dailyQuota int) (NewAPIKey, error) {
+	newKey, err := generateAPIKey(ctx, name, scopes, dailyQuota)
+	if err != nil {
+		return NewAPIKey{}, err
+	}
+	newKey.ID = primitive.NewObjectID()
+	id := []byte(newKey.ID.Hex())
+
+	err = boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		if err := putBoltRecord(tx.Bucket(boltAPIKeysBucket), id, newKey.APIKey); err != nil {
+			return err
+		}
+		return tx.Bucket(boltAPIKeyHashesBucket).Put([]byte(newKey.KeyHash), id)
+	})
+	if err != nil {
+		return NewAPIKey{}, boltError(ctx, "CreateAPIKey", err, "failed when inserting into database")
+	}
+	loggerFrom(ctx).Info("API key created.", "id", newKey.ID, "name", name, "scopes", scopes)
+	return newKey, nil
+}
+
+
+// Returns every key, newest first.
+// Keys are stored by ID, and IDs start with their creation time.
+func (store *boltAPIKeyStore) GetAllAPIKeys(ctx context.Context) ([]APIKey, error) {
+	keys := []APIKey{}
+	err := boltView(ctx, store.db, func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(boltAPIKeysBucket).Cursor()
+		for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+			var record APIKey
+			if err := bson.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			keys = append(keys, record)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, boltError(ctx, "GetAllAPIKeys", err, "failed when reading from database")
+	}
+	return keys, nil
+}
+
+
+// Marks a key as revoked so that it is no longer accepted.
+func (store *boltAPIKeyStore) RevokeAPIKey(ctx context.Context, id string) error {
+	if !primitive.IsValidObjectID(id) {
+		return newStoreError(ErrInvalidInput, "invalid id")
+	}
+	err := boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		keys := tx.Bucket(boltAPIKeysBucket)
+		var record APIKey
+		found, err := getBoltRecord(keys, []byte(id), &record)
+		if err != nil {
+			return err
+		}
+		if !found || record.RevokedAt != nil {
+			return newStoreError(ErrNotFound, "no such active key")
+		}
+		now := time.Now().UTC()
+		record.RevokedAt = &now
+		return putBoltRecord(keys, []byte(id), record)
+	})
+	if err != nil {
+		return boltError(ctx, "RevokeAPIKey", err, "failed when updating database")
+	}
+	loggerFrom(ctx).Info("API key revoked.", "id", id)
+	return nil
+}
+
+
+// Looks up a key and, if it is active and hasn't used up today's quota,
+// counts one more use of it. Returns the key's record after the update.
+func (store *boltAPIKeyStore) UseAPIKey(ctx context.Context, key string) (APIKey, error) {
+	today := quotaDay(time.Now())
+	var record APIKey
+	var quotaErr error
+	err := boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		id := tx.Bucket(boltAPIKeyHashesBucket).Get([]byte(hashAPIKey(key)))
+		if id == nil {
+			return errInvalidAPIKey
+		}
+		keys := tx.Bucket(boltAPIKeysBucket)
+		found, err := getBoltRecord(keys, id, &record)
+		if err != nil {
+			return err
+		}
+		if !found || record.RevokedAt != nil {
+			return errInvalidAPIKey
+		}
+
+		if record.UsageDay != today {
+			record.UsageDay = today
+			record.UsageCount = 0
+		}
+		if record.DailyQuota > 0 && record.UsageCount >= record.DailyQuota {
+			// Report the error without rolling back, as nothing was changed
+			quotaErr = errQuotaExceeded
+			return nil
+		}
+		record.UsageCount++
+		return putBoltRecord(keys, id, record)
+	})
+	if err != nil {
+		return APIKey{}, boltError(ctx, "UseAPIKey", err, "failed when updating database")
+	}
+	return record, quotaErr
+}
+
+
+// Passes every short URL to fn, ordered by their keys rather than by when they were created.
+// fn is called inside a read transaction, which doesn't block writers.
+func (store *boltURLStore) ExportURLs(ctx context.Context, fn func(URLExport) error) error {
+	var fnErr error
+	err := boltView(ctx, store.db, func(tx *bolt.Tx) error {
+		return tx.Bucket(boltURLsBucket).ForEach(func(k, v []byte) error {
+			var record urlDBRecord
+			if err := bson.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			fnErr = fn(exportURL(record))
+			return fnErr
+		})
+	})
+	if fnErr != nil {
+		return fnErr
+	} else if err != nil {
+		return boltError(ctx, "ExportURLs", err, "failed when reading from database")
+	}
+	return nil
+}
+
+
+// Passes every user to fn along with their whole log, in the order in which they were created.
+// fn is called inside a read transaction, which doesn't block writers.
+func (store *boltExerciseStore) ExportUsers(ctx context.Context, fn func(ExerciseUserExport) error) error {
+	var fnErr error
+	err := boltView(ctx, store.db, func(tx *bolt.Tx) error {
+		return tx.Bucket(boltUsersBucket).ForEach(func(k, v []byte) error {
+			var record ExerciseUserRecord
+			if err := bson.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			fnErr = fn(exportUser(record))
+			return fnErr
+		})
+	})
+	if fnErr != nil {
+		return fnErr
+	} else if err != nil {
+		return boltError(ctx, "ExportUsers", err, "failed when reading from database")
+	}
+	return nil
+}
+
+
+// Restores exported short URLs in a single transaction, recording an error for each
+// that clashes instead of failing the others. The sequence behind new short URLs
+// is moved past any imported ones, so that InsertURL doesn't hand them out again.
+func (store *boltURLStore) ImportURLs(ctx context.Context, urls []URLExport, replace bool) ([]error, error) {
+	var results []error
+	err := boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		results = make([]error, len(urls))
+		bucket := tx.Bucket(boltURLsBucket)
+		originals := tx.Bucket(boltOriginalURLsBucket)
+		for i, u := range urls {
+			var existing urlDBRecord
+			found, err := getBoltRecord(bucket, []byte(u.ShortURL), &existing)
+			if err != nil {
+				return err
+			}
+			if found && !replace {
+				results[i] = newStoreError(ErrDuplicate, "short url " + u.ShortURL + " already exists")
+				continue
+			}
+			if other := originals.Get([]byte(u.OriginalURL)); other != nil && string(other) != u.ShortURL {
+				results[i] = newStoreError(ErrDuplicate, "original url " + u.OriginalURL + " already has a short url")
+				continue
+			}
+
+			if found {
+				if err := originals.Delete([]byte(existing.OriginalURL)); err != nil {
+					return err
+				}
+			}
+			if err := putBoltRecord(bucket, []byte(u.ShortURL), importURL(u)); err != nil {
+				return err
+			}
+			if err := originals.Put([]byte(u.OriginalURL), []byte(u.ShortURL)); err != nil {
+				return err
+			}
+			if n, err := strconv.ParseUint(u.ShortURL, 36, 64); err == nil && n >= bucket.Sequence() {
+				if err := bucket.SetSequence(n + 1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, boltError(ctx, "ImportURLs", err, "failed when writing to database")
+	}
+	return results, nil
+}
+
+
+// Restores exported users in a single transaction, recording an error for each
+// that clashes instead of failing the others.
+func (store *boltExerciseStore) ImportUsers(ctx context.Context, users []ExerciseUserExport, replace bool) ([]error, error) {
+	var results []error
+	err := boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		results = make([]error, len(users))
+		bucket := tx.Bucket(boltUsersBucket)
+		usernames := tx.Bucket(boltUsernamesBucket)
+		for i, u := range users {
+			var existing ExerciseUserRecord
+			found, err := getBoltRecord(bucket, []byte(u.ID), &existing)
+			if err != nil {
+				return err
+			}
+			if found && !replace {
+				results[i] = newStoreError(ErrDuplicate, "user " + u.ID + " already exists")
+				continue
+			}
+			if other := usernames.Get([]byte(u.Username)); other != nil && string(other) != u.ID {
+				results[i] = newStoreError(ErrDuplicate, "username " + u.Username + " belongs to another user")
+				continue
+			}
+
+			if found {
+				if err := usernames.Delete([]byte(existing.Username)); err != nil {
+					return err
+				}
+			}
+			record := ExerciseUserRecord{ID: u.ID, Username: u.Username, ExerciseProfile: u.ExerciseProfile, Log: u.Log, Version: u.Version}
+			// Stored dates have millisecond precision, as in MongoDB
+			for j := range record.Log {
+				record.Log[j].Date = record.Log[j].Date.Truncate(time.Millisecond)
+			}
+			if err := putBoltRecord(bucket, []byte(u.ID), record); err != nil {
+				return err
+			}
+			if err := usernames.Put([]byte(u.Username), []byte(u.ID)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, boltError(ctx, "ImportUsers", err, "failed when writing to database")
+	}
+	return results, nil
+}
+
+
+// Deletes the short URLs that haven't been visited since the cutoff,
+// or that were created before it and never visited, and returns them.
+func (store *boltURLStore) PruneURLs(ctx context.Context, cutoff time.Time, dryRun bool) ([]string, error) {
+	var pruned []string
+	err := boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		urls := tx.Bucket(boltURLsBucket)
+		var stale []urlDBRecord
+		err := urls.ForEach(func(k, v []byte) error {
+			var record urlDBRecord
+			if err := bson.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			lastActive := record.LastVisitedAt
+			if lastActive.IsZero() {
+				lastActive = record.CreatedAt
+			}
+			if !lastActive.IsZero() && lastActive.Before(cutoff) {
+				stale = append(stale, record)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		pruned = make([]string, len(stale))
+		for i, record := range stale {
+			pruned[i] = record.ShortURL
+			if dryRun {
+				continue
+			}
+			if err := tx.Bucket(boltOriginalURLsBucket).Delete([]byte(record.OriginalURL)); err != nil {
+				return err
+			}
+			if err := urls.Delete([]byte(record.ShortURL)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, boltError(ctx, "PruneURLs", err, "failed when deleting from database")
+	}
+	return pruned, nil
+}
+
+
+// Removes the exercises dated before the cutoff from every log
+// and returns how many there were.
+func (store *boltExerciseStore) PruneExercises(ctx context.Context, cutoff time.Time, dryRun bool) (int64, error) {
+	var count int64
+	err := boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		users := tx.Bucket(boltUsersBucket)
+		var changed []ExerciseUserRecord
+		err := users.ForEach(func(k, v []byte) error {
+			var record ExerciseUserRecord
+			if err := bson.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			kept := record.Log[:0]
+			for _, exercise := range record.Log {
+				if exercise.Date.Before(cutoff) {
+					count++
+				} else {
+					kept = append(kept, exercise)
+				}
+			}
+			if len(kept) < len(record.Log) {
+				record.Log = kept
+				record.Version++
+				changed = append(changed, record)
+			}
+			return nil
+		})
+		if err != nil || dryRun {
+			return err
+		}
+		for _, record := range changed {
+			if err := putBoltRecord(users, []byte(record.ID), record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, boltError(ctx, "PruneExercises", err, "failed when updating database")
+	}
+	return count, nil
+}
+
+
+// Adds a batch of clicks in one transaction.
+func (store *boltClickStore) RecordClicks(ctx context.Context, clicks []ClickEvent) error {
+	if len(clicks) == 0 {
+		return nil
+	}
+	err := boltUpdate(ctx, store.db, func(tx *bolt.Tx) error {
+		buckets := tx.Bucket(boltClicksBucket)
+		for _, click := range clicks {
+			bucket, err := buckets.CreateBucketIfNotExists([]byte(click.ShortURL))
+			if err != nil {
+				return err
+			}
+			seq, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			// Stored dates have millisecond precision, as in MongoDB
+			click.Time = click.Time.UTC().Truncate(time.Millisecond)
+			if err := putBoltRecord(bucket, binary.BigEndian.AppendUint64(nil, seq), click); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return boltError(ctx, "RecordClicks", err, "failed when inserting into database")
+	}
+	return nil
+}
+
+
+// Summarizes the clicks on a short URL since the given time.
+// Clicks are kept in the order they were recorded, so only the ones since then are read,
+// newest first. Days are only listed if they had clicks.
+func (store *boltClickStore) GetClickStats(ctx context.Context, shortURL string, since time.Time) (ClickStats, error) {
+	stats := ClickStats{ShortURL: shortURL, Since: since}
+	days := make(map[string]int64)
+	referrers := make(map[string]int64)
+	countries := make(map[string]int64)
+	visitors := make(map[string]bool)
+
+	err := boltView(ctx, store.db, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltClicksBucket).Bucket([]byte(shortURL))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+			var click ClickEvent
+			if err := bson.Unmarshal(v, &click); err != nil {
+				return err
+			}
+			if click.Time.Before(since) {
+				break
+			}
+			stats.Total++
+			days[click.Time.UTC().Format("2006-01-02")]++
+			referrers[click.Referrer]++
+			countries[click.Country]++
+			visitors[click.IPHash] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return ClickStats{}, boltError(ctx, "GetClickStats", err, "failed when reading from database")
+	}
+
+	stats.UniqueVisitors = int64(len(visitors))
+	for date, count := range days {
+		stats.Days = append(stats.Days, DailyClicks{Date: date, Count: count})
+	}
+	sort.Slice(stats.Days, func(i, j int) bool {
+		return stats.Days[i].Date < stats.Days[j].Date
+	})
+	for referrer, count := range referrers {
+		stats.TopReferrers = append(stats.TopReferrers, ReferrerClicks{Referrer: referrer, Count: count})
+	}
+	sort.Slice(stats.TopReferrers, func(i, j int) bool {
+		a, b := stats.TopReferrers[i], stats.TopReferrers[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Referrer < b.Referrer
+	})
+	if len(stats.TopReferrers) > topReferrersLimit {
+		stats.TopReferrers = stats.TopReferrers[:topReferrersLimit]
+	}
+	for country, count := range countries {
+		stats.Countries = append(stats.Countries, CountryClicks{Country: country, Count: count})
+	}
+	sort.Slice(stats.Countries, func(i, j int) bool {
+		a, b := stats.Countries[i], stats.Countries[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Country < b.Country
+	})
+	return stats, nil
+}
+
+
+// Passes the clicks since the given time to fn. Short URLs' buckets are named after them,
+// so they are read in order, and the clicks in each are in the order they were recorded.
+// fn is called inside a read transaction, which doesn't block writers.
+func (store *boltClickStore) ExportClicks(ctx context.Context, shortURL string, since time.Time, fn func(ClickEvent) error) error {
+	var fnErr error
+	exportBucket := func(bucket *bolt.Bucket) error {
+		return bucket.ForEach(func(k, v []byte) error {
+			var click ClickEvent
+			if err := bson.Unmarshal(v, &click); err != nil {
+				return err
+			}
+			if click.Time.Before(since) {
+				return nil
+			}
+			fnErr = fn(click)
+			return fnErr
+		})
+	}
+	err := boltView(ctx, store.db, func(tx *bolt.Tx) error {
+		buckets := tx.Bucket(boltClicksBucket)
+		if len(shortURL) > 0 {
+			if bucket := buckets.Bucket([]byte(shortURL)); bucket != nil {
+				return exportBucket(bucket)
+			}
+			return nil
+		}
+		return buckets.ForEachBucket(func(k []byte) error {
+			return exportBucket(buckets.Bucket(k))
+		})
+	})
+	if fnErr != nil {
+		return fnErr
+	} else if err != nil {
+		return boltError(ctx, "ExportClicks", err, "failed when reading from database")
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+// Shortens many URLs in one request, for clients that would otherwise
+// make a round trip for each of them.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The outcome for one URL of a bulk request: either the short URL it was given,
+// or why it wasn't shortened.
+type BulkURLResult struct {
+	// The URL as it was sent
+	URL         string     `json:"url"`
+	OriginalURL string     `json:"original_url,omitempty"`
+	ShortURL    string     `json:"short_url,omitempty"`
+	// Missing if the short URL never expires
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	Code        int        `json:"code,omitempty"`
+}
+
+// The most URLs that can be shortened in one request, set by initBulkURLs
+var maxBulkURLs = 100
+
+
+// Reads how many URLs can be shortened at once from SHORTURL_BULK_MAX (default 100).
+func initBulkURLs() {
+	limit := getEnvInt("SHORTURL_BULK_MAX", 100)
+	if limit < 1 {
+		slog.Warn("SHORTURL_BULK_MAX must be at least 1, so using the default.", "value", limit)
+		return
+	}
+	maxBulkURLs = int(limit)
+}
+
+
+// Shortens each URL in a JSON array such as ["https://example.com", "example.org"]
+// and sends back an array with the result for each, in the same order.
+// A URL that fails validation doesn't stop the others from being shortened.
+// They are inserted together, and each one counts towards the client's daily quota.
+// URLs aren't probed, as that would take too long for a whole batch.
+func postBulkShortURLs(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFrom(r.Context())
+
+	var rawURLs []string
+	if err := json.NewDecoder(r.Body).Decode(&rawURLs); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeError(w, r, errBodyTooLarge)
+		} else {
+			writeError(w, r, newErrorMessage(http.StatusBadRequest, "body must be a json array of urls"))
+		}
+		return
+	}
+	if len(rawURLs) == 0 {
+		writeError(w, r, newErrorMessage(http.StatusBadRequest, "at least one url is required"))
+		return
+	}
+	if len(rawURLs) > maxBulkURLs {
+		writeError(w, r, newErrorMessage(http.StatusBadRequest, "at most " + strconv.Itoa(maxBulkURLs) + " urls can be shortened at once"))
+		return
+	}
+
+	results := make([]BulkURLResult, len(rawURLs))
+	fail := func(i int, err error) {
+		errMsg := toErrorMessage(err)
+		results[i].Error, results[i].Code = errMsg.Content, errMsg.Code
+	}
+	owner := requestOwner(r)
+	var newURLs []NewURL
+	var indexes []int
+	for i, rawURL := range rawURLs {
+		results[i].URL = rawURL
+		originalURL, err := validateURL(r.Context(), rawURL)
+		if err != nil {
+			fail(i, err)
+			continue
+		}
+		newURLs = append(newURLs, NewURL{OriginalURL: originalURL, Owner: owner})
+		indexes = append(indexes, i)
+	}
+
+	if len(newURLs) > 0 {
+		if !takeCreationQuota(w, r, len(newURLs)) {
+			return
+		}
+		receipts, errs, err := urlStore.InsertURLs(r.Context(), newURLs)
+		if err != nil {
+			giveBackCreationQuota(r, len(newURLs))
+			writeError(w, r, err)
+			return
+		}
+		failed := 0
+		for j, i := range indexes {
+			if errs[j] != nil {
+				failed++
+				fail(i, errs[j])
+				continue
+			}
+			results[i].OriginalURL = receipts[j].OriginalURL
+			results[i].ShortURL = receipts[j].ShortURL
+			results[i].ExpiresAt = receipts[j].ExpiresAt
+		}
+		giveBackCreationQuota(r, failed)
+	}
+
+	logger.Info("Shortened URLs in bulk.", "urls", len(rawURLs), "valid", len(newURLs))
+	writeJSON(w, http.StatusOK, results)
+}
@@ -0,0 +1,210 @@
+// Handles the MongoDB operations for click analytics.
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	"time"
+)
+
+// How many referrers are listed in click statistics
+const topReferrersLimit = 10
+
+// A single visit to a short URL.
+// The visitor's IP address is only kept as a keyed hash, so that unique visitors
+// can be counted without storing who they are.
+type ClickEvent struct {
+	ShortURL  string    `bson:"short_url"`
+	Time      time.Time `bson:"time"`
+	// Missing if the visitor's browser didn't send one
+	Referrer  string    `bson:"referrer,omitempty"`
+	UserAgent string    `bson:"user_agent,omitempty"`
+	IPHash    string    `bson:"ip_hash"`
+	// Where the visitor was, if there is a GeoIP database and it knew.
+	// The country is an ISO 3166-1 code, e.g. "GB".
+	Country   string    `bson:"country,omitempty"`
+	City      string    `bson:"city,omitempty"`
+}
+
+// A summary of the clicks on a short URL over a number of days.
+type ClickStats struct {
+	XMLName        xml.Name         `json:"-" xml:"clicks"`
+	ShortURL       string           `json:"short_url" xml:"short_url"`
+	Since          time.Time        `json:"since" xml:"since"`
+	Total          int64            `json:"total" xml:"total"`
+	UniqueVisitors int64            `json:"unique_visitors" xml:"unique_visitors"`
+	// One entry for every day, oldest first, including days without clicks
+	Days           []DailyClicks    `json:"days" xml:"day"`
+	// The most common first. An empty referrer stands for visits without one.
+	TopReferrers   []ReferrerClicks `json:"top_referrers" xml:"referrer"`
+	// Every country that there were clicks from, the most common first.
+	// An empty country stands for visits from unknown places.
+	Countries      []CountryClicks  `json:"countries" xml:"country"`
+}
+
+type DailyClicks struct {
+	Date  string `json:"date" bson:"_id" xml:"date"`
+	Count int64  `json:"count" bson:"count" xml:"count"`
+}
+
+type ReferrerClicks struct {
+	Referrer string `json:"referrer" bson:"_id" xml:"url"`
+	Count    int64  `json:"count" bson:"count" xml:"count"`
+}
+
+type CountryClicks struct {
+	Country string `json:"country" bson:"_id" xml:"code"`
+	Count   int64  `json:"count" bson:"count" xml:"count"`
+}
+
+
+// Keeps clicks in a MongoDB collection, one document per click.
+type mongoClickStore struct {
+	collection *mongo.Collection
+	// The same collection with the read preference for read-heavy operations
+	reads      *mongo.Collection
+}
+
+
+// Get a pointer to the click collection, which is named by COLLECTION_A
+// (default "clicks"), and make sure a short URL's clicks can be found by time.
+func newMongoClickStore(db *mongo.Database) *mongoClickStore {
+	slog.Info("Getting reference to click collection.")
+	collection := db.Collection(getEnv("COLLECTION_A", "clicks"))
+	if err := ensureCompoundIndex(collection, "short_url", "time"); err != nil {
+		slog.Error("Failed to create index on click collection, so click statistics will be slow.", "err", err)
+	}
+	return &mongoClickStore{collection: collection, reads: forReads(collection)}
+}
+
+
+// Inserts a batch of clicks. The batch isn't retried, as that could count some clicks twice.
+func (store *mongoClickStore) RecordClicks(ctx context.Context, clicks []ClickEvent) error {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	if len(clicks) == 0 {
+		return nil
+	}
+	docs := make([]any, len(clicks))
+	for i, click := range clicks {
+		docs[i] = click
+	}
+	err := retryDB(ctx, "RecordClicks", false, func() error {
+		_, err := store.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+		return err
+	})
+	if err != nil {
+		loggerFrom(ctx).Error("Collection.InsertMany failed", "func", "RecordClicks", "err", err)
+		return newStoreError(ErrStorage, "failed when inserting into database")
+	}
+	return nil
+}
+
+
+// Summarizes the clicks on a short URL since the given time in a single aggregation.
+// Days are only listed if they had clicks.
+func (store *mongoClickStore) GetClickStats(ctx context.Context, shortURL string, since time.Time) (ClickStats, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	funcName := "GetClickStats"
+
+	count := bson.M{"$sum": 1}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"short_url": shortURL, "time": bson.M{"$gte": since}}}},
+		{{Key: "$facet", Value: bson.M{
+			"total": bson.A{bson.M{"$count": "n"}},
+			"visitors": bson.A{bson.M{"$group": bson.M{"_id": "$ip_hash"}}, bson.M{"$count": "n"}},
+			"days": bson.A{
+				bson.M{"$group": bson.M{
+					"_id": bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$time"}},
+					"count": count,
+				}},
+				bson.M{"$sort": bson.M{"_id": 1}},
+			},
+			"referrers": bson.A{
+				bson.M{"$group": bson.M{"_id": bson.M{"$ifNull": bson.A{"$referrer", ""}}, "count": count}},
+				bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+				bson.M{"$limit": topReferrersLimit},
+			},
+			"countries": bson.A{
+				bson.M{"$group": bson.M{"_id": bson.M{"$ifNull": bson.A{"$country", ""}}, "count": count}},
+				bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			},
+		}}},
+	}
+
+	var results []struct {
+		Total     []struct{ N int64 `bson:"n"` } `bson:"total"`
+		Visitors  []struct{ N int64 `bson:"n"` } `bson:"visitors"`
+		Days      []DailyClicks                  `bson:"days"`
+		Referrers []ReferrerClicks               `bson:"referrers"`
+		Countries []CountryClicks                `bson:"countries"`
+	}
+	err := retryDB(ctx, funcName, true, func() error {
+		cursor, err := store.reads.Aggregate(ctx, pipeline)
+		if err != nil {
+			return err
+		}
+		results = nil
+		return cursor.All(ctx, &results)
+	})
+	if err != nil {
+		loggerFrom(ctx).Error("Collection.Aggregate failed", "func", funcName, "err", err)
+		return ClickStats{}, newStoreError(ErrStorage, "failed when searching database")
+	}
+
+	stats := ClickStats{ShortURL: shortURL, Since: since}
+	if len(results) == 0 {
+		return stats, nil
+	}
+	result := results[0]
+	if len(result.Total) > 0 {
+		stats.Total = result.Total[0].N
+	}
+	if len(result.Visitors) > 0 {
+		stats.UniqueVisitors = result.Visitors[0].N
+	}
+	stats.Days = result.Days
+	stats.TopReferrers = result.Referrers
+	stats.Countries = result.Countries
+	return stats, nil
+}
+
+
+// Passes the clicks since the given time to fn, which the index on short_url and time
+// returns in order. As with ExportURLs, the export isn't subject to DB_OP_TIMEOUT
+// and isn't retried.
+func (store *mongoClickStore) ExportClicks(ctx context.Context, shortURL string, since time.Time, fn func(ClickEvent) error) error {
+	logger := loggerFrom(ctx)
+	filter := bson.M{"time": bson.M{"$gte": since}}
+	if len(shortURL) > 0 {
+		filter["short_url"] = shortURL
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "short_url", Value: 1}, {Key: "time", Value: 1}})
+	cursor, err := store.reads.Find(ctx, filter, opts)
+	if err != nil {
+		logger.Error("Collection.Find failed", "func", "ExportClicks", "err", err)
+		return newStoreError(ErrStorage, "failed when reading from database")
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var click ClickEvent
+		if err := cursor.Decode(&click); err != nil {
+			logger.Error("Cursor.Decode failed", "func", "ExportClicks", "err", err)
+			return newStoreError(ErrStorage, "failed when reading from database")
+		}
+		if err := fn(click); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		logger.Error("Cursor.Next failed", "func", "ExportClicks", "err", err)
+		return newStoreError(ErrStorage, "failed when reading from database")
+	}
+	return nil
+}
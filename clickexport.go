@@ -0,0 +1,215 @@
+// Exports clicks on short URLs as CSV, so that they can be analysed
+// in a spreadsheet rather than only through the summaries of the stats API.
+package main
+
+import (
+	"encoding/csv"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The kinds of click export, chosen by the "type" query parameter
+const (
+	// One row for every click
+	clickExportClicks = "clicks"
+	// One row for every short URL and day
+	clickExportDaily = "daily"
+)
+
+var errInvalidClickExportType = newErrorMessage(http.StatusBadRequest, "type must be clicks or daily")
+
+var (
+	clickExportColumns = []string{"short_url", "time", "referrer", "user_agent", "country", "city", "visitor"}
+	dailyExportColumns = []string{"short_url", "date", "clicks", "unique_visitors"}
+)
+
+// Adds up the clicks that an export passes it into a row for each day,
+// one short URL at a time, which works because the clicks come ordered by short URL.
+type dailyClickRows struct {
+	write func([]string) error
+	// Every day of the export, oldest first
+	dates []string
+
+	shortURL string
+	clicks   map[string]int64
+	visitors map[string]map[string]bool
+}
+
+
+// Sends the clicks on the short URL in the path as CSV, either one row for each click
+// or, with "type=daily", one row for each day with its clicks and unique visitors.
+// The "days" parameter chooses how many days, including today, are exported (default 30, at most 365).
+// Only the short URL's owner or an admin may export them.
+func getShortURLClickExport(w http.ResponseWriter, r *http.Request) {
+	stored, err := authorizeShortURL(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	exportClicksCSV(w, r, stored.ShortURL)
+}
+
+
+// Sends the clicks on every short URL as CSV, as getShortURLClickExport does for one of them.
+func getAdminClickExport(w http.ResponseWriter, r *http.Request) {
+	exportClicksCSV(w, r, "")
+}
+
+
+// Streams the clicks on the short URL, or on every short URL if it is empty,
+// in the form that the "type" and "days" query parameters ask for.
+// As with streamExport, the response is cut off if the store fails partway.
+func exportClicksCSV(w http.ResponseWriter, r *http.Request, shortURL string) {
+	logger := loggerFrom(r.Context())
+	q := r.URL.Query()
+	days, err := parseClickDays(q.Get("days"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	kind := q.Get("type")
+	if len(kind) == 0 {
+		kind = clickExportClicks
+	} else if kind != clickExportClicks && kind != clickExportDaily {
+		writeError(w, r, errInvalidClickExportType)
+		return
+	}
+	since := clicksSince(days)
+
+	// A large export can take longer than the server's write timeout allows
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		logger.Warn("Unable to clear write deadline.", "err", err)
+	}
+
+	name := "clicks"
+	if kind == clickExportDaily {
+		name = "daily-clicks"
+	}
+	if len(shortURL) > 0 {
+		name = shortURL + "-" + name
+	}
+	filename := name + "-" + time.Now().UTC().Format("20060102T150405Z") + ".csv"
+	columns := clickExportColumns
+	if kind == clickExportDaily {
+		columns = dailyExportColumns
+	}
+
+	// Nothing is sent until the first row is, so that the store failing before then can still be reported
+	writer := csv.NewWriter(w)
+	started := false
+	start := func() error {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+		w.WriteHeader(http.StatusOK)
+		started = true
+		return writer.Write(columns)
+	}
+	write := func(row []string) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		return writer.Write(row)
+	}
+
+	rows := 0
+	if kind == clickExportClicks {
+		err = clickStore.ExportClicks(r.Context(), shortURL, since, func(click ClickEvent) error {
+			rows++
+			return write([]string{
+				click.ShortURL,
+				click.Time.UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+				csvCell(click.Referrer),
+				csvCell(click.UserAgent),
+				click.Country,
+				csvCell(click.City),
+				click.IPHash,
+			})
+		})
+	} else {
+		daily := &dailyClickRows{write: write}
+		for i := range days {
+			daily.dates = append(daily.dates, since.AddDate(0, 0, i).Format("2006-01-02"))
+		}
+		err = clickStore.ExportClicks(r.Context(), shortURL, since, func(click ClickEvent) error {
+			rows++
+			return daily.add(click)
+		})
+		// A single short URL gets its days even if it had no clicks
+		if err == nil && len(daily.shortURL) == 0 {
+			daily.shortURL = shortURL
+		}
+		if err == nil && len(daily.shortURL) > 0 {
+			err = daily.flush()
+		}
+	}
+	if err == nil && !started {
+		// There was nothing to export
+		err = start()
+	}
+	if err == nil {
+		writer.Flush()
+		err = writer.Error()
+	}
+	if err != nil && !started {
+		writeError(w, r, err)
+		return
+	} else if err != nil {
+		logger.Error("Export failed partway.", "kind", name, "exported", rows, "err", err)
+		panic(http.ErrAbortHandler)
+	}
+	logger.Info("Exported clicks.", "kind", name, "short_url", shortURL, "days", days, "exported", rows)
+}
+
+
+// Counts the click towards its short URL and day, first writing the rows
+// of the previous short URL if this is the first click on another one.
+func (d *dailyClickRows) add(click ClickEvent) error {
+	if click.ShortURL != d.shortURL {
+		if len(d.shortURL) > 0 {
+			if err := d.flush(); err != nil {
+				return err
+			}
+		}
+		d.shortURL = click.ShortURL
+	}
+	if d.clicks == nil {
+		d.clicks = make(map[string]int64)
+		d.visitors = make(map[string]map[string]bool)
+	}
+	date := click.Time.UTC().Format("2006-01-02")
+	d.clicks[date]++
+	if d.visitors[date] == nil {
+		d.visitors[date] = make(map[string]bool)
+	}
+	d.visitors[date][click.IPHash] = true
+	return nil
+}
+
+
+// Writes a row for every day of the current short URL, including those without clicks.
+func (d *dailyClickRows) flush() error {
+	for _, date := range d.dates {
+		row := []string{d.shortURL, date, strconv.FormatInt(d.clicks[date], 10), strconv.Itoa(len(d.visitors[date]))}
+		if err := d.write(row); err != nil {
+			return err
+		}
+	}
+	d.clicks, d.visitors = nil, nil
+	return nil
+}
+
+
+// Keeps a value that came from a visitor's browser from being run as a formula
+// when the CSV is opened in a spreadsheet, by putting a "'" in front of it.
+func csvCell(value string) string {
+	if len(value) > 0 && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,245 @@
+// Records every visit to a short URL, so that its clicks can be broken down
+// by day and by referrer rather than only counted.
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var clickEventsTotal = newCounterVec("click_events_total",
+	"Total number of clicks on short URLs by what became of them.", "outcome")
+
+// How many days of clicks are summarized by default, and at most
+const (
+	defaultClickDays = 30
+	maxClickDays     = 365
+)
+
+// The most clicks held in memory while the database can't be written to.
+// Any more are dropped rather than letting a long outage use up the memory.
+const maxPendingClicks = 10000
+
+// The longest referrer or user agent that is kept, as both are up to the client
+const maxClickHeaderLength = 512
+
+// Buffers clicks in memory and writes them to the click store in batches,
+// so that redirects don't wait for the database.
+type clickRecorder struct {
+	// The key that client IP addresses are hashed with
+	salt []byte
+
+	mu      sync.Mutex
+	pending []ClickEvent
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// The recorder, or nil if click analytics is disabled
+var clicks *clickRecorder
+
+
+// Starts recording clicks unless CLICK_ANALYTICS is false, writing them to the database
+// every CLICK_FLUSH_INTERVAL (default 5s). Client IP addresses are hashed with
+// CLICK_IP_SALT, which should be set so that unique visitors are still recognized
+// after a restart; otherwise, a random salt is used.
+// Visitors' countries and cities are looked up if GEOIP_DATABASE is set.
+func initClickAnalytics() {
+	if !getEnvBool("CLICK_ANALYTICS", true) {
+		slog.Info("Click analytics is disabled.")
+		return
+	}
+	initGeoIP()
+	salt := []byte(getEnv("CLICK_IP_SALT", ""))
+	if len(salt) == 0 {
+		salt = make([]byte, 32)
+		rand.Read(salt)
+		slog.Warn("CLICK_IP_SALT isn't set, so unique visitors will be counted again after a restart.")
+	}
+	interval := getEnvDuration("CLICK_FLUSH_INTERVAL", 5*time.Second)
+	if interval <= 0 {
+		slog.Warn("Invalid CLICK_FLUSH_INTERVAL, so using the default.", "value", interval)
+		interval = 5 * time.Second
+	}
+
+	clicks = &clickRecorder{
+		salt: salt,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go clicks.flushPeriodically(interval)
+}
+
+
+// Buffers a click on the short URL by the client that made the request.
+func recordClick(r *http.Request, shortURL string) {
+	if clicks == nil {
+		return
+	}
+	ip := clientIP(r)
+	mac := hmac.New(sha256.New, clicks.salt)
+	mac.Write([]byte(ip))
+	country, city := lookupGeoIP(ip)
+	click := ClickEvent{
+		ShortURL:  shortURL,
+		Time:      time.Now().UTC(),
+		Referrer:  truncate(r.Referer(), maxClickHeaderLength),
+		UserAgent: truncate(r.UserAgent(), maxClickHeaderLength),
+		IPHash:    hex.EncodeToString(mac.Sum(nil)),
+		Country:   country,
+		City:      city,
+	}
+
+	clicks.mu.Lock()
+	defer clicks.mu.Unlock()
+	if len(clicks.pending) >= maxPendingClicks {
+		clickEventsTotal.inc("dropped")
+		return
+	}
+	clicks.pending = append(clicks.pending, click)
+}
+
+
+// Returns at most the first n bytes of s.
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+
+// Writes the buffered clicks to the click store, keeping them for the next try
+// if the database can't be reached.
+func (c *clickRecorder) flush() {
+	if !storageAvailable() {
+		return
+	}
+	c.mu.Lock()
+	batch := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbPingTimeout)
+	defer cancel()
+	if err := clickStore.RecordClicks(ctx, batch); err != nil {
+		c.mu.Lock()
+		c.pending = append(batch, c.pending...)
+		if dropped := len(c.pending) - maxPendingClicks; dropped > 0 {
+			clickEventsTotal.add(float64(dropped), "dropped")
+			c.pending = c.pending[dropped:]
+		}
+		c.mu.Unlock()
+		return
+	}
+	clickEventsTotal.add(float64(len(batch)), "recorded")
+	slog.Debug("Wrote clicks to the database.", "clicks", len(batch))
+}
+
+
+func (c *clickRecorder) flushPeriodically(interval time.Duration) {
+	defer close(c.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.flush()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+
+// Writes any buffered clicks to the database before it is closed.
+func closeClickAnalytics() {
+	if clicks == nil {
+		return
+	}
+	close(clicks.stop)
+	<-clicks.done
+	clicks.flush()
+	clicks.mu.Lock()
+	if len(clicks.pending) > 0 {
+		slog.Error("Unable to write clicks to the database.", "clicks", len(clicks.pending))
+	}
+	clicks.mu.Unlock()
+	closeGeoIP()
+}
+
+
+// Reads how many days of clicks were asked for, from 1 to maxClickDays,
+// or defaultClickDays if none were.
+func parseClickDays(param string) (int, error) {
+	if len(param) == 0 {
+		return defaultClickDays, nil
+	}
+	days, err := strconv.Atoi(param)
+	if err != nil || days < 1 || days > maxClickDays {
+		return 0, newErrorMessage(http.StatusBadRequest, "days must be from 1 to " + strconv.Itoa(maxClickDays))
+	}
+	return days, nil
+}
+
+
+// Returns the start of the first of the last n days, including today, in UTC.
+func clicksSince(days int) time.Time {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	return today.AddDate(0, 0, 1-days)
+}
+
+
+// Sends the clicks on the short URL in the path, counted for each of the last
+// "days" days (default 30, at most 365) including today, along with its top referrers
+// and the countries that they came from.
+// Days are in UTC, and those without clicks are listed with a count of zero.
+func getShortURLClicks(w http.ResponseWriter, r *http.Request) {
+	shortURL := r.PathValue("code")
+	days, err := parseClickDays(r.URL.Query().Get("days"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Clicks are only reported for short URLs that still work, and only to their owners
+	if _, err := authorizeShortURL(r); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	since := clicksSince(days)
+	stats, err := clickStore.GetClickStats(r.Context(), shortURL, since)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	counts := make(map[string]int64, len(stats.Days))
+	for _, day := range stats.Days {
+		counts[day.Date] = day.Count
+	}
+	stats.Days = make([]DailyClicks, days)
+	for i := range stats.Days {
+		date := since.AddDate(0, 0, i).Format("2006-01-02")
+		stats.Days[i] = DailyClicks{Date: date, Count: counts[date]}
+	}
+	if stats.TopReferrers == nil {
+		stats.TopReferrers = []ReferrerClicks{}
+	}
+	if stats.Countries == nil {
+		stats.Countries = []CountryClicks{}
+	}
+	writeResponse(w, r, http.StatusOK, stats)
+}
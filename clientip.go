@@ -0,0 +1,130 @@
+// Works out the real client IP address when the app runs behind a reverse proxy.
+package main
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Networks whose forwarding headers can be believed,
+// e.g. the address of nginx or a cloud load balancer.
+var trustedProxies []netip.Prefix
+
+
+// Loads the trusted proxies from TRUSTED_PROXIES, a comma-separated list
+// of IP addresses or CIDR ranges, e.g. "127.0.0.1,10.0.0.0/8".
+func initTrustedProxies() {
+	for _, entry := range getEnvList("TRUSTED_PROXIES") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				slog.Warn("Ignoring invalid entry in TRUSTED_PROXIES.", "entry", entry, "err", err)
+				continue
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		trustedProxies = append(trustedProxies, prefix.Masked())
+	}
+	if len(trustedProxies) > 0 {
+		slog.Info("Trusting forwarding headers from proxies.", "proxies", trustedProxies)
+	}
+}
+
+
+func isTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+
+// Returns the IP address of the client that made the request.
+// If the request came through trusted proxies, the address is taken from
+// the Forwarded, X-Forwarded-For, or X-Real-IP header, in that order.
+// Otherwise, it is the address of the connection's peer.
+func clientIP(r *http.Request) string {
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	peerAddr, err := netip.ParseAddr(peer)
+	if err != nil || !isTrustedProxy(peerAddr) {
+		return peer
+	}
+
+	// Forwarding headers list the hops from the client to the nearest proxy,
+	// so walk them from the right and stop at the first untrusted address
+	if forwarded := r.Header.Values("Forwarded"); len(forwarded) > 0 {
+		if ip, ok := rightmostUntrusted(parseForwardedFor(forwarded), peer); ok {
+			return ip
+		}
+	}
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		var hops []string
+		for _, value := range xff {
+			for _, hop := range strings.Split(value, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+		if ip, ok := rightmostUntrusted(hops, peer); ok {
+			return ip
+		}
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); len(realIP) > 0 {
+		if addr, err := netip.ParseAddr(realIP); err == nil {
+			return addr.String()
+		}
+	}
+	return peer
+}
+
+
+// Returns the last address in the list that isn't a trusted proxy.
+// If every address is trusted, the leftmost one is returned.
+// If a malformed address is reached first, the list can't be trusted
+// beyond the peer, so the peer's address is returned.
+func rightmostUntrusted(hops []string, peer string) (string, bool) {
+	var leftmost string
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			return peer, true
+		}
+		leftmost = addr.Unmap().String()
+		if !isTrustedProxy(addr) {
+			return leftmost, true
+		}
+	}
+	return leftmost, len(leftmost) > 0
+}
+
+
+// Extracts the "for" addresses from RFC 7239 Forwarded headers, e.g.
+// Forwarded: for=192.0.2.60;proto=http, for="[2001:db8::1]:4711"
+func parseForwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(key, "for") {
+					continue
+				}
+				val = strings.Trim(val, `"`)
+				if host, _, err := net.SplitHostPort(val); err == nil {
+					val = host
+				}
+				hops = append(hops, strings.Trim(val, "[]"))
+			}
+		}
+	}
+	return hops
+}
@@ -0,0 +1,123 @@
+// Tests how the client's IP address is found among the hops listed by proxies.
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+// Trusts the given networks as proxies until the test ends.
+func setTestTrustedProxies(t *testing.T, prefixes ...string) {
+	t.Helper()
+	previous := trustedProxies
+	trustedProxies = nil
+	for _, prefix := range prefixes {
+		trustedProxies = append(trustedProxies, netip.MustParsePrefix(prefix))
+	}
+	t.Cleanup(func() { trustedProxies = previous })
+}
+
+
+func TestRightmostUntrusted(t *testing.T) {
+	setTestTrustedProxies(t, "10.0.0.0/8", "2001:db8::/32")
+	const peer = "10.0.0.1"
+
+	tests := []struct {
+		name   string
+		hops   []string
+		want   string
+		wantOK bool
+	}{
+		{"no hops", nil, "", false},
+		{"single client", []string{"203.0.113.7"}, "203.0.113.7", true},
+		{"client behind proxies", []string{"203.0.113.7", "10.1.2.3", "10.4.5.6"}, "203.0.113.7", true},
+		{"spoofed leftmost hop", []string{"198.51.100.1", "203.0.113.7", "10.1.2.3"}, "203.0.113.7", true},
+		{"every hop trusted", []string{"10.1.1.1", "10.2.2.2"}, "10.1.1.1", true},
+		{"ipv6 client", []string{"2001:db9::1", "2001:db8::2"}, "2001:db9::1", true},
+		{"ipv4-mapped ipv6", []string{"::ffff:203.0.113.7"}, "203.0.113.7", true},
+		{"ipv4-mapped trusted proxy", []string{"203.0.113.7", "::ffff:10.1.2.3"}, "203.0.113.7", true},
+		// Nothing to the left of a hop that can't be read can be believed
+		{"malformed rightmost hop", []string{"203.0.113.7", "unknown"}, peer, true},
+		{"malformed hop behind a proxy", []string{"203.0.113.7", "not-an-ip", "10.1.2.3"}, peer, true},
+		{"malformed hop beyond the client", []string{"garbage", "203.0.113.7"}, "203.0.113.7", true},
+		{"empty hop", []string{"203.0.113.7", ""}, peer, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := rightmostUntrusted(test.hops, peer)
+			if got != test.want || ok != test.wantOK {
+				t.Errorf("rightmostUntrusted(%q) = %q, %t, want %q, %t", test.hops, got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
+
+
+func TestParseForwardedFor(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   []string
+	}{
+		{"single", []string{"for=192.0.2.60"}, []string{"192.0.2.60"}},
+		{"with other parameters", []string{"for=192.0.2.60;proto=http;by=203.0.113.43"}, []string{"192.0.2.60"}},
+		{"case of the key", []string{"For=192.0.2.60"}, []string{"192.0.2.60"}},
+		{"several elements", []string{"for=192.0.2.43, for=198.51.100.17"}, []string{"192.0.2.43", "198.51.100.17"}},
+		{"several headers", []string{"for=192.0.2.43", "for=198.51.100.17"}, []string{"192.0.2.43", "198.51.100.17"}},
+		{"quoted ipv6 with port", []string{`for="[2001:db8:cafe::17]:4711"`}, []string{"2001:db8:cafe::17"}},
+		{"quoted ipv6", []string{`for="[2001:db8:cafe::17]"`}, []string{"2001:db8:cafe::17"}},
+		{"ipv4 with port", []string{`for="192.0.2.60:8080"`}, []string{"192.0.2.60"}},
+		// Obfuscated and unknown hops are kept, so that rightmostUntrusted stops at them
+		{"unknown", []string{"for=unknown, for=192.0.2.60"}, []string{"unknown", "192.0.2.60"}},
+		{"obfuscated", []string{"for=_hidden"}, []string{"_hidden"}},
+		{"no for", []string{"proto=https;by=203.0.113.43"}, nil},
+		{"no value", []string{"for"}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := parseForwardedFor(test.values); !slices.Equal(got, test.want) {
+				t.Errorf("parseForwardedFor(%q) = %q, want %q", test.values, got, test.want)
+			}
+		})
+	}
+}
+
+
+func TestClientIP(t *testing.T) {
+	setTestTrustedProxies(t, "10.0.0.0/8")
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{"no proxy", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"untrusted peer's headers", "198.51.100.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.7"}, "198.51.100.1"},
+		{"forwarded", "10.0.0.1:1234", map[string]string{"Forwarded": "for=203.0.113.7;proto=https"}, "203.0.113.7"},
+		{"forwarded before x-forwarded-for", "10.0.0.1:1234",
+			map[string]string{"Forwarded": "for=203.0.113.7", "X-Forwarded-For": "198.51.100.1"}, "203.0.113.7"},
+		{"x-forwarded-for", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.1, 203.0.113.7, 10.0.0.2"}, "203.0.113.7"},
+		{"malformed x-forwarded-for", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.7, bogus"}, "10.0.0.1"},
+		{"x-real-ip", "10.0.0.1:1234", map[string]string{"X-Real-IP": "203.0.113.7"}, "203.0.113.7"},
+		{"malformed x-real-ip", "10.0.0.1:1234", map[string]string{"X-Real-IP": "bogus"}, "10.0.0.1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = test.remoteAddr
+			for key, value := range test.headers {
+				r.Header.Set(key, value)
+			}
+			if got := clientIP(r); got != test.want {
+				t.Errorf("clientIP = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
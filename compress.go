@@ -0,0 +1,156 @@
+// Transparent compression of responses for clients that support it.
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Content types that are already compressed,
+// so compressing them again would only waste CPU time.
+var incompressibleTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/pdf",
+	// Compressing streams would hold events back until the compressor's buffer fills
+	"text/event-stream",
+	"application/octet-stream",
+}
+
+
+// Compresses responses with gzip or deflate, whichever the client prefers
+// according to its Accept-Encoding header.
+func compressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := chooseEncoding(r.Header.Get("Accept-Encoding"))
+		w.Header().Add("Vary", "Accept-Encoding")
+		if len(encoding) == 0 || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+
+// Returns "gzip" or "deflate" if the client accepts either, otherwise "".
+// gzip is preferred when both are accepted.
+func chooseEncoding(acceptEncoding string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		// A quality value of zero means the encoding is not acceptable
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if quality, err := strconv.ParseFloat(q, 64); err == nil && quality == 0 {
+				continue
+			}
+		}
+		accepted[strings.ToLower(name)] = true
+	}
+	if accepted["gzip"] || accepted["*"] {
+		return "gzip"
+	}
+	if accepted["deflate"] {
+		return "deflate"
+	}
+	return ""
+}
+
+
+// Wraps a ResponseWriter, compressing the body if its content type allows it.
+// The decision is made once the handler writes the header or the first bytes.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	compressor  io.WriteCloser
+	wroteHeader bool
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	header := cw.Header()
+	if shouldCompress(status, header) {
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		// Byte ranges would refer to the uncompressed content
+		header.Del("Accept-Ranges")
+		if cw.encoding == "gzip" {
+			cw.compressor = gzip.NewWriter(cw.ResponseWriter)
+		} else {
+			cw.compressor, _ = flate.NewWriter(cw.ResponseWriter, flate.DefaultCompression)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		if len(cw.Header().Get("Content-Type")) == 0 {
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.compressor != nil {
+		return cw.compressor.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Sends any buffered compressed data to the client, e.g. for streamed responses.
+func (cw *compressWriter) Flush() {
+	if flusher, ok := cw.compressor.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			slog.Debug("Error when flushing compressed response.", "err", err)
+		}
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *compressWriter) Close() {
+	if cw.compressor != nil {
+		if err := cw.compressor.Close(); err != nil {
+			slog.Debug("Error when closing compressed response.", "err", err)
+		}
+	}
+}
+
+// Allows http.ResponseController to reach the underlying ResponseWriter.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+
+// Reports whether a response with this status and header should be compressed.
+func shouldCompress(status int, header http.Header) bool {
+	if status < http.StatusOK || status == http.StatusNoContent ||
+		status == http.StatusNotModified || status == http.StatusPartialContent {
+		return false
+	}
+	if len(header.Get("Content-Encoding")) > 0 {
+		return false
+	}
+	contentType := strings.ToLower(header.Get("Content-Type"))
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
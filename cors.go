@@ -0,0 +1,78 @@
+// Cross-origin resource sharing for browser front-ends on other domains.
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type corsConfig struct {
+	allowedOrigins []string
+	allowedMethods string
+	allowedHeaders string
+	maxAge         string
+}
+
+
+// Returns middleware that sets CORS headers based on the environment:
+// CORS_ALLOWED_ORIGINS (default "*"), CORS_ALLOWED_METHODS, CORS_ALLOWED_HEADERS,
+// and CORS_MAX_AGE (how many seconds browsers may cache a preflight response).
+func newCORSMiddleware() Middleware {
+	config := corsConfig{
+		allowedOrigins: getEnvList("CORS_ALLOWED_ORIGINS", "*"),
+		allowedMethods: strings.Join(getEnvList("CORS_ALLOWED_METHODS", "GET", "POST", "OPTIONS"), ", "),
+		allowedHeaders: strings.Join(getEnvList("CORS_ALLOWED_HEADERS", "Content-Type"), ", "),
+		maxAge:         getEnv("CORS_MAX_AGE", "600"),
+	}
+	if _, err := strconv.Atoi(config.maxAge); err != nil {
+		slog.Warn("Invalid CORS_MAX_AGE, so defaulting to 600.", "err", err)
+		config.maxAge = "600"
+	}
+	slog.Info("Allowing cross-origin requests.", "origins", config.allowedOrigins)
+	return config.middleware
+}
+
+
+func (config corsConfig) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		// The response varies by origin even when the origin isn't allowed
+		w.Header().Add("Vary", "Origin")
+		if len(origin) == 0 || !config.allows(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if slices.Contains(config.allowedOrigins, "*") {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+
+		// Answer preflight requests here instead of passing them on
+		if r.Method == http.MethodOptions && len(r.Header.Get("Access-Control-Request-Method")) > 0 {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", config.allowedMethods)
+			w.Header().Set("Access-Control-Allow-Headers", config.allowedHeaders)
+			w.Header().Set("Access-Control-Max-Age", config.maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+
+// Reports whether requests from the given origin are allowed.
+func (config corsConfig) allows(origin string) bool {
+	for _, allowed := range config.allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+// Limits how many short URLs each client can create in a day,
+// so that a bot can't fill the collection with links.
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var creationQuotaTotal = newCounterVec("shorturl_creation_quota_total",
+	"Total number of short URL creations checked against the per-client daily quota.", "result")
+
+var errCreationQuotaExceeded = newErrorMessage(http.StatusTooManyRequests, "daily short url creation quota exceeded")
+
+// How many short URLs each client has created today.
+// Clients are counted in memory, so each instance of the server has its own counts.
+type creationQuota struct {
+	limit int
+
+	mu     sync.Mutex
+	day    string
+	counts map[string]int
+}
+
+
+// The daily quota of each client, or nil if there is none
+var creationQuotas *creationQuota
+
+
+// Returns middleware that limits each client to SHORTURL_DAILY_QUOTA new short URLs
+// a day, or nil if it isn't set. A client is its API key if API keys are required,
+// and its IP address otherwise. Quotas reset at midnight UTC.
+func newCreationQuotaMiddleware() Middleware {
+	limit := int(getEnvInt("SHORTURL_DAILY_QUOTA", 0))
+	if limit <= 0 {
+		return nil
+	}
+	slog.Info("Limiting how many short URLs each client can create a day.", "quota", limit)
+	creationQuotas = &creationQuota{limit: limit, counts: make(map[string]int)}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !takeCreationQuota(w, r, 1) {
+				return
+			}
+			// Only short URLs that were created count, not requests that failed
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if rec.status >= 400 {
+				giveBackCreationQuota(r, 1)
+			}
+		})
+	}
+}
+
+
+// Returns the client that the request counts against, for its quota.
+func creationClient(r *http.Request) string {
+	return creationClientOf(r.Context(), clientIP(r))
+}
+
+
+// Returns the client that a request made from the IP address counts against,
+// which is its API key if it was made with one.
+func creationClientOf(ctx context.Context, ip string) string {
+	if id := apiKeyIDFrom(ctx); len(id) > 0 {
+		return "key:" + id
+	}
+	return "ip:" + ip
+}
+
+
+// Counts n creations against the client's quota, if there is one, and tells it how much it has left.
+// If it doesn't have enough left, none are counted, and it is sent a 429 and false is returned.
+func takeCreationQuota(w http.ResponseWriter, r *http.Request, n int) bool {
+	if creationQuotas == nil {
+		return true
+	}
+	remaining, err := takeCreationQuotaOf(r.Context(), creationClient(r), n)
+	w.Header().Set("X-Creation-Quota-Limit", strconv.Itoa(creationQuotas.limit))
+	w.Header().Set("X-Creation-Quota-Remaining", strconv.Itoa(remaining))
+	if err != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(secondsUntilQuotaReset(time.Now())))
+		writeError(w, r, err)
+		return false
+	}
+	return true
+}
+
+
+// Counts n creations against the client's quota, if there is one, and returns how much it has left.
+// If it doesn't have enough left, none are counted, and it fails with errCreationQuotaExceeded.
+func takeCreationQuotaOf(ctx context.Context, client string, n int) (int, error) {
+	if creationQuotas == nil {
+		return 0, nil
+	}
+	remaining, ok := creationQuotas.take(client, n, time.Now())
+	if !ok {
+		creationQuotaTotal.inc("exceeded")
+		loggerFrom(ctx).Warn("Short URL creation quota exceeded.", "client", client, "requested", n)
+		return remaining, errCreationQuotaExceeded
+	}
+	creationQuotaTotal.add(float64(n), "allowed")
+	return remaining, nil
+}
+
+
+// Uncounts n creations that failed.
+func giveBackCreationQuota(r *http.Request, n int) {
+	giveBackCreationQuotaOf(creationClient(r), n)
+}
+
+
+// Uncounts n creations by the client that failed.
+func giveBackCreationQuotaOf(client string, n int) {
+	if creationQuotas == nil || n == 0 {
+		return
+	}
+	creationQuotas.giveBack(client, n)
+	creationQuotaTotal.add(float64(n), "given_back")
+}
+
+
+// Counts n creations by the client if it has that much of today's quota left,
+// and returns how much it has left afterwards.
+// The counts are cleared on the first call of each day.
+func (q *creationQuota) take(client string, n int, now time.Time) (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if day := now.UTC().Format("2006-01-02"); day != q.day {
+		q.day = day
+		clear(q.counts)
+	}
+	if q.counts[client]+n > q.limit {
+		return q.limit - q.counts[client], false
+	}
+	q.counts[client] += n
+	return q.limit - q.counts[client], true
+}
+
+
+// Uncounts creations that failed.
+func (q *creationQuota) giveBack(client string, n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.counts[client] = max(q.counts[client]-n, 0)
+}
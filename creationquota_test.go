@@ -0,0 +1,57 @@
+// Tests how short URL creations are counted against each client's daily quota.
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+
+func TestCreationQuota(t *testing.T) {
+	day := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	beforeMidnight := time.Date(2024, 3, 10, 23, 59, 59, 0, time.UTC)
+	nextDay := time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)
+	// Still 10 March in UTC, although it is already 11 March here
+	nextDayEast := time.Date(2024, 3, 11, 1, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60))
+
+	// Each step is run in order against the same quota
+	steps := []struct {
+		name          string
+		client        string
+		// Creations to take, or to give back if negative
+		n             int
+		now           time.Time
+		wantRemaining int
+		wantOK        bool
+	}{
+		{"first creation", "ip:a", 1, day, 4, true},
+		{"several at once", "ip:a", 3, day, 1, true},
+		{"more than is left", "ip:a", 2, day, 1, false},
+		{"another client", "ip:b", 5, day, 0, true},
+		{"the last one", "ip:a", 1, day, 0, true},
+		{"over the quota", "ip:a", 1, beforeMidnight, 0, false},
+		{"given back", "ip:a", -2, beforeMidnight, 2, true},
+		{"after giving back", "ip:a", 2, beforeMidnight, 0, true},
+		{"more than the quota", "ip:c", 6, day, 5, false},
+		{"zone other than utc", "ip:b", 1, nextDayEast, 0, false},
+		{"next day", "ip:a", 1, nextDay, 4, true},
+		{"next day, another client", "ip:b", 5, nextDay, 0, true},
+		{"given back more than taken", "ip:a", -3, nextDay, 5, true},
+	}
+
+	quota := &creationQuota{limit: 5, counts: make(map[string]int)}
+	for _, step := range steps {
+		if step.n < 0 {
+			quota.giveBack(step.client, -step.n)
+			if remaining := quota.limit - quota.counts[step.client]; remaining != step.wantRemaining {
+				t.Errorf("%s: remaining = %d, want %d", step.name, remaining, step.wantRemaining)
+			}
+			continue
+		}
+		remaining, ok := quota.take(step.client, step.n, step.now)
+		if remaining != step.wantRemaining || ok != step.wantOK {
+			t.Errorf("%s: take(%q, %d) = %d, %t, want %d, %t",
+				step.name, step.client, step.n, remaining, ok, step.wantRemaining, step.wantOK)
+		}
+	}
+}
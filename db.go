@@ -0,0 +1,302 @@
+// Manages the connection to MongoDB.
+package main
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+var mongoClient *mongo.Client
+
+// Whether the database connection has been established,
+// i.e. whether the endpoints that depend on it can be served
+var dbReady atomic.Bool
+
+// How long a single attempt at reaching MongoDB may take
+const dbPingTimeout = 5 * time.Second
+
+
+// Connects to MongoDB, retrying with exponential backoff until it responds.
+// If DB_CONNECT_IN_BACKGROUND is true, this returns immediately and the retries
+// continue in the background, so endpoints that don't need the database
+// can be served in the meantime. Either way, the program exits if the database
+// can't be reached within DB_CONNECT_MAX_WAIT.
+func initDatabase() {
+	initDBTimeouts()
+	var err error
+	mongoClient, err = mongo.Connect(context.Background(), mongoClientOptions())
+	if err != nil {
+		// This only fails if the options are invalid, so retrying won't help
+		fatal("Error when connecting to MongoDB.", "err", err)
+	}
+
+	connect := func() {
+		if err := waitForDatabase(context.Background()); err != nil {
+			fatal("Unable to reach MongoDB.", "err", err)
+		}
+		db := mongoClient.Database(os.Getenv("DB_NAME"))
+		ctx, cancel := context.WithTimeout(context.Background(), dbPingTimeout)
+		mongoSupportsTransactions = detectMongoTransactions(ctx)
+		cancel()
+		if !mongoSupportsTransactions {
+			slog.Warn("MongoDB isn't a replica set, so compound operations won't run in transactions.")
+		}
+		if err := migrateMongo(db); err != nil {
+			fatal("Failed to migrate MongoDB.", "err", err)
+		}
+		setStores(newMongoURLStore(db), newMongoExerciseStore(db), newMongoAPIKeyStore(db), newMongoClickStore(db))
+		markStorageReady()
+		slog.Info("Connected to MongoDB.")
+	}
+
+	if getEnvBool("DB_CONNECT_IN_BACKGROUND", false) {
+		go connect()
+	} else {
+		connect()
+	}
+}
+
+
+// Returns the options for the MongoDB client. The pool size and timeouts
+// can be tuned through the environment, e.g. for small free-tier clusters
+// that only allow a few connections. The driver's defaults are used otherwise.
+func mongoClientOptions() *options.ClientOptions {
+	clientOptions := options.Client().ApplyURI(os.Getenv("DB_URI")).SetMonitor(newMongoCommandMonitor())
+	if len(os.Getenv("DB_MAX_POOL_SIZE")) > 0 {
+		maxPoolSize := getEnvInt("DB_MAX_POOL_SIZE", 100)
+		if maxPoolSize < 0 {
+			fatal("DB_MAX_POOL_SIZE must not be negative.", "value", maxPoolSize)
+		}
+		clientOptions.SetMaxPoolSize(uint64(maxPoolSize))
+	}
+	if len(os.Getenv("DB_MIN_POOL_SIZE")) > 0 {
+		minPoolSize := getEnvInt("DB_MIN_POOL_SIZE", 0)
+		if minPoolSize < 0 {
+			fatal("DB_MIN_POOL_SIZE must not be negative.", "value", minPoolSize)
+		}
+		clientOptions.SetMinPoolSize(uint64(minPoolSize))
+	}
+	if len(os.Getenv("DB_CONNECT_TIMEOUT")) > 0 {
+		clientOptions.SetConnectTimeout(getEnvDuration("DB_CONNECT_TIMEOUT", 30*time.Second))
+	}
+	if len(os.Getenv("DB_SERVER_SELECTION_TIMEOUT")) > 0 {
+		clientOptions.SetServerSelectionTimeout(getEnvDuration("DB_SERVER_SELECTION_TIMEOUT", 30*time.Second))
+	}
+	applyConcerns(clientOptions)
+	return clientOptions
+}
+
+
+// Pings MongoDB until it responds, waiting longer after each failure.
+func waitForDatabase(ctx context.Context) error {
+	maxWait := getEnvDuration("DB_CONNECT_MAX_WAIT", 30*time.Second)
+	backoff := getEnvDuration("DB_CONNECT_INITIAL_BACKOFF", 500*time.Millisecond)
+	const maxBackoff = 10 * time.Second
+
+	deadline := time.Now().Add(maxWait)
+	for attempt := 1; ; attempt++ {
+		pingDeadline := time.Now().Add(dbPingTimeout)
+		if pingDeadline.After(deadline) {
+			pingDeadline = deadline
+		}
+		pingCtx, cancel := context.WithDeadline(ctx, pingDeadline)
+		err := mongoClient.Ping(pingCtx, nil)
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		if time.Now().Add(backoff).After(deadline) {
+			return errors.New("gave up after " + maxWait.String() + ": " + err.Error())
+		}
+		slog.Warn("MongoDB is unreachable, so retrying.", "attempt", attempt, "retry_in", backoff, "err", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+
+// Closes the connection to MongoDB, if one was made.
+func closeDatabase() {
+	if mongoClient == nil {
+		return
+	}
+	slog.Info("Closing connection to MongoDB.")
+	if err := mongoClient.Disconnect(context.Background()); err != nil {
+		slog.Error("Error when disconnecting from MongoDB.", "err", err)
+	}
+}
+
+
+// Creates a unique index on each of the given fields, unless it already exists.
+func ensureUniqueIndexes(collection *mongo.Collection, fields ...string) error {
+	models := make([]mongo.IndexModel, 0, len(fields))
+	for _, field := range fields {
+		models = append(models, mongo.IndexModel{
+			Keys:    bson.D{{Key: field, Value: 1}},
+			Options: options.Index().SetUnique(true),
+		})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dbPingTimeout)
+	defer cancel()
+	_, err := collection.Indexes().CreateMany(ctx, models)
+	return err
+}
+
+
+// Creates an ascending index on the given fields together, unless it already exists.
+func ensureCompoundIndex(collection *mongo.Collection, fields ...string) error {
+	keys := make(bson.D, 0, len(fields))
+	for _, field := range fields {
+		keys = append(keys, bson.E{Key: field, Value: 1})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dbPingTimeout)
+	defer cancel()
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: keys})
+	return err
+}
+
+
+// Creates an index on the field that only covers the documents that have it,
+// unless it already exists, so that they can be found when few documents do.
+func ensureSparseIndex(collection *mongo.Collection, field string) error {
+	model := mongo.IndexModel{
+		Keys:    bson.D{{Key: field, Value: 1}},
+		Options: options.Index().SetSparse(true),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dbPingTimeout)
+	defer cancel()
+	_, err := collection.Indexes().CreateOne(ctx, model)
+	return err
+}
+
+
+// Creates a descending index on each of the given fields, unless it already exists,
+// so that documents can be listed in order of any of them without sorting in memory.
+func ensureSortIndexes(collection *mongo.Collection, fields ...string) error {
+	models := make([]mongo.IndexModel, 0, len(fields))
+	for _, field := range fields {
+		models = append(models, mongo.IndexModel{Keys: bson.D{{Key: field, Value: -1}}})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dbPingTimeout)
+	defer cancel()
+	_, err := collection.Indexes().CreateMany(ctx, models)
+	return err
+}
+
+
+// Applies an update to the document matching the filter only if it is still
+// at the given version, i.e. hasn't been changed since it was read, or the version is anyVersion,
+// increments its version along with the update, and decodes the updated document into result.
+// Fails with ErrConflict if it has been changed, or ErrNotFound if it doesn't exist.
+// Duplicate key errors are returned as they are, for the caller to explain.
+func updateVersioned(ctx context.Context, funcName string, collection *mongo.Collection, filter bson.M, version int64, update bson.M, opts *options.FindOneAndUpdateOptions, result any) error {
+	logger := loggerFrom(ctx)
+	versionedFilter := bson.M{}
+	for key, value := range filter {
+		versionedFilter[key] = value
+	}
+	if version != anyVersion {
+		versionedFilter["version"] = version
+	}
+	versionedUpdate := bson.M{"$inc": bson.M{"version": 1}}
+	for key, value := range update {
+		versionedUpdate[key] = value
+	}
+	opts.SetReturnDocument(options.After)
+
+	// Not idempotent, as a repeat after a lost reply would look like a conflict
+	err := retryDB(ctx, funcName, false, func() error {
+		return collection.FindOneAndUpdate(ctx, versionedFilter, versionedUpdate, opts).Decode(result)
+	})
+	if err == nil || mongo.IsDuplicateKeyError(err) {
+		return err
+	} else if err != mongo.ErrNoDocuments {
+		logger.Error("Collection.FindOneAndUpdate failed", "func", funcName, "err", err)
+		return newStoreError(ErrStorage, "failed when updating database")
+	} else if version == anyVersion {
+		return newStoreError(ErrNotFound, "not found")
+	}
+
+	// Either the document is gone or its version has moved on
+	var count int64
+	err = retryDB(ctx, funcName, true, func() error {
+		var err error
+		count, err = collection.CountDocuments(ctx, filter)
+		return err
+	})
+	if err != nil {
+		logger.Error("Collection.CountDocuments failed", "func", funcName, "err", err)
+		return newStoreError(ErrStorage, "failed when searching database")
+	} else if count == 0 {
+		return newStoreError(ErrNotFound, "not found")
+	}
+	return newVersionConflict(version)
+}
+
+
+// Creates an index that makes MongoDB delete each document once the time in the field
+// has passed, unless it already exists. Documents without the field are kept.
+// Deletion happens in a background task that runs about once a minute.
+func ensureTTLIndex(collection *mongo.Collection, field string) error {
+	model := mongo.IndexModel{
+		Keys:    bson.D{{Key: field, Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dbPingTimeout)
+	defer cancel()
+	_, err := collection.Indexes().CreateOne(ctx, model)
+	return err
+}
+
+
+// Splits the error from an unordered BulkWrite of n models into an error for each model.
+// Duplicate keys are described by duplicate, and any other failure of a single model
+// is logged and reported as ErrStorage. A failure of the whole write is returned on its own.
+func bulkWriteErrors(ctx context.Context, funcName string, err error, n int, duplicate func(index int, message string) error) ([]error, error) {
+	logger := loggerFrom(ctx)
+	results := make([]error, n)
+	if err == nil {
+		return results, nil
+	}
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		logger.Error("Collection.BulkWrite failed", "func", funcName, "err", err)
+		return nil, newStoreError(ErrStorage, "failed when writing to database")
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if mongo.IsDuplicateKeyError(writeErr.WriteError) {
+			results[writeErr.Index] = duplicate(writeErr.Index, writeErr.Message)
+		} else {
+			logger.Error("Collection.BulkWrite failed for a document", "func", funcName, "index", writeErr.Index, "err", writeErr)
+			results[writeErr.Index] = newStoreError(ErrStorage, "failed when writing to database")
+		}
+	}
+	return results, nil
+}
+
+
+// Rejects requests with a 503 until the database connection has been established,
+// and while the database is failing its health checks.
+func requireDB(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !storageAvailable() {
+			w.Header().Set("Retry-After", "5")
+			writeError(w, r, newErrorMessage(http.StatusServiceUnavailable, "database unavailable"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
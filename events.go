@@ -0,0 +1,176 @@
+// Streams a live feed of request activity as server-sent events.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// How many past summaries are replayed to a newly connected client.
+const eventBacklogSize = 50
+
+// How often a comment is sent to keep idle connections from being dropped.
+const eventKeepAliveInterval = 15 * time.Second
+
+// A short summary of a handled request.
+type RequestEvent struct {
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	LatencyMS float64   `json:"latency_ms"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// Fans request summaries out to every connected client
+// and remembers the most recent ones.
+type eventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan RequestEvent]struct{}
+	backlog     []RequestEvent
+}
+
+var requestEvents = &eventBroker{subscribers: make(map[chan RequestEvent]struct{})}
+
+
+// Sends an event to every subscriber without blocking.
+// Subscribers that can't keep up simply miss events.
+func (b *eventBroker) publish(e RequestEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.backlog = append(b.backlog, e)
+	if len(b.backlog) > eventBacklogSize {
+		b.backlog = b.backlog[len(b.backlog)-eventBacklogSize:]
+	}
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+
+// Registers a new subscriber and returns its channel
+// along with a copy of the recent events.
+func (b *eventBroker) subscribe() (chan RequestEvent, []RequestEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan RequestEvent, 64)
+	b.subscribers[ch] = struct{}{}
+	return ch, append([]RequestEvent(nil), b.backlog...)
+}
+
+
+func (b *eventBroker) unsubscribe(ch chan RequestEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers, ch)
+}
+
+
+// Disconnects every subscriber.
+func (b *eventBroker) closeAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		close(ch)
+		delete(b.subscribers, ch)
+	}
+}
+
+
+// Returns a middleware that publishes a summary of every request once it completes.
+// Returns nil unless EVENTS_ENABLED is true, since the feed reveals
+// the paths requested by every visitor.
+func newEventsMiddleware() Middleware {
+	if !getEnvBool("EVENTS_ENABLED", false) {
+		return nil
+	}
+	slog.Info("Streaming request events.", "path", "/events")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			requestEvents.publish(RequestEvent{
+				Time:      start,
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Status:    rec.status,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+				RequestID: requestIDFrom(r.Context()),
+			})
+		})
+	}
+}
+
+
+// Streams request summaries to the client as server-sent events,
+// starting with the most recent ones, until the client disconnects.
+func getEvents(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFrom(r.Context())
+
+	// The stream is long-lived, so it mustn't be cut off by the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		logger.Warn("Unable to clear write deadline.", "err", err)
+	}
+
+	ch, backlog := requestEvents.subscribe()
+	defer requestEvents.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(e RequestEvent) error {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "event: request\ndata: %s\n\n", data)
+		return err
+	}
+	for _, e := range backlog {
+		if err := send(e); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		logger.Error("http.ResponseController.Flush failed", "func", "getEvents", "err", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(eventKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				// The server is shutting down
+				return
+			}
+			if err := send(e); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Adds many exercises to a user's log in one request, for clients that would otherwise
+// make a round trip for each of them.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// The outcome for one exercise of a bulk request: either the exercise as it was added,
+// or why it wasn't.
+type BulkExerciseResult struct {
+	Exercise *ExerciseRecord `json:"exercise,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	Code     int             `json:"code,omitempty"`
+}
+
+// The most exercises that can be added in one request, set by initBulkExercises
+var maxBulkExercises = 100
+
+
+// Reads how many exercises can be added at once from EXERCISE_BULK_MAX (default 100).
+func initBulkExercises() {
+	limit := getEnvInt("EXERCISE_BULK_MAX", 100)
+	if limit < 1 {
+		slog.Warn("EXERCISE_BULK_MAX must be at least 1, so using the default.", "value", limit)
+		return
+	}
+	maxBulkExercises = int(limit)
+}
+
+
+// Adds each exercise in a JSON array, such as [{ "description": "Run", "duration": 30 }],
+// to the log of the user whose ID is in the path, and sends back an array with the result
+// for each, in the same order. Each exercise has the same fields as one added on its own.
+// One that fails validation doesn't stop the others, which are all added in a single write.
+func postBulkExercises(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFrom(r.Context())
+
+	var rawExercises []json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&rawExercises); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeError(w, r, errBodyTooLarge)
+		} else {
+			writeError(w, r, newErrorMessage(http.StatusBadRequest, "body must be a json array of exercises"))
+		}
+		return
+	}
+	if len(rawExercises) == 0 {
+		writeError(w, r, newErrorMessage(http.StatusBadRequest, "at least one exercise is required"))
+		return
+	}
+	if len(rawExercises) > maxBulkExercises {
+		writeError(w, r, newErrorMessage(http.StatusBadRequest, "at most " + strconv.Itoa(maxBulkExercises) + " exercises can be added at once"))
+		return
+	}
+
+	results := make([]BulkExerciseResult, len(rawExercises))
+	var exercises []ExerciseRecord
+	var indexes []int
+	for i, raw := range rawExercises {
+		exercise, err := parseBulkExercise(raw)
+		if err != nil {
+			errMsg := toErrorMessage(err)
+			results[i].Error, results[i].Code = errMsg.Content, errMsg.Code
+			continue
+		}
+		exercises = append(exercises, exercise)
+		indexes = append(indexes, i)
+	}
+
+	if len(exercises) > 0 {
+		// The store gives each exercise its ID
+		if err := exerciseStore.AddExercises(r.Context(), r.PathValue("id"), exercises); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		for j, i := range indexes {
+			results[i].Exercise = &exercises[j]
+		}
+	}
+
+	logger.Info("Added exercises in bulk.", "id", r.PathValue("id"), "exercises", len(rawExercises), "valid", len(exercises))
+	writeJSON(w, http.StatusOK, results)
+}
+
+
+// Reads and validates one exercise of a bulk request.
+func parseBulkExercise(raw json.RawMessage) (ExerciseRecord, error) {
+	var req NewExerciseRequest
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return ExerciseRecord{}, newErrorMessage(http.StatusBadRequest, "exercise must be a json object of description, duration, unit, date, category, and tags")
+	}
+	exercise, err := parseExercise(req.Description, req.duration(), req.Unit, req.Date)
+	if err != nil {
+		return ExerciseRecord{}, err
+	}
+	exercise.Category, err = parseExerciseCategory(req.Category)
+	if err != nil {
+		return ExerciseRecord{}, err
+	}
+	exercise.Tags, err = parseExerciseTags(req.Tags)
+	if err != nil {
+		return ExerciseRecord{}, err
+	}
+	return exercise, nil
+}
@@ -3,197 +3,359 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
+	"encoding/xml"
+	"errors"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"log"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	"math"
 	"os"
-	"strconv"
+	"regexp"
+	"strings"
 	"time"
 )
 
-var exerciseCollection *mongo.Collection
+// Keeps exercise users and their logs in a MongoDB collection,
+// with one document per user that holds their whole log.
+type mongoExerciseStore struct {
+	collection *mongo.Collection
+	// The same collection with the read preference for read-heavy operations
+	reads      *mongo.Collection
+}
 
 type ExerciseUser struct {
-	ID		 string `json:"_id" bson:"_id"`
-	Username string `json:"username" bson:"username"`
+	XMLName  xml.Name `json:"-" bson:"-" xml:"user"`
+	ID		 string   `json:"_id" bson:"_id" xml:"_id"`
+	Username string   `json:"username" bson:"username" xml:"username"`
+	ExerciseProfile   `bson:",inline"`
+	// Sent as the ETag of the user, so that If-Match can make sure it hasn't changed since
+	Version  int64    `json:"-" bson:"version" xml:"-"`
 }
 
 type ExerciseRecord struct {
-	Description string    `json:"description" bson:"description"`
-	Duration    int       `json:"duration" bson:"duration"`
-	Date        time.Time `json:"date" bson:"date"`
+	// Given when the exercise is added. Missing from exercises added before they had IDs
+	ID          string    `json:"_id,omitempty" bson:"_id,omitempty" xml:"_id,omitempty"`
+	Description string    `json:"description" bson:"description" xml:"description"`
+	// In minutes, unless Unit is set
+	Duration    int       `json:"duration" bson:"duration" xml:"duration" openapi:"number"`
+	// Encoded in JSON as freeCodeCamp's tests expect, e.g. "Mon Jan 01 1990"
+	Date        time.Time `json:"date" bson:"date" xml:"date" openapi:"string"`
+	// What kind of exercise it was, e.g. "run", "lift", or "yoga". Missing if none was given
+	Category    string    `json:"category,omitempty" bson:"category,omitempty" xml:"category,omitempty"`
+	// Free-form labels such as "outdoors" or "with-friends", which the log can be filtered by.
+	// Missing if none were given
+	Tags        []string  `json:"tags,omitempty" bson:"tags,omitempty" xml:"tags>tag,omitempty"`
+	// The unit that the duration is given in, when a log is asked for in one. Never stored,
+	// as durations are always stored in minutes
+	Unit        string    `json:"unit,omitempty" bson:"-" xml:"unit,omitempty"`
 }
 
 type ExerciseUserRecord struct {
-	ID       string           `json:"_id" bson:"_id"`
-	Username string           `json:"username" bson:"username"`
-	Log		 []ExerciseRecord `json:"log,omitempty" bson:"log"`
+	XMLName  xml.Name         `json:"-" bson:"-" xml:"user"`
+	ID       string           `json:"_id" bson:"_id" xml:"_id"`
+	Username string           `json:"username" bson:"username" xml:"username"`
+	ExerciseProfile           `bson:",inline"`
+	// How many exercises are in the log as returned. Only set for the log of a single user
+	Count    *int             `json:"count,omitempty" bson:"-" xml:"count,omitempty"`
+	Log		 []ExerciseRecord `json:"log,omitempty" bson:"log" xml:"log>exercise,omitempty"`
+	// How many exercises in the log match the filters asked for, before the offset
+	// and limit are applied. Only set for the log of a single user
+	Total    *int             `json:"total,omitempty" bson:"total,omitempty" xml:"total,omitempty"`
+	// Incremented whenever the user or their log is changed
+	Version  int64            `json:"-" bson:"version" xml:"-"`
+}
+
+// A list of users that is encoded as a plain array in JSON
+// and as <users><user>...</user></users> in XML.
+type ExerciseUserList []ExerciseUserRecord
+
+func (list ExerciseUserList) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	wrapper := struct {
+		XMLName xml.Name             `xml:"users"`
+		Users   []ExerciseUserRecord `xml:"user"`
+	}{Users: list}
+	return e.Encode(wrapper)
 }
 
 type ExerciseAddedReceipt struct {
-	ID			string    `json:"_id" bson:"_id"`
-	Username	string    `json:"username" bson:"username"`
-	Description string    `json:"description" bson:"description"`
-	Duration    int       `json:"duration" bson:"duration"`
-	Date        time.Time `json:"date" bson:"date"`
+	XMLName     xml.Name  `json:"-" bson:"-" xml:"exercise"`
+	ID			string    `json:"_id" bson:"_id" xml:"_id"`
+	Username	string    `json:"username" bson:"username" xml:"username"`
+	// The ID of the exercise, with which it can be deleted from the log
+	ExerciseID  string    `json:"exercise_id" bson:"-" xml:"exercise_id"`
+	Description string    `json:"description" bson:"description" xml:"description"`
+	Duration    int       `json:"duration" bson:"duration" xml:"duration"`
+	// Encoded in JSON as freeCodeCamp's tests expect, e.g. "Mon Jan 01 1990"
+	Date        time.Time `json:"date" bson:"date" xml:"date" openapi:"string"`
+	// What kind of exercise it was, e.g. "run", "lift", or "yoga". Missing if none was given
+	Category    string    `json:"category,omitempty" bson:"category,omitempty" xml:"category,omitempty"`
+	// Free-form labels such as "outdoors" or "with-friends", which the log can be filtered by.
+	// Missing if none were given
+	Tags        []string  `json:"tags,omitempty" bson:"tags,omitempty" xml:"tags>tag,omitempty"`
 }
 
 // Important stages in the aggregation pipeline that don't change.
-// These get used if the user specifies a date range or a limit.
+// These get used if the user narrows down or sorts the log.
 var (
 	unwindStage bson.M = bson.M{"$unwind": "$log"}
 
-	sortStage = bson.M{"$sort": bson.M{"log.date": 1}}
-
 	regroupStage = bson.M{
 		"$group": bson.M{
 			"_id": "$_id",
 			"username": bson.M{"$first": "$username"},
-			"count": bson.M{"$first": "$count"},
+			"display_name": bson.M{"$first": "$display_name"},
+			"timezone": bson.M{"$first": "$timezone"},
+			"weekly_goal": bson.M{"$first": "$weekly_goal"},
 			"log": bson.M{"$push": "$log"},
+			"version": bson.M{"$first": "$version"},
 		},
 	}
 )
 
 
-// Connect to the MongoDB database and get a reference to the exercise collection
-func initExerciseCollection() {
-	log.Println("Getting reference to exercise collection.")
-	exerciseCollection = mongoClient.Database(os.Getenv("DB_NAME")).Collection(os.Getenv("COLLECTION_E"))
-	if exerciseCollection == nil {
-		log.Fatal("Failed to get pointer to exercise collection.\n")
+// Returns the stage that sorts an unwound log as the filter asks.
+// Exercises of the same duration are sorted by date.
+func exerciseSortStage(filter ExerciseLogFilter) bson.M {
+	direction := 1
+	if filter.Descending {
+		direction = -1
+	}
+	keys := bson.D{}
+	if filter.Sort == sortExercisesByDuration {
+		keys = append(keys, bson.E{Key: "log.duration", Value: direction})
 	}
+	keys = append(keys, bson.E{Key: "log.date", Value: direction})
+	return bson.M{"$sort": keys}
 }
 
 
-// Add a new user to the database, then return its ID
-func createExerciseUser(uname string) []byte {
-	log.Printf("Attempting to create new exercise user with username %q.\n", uname)
-	funcName := "createExerciseUser"
+// Connect to the MongoDB database and get a reference to the exercise collection,
+// making sure that each username belongs to only one user.
+func newMongoExerciseStore(db *mongo.Database) *mongoExerciseStore {
+	slog.Info("Getting reference to exercise collection.")
+	collection := db.Collection(os.Getenv("COLLECTION_E"))
+	if collection == nil {
+		fatal("Failed to get pointer to exercise collection.")
+	}
+	// Existing duplicates make this fail, but the app can still run without the index
+	if err := ensureUniqueIndexes(collection, "username"); err != nil {
+		slog.Error("Failed to create index on exercise collection. Remove any duplicate usernames and restart.", "err", err)
+	}
+	return &mongoExerciseStore{collection: collection, reads: forReads(collection)}
+}
 
-	// Attempt to create a new record for the user.
-	insertResult, err := exerciseCollection.InsertOne(context.TODO(), bson.M{"username": uname})
-	if err != nil {
-		log.Printf("Error in %s with Collection.InsertOne: %s\n", funcName, err)
-		// The username is likely already taken, so try to find that user
+
+// Add a new user to the database, then return its ID. The username is looked up first,
+// so that it is found to be taken even if the unique index couldn't be created.
+func (store *mongoExerciseStore) CreateUser(ctx context.Context, uname string) (ExerciseUser, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	logger := loggerFrom(ctx)
+	logger.Debug("Attempting to create new exercise user.", "username", uname)
+	funcName := "CreateUser"
+
+	findExisting := func() (ExerciseUser, error) {
 		var foundUser ExerciseUser
-		err = exerciseCollection.FindOne(context.TODO(), bson.M{"username": uname}).Decode(&foundUser)
+		err := retryDB(ctx, funcName, true, func() error {
+			return store.collection.FindOne(ctx, bson.M{"username": uname}).Decode(&foundUser)
+		})
+		return foundUser, err
+	}
+	taken := func(user ExerciseUser) (ExerciseUser, error) {
+		return user, newStoreError(ErrDuplicate, "username " + uname + " is taken")
+	}
+
+	existingUser, err := findExisting()
+	if err == nil {
+		return taken(existingUser)
+	} else if err != mongo.ErrNoDocuments {
+		logger.Error("Collection.FindOne failed", "func", funcName, "err", err)
+		return ExerciseUser{}, newStoreError(ErrStorage, "unable to create or find user with username " + uname)
+	}
+
+	// The ID is chosen here so that a retry of an insert that succeeded,
+	// which fails as a duplicate, can be told apart from another user taking the username
+	id := primitive.NewObjectID()
+	newUser := ExerciseUser{ID: id.Hex(), Username: uname}
+	err = retryDB(ctx, funcName, true, func() error {
+		_, err := store.collection.InsertOne(ctx, bson.M{"_id": id, "username": uname, "version": 0})
+		return err
+	})
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		logger.Error("Collection.InsertOne failed", "func", funcName, "err", err)
+		return ExerciseUser{}, newStoreError(ErrStorage, "failed when inserting into database")
+	} else if err != nil {
+		// Another request took the username after it was looked up, unless this one did
+		existingUser, err = findExisting()
 		if err != nil {
-			log.Printf("Error in %s with Collection.FindOne: %s\n", funcName, err)
-			errorMessage := `{"error":"unable to create or find user with username` + uname + `"}`
-			return []byte(errorMessage)
+			logger.Error("Collection.FindOne failed", "func", funcName, "err", err)
+			return ExerciseUser{}, newStoreError(ErrStorage, "unable to create or find user with username " + uname)
 		}
-		// Return the existing user's username and ID
-		foundUserJSON, err := json.Marshal(foundUser)
-		if err != nil {
-			log.Printf("Error in %s with json.Marshal: %s\n", funcName, err)
+		if existingUser.ID != newUser.ID {
+			return taken(existingUser)
 		}
-		return foundUserJSON
 	}
-
-	// Insert was successful, so return the username with its newly created ID
-	var newUser ExerciseUser
-	newUser.Username = uname
-	newUser.ID = fmt.Sprintf("%v", insertResult.InsertedID)
-	newUserJSON, err := json.Marshal(newUser)
-	if err != nil {
-		log.Printf("Error in %s with json.Marshal: %s\n", funcName, err)
-	}
-	return newUserJSON
+	return newUser, nil
 }
 
 
 // Return the records of every user in the database
-func getAllExerciseData() []byte {
-	log.Println("Attempting to retrieve all exercise user data.")
-	funcName := "getAllExerciseDate"
+func (store *mongoExerciseStore) GetAllUsers(ctx context.Context) (ExerciseUserList, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	logger := loggerFrom(ctx)
+	logger.Debug("Attempting to retrieve all exercise user data.")
+	funcName := "GetAllUsers"
 
 	// Execute a search with an empty filter interface
-	// to get the entire contents of the database
-	cursor, err := exerciseCollection.Find(context.TODO(), bson.M{})
+	// to get the entire contents of the database,
+	// then use the cursor to transfer all the contents into this slice of structs.
+	// Both are retried together, as a cursor can't be resumed.
+	var userCollection ExerciseUserList
+	err := retryDB(ctx, funcName, true, func() error {
+		cursor, err := store.reads.Find(ctx, bson.M{})
+		if err != nil {
+			return err
+		}
+		userCollection = nil
+		return cursor.All(ctx, &userCollection)
+	})
 	if err != nil {
-		log.Printf("Error in %s with Collection.Find: %s\n", funcName, err)
-		return []byte(`{"error":"Collection.Find failed"}`)
+		logger.Error("Collection.Find failed", "func", funcName, "err", err)
+		return nil, newStoreError(ErrStorage, "failed when reading from database")
 	}
 
-	// Use the cursor to transfer all the contents into this slice of structs
-	var userCollection []ExerciseUserRecord
-	err = cursor.All(context.TODO(), &userCollection)
+	logger.Debug("Returning exercise user records.", "count", len(userCollection))
+	return userCollection, nil
+}
+
+
+// Reads users from a cursor one at a time. There is no timeout or retry,
+// as the time taken depends on fn, and a cursor can't be resumed.
+func (store *mongoExerciseStore) StreamUsers(ctx context.Context, fn func(ExerciseUserRecord) error) error {
+	logger := loggerFrom(ctx)
+	funcName := "StreamUsers"
+	cursor, err := store.reads.Find(ctx, bson.D{}, options.Find().SetSort(bson.M{"_id": 1}))
 	if err != nil {
-		log.Printf("Error in %s with Cursor.All: %s\n", funcName, err)
-		return []byte(`{"error":"Cursor.All failed"}`)
+		logger.Error("Collection.Find failed", "func", funcName, "err", err)
+		return newStoreError(ErrStorage, "failed when reading from database")
 	}
+	defer cursor.Close(ctx)
 
-	// Convert the slice of structs to JSON
-	userCollectionAsJSON, err := json.Marshal(userCollection)
+	for cursor.Next(ctx) {
+		var record ExerciseUserRecord
+		if err := cursor.Decode(&record); err != nil {
+			logger.Error("Cursor.Decode failed", "func", funcName, "err", err)
+			return newStoreError(ErrStorage, "failed when reading from database")
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		logger.Error("Cursor.Next failed", "func", funcName, "err", err)
+		return newStoreError(ErrStorage, "failed when reading from database")
+	}
+	return nil
+}
+
+
+// Matches usernames with a regular expression, which for a prefix is anchored,
+// so that only the matching range of the unique index on username is read.
+func (store *mongoExerciseStore) SearchUsers(ctx context.Context, search string, substring bool, limit int) ([]ExerciseUser, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	funcName := "SearchUsers"
+
+	pattern := regexp.QuoteMeta(search)
+	if !substring {
+		pattern = "^" + pattern
+	}
+	filter := bson.M{"username": primitive.Regex{Pattern: pattern}}
+	opts := options.Find().
+		SetProjection(bson.M{"log": 0}).
+		SetSort(bson.M{"username": 1}).
+		SetLimit(int64(limit))
+	var users []ExerciseUser
+	err := retryDB(ctx, funcName, true, func() error {
+		cursor, err := store.reads.Find(ctx, filter, opts)
+		if err != nil {
+			return err
+		}
+		users = nil
+		return cursor.All(ctx, &users)
+	})
 	if err != nil {
-		log.Printf("Error in %s with json.Marshal: %s\n", funcName, err)
-		return []byte(`{"error":"json.Marshal failed"}`)
+		loggerFrom(ctx).Error("Collection.Find failed", "func", funcName, "err", err)
+		return nil, newStoreError(ErrStorage, "failed when searching database")
 	}
+	return users, nil
+}
+
+
+// Finds a user without reading their log.
+func (store *mongoExerciseStore) GetUser(ctx context.Context, userID string) (ExerciseUser, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	funcName := "GetUser"
 
-	log.Printf("%d users' records will be returned.\n", len(userCollection))
-	return userCollectionAsJSON
+	userIDObject, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ExerciseUser{}, newStoreError(ErrInvalidInput, "invalid id")
+	}
+	var user ExerciseUser
+	opts := options.FindOne().SetProjection(bson.M{"log": 0})
+	err = retryDB(ctx, funcName, true, func() error {
+		return store.reads.FindOne(ctx, bson.M{"_id": userIDObject}, opts).Decode(&user)
+	})
+	if err == mongo.ErrNoDocuments {
+		return ExerciseUser{}, newStoreError(ErrNotFound, "invalid user")
+	} else if err != nil {
+		loggerFrom(ctx).Error("Collection.FindOne failed", "func", funcName, "err", err)
+		return ExerciseUser{}, newStoreError(ErrStorage, "failed when searching database")
+	}
+	return user, nil
 }
 
 
 // Add a single exercise to an existing user's log
-func addExerciseToUser(userID string, desc string, duration string, date string) []byte {
-	log.Println("Attempting to add an exercise to a user.")
-	funcName := "addExerciseToUser"
+func (store *mongoExerciseStore) AddExercise(ctx context.Context, userID string, newExercise ExerciseRecord) (ExerciseAddedReceipt, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	logger := loggerFrom(ctx)
+	logger.Debug("Attempting to add an exercise to a user.", "id", userID)
+	funcName := "AddExercise"
 
 	// Make sure the ID is a valid MongoDB ObjectID
 	if !primitive.IsValidObjectID(userID) {
-		return []byte(`{"error":"invalid id"}`)
+		return ExerciseAddedReceipt{}, newStoreError(ErrInvalidInput, "invalid id")
 	}
 	// Now convert the ID string to an actual MongoDB ObjectID
 	userIDObject, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
-		log.Printf("Error in %s with primitive.ObjectIDFromHex: %s\n", funcName, err)
-		return []byte(`{"error":"invalid id"}`)
-	}
-
-	// Convert the duration string to an int
-	durationValue, err := strconv.Atoi(duration)
-	if err != nil {
-		log.Printf("Error in %s with strconv.Atoi: %s\n", funcName, err)
-		return []byte(`{"error":"invalid duration"}`)
-	}
-
-	// Convert the date string to a Time object
-	var dateObject time.Time
-	if len(date) > 0 {
-		dateObject, err = time.Parse("2006-01-02", date)
-		if err != nil {
-			log.Printf("Error in %s with time.Parse: %s\n", funcName, err)
-			return []byte(`{"error":"invalid date"}`)
-		}
-	} else {
-		dateObject = time.Now()
+		logger.Error("primitive.ObjectIDFromHex failed", "func", funcName, "err", err)
+		return ExerciseAddedReceipt{}, newStoreError(ErrInvalidInput, "invalid id")
 	}
 
-	// Save all the above exercise data in an object.
-	// This object will be added to the user's log in the database.
-	newExercise := ExerciseRecord{
-		Description: desc,
-		Duration: durationValue,
-		Date: dateObject,
-	}
-	log.Printf("Adding exercise: %+v\n", newExercise)
+	newExercise.ID = primitive.NewObjectID().Hex()
+	logger.Debug("Adding exercise.", "exercise", newExercise)
 
 	// Note that FindOneAndUpdate returns the document "as it appeared before updating"
 	var updatedDoc ExerciseUserRecord
-	err = exerciseCollection.FindOneAndUpdate(
-		context.TODO(),
-		bson.M{"_id": userIDObject},
-		bson.M{"$push": bson.M{"log": newExercise}},
-	).Decode(&updatedDoc)
-	if err != nil {
-		log.Printf("Error in %s with Collection.FindOneAndUpdate: %s\n", funcName, err)
-		errorString := `{"error":"unable to add exercise to` + userID + `"}`
-		return []byte(errorString)
+	err = retryDB(ctx, funcName, false, func() error {
+		return store.collection.FindOneAndUpdate(
+			ctx,
+			bson.M{"_id": userIDObject},
+			bson.M{"$push": bson.M{"log": newExercise}, "$inc": bson.M{"version": 1}},
+		).Decode(&updatedDoc)
+	})
+	if err == mongo.ErrNoDocuments {
+		return ExerciseAddedReceipt{}, newStoreError(ErrNotFound, "unknown user " + userID)
+	} else if err != nil {
+		logger.Error("Collection.FindOneAndUpdate failed", "func", funcName, "err", err)
+		return ExerciseAddedReceipt{}, newStoreError(ErrStorage, "unable to add exercise to " + userID)
 	}
 
 	// Return to the user a combination of
@@ -201,32 +363,69 @@ func addExerciseToUser(userID string, desc string, duration string, date string)
 	var receipt ExerciseAddedReceipt
 	receipt.ID = updatedDoc.ID
 	receipt.Username = updatedDoc.Username
-	receipt.Description = desc
-	receipt.Duration = durationValue
-	receipt.Date = dateObject
-	receiptInJSON, err := json.Marshal(receipt)
+	receipt.ExerciseID = newExercise.ID
+	receipt.Description = newExercise.Description
+	receipt.Duration = newExercise.Duration
+	receipt.Date = newExercise.Date
+	receipt.Category = newExercise.Category
+	receipt.Tags = newExercise.Tags
+	return receipt, nil
+}
+
+
+// Pushes exercises onto a user's log in a single update, which isn't retried,
+// as that could add them twice.
+func (store *mongoExerciseStore) AddExercises(ctx context.Context, userID string, exercises []ExerciseRecord) error {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	funcName := "AddExercises"
+
+	userIDObject, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return newStoreError(ErrInvalidInput, "invalid id")
+	}
+	for i := range exercises {
+		exercises[i].ID = primitive.NewObjectID().Hex()
+	}
+
+	var result *mongo.UpdateResult
+	err = retryDB(ctx, funcName, false, func() error {
+		var err error
+		result, err = store.collection.UpdateOne(
+			ctx,
+			bson.M{"_id": userIDObject},
+			bson.M{"$push": bson.M{"log": bson.M{"$each": exercises}}, "$inc": bson.M{"version": 1}},
+		)
+		return err
+	})
 	if err != nil {
-		log.Printf("Error in %s with json.Marshal: %s\n", funcName, err)
+		loggerFrom(ctx).Error("Collection.UpdateOne failed", "func", funcName, "err", err)
+		return newStoreError(ErrStorage, "unable to add exercises to " + userID)
+	}
+	if result.MatchedCount == 0 {
+		return newStoreError(ErrNotFound, "unknown user " + userID)
 	}
-	return receiptInJSON
+	return nil
 }
 
 
 // Return all the exercises for a specific user matching the given search criteria
-func getExerciseLogsFromUser(userID string, fromDate string, toDate string, limit string) []byte {
-	log.Printf("Attempting to retrieve exercise logs for %s.\n", userID)
-	log.Printf("{_id: %s, from: %s, to: %s, limit: %s}\n", userID, fromDate, toDate, limit)
-	funcName := "getExerciseLogsFromUser"
+func (store *mongoExerciseStore) GetExerciseLog(ctx context.Context, userID string, filter ExerciseLogFilter) (ExerciseUserRecord, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	logger := loggerFrom(ctx)
+	logger.Debug("Attempting to retrieve exercise logs.", "id", userID, "filter", filter)
+	funcName := "GetExerciseLog"
 
 	// Validate the ID string
 	if !primitive.IsValidObjectID(userID) {
-		log.Println("Invalid user ID.")
-		return []byte(`{"error":"invalid id"}`)
+		logger.Debug("Invalid user ID.", "id", userID)
+		return ExerciseUserRecord{}, newStoreError(ErrInvalidInput, "invalid id")
 	}
 	userIDObject, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
-		log.Println("Unable to convert to ObjectID.")
-		return []byte(`{"error":"invalid id"}`)
+		logger.Debug("Unable to convert to ObjectID.", "id", userID)
+		return ExerciseUserRecord{}, newStoreError(ErrInvalidInput, "invalid id")
 	}
 
 	// Initialize the aggregation pipeline
@@ -243,43 +442,16 @@ func getExerciseLogsFromUser(userID string, fromDate string, toDate string, limi
 	}
 	pipe = append(pipe, matchStage)
 
-	// Create the stage that counts the size of the user's exercise log
-	addFieldsStage := bson.M{
-		"$addFields": bson.M{
-			"count": bson.M{"$size": "$log"},
-		},
-	}
-	pipe = append(pipe, addFieldsStage)
+	fromDateObj, toDateObj, limitVal := filter.From, filter.To, filter.Limit
+	fromDateWasValid := !fromDateObj.IsZero()
+	toDateWasValid := !toDateObj.IsZero()
+	limitWasValid := limitVal > 0
 
-	// Validate the "from" date parameter
-	var fromDateObj time.Time
-	fromDateWasValid := false
-	if len(fromDate) > 0 {
-		fromDateObj, err = time.Parse("2006-01-02", fromDate)
-		fromDateWasValid = (err == nil)
-	}
-
-	// Validate the "to" date parameter
-	var toDateObj time.Time
-	toDateWasValid := false
-	if len(toDate) > 0 {
-		toDateObj, err = time.Parse("2006-01-02", toDate)
-		toDateWasValid = (err == nil)
-	}
-
-	// Validate the "limit" parameter
-	var limitVal int
-	limitWasValid := false
-	if len(limit) > 0 {
-		limitVal, err = strconv.Atoi(limit)
-		limitWasValid = (err == nil)
-	}
-
-	// Only continue if at least one of the 3 parameters was valid.
+	// Only continue if at least one of the 4 parameters was given.
 	// All of these require the use of an unwind stage.
-	if fromDateWasValid || toDateWasValid || limitWasValid {
-		// Unwind the log array and sort by log date
-		pipe = append(pipe, unwindStage, sortStage)
+	if filter.narrows() {
+		// Unwind the log array and sort it, by date unless asked otherwise
+		pipe = append(pipe, unwindStage, exerciseSortStage(filter))
 
 		if fromDateWasValid && toDateWasValid {
 			// from_date <= x <= to_date
@@ -310,57 +482,577 @@ func getExerciseLogsFromUser(userID string, fromDate string, toDate string, limi
 			pipe = append(pipe, matchDate)
 		}
 
-		// The limit parameter determines how many entries
-		// in the user's exercise log will be returned
-		if limitWasValid {
-			pipe = append(pipe, bson.M{"$limit": limitVal})
+		if len(filter.Category) > 0 {
+			matchCategory := bson.M{
+				"$match": bson.M{
+					"log.category": filter.Category,
+				},
+			}
+			pipe = append(pipe, matchCategory)
+		}
+		if len(filter.Tags) > 0 {
+			matchTags := bson.M{
+				"$match": bson.M{
+					"log.tags": bson.M{"$all": filter.Tags},
+				},
+			}
+			pipe = append(pipe, matchTags)
+		}
+		if len(filter.Description) > 0 {
+			matchDescription := bson.M{
+				"$match": bson.M{
+					"log.description": primitive.Regex{Pattern: filter.Description, Options: "i"},
+				},
+			}
+			pipe = append(pipe, matchDescription)
 		}
 
 		// Undo the unwind operation
 		pipe = append(pipe, regroupStage)
+
+		// Count the entries that matched, then keep only those from the offset on.
+		// The limit parameter determines how many of them will be returned
+		pageSize := math.MaxInt32
+		if limitWasValid {
+			pageSize = limitVal
+		}
+		pageStage := bson.M{
+			"$addFields": bson.M{
+				"total": bson.M{"$size": "$log"},
+				"log": bson.M{"$slice": bson.A{"$log", filter.Offset, pageSize}},
+			},
+		}
+		pipe = append(pipe, pageStage)
 	}
 
-	// Execute the search
-	cursor, err := exerciseCollection.Aggregate(context.TODO(), pipe)
+	// Execute the search and get the resulting document from the cursor
+	var doc ExerciseUserRecord
+	found := false
+	// Stop MongoDB itself from working on a search for too long
+	opts := options.Aggregate()
+	if len(filter.Description) > 0 && searchMaxTime > 0 {
+		opts.SetMaxTime(searchMaxTime)
+	}
+	err = retryDB(ctx, funcName, true, func() error {
+		cursor, err := store.reads.Aggregate(ctx, pipe, opts)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		if found = cursor.Next(ctx); found {
+			return cursor.Decode(&doc)
+		}
+		return cursor.Err()
+	})
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(maxTimeMSExpiredCode) {
+		logger.Warn("Exercise log search took too long", "func", funcName, "q", filter.Description)
+		return ExerciseUserRecord{}, newStoreError(ErrInvalidInput, "searching for q took too long")
+	}
 	if err != nil {
-		log.Printf("Error in %s with Collection.Aggregate: %s\n", funcName, err)
+		logger.Error("Collection.Aggregate failed", "func", funcName, "err", err)
+		return ExerciseUserRecord{}, newStoreError(ErrStorage, "failed when searching database")
 	}
 
-	// Initialize a byte slice that will hold the JSON to be returned
-	var docJSON []byte
+	if !found {
+		// Perhaps the user exists but hasn't added to his/her log yet.
+		err = retryDB(ctx, funcName, true, func() error {
+			return store.reads.FindOne(ctx, bson.M{"_id": userIDObject}).Decode(&doc)
+		})
+		if err == mongo.ErrNoDocuments {
+			return ExerciseUserRecord{}, newStoreError(ErrNotFound, "invalid user")
+		} else if err != nil {
+			logger.Error("Collection.FindOne failed", "func", funcName, "err", err)
+			return ExerciseUserRecord{}, newStoreError(ErrStorage, "Collection.FindOne failed")
+		}
+		// Or perhaps none of the entries matched
+		if filter.narrows() {
+			doc.Log = []ExerciseRecord{}
+		}
+	}
+	// The count is of the entries returned, whether or not the log was narrowed down
+	count := len(doc.Log)
+	doc.Count = &count
+	if doc.Total == nil {
+		doc.Total = &count
+	}
 
-	// Get the resulting document from the cursor
-	if cursor.Next(context.TODO()) {
-		var doc ExerciseUserRecord
-		if err = cursor.Decode(&doc); err != nil {
-			log.Printf("Error in %s with Cursor.Decode: %s\n", funcName, err)
-			errorString := `{"error":"Cursor.Decode failed"}`
-			docJSON = []byte(errorString)
-		} else {
-			// Convert the document to JSON
-			docJSON, err = json.Marshal(doc)
-			if err != nil {
-				log.Printf("Error in %s with json.Marshal: %s\n", funcName, err)
-			}
+	return doc, nil
+}
+
+
+// Summarizes a user's log in a single aggregation, which groups their exercises by day,
+// as exerciseDay finds it, and by the Monday that starts their ISO week.
+// A user without exercises has an empty result for each facet.
+func (store *mongoExerciseStore) GetExerciseStats(ctx context.Context, userID string, loc *time.Location) (ExerciseStats, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	funcName := "GetExerciseStats"
+
+	userIDObject, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ExerciseStats{}, newStoreError(ErrInvalidInput, "invalid id")
+	}
+
+	// Midnight UTC on the day of the exercise, which is only moved into the time zone
+	// if the exercise has a time of day
+	atMidnight := bson.M{"$eq": bson.A{bson.M{"$dateToString": bson.M{"format": "%H:%M:%S.%L", "date": "$log.date"}}, "00:00:00.000"}}
+	localDay := bson.M{"$dateFromString": bson.M{
+		"dateString": bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$log.date", "timezone": loc.String()}},
+	}}
+	addDay := bson.M{"$addFields": bson.M{"day": bson.M{"$cond": bson.A{atMidnight, "$log.date", localDay}}}}
+	weekStart := bson.M{"$dateFromParts": bson.M{
+		"isoWeekYear": bson.M{"$isoWeekYear": "$day"},
+		"isoWeek": bson.M{"$isoWeek": "$day"},
+		"isoDayOfWeek": 1,
+	}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"_id": userIDObject}}},
+		{{Key: "$facet", Value: bson.M{
+			"user": bson.A{bson.M{"$project": bson.M{"username": 1}}},
+			"totals": bson.A{
+				bson.M{"$unwind": "$log"},
+				bson.M{"$group": bson.M{
+					"_id": nil,
+					"exercises": bson.M{"$sum": 1},
+					"minutes": bson.M{"$sum": "$log.duration"},
+					"average": bson.M{"$avg": "$log.duration"},
+				}},
+			},
+			"weeks": bson.A{
+				bson.M{"$unwind": "$log"},
+				addDay,
+				bson.M{"$group": bson.M{
+					"_id": bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": weekStart}},
+					"exercises": bson.M{"$sum": 1},
+					"minutes": bson.M{"$sum": "$log.duration"},
+				}},
+				bson.M{"$sort": bson.M{"_id": 1}},
+			},
+			"days": bson.A{
+				bson.M{"$unwind": "$log"},
+				addDay,
+				bson.M{"$group": bson.M{"_id": bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$day"}}}},
+				bson.M{"$sort": bson.M{"_id": 1}},
+			},
+			"tags": bson.A{
+				bson.M{"$unwind": "$log"},
+				bson.M{"$unwind": "$log.tags"},
+				bson.M{"$group": bson.M{"_id": "$log.tags", "count": bson.M{"$sum": 1}}},
+				bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			},
+		}}},
+	}
+
+	var results []struct {
+		User   []ExerciseUser   `bson:"user"`
+		Totals []struct {
+			Exercises int     `bson:"exercises"`
+			Minutes   int     `bson:"minutes"`
+			Average   float64 `bson:"average"`
+		} `bson:"totals"`
+		Weeks  []WeeklyExercise `bson:"weeks"`
+		Days   []struct{ Day string `bson:"_id"` } `bson:"days"`
+		Tags   []TagCount       `bson:"tags"`
+	}
+	err = retryDB(ctx, funcName, true, func() error {
+		cursor, err := store.reads.Aggregate(ctx, pipeline)
+		if err != nil {
+			return err
 		}
+		results = nil
+		return cursor.All(ctx, &results)
+	})
+	if err != nil {
+		loggerFrom(ctx).Error("Collection.Aggregate failed", "func", funcName, "err", err)
+		return ExerciseStats{}, newStoreError(ErrStorage, "failed when searching database")
+	}
+	if len(results) == 0 || len(results[0].User) == 0 {
+		return ExerciseStats{}, newStoreError(ErrNotFound, "invalid user")
 	}
 
-	if err = cursor.Err(); err != nil {
-		// Perhaps the user exists but hasn't added to his/her log yet.
-		var foundDoc ExerciseUserRecord
-		err = exerciseCollection.FindOne(context.TODO(), bson.M{"_id": userIDObject}).Decode(&foundDoc)
+	result := results[0]
+	stats := ExerciseStats{ID: userID, Username: result.User[0].Username, Weeks: result.Weeks, Tags: result.Tags}
+	if len(result.Totals) > 0 {
+		stats.TotalExercises = result.Totals[0].Exercises
+		stats.TotalMinutes = result.Totals[0].Minutes
+		stats.AverageDuration = result.Totals[0].Average
+	}
+	days := make([]string, len(result.Days))
+	for i, day := range result.Days {
+		days[i] = day.Day
+	}
+	stats.CurrentStreak, stats.LongestStreak = exerciseStreaks(days, loc)
+	return stats, nil
+}
+
+
+// Ranks users in a single aggregation over every log, which is why the handler caches it.
+func (store *mongoExerciseStore) GetLeaderboard(ctx context.Context, since time.Time, by string, limit int) ([]LeaderboardEntry, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	funcName := "GetLeaderboard"
+
+	pipeline := mongo.Pipeline{{{Key: "$unwind", Value: "$log"}}}
+	if !since.IsZero() {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"log.date": bson.M{"$gte": since}}}})
+	}
+	sortKeys := bson.D{{Key: "minutes", Value: -1}, {Key: "exercises", Value: -1}, {Key: "_id", Value: 1}}
+	if by == leaderboardByExercises {
+		sortKeys = bson.D{{Key: "exercises", Value: -1}, {Key: "minutes", Value: -1}, {Key: "_id", Value: 1}}
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id": "$_id",
+			"username": bson.M{"$first": "$username"},
+			"display_name": bson.M{"$first": "$display_name"},
+			"minutes": bson.M{"$sum": "$log.duration"},
+			"exercises": bson.M{"$sum": 1},
+		}}},
+		bson.D{{Key: "$sort", Value: sortKeys}},
+		bson.D{{Key: "$limit", Value: limit}},
+	)
+
+	var users []LeaderboardEntry
+	err := retryDB(ctx, funcName, true, func() error {
+		cursor, err := store.reads.Aggregate(ctx, pipeline)
 		if err != nil {
-			log.Printf("Error in %s with Collection.FindOne: %s\n", funcName, err)
-			return []byte(`{"error":"invalid user"}`)
+			return err
+		}
+		users = nil
+		return cursor.All(ctx, &users)
+	})
+	if err != nil {
+		loggerFrom(ctx).Error("Collection.Aggregate failed", "func", funcName, "err", err)
+		return nil, newStoreError(ErrStorage, "failed when searching database")
+	}
+	return users, nil
+}
+
+
+// Returns the number of exercise users in the database.
+func (store *mongoExerciseStore) CountUsers(ctx context.Context) (int64, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	var count int64
+	err := retryDB(ctx, "CountUsers", true, func() error {
+		var err error
+		count, err = store.collection.CountDocuments(ctx, bson.D{})
+		return err
+	})
+	if err != nil {
+		loggerFrom(ctx).Error("Collection.CountDocuments failed", "func", "CountUsers", "err", err)
+		return 0, newStoreError(ErrStorage, "failed when counting database")
+	}
+	return count, nil
+}
+
+
+// Deletes a user along with their exercise log, returning both.
+func (store *mongoExerciseStore) DeleteUser(ctx context.Context, userID string) (ExerciseUserRecord, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	logger := loggerFrom(ctx)
+	logger.Debug("Attempting to delete exercise user.", "id", userID)
+
+	userIDObject, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ExerciseUserRecord{}, newStoreError(ErrInvalidInput, "invalid id")
+	}
+	// Deleting again would find nothing, so it is only retried if rejected
+	var deleted ExerciseUserRecord
+	err = retryDB(ctx, "DeleteUser", false, func() error {
+		return store.collection.FindOneAndDelete(ctx, bson.M{"_id": userIDObject}).Decode(&deleted)
+	})
+	if err == mongo.ErrNoDocuments {
+		return ExerciseUserRecord{}, newStoreError(ErrNotFound, "unknown user " + userID)
+	} else if err != nil {
+		logger.Error("Collection.FindOneAndDelete failed", "func", "DeleteUser", "err", err)
+		return ExerciseUserRecord{}, newStoreError(ErrStorage, "failed when deleting from database")
+	}
+	logger.Info("Exercise user deleted.", "id", userID)
+	return deleted, nil
+}
+
+// Removes a single exercise from a user's log.
+func (store *mongoExerciseStore) DeleteExercise(ctx context.Context, userID string, exerciseID string) error {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	logger := loggerFrom(ctx)
+	logger.Debug("Attempting to delete exercise.", "id", userID, "exercise_id", exerciseID)
+	funcName := "DeleteExercise"
+
+	userIDObject, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return newStoreError(ErrInvalidInput, "invalid id")
+	}
+	if !primitive.IsValidObjectID(exerciseID) {
+		return newStoreError(ErrInvalidInput, "invalid exercise id")
+	}
+
+	// Pulling again would find nothing, so it is only retried if rejected
+	var result *mongo.UpdateResult
+	err = retryDB(ctx, funcName, false, func() error {
+		var err error
+		result, err = store.collection.UpdateOne(ctx,
+			bson.M{"_id": userIDObject, "log._id": exerciseID},
+			bson.M{"$pull": bson.M{"log": bson.M{"_id": exerciseID}}, "$inc": bson.M{"version": 1}})
+		return err
+	})
+	if err != nil {
+		logger.Error("Collection.UpdateOne failed", "func", funcName, "err", err)
+		return newStoreError(ErrStorage, "failed when updating database")
+	}
+	if result.MatchedCount == 0 {
+		return newStoreError(ErrNotFound, "unknown exercise " + exerciseID + " for user " + userID)
+	}
+	logger.Info("Exercise deleted.", "id", userID, "exercise_id", exerciseID)
+	return nil
+}
+
+// Changes a single exercise in a user's log, finding it with the positional operator,
+// and returns it as it now is.
+func (store *mongoExerciseStore) UpdateExercise(ctx context.Context, userID string, exerciseID string, update ExerciseUpdate) (ExerciseRecord, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	logger := loggerFrom(ctx)
+	logger.Debug("Attempting to update exercise.", "id", userID, "exercise_id", exerciseID)
+	funcName := "UpdateExercise"
+
+	userIDObject, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ExerciseRecord{}, newStoreError(ErrInvalidInput, "invalid id")
+	}
+	if !primitive.IsValidObjectID(exerciseID) {
+		return ExerciseRecord{}, newStoreError(ErrInvalidInput, "invalid exercise id")
+	}
+	changes := bson.M{}
+	if update.Description != nil {
+		changes["log.$.description"] = *update.Description
+	}
+	if update.Duration != nil {
+		changes["log.$.duration"] = *update.Duration
+	}
+	if update.Date != nil {
+		changes["log.$.date"] = *update.Date
+	}
+
+	// The version would be incremented twice if it were repeated
+	var updatedDoc ExerciseUserRecord
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"log": bson.M{"$elemMatch": bson.M{"_id": exerciseID}}})
+	err = retryDB(ctx, funcName, false, func() error {
+		return store.collection.FindOneAndUpdate(ctx,
+			bson.M{"_id": userIDObject, "log._id": exerciseID},
+			bson.M{"$set": changes, "$inc": bson.M{"version": 1}},
+			opts,
+		).Decode(&updatedDoc)
+	})
+	if err == mongo.ErrNoDocuments || (err == nil && len(updatedDoc.Log) == 0) {
+		return ExerciseRecord{}, newStoreError(ErrNotFound, "unknown exercise " + exerciseID + " for user " + userID)
+	} else if err != nil {
+		logger.Error("Collection.FindOneAndUpdate failed", "func", funcName, "err", err)
+		return ExerciseRecord{}, newStoreError(ErrStorage, "failed when updating database")
+	}
+	return updatedDoc.Log[0], nil
+}
+
+
+// Changes a user's profile, returning the user without their log.
+// The version would be incremented twice if it were repeated, so it isn't retried.
+func (store *mongoExerciseStore) UpdateUserProfile(ctx context.Context, userID string, update ExerciseProfileUpdate, version int64) (ExerciseUser, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	funcName := "UpdateUserProfile"
+
+	userIDObject, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ExerciseUser{}, newStoreError(ErrInvalidInput, "invalid id")
+	}
+	set, unset := bson.M{}, bson.M{}
+	change := func(field string, value any, empty bool) {
+		if empty {
+			unset[field] = ""
 		} else {
-			// Convert the document to JSON
-			docJSON, err = json.Marshal(foundDoc)
-			if err != nil {
-				log.Printf("Error in %s with json.Marshal: %s\n", funcName, err)
-			}
+			set[field] = value
+		}
+	}
+	if update.DisplayName != nil {
+		change("display_name", *update.DisplayName, len(*update.DisplayName) == 0)
+	}
+	if update.TimeZone != nil {
+		change("timezone", *update.TimeZone, len(*update.TimeZone) == 0)
+	}
+	if update.WeeklyGoal != nil {
+		change("weekly_goal", *update.WeeklyGoal, *update.WeeklyGoal == 0)
+	}
+	changes := bson.M{}
+	if len(set) > 0 {
+		changes["$set"] = set
+	}
+	if len(unset) > 0 {
+		changes["$unset"] = unset
+	}
+
+	var user ExerciseUser
+	opts := options.FindOneAndUpdate().SetProjection(bson.M{"log": 0})
+	err = updateVersioned(ctx, funcName, store.collection, bson.M{"_id": userIDObject}, version, changes, opts, &user)
+	if errors.Is(err, ErrNotFound) {
+		return ExerciseUser{}, newStoreError(ErrNotFound, "unknown user " + userID)
+	} else if err != nil {
+		return ExerciseUser{}, err
+	}
+	return user, nil
+}
+
+
+// Relies on the unique index on username to reject one that another user has.
+func (store *mongoExerciseStore) RenameUser(ctx context.Context, userID string, username string, version int64) (ExerciseUser, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	funcName := "RenameUser"
+
+	userIDObject, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ExerciseUser{}, newStoreError(ErrInvalidInput, "invalid id")
+	}
+	changes := bson.M{"$set": bson.M{"username": username}}
+	var user ExerciseUser
+	opts := options.FindOneAndUpdate().SetProjection(bson.M{"log": 0})
+	err = updateVersioned(ctx, funcName, store.collection, bson.M{"_id": userIDObject}, version, changes, opts, &user)
+	if errors.Is(err, ErrNotFound) {
+		return ExerciseUser{}, newStoreError(ErrNotFound, "unknown user " + userID)
+	} else if mongo.IsDuplicateKeyError(err) {
+		return ExerciseUser{}, newStoreError(ErrDuplicate, "username " + username + " is taken")
+	} else if err != nil {
+		return ExerciseUser{}, err
+	}
+	return user, nil
+}
+
+
+// Passes every user to fn along with their whole log, in the order in which they were created.
+// The export can take as long as it needs, so it isn't subject to DB_OP_TIMEOUT,
+// and it isn't retried, as fn may already have been given some of the users.
+func (store *mongoExerciseStore) ExportUsers(ctx context.Context, fn func(ExerciseUserExport) error) error {
+	logger := loggerFrom(ctx)
+	cursor, err := store.reads.Find(ctx, bson.D{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		logger.Error("Collection.Find failed", "func", "ExportUsers", "err", err)
+		return newStoreError(ErrStorage, "failed when reading from database")
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var record ExerciseUserRecord
+		if err := cursor.Decode(&record); err != nil {
+			logger.Error("Cursor.Decode failed", "func", "ExportUsers", "err", err)
+			return newStoreError(ErrStorage, "failed when reading from database")
 		}
+		if err := fn(exportUser(record)); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		logger.Error("Cursor.Next failed", "func", "ExportUsers", "err", err)
+		return newStoreError(ErrStorage, "failed when reading from database")
 	}
+	return nil
+}
+
+
+// Restores exported users in a single unordered bulk write,
+// so that a user who clashes doesn't stop the others.
+// Repeating it could turn users who were imported into duplicates, so it is only retried if rejected.
+func (store *mongoExerciseStore) ImportUsers(ctx context.Context, users []ExerciseUserExport, replace bool) ([]error, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	funcName := "ImportUsers"
 
-	return docJSON
+	models := make([]mongo.WriteModel, 0, len(users))
+	for _, u := range users {
+		// The IDs were validated by the handler
+		id, _ := primitive.ObjectIDFromHex(u.ID)
+		doc := bson.M{"_id": id, "username": u.Username, "log": u.Log, "version": u.Version}
+		// Profile fields that were never set are left out, as they are when removed
+		if len(u.DisplayName) > 0 {
+			doc["display_name"] = u.DisplayName
+		}
+		if len(u.TimeZone) > 0 {
+			doc["timezone"] = u.TimeZone
+		}
+		if u.WeeklyGoal > 0 {
+			doc["weekly_goal"] = u.WeeklyGoal
+		}
+		if replace {
+			models = append(models, mongo.NewReplaceOneModel().
+				SetFilter(bson.M{"_id": id}).
+				SetReplacement(doc).
+				SetUpsert(true))
+		} else {
+			models = append(models, mongo.NewInsertOneModel().SetDocument(doc))
+		}
+	}
+	err := retryDB(ctx, funcName, false, func() error {
+		_, err := store.collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		return err
+	})
+	return bulkWriteErrors(ctx, funcName, err, len(users), func(i int, message string) error {
+		if strings.Contains(message, "username") {
+			return newStoreError(ErrDuplicate, "username " + users[i].Username + " belongs to another user")
+		}
+		return newStoreError(ErrDuplicate, "user " + users[i].ID + " already exists")
+	})
 }
 
+
+// Removes the exercises dated before the cutoff from every log
+// and returns how many there were.
+// It can take as long as it needs, so it isn't subject to DB_OP_TIMEOUT.
+func (store *mongoExerciseStore) PruneExercises(ctx context.Context, cutoff time.Time, dryRun bool) (int64, error) {
+	logger := loggerFrom(ctx)
+	funcName := "PruneExercises"
+	old := bson.M{"$lt": cutoff}
+
+	pipe := []bson.M{
+		{"$match": bson.M{"log.date": old}},
+		unwindStage,
+		{"$match": bson.M{"log.date": old}},
+		{"$count": "count"},
+	}
+	var result struct {
+		Count int64 `bson:"count"`
+	}
+	err := retryDB(ctx, funcName, true, func() error {
+		cursor, err := store.collection.Aggregate(ctx, pipe)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		if cursor.Next(ctx) {
+			return cursor.Decode(&result)
+		}
+		return cursor.Err()
+	})
+	if err != nil {
+		logger.Error("Collection.Aggregate failed", "func", funcName, "err", err)
+		return 0, newStoreError(ErrStorage, "failed when searching database")
+	}
+	if dryRun || result.Count == 0 {
+		return result.Count, nil
+	}
+
+	// Pulling again is harmless, but the version would be incremented twice
+	err = retryDB(ctx, funcName, false, func() error {
+		_, err := store.collection.UpdateMany(ctx,
+			bson.M{"log.date": old},
+			bson.M{"$pull": bson.M{"log": bson.M{"date": old}}, "$inc": bson.M{"version": 1}})
+		return err
+	})
+	if err != nil {
+		logger.Error("Collection.UpdateMany failed", "func", funcName, "err", err)
+		return 0, newStoreError(ErrStorage, "failed when updating database")
+	}
+	return result.Count, nil
+}
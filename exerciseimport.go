@@ -0,0 +1,280 @@
+// Adds exercises to a user's log in bulk from a CSV file, or from the GPX and TCX files
+// that fitness trackers such as Strava export, so that users can bring their history with them.
+package main
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The kinds of file that exercises can be imported from
+const (
+	exerciseImportCSV = "csv"
+	exerciseImportGPX = "gpx"
+	exerciseImportTCX = "tcx"
+)
+
+var (
+	errInvalidExerciseImportFormat = newErrorMessage(http.StatusBadRequest, "format must be csv, gpx, or tcx")
+	errExerciseCSVHeader           = newErrorMessage(http.StatusBadRequest, "the first row of the csv must name its columns, including description and duration")
+)
+
+// A GPX file, of which only the tracks are imported, one exercise for each.
+type gpxFile struct {
+	Tracks []struct {
+		Name     string `xml:"name"`
+		// e.g. "running", or a number in files from some trackers
+		Type     string `xml:"type"`
+		Segments []struct {
+			Points []struct {
+				Time string `xml:"time"`
+			} `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+// A TCX file, with one exercise for each activity.
+type tcxFile struct {
+	Activities []struct {
+		// e.g. "Running", "Biking", or "Other"
+		Sport string `xml:"Sport,attr"`
+		// When the activity started
+		ID    string `xml:"Id"`
+		Notes string `xml:"Notes"`
+		Laps  []struct {
+			TotalTimeSeconds float64 `xml:"TotalTimeSeconds"`
+		} `xml:"Lap"`
+	} `xml:"Activities>Activity"`
+}
+
+// An exercise read from an import, or why it couldn't be.
+type importedExercise struct {
+	// The line of a CSV file that the row starts on, or the number of a track or activity
+	Line     int
+	Exercise ExerciseRecord
+	Err      error
+}
+
+
+// Adds the exercises in the file uploaded as "file" to the log of the user whose ID is in the path,
+// and reports those that couldn't be added. The file is read as the "format" in the form data,
+// or else by its extension, as CSV by default. A CSV file names its columns in its first row:
+// description and duration are required, while date, category, tags as a comma-separated list,
+// and the unit of the duration are optional.
+// GPX tracks and TCX activities each become an exercise, which lasts from their start to their end.
+// Either every valid exercise is added or, if the store fails, none of them are.
+func postExerciseImport(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFrom(r.Context())
+	funcName := "postExerciseImport"
+
+	// The size of the body is limited by the body limit middleware
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		logger.Error("Request.ParseMultipartForm failed", "func", funcName, "err", err)
+		writeError(w, r, formError(err))
+		return
+	}
+	file, fileHeader, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, r, newErrorMessage(http.StatusBadRequest, "no file uploaded"))
+		return
+	}
+	defer file.Close()
+
+	format := strings.ToLower(r.FormValue("format"))
+	if len(format) == 0 {
+		format = strings.TrimPrefix(strings.ToLower(path.Ext(fileHeader.Filename)), ".")
+		if format != exerciseImportGPX && format != exerciseImportTCX {
+			format = exerciseImportCSV
+		}
+	}
+	var imported []importedExercise
+	switch format {
+	case exerciseImportCSV:
+		imported, err = readExerciseCSV(file)
+	case exerciseImportGPX:
+		imported, err = readExerciseGPX(file)
+	case exerciseImportTCX:
+		imported, err = readExerciseTCX(file)
+	default:
+		err = errInvalidExerciseImportFormat
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	report := ImportReport{Errors: []ImportError{}}
+	var exercises []ExerciseRecord
+	for _, entry := range imported {
+		// Activities from GPX and TCX files don't go through parseExercise
+		if entry.Err == nil {
+			entry.Err = validateExercise(entry.Exercise)
+		}
+		if entry.Err != nil {
+			report.Failed++
+			report.Errors = append(report.Errors, ImportError{Line: entry.Line, Error: toErrorMessage(entry.Err).Content})
+			continue
+		}
+		exercises = append(exercises, entry.Exercise)
+	}
+	if len(exercises) > 0 {
+		if err := exerciseStore.AddExercises(r.Context(), r.PathValue("id"), exercises); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	report.Imported = len(exercises)
+
+	logger.Info("Imported exercises.", "id", r.PathValue("id"), "format", format, "imported", report.Imported, "failed", report.Failed)
+	writeJSON(w, http.StatusOK, report)
+}
+
+
+// Reads an exercise from each row of a CSV file after its header.
+func readExerciseCSV(file io.Reader) ([]importedExercise, error) {
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errExerciseCSVHeader
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	if _, ok := columns["description"]; !ok {
+		return nil, errExerciseCSVHeader
+	}
+	if _, ok := columns["duration"]; !ok {
+		return nil, errExerciseCSVHeader
+	}
+
+	var imported []importedExercise
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, newErrorMessage(http.StatusBadRequest, "invalid csv on line " + strconv.Itoa(parseErr.Line))
+		} else if err != nil {
+			return nil, formError(err)
+		}
+		cell := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+
+		// Quoted cells can span lines, so the row's line is taken from the reader
+		line, _ := reader.FieldPos(0)
+		entry := importedExercise{Line: line}
+		entry.Exercise, entry.Err = parseExercise(cell("description"), cell("duration"), cell("unit"), cell("date"))
+		if entry.Err == nil {
+			entry.Exercise.Category, entry.Err = parseExerciseCategory(cell("category"))
+		}
+		if entry.Err == nil {
+			entry.Exercise.Tags, entry.Err = parseExerciseTags([]string{cell("tags")})
+		}
+		imported = append(imported, entry)
+	}
+	return imported, nil
+}
+
+
+// Reads an exercise from each track in a GPX file, described by its name
+// and lasting from its first point to its last.
+func readExerciseGPX(file io.Reader) ([]importedExercise, error) {
+	var gpx gpxFile
+	if err := xml.NewDecoder(file).Decode(&gpx); err != nil {
+		return nil, newErrorMessage(http.StatusBadRequest, "invalid gpx file")
+	}
+
+	imported := make([]importedExercise, len(gpx.Tracks))
+	for i, track := range gpx.Tracks {
+		imported[i].Line = i + 1
+		var start, end time.Time
+		for _, segment := range track.Segments {
+			for _, point := range segment.Points {
+				at, err := time.Parse(time.RFC3339, point.Time)
+				if err != nil {
+					continue
+				}
+				if start.IsZero() || at.Before(start) {
+					start = at
+				}
+				if at.After(end) {
+					end = at
+				}
+			}
+		}
+		if start.IsZero() {
+			imported[i].Err = newErrorMessage(http.StatusBadRequest, "track has no times")
+			continue
+		}
+		imported[i].Exercise = importedActivity(track.Name, track.Type, start, end.Sub(start))
+	}
+	return imported, nil
+}
+
+
+// Reads an exercise from each activity in a TCX file, lasting as long as its laps put together.
+func readExerciseTCX(file io.Reader) ([]importedExercise, error) {
+	var tcx tcxFile
+	if err := xml.NewDecoder(file).Decode(&tcx); err != nil {
+		return nil, newErrorMessage(http.StatusBadRequest, "invalid tcx file")
+	}
+
+	imported := make([]importedExercise, len(tcx.Activities))
+	for i, activity := range tcx.Activities {
+		imported[i].Line = i + 1
+		start, err := time.Parse(time.RFC3339, strings.TrimSpace(activity.ID))
+		if err != nil {
+			imported[i].Err = newErrorMessage(http.StatusBadRequest, "activity has no start time")
+			continue
+		}
+		var seconds float64
+		for _, lap := range activity.Laps {
+			seconds += lap.TotalTimeSeconds
+		}
+		imported[i].Exercise = importedActivity(activity.Notes, activity.Sport, start, time.Duration(seconds * float64(time.Second)))
+	}
+	return imported, nil
+}
+
+
+// Converts an activity from a fitness tracker into an exercise, named after its sport if
+// it has no name of its own, with its length rounded to the nearest minute.
+// Sports that are only numbers, as some trackers give them, aren't kept as the category.
+func importedActivity(name string, sport string, start time.Time, length time.Duration) ExerciseRecord {
+	sport = strings.TrimSpace(sport)
+	if _, err := strconv.Atoi(sport); err == nil {
+		sport = ""
+	}
+	name = strings.TrimSpace(name)
+	if len(name) == 0 {
+		name = strings.TrimSpace(sport + " activity")
+	}
+	category, err := parseExerciseCategory(sport)
+	if err != nil {
+		category = ""
+	}
+	return ExerciseRecord{
+		Description: name,
+		Duration:    int(math.Round(length.Minutes())),
+		Date:        start.UTC(),
+		Category:    category,
+	}
+}
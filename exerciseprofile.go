@@ -0,0 +1,123 @@
+// Lets exercise users describe themselves beyond their username,
+// with a name to show, the time zone they are in, and a goal for each week.
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// The longest display name that a user can have
+const maxDisplayNameLength = 50
+
+// The most minutes a week that a user can aim for, which is every minute of it
+const maxWeeklyGoal = 7 * 24 * 60
+
+var (
+	errDisplayNameTooLong = newErrorMessage(http.StatusBadRequest, "display_name can be at most " + strconv.Itoa(maxDisplayNameLength) + " characters")
+	errInvalidWeeklyGoal  = newErrorMessage(http.StatusBadRequest, "weekly_goal must be a number of minutes from 0 to " + strconv.Itoa(maxWeeklyGoal))
+	errNoProfileFields    = newErrorMessage(http.StatusBadRequest, "at least one of display_name, timezone, and weekly_goal is required")
+)
+
+// What a user has said about themselves. Each field is missing until they set it.
+type ExerciseProfile struct {
+	DisplayName string `json:"display_name,omitempty" bson:"display_name,omitempty" xml:"display_name,omitempty"`
+	// An IANA time zone, e.g. "America/New_York"
+	TimeZone    string `json:"timezone,omitempty" bson:"timezone,omitempty" xml:"timezone,omitempty"`
+	// How many minutes of exercise the user aims for each week
+	WeeklyGoal  int    `json:"weekly_goal,omitempty" bson:"weekly_goal,omitempty" xml:"weekly_goal,omitempty"`
+}
+
+// Changes to a user's profile. Nil fields are left as they are, while empty ones are removed.
+type ExerciseProfileUpdate struct {
+	DisplayName *string
+	TimeZone    *string
+	WeeklyGoal  *int
+}
+
+
+// Converts the profile fields sent by a client as form data into an update.
+// Fields that are sent empty, or a weekly_goal of 0, are removed from the profile.
+func parseExerciseProfileUpdate(form url.Values) (ExerciseProfileUpdate, error) {
+	var update ExerciseProfileUpdate
+	if form.Has("display_name") {
+		displayName := strings.TrimSpace(form.Get("display_name"))
+		if len(displayName) > maxDisplayNameLength {
+			return ExerciseProfileUpdate{}, errDisplayNameTooLong
+		}
+		update.DisplayName = &displayName
+	}
+	if form.Has("timezone") {
+		timeZone := strings.TrimSpace(form.Get("timezone"))
+		if len(timeZone) > 0 {
+			if _, err := parseTimeZone(timeZone); err != nil {
+				return ExerciseProfileUpdate{}, newErrorMessage(http.StatusBadRequest, "timezone must be an IANA time zone, e.g. America/New_York")
+			}
+		}
+		update.TimeZone = &timeZone
+	}
+	if form.Has("weekly_goal") {
+		weeklyGoal := 0
+		if value := strings.TrimSpace(form.Get("weekly_goal")); len(value) > 0 {
+			var err error
+			weeklyGoal, err = strconv.Atoi(value)
+			if err != nil || weeklyGoal < 0 || weeklyGoal > maxWeeklyGoal {
+				return ExerciseProfileUpdate{}, errInvalidWeeklyGoal
+			}
+		}
+		update.WeeklyGoal = &weeklyGoal
+	}
+	if update == (ExerciseProfileUpdate{}) {
+		return ExerciseProfileUpdate{}, errNoProfileFields
+	}
+	return update, nil
+}
+
+
+// Applies an update to a profile.
+func (profile *ExerciseProfile) apply(update ExerciseProfileUpdate) {
+	if update.DisplayName != nil {
+		profile.DisplayName = *update.DisplayName
+	}
+	if update.TimeZone != nil {
+		profile.TimeZone = *update.TimeZone
+	}
+	if update.WeeklyGoal != nil {
+		profile.WeeklyGoal = *update.WeeklyGoal
+	}
+}
+
+
+// Changes the "display_name", "timezone", and "weekly_goal" in the profile of the user
+// whose ID is in the path to those in the form data, and sends back the user.
+// Only the fields that are given are changed. If an If-Match header is given, such as the ETag
+// of the user's log, the change is only made if the user is still at that version.
+func patchExerciseUser(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFrom(r.Context())
+	funcName := "patchExerciseUser"
+
+	if err := r.ParseForm(); err != nil {
+		logger.Error("Request.ParseForm failed", "func", funcName, "err", err)
+		writeError(w, r, formError(err))
+		return
+	}
+	version, err := parseIfMatch(r.Header.Get("If-Match"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	update, err := parseExerciseProfileUpdate(r.Form)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	user, err := exerciseStore.UpdateUserProfile(r.Context(), r.PathValue("id"), update, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", `W/"` + strconv.FormatInt(user.Version, 10) + `"`)
+	writeResponse(w, r, http.StatusOK, user)
+}
@@ -0,0 +1,245 @@
+// Summarizes a user's exercise log, so that front-ends can chart it
+// without downloading the whole log.
+package main
+
+import (
+	"encoding/xml"
+	"math"
+	"net/http"
+	"time"
+	// So that time zones can be looked up on systems without a time zone database
+	_ "time/tzdata"
+)
+
+// How many weeks before the last with exercises have the weeks without exercises
+// filled in, so that a log spanning centuries can't make for a huge response
+const maxFilledExerciseWeeks = 520
+
+var errInvalidTimeZone = newErrorMessage(http.StatusBadRequest, "tz must be an IANA time zone, e.g. America/New_York")
+
+// A summary of a user's whole exercise log.
+type ExerciseStats struct {
+	XMLName         xml.Name         `json:"-" xml:"stats"`
+	ID              string           `json:"_id" xml:"_id"`
+	Username        string           `json:"username" xml:"username"`
+	TotalExercises  int              `json:"total_exercises" xml:"total_exercises"`
+	TotalMinutes    int              `json:"total_minutes" xml:"total_minutes"`
+	// In minutes, or 0 if there are no exercises
+	AverageDuration float64          `json:"average_duration" xml:"average_duration"`
+	// The consecutive days with exercises up to today, or up to yesterday
+	// if there are none yet today, so that a streak isn't lost until the day is over
+	CurrentStreak   int              `json:"current_streak" xml:"current_streak"`
+	LongestStreak   int              `json:"longest_streak" xml:"longest_streak"`
+	// Every week with exercises, oldest first, including weeks without exercises
+	// in between for up to maxFilledExerciseWeeks before the last
+	Weeks           []WeeklyExercise `json:"weeks" xml:"week"`
+	// How many exercises have each tag, the most used first, e.g. for a tag cloud
+	Tags            []TagCount       `json:"tags" xml:"tags>tag"`
+}
+
+// How far a user is towards their weekly goal this week.
+type ExerciseProgress struct {
+	XMLName         xml.Name `json:"-" xml:"progress"`
+	ID              string   `json:"_id" xml:"_id"`
+	Username        string   `json:"username" xml:"username"`
+	// The Monday that this week started on, in TimeZone
+	Week            string   `json:"week" xml:"week"`
+	TimeZone        string   `json:"timezone" xml:"timezone"`
+	// The minutes a week that the user aims for, or 0 if they haven't set a goal
+	Goal            int      `json:"goal" xml:"goal"`
+	Minutes         int      `json:"minutes" xml:"minutes"`
+	Exercises       int      `json:"exercises" xml:"exercises"`
+	// The minutes as a percentage of the goal, which goes over 100 once the goal is beaten.
+	// Null if the user hasn't set a goal
+	PercentComplete *float64 `json:"percent_complete" xml:"percent_complete,omitempty"`
+}
+
+// How many of a user's exercises have a tag.
+type TagCount struct {
+	Tag   string `json:"tag" bson:"_id" xml:"name"`
+	Count int    `json:"count" bson:"count" xml:"count"`
+}
+
+// The exercises in a week, which starts on Monday in the time zone that was asked for.
+type WeeklyExercise struct {
+	// The Monday that the week starts on, e.g. "2024-01-01"
+	Week      string `json:"week" bson:"_id" xml:"week"`
+	Exercises int    `json:"exercises" bson:"exercises" xml:"exercises"`
+	Minutes   int    `json:"minutes" bson:"minutes" xml:"minutes"`
+}
+
+
+// Sends the totals of the exercise log of the user whose ID is in the path,
+// along with the exercises and minutes of each week, the user's streaks, and how often they used each tag.
+// Days and weeks are in the time zone named by the "tz" query parameter (default UTC).
+func getExerciseStats(w http.ResponseWriter, r *http.Request) {
+	loc, err := parseTimeZone(r.URL.Query().Get("tz"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	stats, err := exerciseStore.GetExerciseStats(r.Context(), r.PathValue("id"), loc)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	stats.Weeks = fillExerciseWeeks(stats.Weeks)
+	if stats.Tags == nil {
+		stats.Tags = []TagCount{}
+	}
+	writeResponse(w, r, http.StatusOK, stats)
+}
+
+
+// Sends how many minutes the user whose ID is in the path has exercised this week,
+// and how far that is towards their weekly goal. The week is in the time zone named by
+// the "tz" query parameter or else the one in the user's profile, or UTC if neither is.
+func getExerciseProgress(w http.ResponseWriter, r *http.Request) {
+	tz := r.URL.Query().Get("tz")
+	if _, err := parseTimeZone(tz); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	user, err := exerciseStore.GetUser(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(tz) == 0 {
+		tz = user.TimeZone
+	}
+	// A time zone in the profile was valid when it was set
+	loc, err := parseTimeZone(tz)
+	if err != nil {
+		loc = time.UTC
+	}
+	stats, err := exerciseStore.GetExerciseStats(r.Context(), user.ID, loc)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	now := time.Now().In(loc)
+	week := exerciseWeek(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC))
+	progress := ExerciseProgress{
+		ID:       user.ID,
+		Username: user.Username,
+		Week:     week,
+		TimeZone: loc.String(),
+		Goal:     user.WeeklyGoal,
+	}
+	for _, weekly := range stats.Weeks {
+		if weekly.Week == week {
+			progress.Minutes, progress.Exercises = weekly.Minutes, weekly.Exercises
+		}
+	}
+	if progress.Goal > 0 {
+		percent := math.Round(float64(progress.Minutes) / float64(progress.Goal) * 1000) / 10
+		progress.PercentComplete = &percent
+	}
+	writeResponse(w, r, http.StatusOK, progress)
+}
+
+
+// Looks up the time zone that a client named, which is UTC if they named none.
+// "Local" isn't accepted, as it depends on where the server runs.
+func parseTimeZone(name string) (*time.Location, error) {
+	if len(name) == 0 {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		return nil, errInvalidTimeZone
+	}
+	return loc, nil
+}
+
+
+// Returns the day that an exercise was on in the time zone, as midnight UTC on that day.
+// Exercises given a date without a time are stored at midnight UTC, and stay on that day
+// wherever the user is, while those added without a date fall on the day it was where they are.
+func exerciseDay(date time.Time, loc *time.Location) time.Time {
+	date = date.UTC()
+	if !date.Equal(date.Truncate(24 * time.Hour)) {
+		date = date.In(loc)
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+
+// Returns the Monday that starts the week of a day from exerciseDay, as it is given in WeeklyExercise.
+func exerciseWeek(day time.Time) string {
+	daysSinceMonday := (int(day.Weekday()) + 6) % 7
+	return day.AddDate(0, 0, -daysSinceMonday).Format("2006-01-02")
+}
+
+
+// Returns the current and longest streaks of consecutive days with exercises,
+// given those days in order as "2006-01-02" and the time zone that decides what day it is today.
+func exerciseStreaks(days []string, loc *time.Location) (int, int) {
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	todayName := today.Format("2006-01-02")
+	yesterdayName := today.AddDate(0, 0, -1).Format("2006-01-02")
+	current, longest, streak := 0, 0, 0
+	var previous time.Time
+	for _, name := range days {
+		day, err := time.Parse("2006-01-02", name)
+		if err != nil {
+			continue
+		}
+		if streak > 0 && day.Equal(previous.AddDate(0, 0, 1)) {
+			streak++
+		} else {
+			streak = 1
+		}
+		previous = day
+		longest = max(longest, streak)
+		if name == todayName || name == yesterdayName {
+			current = streak
+		}
+	}
+	return current, longest
+}
+
+
+// Adds the weeks without exercises between those that had them, which the stores leave out,
+// so that charts of the weeks are evenly spaced. Only the last maxFilledExerciseWeeks are
+// filled in, and any weeks with exercises before them are kept as they are.
+func fillExerciseWeeks(weeks []WeeklyExercise) []WeeklyExercise {
+	if len(weeks) == 0 {
+		return []WeeklyExercise{}
+	}
+	first, err := time.Parse("2006-01-02", weeks[0].Week)
+	if err != nil {
+		return weeks
+	}
+	last, err := time.Parse("2006-01-02", weeks[len(weeks)-1].Week)
+	if err != nil {
+		return weeks
+	}
+	filled := []WeeklyExercise{}
+	rest := weeks
+	if earliest := last.AddDate(0, 0, -7*(maxFilledExerciseWeeks-1)); first.Before(earliest) {
+		earliestName := earliest.Format("2006-01-02")
+		for len(rest) > 0 && rest[0].Week < earliestName {
+			filled = append(filled, rest[0])
+			rest = rest[1:]
+		}
+		first = earliest
+	}
+	for week := first; len(rest) > 0; week = week.AddDate(0, 0, 7) {
+		name := week.Format("2006-01-02")
+		switch {
+		case rest[0].Week == name:
+			filled = append(filled, rest[0])
+			rest = rest[1:]
+		case rest[0].Week < name:
+			// Not a Monday, or out of order, so there is no telling where the gaps are
+			return weeks
+		default:
+			filled = append(filled, WeeklyExercise{Week: name})
+		}
+	}
+	return filled
+}
@@ -0,0 +1,42 @@
+// Tests how the weeks of exercise stats are filled in.
+package main
+
+import (
+	"testing"
+)
+
+
+func TestFillExerciseWeeks(t *testing.T) {
+	tests := []struct {
+		name      string
+		weeks     []WeeklyExercise
+		wantLen   int
+		wantFirst string
+	}{
+		{"no weeks", nil, 0, ""},
+		{"one week", []WeeklyExercise{{Week: "2024-01-01", Exercises: 1}}, 1, "2024-01-01"},
+		{"gap of two weeks", []WeeklyExercise{{Week: "2024-01-01", Exercises: 1}, {Week: "2024-01-22", Exercises: 1}}, 4, "2024-01-01"},
+		{"not a Monday", []WeeklyExercise{{Week: "2024-01-02", Exercises: 1}, {Week: "2024-01-22", Exercises: 1}}, 2, "2024-01-02"},
+		{"centuries apart", []WeeklyExercise{{Week: "1900-01-01", Exercises: 1}, {Week: "2099-12-28", Exercises: 1}},
+			maxFilledExerciseWeeks + 1, "1900-01-01"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filled := fillExerciseWeeks(test.weeks)
+			if len(filled) != test.wantLen {
+				t.Fatalf("got %d weeks, want %d", len(filled), test.wantLen)
+			}
+			if len(filled) > 0 && filled[0].Week != test.wantFirst {
+				t.Errorf("first week = %q, want %q", filled[0].Week, test.wantFirst)
+			}
+			exercises := 0
+			for _, week := range filled {
+				exercises += week.Exercises
+			}
+			if exercises != len(test.weeks) {
+				t.Errorf("got %d exercises, want %d", exercises, len(test.weeks))
+			}
+		})
+	}
+}
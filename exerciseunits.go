@@ -0,0 +1,103 @@
+// Lets clients give and get the durations of exercises in hours or seconds,
+// while they are always stored in minutes.
+package main
+
+import (
+	"encoding/xml"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The units that durations can be given in
+const (
+	durationMinutes = "minutes"
+	durationHours   = "hours"
+	durationSeconds = "seconds"
+)
+
+// The longest that an exercise can last, which is a week, in minutes
+const maxExerciseMinutes = 7 * 24 * 60
+
+var (
+	errInvalidDurationUnit = newErrorMessage(http.StatusBadRequest, "unit must be minutes, hours, or seconds")
+	errInvalidDuration     = newErrorMessage(http.StatusBadRequest,
+		"duration must be a whole number of minutes, at most " + strconv.Itoa(maxExerciseMinutes) + ", in any unit")
+)
+
+
+// Converts the unit sent by a client, ignoring case and surrounding spaces,
+// into one of the units above, which is minutes if none was sent.
+func parseDurationUnit(unit string) (string, error) {
+	switch unit = strings.ToLower(strings.TrimSpace(unit)); unit {
+	case "":
+		return durationMinutes, nil
+	case durationMinutes, durationHours, durationSeconds:
+		return unit, nil
+	default:
+		return "", errInvalidDurationUnit
+	}
+}
+
+
+// Converts a duration sent by a client in the unit into minutes, which are how it is stored.
+// Durations in minutes must be whole numbers as before, while those in hours or seconds
+// can be fractions, e.g. 1.5 hours, as long as they come to a whole number of minutes,
+// so that nothing is lost by rounding. No duration can be longer than maxExerciseMinutes.
+func parseExerciseDuration(duration string, unit string) (int, error) {
+	unit, err := parseDurationUnit(unit)
+	if err != nil {
+		return 0, err
+	}
+	var minutes float64
+	if unit == durationMinutes {
+		n, err := strconv.Atoi(duration)
+		if err != nil {
+			return 0, errInvalidDuration
+		}
+		minutes = float64(n)
+	} else {
+		value, err := strconv.ParseFloat(duration, 64)
+		if err != nil || math.IsInf(value, 0) || math.IsNaN(value) {
+			return 0, errInvalidDuration
+		}
+		if unit == durationHours {
+			minutes = value * 60
+		} else {
+			minutes = value / 60
+		}
+		// Allow for hours such as 0.1 not being exact in binary
+		if math.Abs(minutes-math.Round(minutes)) > 1e-6 {
+			return 0, errInvalidDuration
+		}
+		minutes = math.Round(minutes)
+	}
+	if math.Abs(minutes) > maxExerciseMinutes {
+		return 0, errInvalidDuration
+	}
+	return int(minutes), nil
+}
+
+
+// Returns the duration of the exercise in its Unit, which is a whole number of minutes or
+// seconds, or a number of hours rounded to two decimal places.
+func (exercise ExerciseRecord) durationInUnit() any {
+	switch exercise.Unit {
+	case durationHours:
+		return math.Round(float64(exercise.Duration) / 60 * 100) / 100
+	case durationSeconds:
+		return exercise.Duration * 60
+	default:
+		return exercise.Duration
+	}
+}
+
+
+// Encodes the exercise with its duration in its Unit, as MarshalJSON does.
+func (exercise ExerciseRecord) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(struct {
+		plainExerciseRecord
+		Duration any `xml:"duration"`
+	}{plainExerciseRecord(exercise), exercise.durationInUnit()}, start)
+}
@@ -0,0 +1,50 @@
+// Tests how exercise durations in different units are converted into minutes.
+package main
+
+import (
+	"testing"
+)
+
+
+func TestParseExerciseDuration(t *testing.T) {
+	tests := []struct {
+		duration string
+		unit     string
+		want     int
+		wantErr  bool
+	}{
+		{"30", "", 30, false},
+		{"30", "Minutes", 30, false},
+		{"1.5", "hours", 90, false},
+		{"0.1", "hours", 6, false},
+		{"120", "seconds", 2, false},
+		{"20", "seconds", 0, true},
+		{"90", "seconds", 0, true},
+		{"0.3333", "hours", 0, true},
+		{"1.5", "", 0, true},
+		{"10080", "", 10080, false},
+		{"10081", "", 0, true},
+		{"168", "hours", 10080, false},
+		{"169", "hours", 0, true},
+		{"604860", "seconds", 0, true},
+		{"9223372036854775807", "", 0, true},
+		{"1e300", "hours", 0, true},
+		{"NaN", "hours", 0, true},
+		{"30", "days", 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.duration+" "+test.unit, func(t *testing.T) {
+			got, err := parseExerciseDuration(test.duration, test.unit)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("got %d, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != test.want {
+				t.Errorf("got %d, %v, want %d", got, err, test.want)
+			}
+		})
+	}
+}
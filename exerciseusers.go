@@ -0,0 +1,134 @@
+// Decides what happens when an exercise user is created with a username that is already taken,
+// lets users change their username to one that isn't, and finds users by their usernames.
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// Whether creating a user whose username is taken fails, set by initExerciseUsers
+var rejectDuplicateUsernames bool
+
+// How usernames can be matched by a search, chosen by the "match" query parameter
+const (
+	matchUsernamePrefix    = "prefix"
+	matchUsernameSubstring = "substring"
+)
+
+// The longest search of usernames, and how many users a search returns by default and at most
+const (
+	maxUsernameSearchLength = 100
+	defaultUserSearchLimit  = 20
+	maxUserSearchLimit      = 100
+)
+
+var (
+	errInvalidUsernameSearch  = newErrorMessage(http.StatusBadRequest, "q is required, and can be at most " + strconv.Itoa(maxUsernameSearchLength) + " characters")
+	errInvalidUsernameMatch   = newErrorMessage(http.StatusBadRequest, "match must be prefix or substring")
+	errInvalidUserSearchLimit = newErrorMessage(http.StatusBadRequest, "limit must be from 1 to " + strconv.Itoa(maxUserSearchLimit))
+)
+
+// The users whose usernames matched a search, in order of username.
+type UserSearchResults struct {
+	XMLName xml.Name       `json:"-" xml:"users"`
+	Query   string         `json:"q" xml:"q"`
+	Match   string         `json:"match" xml:"match"`
+	Users   []ExerciseUser `json:"users" xml:"user"`
+}
+
+
+// Reads from EXERCISE_REJECT_DUPLICATE_USERNAMES (default false) whether creating a user
+// whose username is taken fails with a 409, rather than returning the user who has it
+// as freeCodeCamp's specification does.
+func initExerciseUsers() {
+	rejectDuplicateUsernames = getEnvBool("EXERCISE_REJECT_DUPLICATE_USERNAMES", false)
+	if rejectDuplicateUsernames {
+		slog.Info("Rejecting exercise users with usernames that are taken.")
+	}
+}
+
+
+// Creates a user with the username or, unless duplicates are rejected,
+// returns the user who already has it.
+func createExerciseUser(ctx context.Context, username string) (ExerciseUser, error) {
+	user, err := exerciseStore.CreateUser(ctx, username)
+	if errors.Is(err, ErrDuplicate) && !rejectDuplicateUsernames {
+		return user, nil
+	}
+	return user, err
+}
+
+
+// Changes the username of the user whose ID is in the path to the "username" sent as form data
+// or JSON, keeping their ID and log, and sends back the user.
+// A username that belongs to another user is always rejected with a 409.
+// If an If-Match header is given, the change is only made if the user is still at that version.
+func putExerciseUsername(w http.ResponseWriter, r *http.Request) {
+	version, err := parseIfMatch(r.Header.Get("If-Match"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	req, err := readNewExerciseUserRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(req.Username) == 0 {
+		writeError(w, r, newErrorMessage(http.StatusBadRequest, "username is required"))
+		return
+	}
+	user, err := exerciseStore.RenameUser(r.Context(), r.PathValue("id"), req.Username, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	loggerFrom(r.Context()).Info("Renamed exercise user.", "id", user.ID, "username", user.Username)
+	w.Header().Set("ETag", `W/"` + strconv.FormatInt(user.Version, 10) + `"`)
+	writeResponse(w, r, http.StatusOK, user)
+}
+
+
+// Sends the users whose usernames start with the "q" query parameter or, if "match" is "substring",
+// contain it anywhere, without their logs. Usernames are matched with case, as they are unique with it.
+// At most "limit" users are sent (default 20, at most 100), in order of username.
+// Prefix searches are answered from the index of usernames, while substring searches scan it.
+func searchExerciseUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	search := q.Get("q")
+	if len(search) == 0 || len(search) > maxUsernameSearchLength {
+		writeError(w, r, errInvalidUsernameSearch)
+		return
+	}
+	match := q.Get("match")
+	if len(match) == 0 {
+		match = matchUsernamePrefix
+	} else if match != matchUsernamePrefix && match != matchUsernameSubstring {
+		writeError(w, r, errInvalidUsernameMatch)
+		return
+	}
+	limit := defaultUserSearchLimit
+	if value := q.Get("limit"); len(value) > 0 {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxUserSearchLimit {
+			writeError(w, r, errInvalidUserSearchLimit)
+			return
+		}
+	}
+
+	users, err := exerciseStore.SearchUsers(r.Context(), search, match == matchUsernameSubstring, limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if users == nil {
+		users = []ExerciseUser{}
+	}
+	writeResponse(w, r, http.StatusOK, UserSearchResults{Query: search, Match: match, Users: users})
+}
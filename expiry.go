@@ -0,0 +1,75 @@
+// Removes records once they expire. MongoDB does this itself through TTL indexes,
+// while bbolt has no such feature, so expired records are swept up periodically.
+package main
+
+import (
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"log/slog"
+	"time"
+)
+
+// Closed to stop the expiry sweep at shutdown
+var stopExpirySweep = make(chan struct{})
+
+
+// Reports whether a record with the given expiry time has expired.
+// A zero time means the record never expires.
+// Expired records may not have been deleted yet, so lookups must check this.
+func isExpired(expiresAt time.Time) bool {
+	return !expiresAt.IsZero() && !time.Now().Before(expiresAt)
+}
+
+
+// Deletes expired short URLs from the bbolt file every interval,
+// which is set by BOLT_EXPIRY_SWEEP_INTERVAL (default 1m). A zero interval disables it.
+func sweepExpiredBoltURLs(db *bolt.DB, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stopExpirySweep:
+			return
+		}
+
+		deleted := 0
+		err := db.Update(func(tx *bolt.Tx) error {
+			urls := tx.Bucket(boltURLsBucket)
+			originals := tx.Bucket(boltOriginalURLsBucket)
+			// Keys can't be deleted while iterating, so collect them first
+			var expired []urlDBRecord
+			err := urls.ForEach(func(k, v []byte) error {
+				var record urlDBRecord
+				if err := bson.Unmarshal(v, &record); err != nil {
+					return err
+				}
+				if isExpired(record.ExpiresAt) {
+					expired = append(expired, record)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, record := range expired {
+				if err := originals.Delete([]byte(record.OriginalURL)); err != nil {
+					return err
+				}
+				if err := urls.Delete([]byte(record.ShortURL)); err != nil {
+					return err
+				}
+			}
+			deleted = len(expired)
+			return nil
+		})
+		if err != nil {
+			slog.Error("Failed to delete expired short URLs.", "err", err)
+		} else if deleted > 0 {
+			slog.Info("Deleted expired short URLs.", "count", deleted)
+		}
+	}
+}
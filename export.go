@@ -0,0 +1,154 @@
+// Dumps the stored URLs and exercise users as newline-delimited JSON,
+// for backups and for moving the data from one storage backend to another.
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// A short URL as it appears in an export, with everything needed to restore it.
+type URLExport struct {
+	ShortURL     string `json:"short_url"`
+	OriginalURL  string `json:"original_url"`
+	TimesVisited int    `json:"times_visited"`
+	Version      int64  `json:"version"`
+	// Missing if the short URL never expires
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	CreatedAt    *time.Time `json:"created_at,omitempty"`
+	// Missing if the short URL has never been visited
+	LastVisitedAt *time.Time `json:"last_visited_at,omitempty"`
+	// Missing if the URL wasn't probed, or couldn't be reached
+	ProbeStatus   int        `json:"probe_status,omitempty"`
+	// Missing if the URL wasn't probed
+	ProbedAt      *time.Time `json:"probed_at,omitempty"`
+	// Missing if nobody was authenticated when it was created
+	Owner         string     `json:"owner,omitempty"`
+	// Missing if no campaign parameters are added to visits
+	UTM           *UTMParams `json:"utm,omitempty"`
+	// Missing if the short URL redirects with the default status code
+	RedirectStatus int       `json:"redirect_status,omitempty"`
+	// Missing unless the short URL has been disabled
+	Disabled      *LinkDisabled `json:"disabled,omitempty"`
+	// Missing if the short URL has the default visit limit
+	VisitLimit    int64      `json:"visit_limit,omitempty"`
+}
+
+// An exercise user as it appears in an export, along with their whole log.
+type ExerciseUserExport struct {
+	ID       string           `json:"_id"`
+	Username string           `json:"username"`
+	ExerciseProfile
+	Log      []ExerciseRecord `json:"log"`
+	Version  int64            `json:"version"`
+}
+
+
+// Converts a stored short URL into the form in which it is exported.
+func exportURL(record urlDBRecord) URLExport {
+	u := URLExport{
+		ShortURL: record.ShortURL,
+		OriginalURL: record.OriginalURL,
+		TimesVisited: record.TimesVisited,
+		Version: record.Version,
+		Owner: record.Owner,
+		UTM: record.UTM,
+		RedirectStatus: record.RedirectStatus,
+		Disabled: record.Disabled,
+		VisitLimit: record.VisitLimit,
+	}
+	if !record.ExpiresAt.IsZero() {
+		u.ExpiresAt = &record.ExpiresAt
+	}
+	if !record.CreatedAt.IsZero() {
+		u.CreatedAt = &record.CreatedAt
+	}
+	if !record.LastVisitedAt.IsZero() {
+		u.LastVisitedAt = &record.LastVisitedAt
+	}
+	if !record.ProbedAt.IsZero() {
+		u.ProbeStatus = record.ProbeStatus
+		u.ProbedAt = &record.ProbedAt
+	}
+	return u
+}
+
+
+// Converts a stored user into the form in which it is exported.
+func exportUser(record ExerciseUserRecord) ExerciseUserExport {
+	if record.Log == nil {
+		record.Log = []ExerciseRecord{}
+	}
+	return ExerciseUserExport{
+		ID: record.ID,
+		Username: record.Username,
+		ExerciseProfile: record.ExerciseProfile,
+		Log: record.Log,
+		Version: record.Version,
+	}
+}
+
+
+// Streams every short URL, one JSON object per line.
+func getAdminURLExport(w http.ResponseWriter, r *http.Request) {
+	streamExport(w, r, "urls", func(send func(any) error) error {
+		return urlStore.ExportURLs(r.Context(), func(u URLExport) error {
+			return send(u)
+		})
+	})
+}
+
+
+// Streams every exercise user along with their log, one JSON object per line.
+func getAdminUserExport(w http.ResponseWriter, r *http.Request) {
+	streamExport(w, r, "users", func(send func(any) error) error {
+		return exerciseStore.ExportUsers(r.Context(), func(u ExerciseUserExport) error {
+			return send(u)
+		})
+	})
+}
+
+
+// Sends the records that export passes to send as an NDJSON attachment named after the kind.
+// The status code has already been sent by the time a store error can occur,
+// so the response is then cut off, rather than ending as if it were complete.
+func streamExport(w http.ResponseWriter, r *http.Request, kind string, export func(send func(any) error) error) {
+	logger := loggerFrom(r.Context())
+
+	// A large export can take longer than the server's write timeout allows
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		logger.Warn("Unable to clear write deadline.", "err", err)
+	}
+
+	filename := kind + "-" + time.Now().UTC().Format("20060102T150405Z") + ".ndjson"
+	started := false
+	start := func() {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="` + filename + `"`)
+		w.WriteHeader(http.StatusOK)
+		started = true
+	}
+
+	count := 0
+	encoder := json.NewEncoder(w)
+	err := export(func(record any) error {
+		if !started {
+			start()
+		}
+		count++
+		return encoder.Encode(record)
+	})
+	if err != nil && !started {
+		writeError(w, r, err)
+		return
+	} else if err != nil {
+		logger.Error("Export failed partway.", "kind", kind, "exported", count, "err", err)
+		panic(http.ErrAbortHandler)
+	} else if !started {
+		// There was nothing to export
+		start()
+	}
+	logger.Info("Exported data.", "kind", kind, "exported", count)
+}
@@ -0,0 +1,219 @@
+// Serves the Exercise Tracker under the routes and in the shapes that freeCodeCamp's
+// specification gives, so that its test suite can be run against this server.
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// How freeCodeCamp's tests expect dates, as JavaScript's Date.toDateString formats them
+const fccDateLayout = "Mon Jan 02 2006"
+
+// The same fields as ExerciseRecord and ExerciseAddedReceipt without their methods,
+// so that they can be encoded as they would be by default
+type (
+	plainExerciseRecord ExerciseRecord
+	plainExerciseAddedReceipt ExerciseAddedReceipt
+)
+
+// An exercise as freeCodeCamp's tests expect it.
+type FCCExercise struct {
+	Description string `json:"description"`
+	Duration    int    `json:"duration"`
+	Date        string `json:"date"`
+}
+
+// The user that an exercise was added to, along with the exercise.
+type FCCExerciseReceipt struct {
+	ID          string `json:"_id"`
+	Username    string `json:"username"`
+	Description string `json:"description"`
+	Duration    int    `json:"duration"`
+	Date        string `json:"date"`
+}
+
+// A user's exercise log as freeCodeCamp's tests expect it.
+type FCCExerciseLog struct {
+	ID       string        `json:"_id"`
+	Username string        `json:"username"`
+	// Missing unless the log was narrowed down to these dates
+	From     string        `json:"from,omitempty"`
+	To       string        `json:"to,omitempty"`
+	// How many exercises are in the log
+	Count    int           `json:"count"`
+	Log      []FCCExercise `json:"log"`
+}
+
+
+// Creates a user with the username in the form data or JSON, and sends back their username and _id.
+func postFCCUser(w http.ResponseWriter, r *http.Request) {
+	req, err := readNewExerciseUserRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(req.Username) == 0 {
+		writeError(w, r, newErrorMessage(http.StatusBadRequest, "username is required"))
+		return
+	}
+	user, err := createExerciseUser(r.Context(), req.Username)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+
+// Sends the username and _id of every user, without their logs.
+func getFCCUsers(w http.ResponseWriter, r *http.Request) {
+	records, err := exerciseStore.GetAllUsers(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	users := make([]ExerciseUser, len(records))
+	for i, record := range records {
+		users[i] = ExerciseUser{ID: record.ID, Username: record.Username}
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+
+// Adds the exercise in the form data or JSON to the log of the user whose _id is in the path,
+// and sends back the user along with the exercise.
+func postFCCExercise(w http.ResponseWriter, r *http.Request) {
+	req, err := readNewExerciseRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	exercise, err := parseExercise(req.Description, req.duration(), req.Unit, req.Date)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	receipt, err := exerciseStore.AddExercise(r.Context(), r.PathValue("_id"), exercise)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, FCCExerciseReceipt{
+		ID:          receipt.ID,
+		Username:    receipt.Username,
+		Description: receipt.Description,
+		Duration:    receipt.Duration,
+		Date:        fccDate(receipt.Date),
+	})
+}
+
+
+// Sends the exercise log of the user whose _id is in the path,
+// optionally filtered by the "from", "to", and "limit" query parameters.
+func getFCCExerciseLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := parseExerciseLogFilter(q.Get("from"), q.Get("to"), q.Get("limit"), "")
+	record, err := exerciseStore.GetExerciseLog(r.Context(), r.PathValue("_id"), filter)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	exerciseLog := FCCExerciseLog{
+		ID:       record.ID,
+		Username: record.Username,
+		Count:    len(record.Log),
+		Log:      make([]FCCExercise, len(record.Log)),
+	}
+	if !filter.From.IsZero() {
+		exerciseLog.From = fccDate(filter.From)
+	}
+	if !filter.To.IsZero() {
+		exerciseLog.To = fccDate(filter.To)
+	}
+	for i, exercise := range record.Log {
+		exerciseLog.Log[i] = FCCExercise{
+			Description: exercise.Description,
+			Duration:    exercise.Duration,
+			Date:        fccDate(exercise.Date),
+		}
+	}
+	writeJSON(w, http.StatusOK, exerciseLog)
+}
+
+
+// Formats a date as freeCodeCamp's tests expect, e.g. "Mon Jan 01 1990".
+func fccDate(date time.Time) string {
+	return date.UTC().Format(fccDateLayout)
+}
+
+
+// Parses the date of an exercise in JSON, which is either in the form that MarshalJSON
+// gives it, or in RFC 3339 as in exports made before exercises were encoded that way.
+func parseFCCDate(value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+	if date, err := time.Parse(fccDateLayout, value); err == nil {
+		return date, nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+
+// Encodes the exercise with its date as freeCodeCamp's tests expect, e.g. "Mon Jan 01 1990",
+// and its duration in its Unit.
+func (exercise ExerciseRecord) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		plainExerciseRecord
+		Duration any    `json:"duration"`
+		Date     string `json:"date"`
+	}{plainExerciseRecord(exercise), exercise.durationInUnit(), fccDate(exercise.Date)})
+}
+
+
+// Decodes an exercise encoded by MarshalJSON or with an RFC 3339 date.
+func (exercise *ExerciseRecord) UnmarshalJSON(data []byte) error {
+	var decoded struct {
+		plainExerciseRecord
+		Date string `json:"date"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	date, err := parseFCCDate(decoded.Date)
+	if err != nil {
+		return err
+	}
+	*exercise = ExerciseRecord(decoded.plainExerciseRecord)
+	exercise.Date = date
+	return nil
+}
+
+
+// Encodes the receipt with its date in the same form as the exercise log.
+func (receipt ExerciseAddedReceipt) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		plainExerciseAddedReceipt
+		Date string `json:"date"`
+	}{plainExerciseAddedReceipt(receipt), fccDate(receipt.Date)})
+}
+
+
+// Encodes an exported user with the full date and time of each exercise,
+// so that restoring the export doesn't cut them down to the day.
+func (u ExerciseUserExport) MarshalJSON() ([]byte, error) {
+	log := make([]plainExerciseRecord, len(u.Log))
+	for i, exercise := range u.Log {
+		log[i] = plainExerciseRecord(exercise)
+	}
+	return json.Marshal(struct {
+		ID       string                `json:"_id"`
+		Username string                `json:"username"`
+		ExerciseProfile
+		Log      []plainExerciseRecord `json:"log"`
+		Version  int64                 `json:"version"`
+	}{u.ID, u.Username, u.ExerciseProfile, log, u.Version})
+}
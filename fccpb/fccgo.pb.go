@@ -0,0 +1,789 @@
+// The gRPC interface to the URL Shortener and Exercise Tracker.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: fccpb/fccgo.proto
+
+package fccpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type CreateShortURLRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Url           string                 `protobuf:"bytes,1,opt,name=url,proto3" json:"url,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateShortURLRequest) Reset() {
+	*x = CreateShortURLRequest{}
+	mi := &file_fccpb_fccgo_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateShortURLRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateShortURLRequest) ProtoMessage() {}
+
+func (x *CreateShortURLRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_fccpb_fccgo_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateShortURLRequest.ProtoReflect.Descriptor instead.
+func (*CreateShortURLRequest) Descriptor() ([]byte, []int) {
+	return file_fccpb_fccgo_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *CreateShortURLRequest) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+type ResolveShortURLRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ShortUrl      string                 `protobuf:"bytes,1,opt,name=short_url,json=shortUrl,proto3" json:"short_url,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResolveShortURLRequest) Reset() {
+	*x = ResolveShortURLRequest{}
+	mi := &file_fccpb_fccgo_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResolveShortURLRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResolveShortURLRequest) ProtoMessage() {}
+
+func (x *ResolveShortURLRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_fccpb_fccgo_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResolveShortURLRequest.ProtoReflect.Descriptor instead.
+func (*ResolveShortURLRequest) Descriptor() ([]byte, []int) {
+	return file_fccpb_fccgo_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ResolveShortURLRequest) GetShortUrl() string {
+	if x != nil {
+		return x.ShortUrl
+	}
+	return ""
+}
+
+type ShortURL struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	OriginalUrl   string                 `protobuf:"bytes,1,opt,name=original_url,json=originalUrl,proto3" json:"original_url,omitempty"`
+	ShortUrl      string                 `protobuf:"bytes,2,opt,name=short_url,json=shortUrl,proto3" json:"short_url,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ShortURL) Reset() {
+	*x = ShortURL{}
+	mi := &file_fccpb_fccgo_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ShortURL) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ShortURL) ProtoMessage() {}
+
+func (x *ShortURL) ProtoReflect() protoreflect.Message {
+	mi := &file_fccpb_fccgo_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ShortURL.ProtoReflect.Descriptor instead.
+func (*ShortURL) Descriptor() ([]byte, []int) {
+	return file_fccpb_fccgo_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ShortURL) GetOriginalUrl() string {
+	if x != nil {
+		return x.OriginalUrl
+	}
+	return ""
+}
+
+func (x *ShortURL) GetShortUrl() string {
+	if x != nil {
+		return x.ShortUrl
+	}
+	return ""
+}
+
+type User struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Username      string                 `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *User) Reset() {
+	*x = User{}
+	mi := &file_fccpb_fccgo_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *User) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*User) ProtoMessage() {}
+
+func (x *User) ProtoReflect() protoreflect.Message {
+	mi := &file_fccpb_fccgo_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use User.ProtoReflect.Descriptor instead.
+func (*User) Descriptor() ([]byte, []int) {
+	return file_fccpb_fccgo_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *User) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *User) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+type Exercise struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Description   string                 `protobuf:"bytes,1,opt,name=description,proto3" json:"description,omitempty"`
+	Duration      int32                  `protobuf:"varint,2,opt,name=duration,proto3" json:"duration,omitempty"`
+	Date          *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=date,proto3" json:"date,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Exercise) Reset() {
+	*x = Exercise{}
+	mi := &file_fccpb_fccgo_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Exercise) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Exercise) ProtoMessage() {}
+
+func (x *Exercise) ProtoReflect() protoreflect.Message {
+	mi := &file_fccpb_fccgo_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Exercise.ProtoReflect.Descriptor instead.
+func (*Exercise) Descriptor() ([]byte, []int) {
+	return file_fccpb_fccgo_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Exercise) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *Exercise) GetDuration() int32 {
+	if x != nil {
+		return x.Duration
+	}
+	return 0
+}
+
+func (x *Exercise) GetDate() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Date
+	}
+	return nil
+}
+
+type UserLog struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Username      string                 `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`
+	Log           []*Exercise            `protobuf:"bytes,3,rep,name=log,proto3" json:"log,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UserLog) Reset() {
+	*x = UserLog{}
+	mi := &file_fccpb_fccgo_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UserLog) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UserLog) ProtoMessage() {}
+
+func (x *UserLog) ProtoReflect() protoreflect.Message {
+	mi := &file_fccpb_fccgo_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UserLog.ProtoReflect.Descriptor instead.
+func (*UserLog) Descriptor() ([]byte, []int) {
+	return file_fccpb_fccgo_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *UserLog) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *UserLog) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *UserLog) GetLog() []*Exercise {
+	if x != nil {
+		return x.Log
+	}
+	return nil
+}
+
+type CreateUserRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Username      string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateUserRequest) Reset() {
+	*x = CreateUserRequest{}
+	mi := &file_fccpb_fccgo_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateUserRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateUserRequest) ProtoMessage() {}
+
+func (x *CreateUserRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_fccpb_fccgo_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateUserRequest.ProtoReflect.Descriptor instead.
+func (*CreateUserRequest) Descriptor() ([]byte, []int) {
+	return file_fccpb_fccgo_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *CreateUserRequest) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+type ListUsersRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListUsersRequest) Reset() {
+	*x = ListUsersRequest{}
+	mi := &file_fccpb_fccgo_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListUsersRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListUsersRequest) ProtoMessage() {}
+
+func (x *ListUsersRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_fccpb_fccgo_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListUsersRequest.ProtoReflect.Descriptor instead.
+func (*ListUsersRequest) Descriptor() ([]byte, []int) {
+	return file_fccpb_fccgo_proto_rawDescGZIP(), []int{7}
+}
+
+type ListUsersResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Users         []*UserLog             `protobuf:"bytes,1,rep,name=users,proto3" json:"users,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListUsersResponse) Reset() {
+	*x = ListUsersResponse{}
+	mi := &file_fccpb_fccgo_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListUsersResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListUsersResponse) ProtoMessage() {}
+
+func (x *ListUsersResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_fccpb_fccgo_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListUsersResponse.ProtoReflect.Descriptor instead.
+func (*ListUsersResponse) Descriptor() ([]byte, []int) {
+	return file_fccpb_fccgo_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ListUsersResponse) GetUsers() []*UserLog {
+	if x != nil {
+		return x.Users
+	}
+	return nil
+}
+
+type AddExerciseRequest struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	UserId      string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Description string                 `protobuf:"bytes,2,opt,name=description,proto3" json:"description,omitempty"`
+	// In minutes.
+	Duration int32 `protobuf:"varint,3,opt,name=duration,proto3" json:"duration,omitempty"`
+	// YYYY-MM-DD. Defaults to today.
+	Date          string `protobuf:"bytes,4,opt,name=date,proto3" json:"date,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddExerciseRequest) Reset() {
+	*x = AddExerciseRequest{}
+	mi := &file_fccpb_fccgo_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddExerciseRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddExerciseRequest) ProtoMessage() {}
+
+func (x *AddExerciseRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_fccpb_fccgo_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddExerciseRequest.ProtoReflect.Descriptor instead.
+func (*AddExerciseRequest) Descriptor() ([]byte, []int) {
+	return file_fccpb_fccgo_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *AddExerciseRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *AddExerciseRequest) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *AddExerciseRequest) GetDuration() int32 {
+	if x != nil {
+		return x.Duration
+	}
+	return 0
+}
+
+func (x *AddExerciseRequest) GetDate() string {
+	if x != nil {
+		return x.Date
+	}
+	return ""
+}
+
+type AddExerciseResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Username      string                 `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`
+	Exercise      *Exercise              `protobuf:"bytes,3,opt,name=exercise,proto3" json:"exercise,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddExerciseResponse) Reset() {
+	*x = AddExerciseResponse{}
+	mi := &file_fccpb_fccgo_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddExerciseResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddExerciseResponse) ProtoMessage() {}
+
+func (x *AddExerciseResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_fccpb_fccgo_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddExerciseResponse.ProtoReflect.Descriptor instead.
+func (*AddExerciseResponse) Descriptor() ([]byte, []int) {
+	return file_fccpb_fccgo_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *AddExerciseResponse) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *AddExerciseResponse) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *AddExerciseResponse) GetExercise() *Exercise {
+	if x != nil {
+		return x.Exercise
+	}
+	return nil
+}
+
+type GetExerciseLogRequest struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	UserId string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	// YYYY-MM-DD. Both ends of the range are optional.
+	From string `protobuf:"bytes,2,opt,name=from,proto3" json:"from,omitempty"`
+	To   string `protobuf:"bytes,3,opt,name=to,proto3" json:"to,omitempty"`
+	// The maximum number of exercises to return, or 0 for all of them.
+	Limit         int32 `protobuf:"varint,4,opt,name=limit,proto3" json:"limit,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetExerciseLogRequest) Reset() {
+	*x = GetExerciseLogRequest{}
+	mi := &file_fccpb_fccgo_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetExerciseLogRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetExerciseLogRequest) ProtoMessage() {}
+
+func (x *GetExerciseLogRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_fccpb_fccgo_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetExerciseLogRequest.ProtoReflect.Descriptor instead.
+func (*GetExerciseLogRequest) Descriptor() ([]byte, []int) {
+	return file_fccpb_fccgo_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *GetExerciseLogRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *GetExerciseLogRequest) GetFrom() string {
+	if x != nil {
+		return x.From
+	}
+	return ""
+}
+
+func (x *GetExerciseLogRequest) GetTo() string {
+	if x != nil {
+		return x.To
+	}
+	return ""
+}
+
+func (x *GetExerciseLogRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+var File_fccpb_fccgo_proto protoreflect.FileDescriptor
+
+const file_fccpb_fccgo_proto_rawDesc = "" +
+	"\n" +
+	"\x11fccpb/fccgo.proto\x12\bfccgo.v1\x1a\x1fgoogle/protobuf/timestamp.proto\")\n" +
+	"\x15CreateShortURLRequest\x12\x10\n" +
+	"\x03url\x18\x01 \x01(\tR\x03url\"5\n" +
+	"\x16ResolveShortURLRequest\x12\x1b\n" +
+	"\tshort_url\x18\x01 \x01(\tR\bshortUrl\"J\n" +
+	"\bShortURL\x12!\n" +
+	"\foriginal_url\x18\x01 \x01(\tR\voriginalUrl\x12\x1b\n" +
+	"\tshort_url\x18\x02 \x01(\tR\bshortUrl\"2\n" +
+	"\x04User\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1a\n" +
+	"\busername\x18\x02 \x01(\tR\busername\"x\n" +
+	"\bExercise\x12 \n" +
+	"\vdescription\x18\x01 \x01(\tR\vdescription\x12\x1a\n" +
+	"\bduration\x18\x02 \x01(\x05R\bduration\x12.\n" +
+	"\x04date\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\x04date\"[\n" +
+	"\aUserLog\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1a\n" +
+	"\busername\x18\x02 \x01(\tR\busername\x12$\n" +
+	"\x03log\x18\x03 \x03(\v2\x12.fccgo.v1.ExerciseR\x03log\"/\n" +
+	"\x11CreateUserRequest\x12\x1a\n" +
+	"\busername\x18\x01 \x01(\tR\busername\"\x12\n" +
+	"\x10ListUsersRequest\"<\n" +
+	"\x11ListUsersResponse\x12'\n" +
+	"\x05users\x18\x01 \x03(\v2\x11.fccgo.v1.UserLogR\x05users\"\x7f\n" +
+	"\x12AddExerciseRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x12 \n" +
+	"\vdescription\x18\x02 \x01(\tR\vdescription\x12\x1a\n" +
+	"\bduration\x18\x03 \x01(\x05R\bduration\x12\x12\n" +
+	"\x04date\x18\x04 \x01(\tR\x04date\"z\n" +
+	"\x13AddExerciseResponse\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x12\x1a\n" +
+	"\busername\x18\x02 \x01(\tR\busername\x12.\n" +
+	"\bexercise\x18\x03 \x01(\v2\x12.fccgo.v1.ExerciseR\bexercise\"j\n" +
+	"\x15GetExerciseLogRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x12\x12\n" +
+	"\x04from\x18\x02 \x01(\tR\x04from\x12\x0e\n" +
+	"\x02to\x18\x03 \x01(\tR\x02to\x12\x14\n" +
+	"\x05limit\x18\x04 \x01(\x05R\x05limit2\x9e\x01\n" +
+	"\fURLShortener\x12E\n" +
+	"\x0eCreateShortURL\x12\x1f.fccgo.v1.CreateShortURLRequest\x1a\x12.fccgo.v1.ShortURL\x12G\n" +
+	"\x0fResolveShortURL\x12 .fccgo.v1.ResolveShortURLRequest\x1a\x12.fccgo.v1.ShortURL2\xa4\x02\n" +
+	"\x0fExerciseTracker\x129\n" +
+	"\n" +
+	"CreateUser\x12\x1b.fccgo.v1.CreateUserRequest\x1a\x0e.fccgo.v1.User\x12D\n" +
+	"\tListUsers\x12\x1a.fccgo.v1.ListUsersRequest\x1a\x1b.fccgo.v1.ListUsersResponse\x12J\n" +
+	"\vAddExercise\x12\x1c.fccgo.v1.AddExerciseRequest\x1a\x1d.fccgo.v1.AddExerciseResponse\x12D\n" +
+	"\x0eGetExerciseLog\x12\x1f.fccgo.v1.GetExerciseLogRequest\x1a\x11.fccgo.v1.UserLogB Z\x1egithub.com/jstlwy/fcc-go/fccpbb\x06proto3"
+
+var (
+	file_fccpb_fccgo_proto_rawDescOnce sync.Once
+	file_fccpb_fccgo_proto_rawDescData []byte
+)
+
+func file_fccpb_fccgo_proto_rawDescGZIP() []byte {
+	file_fccpb_fccgo_proto_rawDescOnce.Do(func() {
+		file_fccpb_fccgo_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_fccpb_fccgo_proto_rawDesc), len(file_fccpb_fccgo_proto_rawDesc)))
+	})
+	return file_fccpb_fccgo_proto_rawDescData
+}
+
+var file_fccpb_fccgo_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
+var file_fccpb_fccgo_proto_goTypes = []any{
+	(*CreateShortURLRequest)(nil),  // 0: fccgo.v1.CreateShortURLRequest
+	(*ResolveShortURLRequest)(nil), // 1: fccgo.v1.ResolveShortURLRequest
+	(*ShortURL)(nil),               // 2: fccgo.v1.ShortURL
+	(*User)(nil),                   // 3: fccgo.v1.User
+	(*Exercise)(nil),               // 4: fccgo.v1.Exercise
+	(*UserLog)(nil),                // 5: fccgo.v1.UserLog
+	(*CreateUserRequest)(nil),      // 6: fccgo.v1.CreateUserRequest
+	(*ListUsersRequest)(nil),       // 7: fccgo.v1.ListUsersRequest
+	(*ListUsersResponse)(nil),      // 8: fccgo.v1.ListUsersResponse
+	(*AddExerciseRequest)(nil),     // 9: fccgo.v1.AddExerciseRequest
+	(*AddExerciseResponse)(nil),    // 10: fccgo.v1.AddExerciseResponse
+	(*GetExerciseLogRequest)(nil),  // 11: fccgo.v1.GetExerciseLogRequest
+	(*timestamppb.Timestamp)(nil),  // 12: google.protobuf.Timestamp
+}
+var file_fccpb_fccgo_proto_depIdxs = []int32{
+	12, // 0: fccgo.v1.Exercise.date:type_name -> google.protobuf.Timestamp
+	4,  // 1: fccgo.v1.UserLog.log:type_name -> fccgo.v1.Exercise
+	5,  // 2: fccgo.v1.ListUsersResponse.users:type_name -> fccgo.v1.UserLog
+	4,  // 3: fccgo.v1.AddExerciseResponse.exercise:type_name -> fccgo.v1.Exercise
+	0,  // 4: fccgo.v1.URLShortener.CreateShortURL:input_type -> fccgo.v1.CreateShortURLRequest
+	1,  // 5: fccgo.v1.URLShortener.ResolveShortURL:input_type -> fccgo.v1.ResolveShortURLRequest
+	6,  // 6: fccgo.v1.ExerciseTracker.CreateUser:input_type -> fccgo.v1.CreateUserRequest
+	7,  // 7: fccgo.v1.ExerciseTracker.ListUsers:input_type -> fccgo.v1.ListUsersRequest
+	9,  // 8: fccgo.v1.ExerciseTracker.AddExercise:input_type -> fccgo.v1.AddExerciseRequest
+	11, // 9: fccgo.v1.ExerciseTracker.GetExerciseLog:input_type -> fccgo.v1.GetExerciseLogRequest
+	2,  // 10: fccgo.v1.URLShortener.CreateShortURL:output_type -> fccgo.v1.ShortURL
+	2,  // 11: fccgo.v1.URLShortener.ResolveShortURL:output_type -> fccgo.v1.ShortURL
+	3,  // 12: fccgo.v1.ExerciseTracker.CreateUser:output_type -> fccgo.v1.User
+	8,  // 13: fccgo.v1.ExerciseTracker.ListUsers:output_type -> fccgo.v1.ListUsersResponse
+	10, // 14: fccgo.v1.ExerciseTracker.AddExercise:output_type -> fccgo.v1.AddExerciseResponse
+	5,  // 15: fccgo.v1.ExerciseTracker.GetExerciseLog:output_type -> fccgo.v1.UserLog
+	10, // [10:16] is the sub-list for method output_type
+	4,  // [4:10] is the sub-list for method input_type
+	4,  // [4:4] is the sub-list for extension type_name
+	4,  // [4:4] is the sub-list for extension extendee
+	0,  // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_fccpb_fccgo_proto_init() }
+func file_fccpb_fccgo_proto_init() {
+	if File_fccpb_fccgo_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_fccpb_fccgo_proto_rawDesc), len(file_fccpb_fccgo_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   12,
+			NumExtensions: 0,
+			NumServices:   2,
+		},
+		GoTypes:           file_fccpb_fccgo_proto_goTypes,
+		DependencyIndexes: file_fccpb_fccgo_proto_depIdxs,
+		MessageInfos:      file_fccpb_fccgo_proto_msgTypes,
+	}.Build()
+	File_fccpb_fccgo_proto = out.File
+	file_fccpb_fccgo_proto_goTypes = nil
+	file_fccpb_fccgo_proto_depIdxs = nil
+}
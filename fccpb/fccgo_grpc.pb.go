@@ -0,0 +1,397 @@
+// The gRPC interface to the URL Shortener and Exercise Tracker.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: fccpb/fccgo.proto
+
+package fccpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	URLShortener_CreateShortURL_FullMethodName  = "/fccgo.v1.URLShortener/CreateShortURL"
+	URLShortener_ResolveShortURL_FullMethodName = "/fccgo.v1.URLShortener/ResolveShortURL"
+)
+
+// URLShortenerClient is the client API for URLShortener service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Creates short URLs and looks up the URLs they stand for.
+type URLShortenerClient interface {
+	// Creates a short URL for a URL, or returns the existing one.
+	CreateShortURL(ctx context.Context, in *CreateShortURLRequest, opts ...grpc.CallOption) (*ShortURL, error)
+	// Returns the original URL for a short URL and counts the visit.
+	ResolveShortURL(ctx context.Context, in *ResolveShortURLRequest, opts ...grpc.CallOption) (*ShortURL, error)
+}
+
+type uRLShortenerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewURLShortenerClient(cc grpc.ClientConnInterface) URLShortenerClient {
+	return &uRLShortenerClient{cc}
+}
+
+func (c *uRLShortenerClient) CreateShortURL(ctx context.Context, in *CreateShortURLRequest, opts ...grpc.CallOption) (*ShortURL, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ShortURL)
+	err := c.cc.Invoke(ctx, URLShortener_CreateShortURL_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *uRLShortenerClient) ResolveShortURL(ctx context.Context, in *ResolveShortURLRequest, opts ...grpc.CallOption) (*ShortURL, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ShortURL)
+	err := c.cc.Invoke(ctx, URLShortener_ResolveShortURL_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// URLShortenerServer is the server API for URLShortener service.
+// All implementations must embed UnimplementedURLShortenerServer
+// for forward compatibility.
+//
+// Creates short URLs and looks up the URLs they stand for.
+type URLShortenerServer interface {
+	// Creates a short URL for a URL, or returns the existing one.
+	CreateShortURL(context.Context, *CreateShortURLRequest) (*ShortURL, error)
+	// Returns the original URL for a short URL and counts the visit.
+	ResolveShortURL(context.Context, *ResolveShortURLRequest) (*ShortURL, error)
+	mustEmbedUnimplementedURLShortenerServer()
+}
+
+// UnimplementedURLShortenerServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedURLShortenerServer struct{}
+
+func (UnimplementedURLShortenerServer) CreateShortURL(context.Context, *CreateShortURLRequest) (*ShortURL, error) {
+	return nil, status.Error(codes.Unimplemented, "method CreateShortURL not implemented")
+}
+func (UnimplementedURLShortenerServer) ResolveShortURL(context.Context, *ResolveShortURLRequest) (*ShortURL, error) {
+	return nil, status.Error(codes.Unimplemented, "method ResolveShortURL not implemented")
+}
+func (UnimplementedURLShortenerServer) mustEmbedUnimplementedURLShortenerServer() {}
+func (UnimplementedURLShortenerServer) testEmbeddedByValue()                      {}
+
+// UnsafeURLShortenerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to URLShortenerServer will
+// result in compilation errors.
+type UnsafeURLShortenerServer interface {
+	mustEmbedUnimplementedURLShortenerServer()
+}
+
+func RegisterURLShortenerServer(s grpc.ServiceRegistrar, srv URLShortenerServer) {
+	// If the following call panics, it indicates UnimplementedURLShortenerServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&URLShortener_ServiceDesc, srv)
+}
+
+func _URLShortener_CreateShortURL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateShortURLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(URLShortenerServer).CreateShortURL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: URLShortener_CreateShortURL_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(URLShortenerServer).CreateShortURL(ctx, req.(*CreateShortURLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _URLShortener_ResolveShortURL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResolveShortURLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(URLShortenerServer).ResolveShortURL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: URLShortener_ResolveShortURL_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(URLShortenerServer).ResolveShortURL(ctx, req.(*ResolveShortURLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// URLShortener_ServiceDesc is the grpc.ServiceDesc for URLShortener service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var URLShortener_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "fccgo.v1.URLShortener",
+	HandlerType: (*URLShortenerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateShortURL",
+			Handler:    _URLShortener_CreateShortURL_Handler,
+		},
+		{
+			MethodName: "ResolveShortURL",
+			Handler:    _URLShortener_ResolveShortURL_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "fccpb/fccgo.proto",
+}
+
+const (
+	ExerciseTracker_CreateUser_FullMethodName     = "/fccgo.v1.ExerciseTracker/CreateUser"
+	ExerciseTracker_ListUsers_FullMethodName      = "/fccgo.v1.ExerciseTracker/ListUsers"
+	ExerciseTracker_AddExercise_FullMethodName    = "/fccgo.v1.ExerciseTracker/AddExercise"
+	ExerciseTracker_GetExerciseLog_FullMethodName = "/fccgo.v1.ExerciseTracker/GetExerciseLog"
+)
+
+// ExerciseTrackerClient is the client API for ExerciseTracker service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Manages exercise users and their exercise logs.
+type ExerciseTrackerClient interface {
+	// Creates a new user.
+	CreateUser(ctx context.Context, in *CreateUserRequest, opts ...grpc.CallOption) (*User, error)
+	// Returns every user along with their exercise logs.
+	ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*ListUsersResponse, error)
+	// Adds an exercise to a user's log.
+	AddExercise(ctx context.Context, in *AddExerciseRequest, opts ...grpc.CallOption) (*AddExerciseResponse, error)
+	// Returns a user's exercise log, optionally filtered by date.
+	GetExerciseLog(ctx context.Context, in *GetExerciseLogRequest, opts ...grpc.CallOption) (*UserLog, error)
+}
+
+type exerciseTrackerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewExerciseTrackerClient(cc grpc.ClientConnInterface) ExerciseTrackerClient {
+	return &exerciseTrackerClient{cc}
+}
+
+func (c *exerciseTrackerClient) CreateUser(ctx context.Context, in *CreateUserRequest, opts ...grpc.CallOption) (*User, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(User)
+	err := c.cc.Invoke(ctx, ExerciseTracker_CreateUser_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *exerciseTrackerClient) ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*ListUsersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListUsersResponse)
+	err := c.cc.Invoke(ctx, ExerciseTracker_ListUsers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *exerciseTrackerClient) AddExercise(ctx context.Context, in *AddExerciseRequest, opts ...grpc.CallOption) (*AddExerciseResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AddExerciseResponse)
+	err := c.cc.Invoke(ctx, ExerciseTracker_AddExercise_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *exerciseTrackerClient) GetExerciseLog(ctx context.Context, in *GetExerciseLogRequest, opts ...grpc.CallOption) (*UserLog, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UserLog)
+	err := c.cc.Invoke(ctx, ExerciseTracker_GetExerciseLog_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ExerciseTrackerServer is the server API for ExerciseTracker service.
+// All implementations must embed UnimplementedExerciseTrackerServer
+// for forward compatibility.
+//
+// Manages exercise users and their exercise logs.
+type ExerciseTrackerServer interface {
+	// Creates a new user.
+	CreateUser(context.Context, *CreateUserRequest) (*User, error)
+	// Returns every user along with their exercise logs.
+	ListUsers(context.Context, *ListUsersRequest) (*ListUsersResponse, error)
+	// Adds an exercise to a user's log.
+	AddExercise(context.Context, *AddExerciseRequest) (*AddExerciseResponse, error)
+	// Returns a user's exercise log, optionally filtered by date.
+	GetExerciseLog(context.Context, *GetExerciseLogRequest) (*UserLog, error)
+	mustEmbedUnimplementedExerciseTrackerServer()
+}
+
+// UnimplementedExerciseTrackerServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedExerciseTrackerServer struct{}
+
+func (UnimplementedExerciseTrackerServer) CreateUser(context.Context, *CreateUserRequest) (*User, error) {
+	return nil, status.Error(codes.Unimplemented, "method CreateUser not implemented")
+}
+func (UnimplementedExerciseTrackerServer) ListUsers(context.Context, *ListUsersRequest) (*ListUsersResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListUsers not implemented")
+}
+func (UnimplementedExerciseTrackerServer) AddExercise(context.Context, *AddExerciseRequest) (*AddExerciseResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method AddExercise not implemented")
+}
+func (UnimplementedExerciseTrackerServer) GetExerciseLog(context.Context, *GetExerciseLogRequest) (*UserLog, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetExerciseLog not implemented")
+}
+func (UnimplementedExerciseTrackerServer) mustEmbedUnimplementedExerciseTrackerServer() {}
+func (UnimplementedExerciseTrackerServer) testEmbeddedByValue()                         {}
+
+// UnsafeExerciseTrackerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ExerciseTrackerServer will
+// result in compilation errors.
+type UnsafeExerciseTrackerServer interface {
+	mustEmbedUnimplementedExerciseTrackerServer()
+}
+
+func RegisterExerciseTrackerServer(s grpc.ServiceRegistrar, srv ExerciseTrackerServer) {
+	// If the following call panics, it indicates UnimplementedExerciseTrackerServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ExerciseTracker_ServiceDesc, srv)
+}
+
+func _ExerciseTracker_CreateUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExerciseTrackerServer).CreateUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ExerciseTracker_CreateUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExerciseTrackerServer).CreateUser(ctx, req.(*CreateUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ExerciseTracker_ListUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExerciseTrackerServer).ListUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ExerciseTracker_ListUsers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExerciseTrackerServer).ListUsers(ctx, req.(*ListUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ExerciseTracker_AddExercise_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddExerciseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExerciseTrackerServer).AddExercise(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ExerciseTracker_AddExercise_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExerciseTrackerServer).AddExercise(ctx, req.(*AddExerciseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ExerciseTracker_GetExerciseLog_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetExerciseLogRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExerciseTrackerServer).GetExerciseLog(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ExerciseTracker_GetExerciseLog_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExerciseTrackerServer).GetExerciseLog(ctx, req.(*GetExerciseLogRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ExerciseTracker_ServiceDesc is the grpc.ServiceDesc for ExerciseTracker service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ExerciseTracker_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "fccgo.v1.ExerciseTracker",
+	HandlerType: (*ExerciseTrackerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateUser",
+			Handler:    _ExerciseTracker_CreateUser_Handler,
+		},
+		{
+			MethodName: "ListUsers",
+			Handler:    _ExerciseTracker_ListUsers_Handler,
+		},
+		{
+			MethodName: "AddExercise",
+			Handler:    _ExerciseTracker_AddExercise_Handler,
+		},
+		{
+			MethodName: "GetExerciseLog",
+			Handler:    _ExerciseTracker_GetExerciseLog_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "fccpb/fccgo.proto",
+}
@@ -0,0 +1,5 @@
+// Package fccpb contains the protocol buffer messages and gRPC services
+// generated from fccgo.proto.
+package fccpb
+
+//go:generate protoc -I .. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative ../fccpb/fccgo.proto
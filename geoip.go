@@ -0,0 +1,66 @@
+// Looks up where visitors are from in a MaxMind GeoLite2 or GeoIP2 database,
+// so that clicks on short URLs can be broken down by country.
+package main
+
+import (
+	"github.com/oschwald/maxminddb-golang"
+	"log/slog"
+	"net"
+)
+
+// The database that visitors' locations are looked up in, or nil if there is none
+var geoIPReader *maxminddb.Reader
+
+// The parts of a City or Country database record that are used.
+// Country databases have no city, so it is left empty.
+type geoIPRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+
+// Opens the database at GEOIP_DATABASE, if set, which is a .mmdb file
+// such as GeoLite2-City.mmdb or GeoLite2-Country.mmdb.
+func initGeoIP() {
+	filename := getEnv("GEOIP_DATABASE", "")
+	if len(filename) == 0 {
+		return
+	}
+	reader, err := maxminddb.Open(filename)
+	if err != nil {
+		fatal("Unable to open GEOIP_DATABASE.", "file", filename, "err", err)
+	}
+	geoIPReader = reader
+	slog.Info("Opened GeoIP database.", "file", filename, "type", reader.Metadata.DatabaseType)
+}
+
+
+// Returns the ISO 3166-1 code of the country that the IP address is in, and the English
+// name of its city. Either is empty if it isn't known or there is no database.
+func lookupGeoIP(ip string) (string, string) {
+	if geoIPReader == nil {
+		return "", ""
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return "", ""
+	}
+	var record geoIPRecord
+	if err := geoIPReader.Lookup(addr, &record); err != nil {
+		slog.Debug("GeoIP lookup failed.", "ip", ip, "err", err)
+		return "", ""
+	}
+	return record.Country.ISOCode, record.City.Names["en"]
+}
+
+
+// Closes the GeoIP database, if one was opened.
+func closeGeoIP() {
+	if geoIPReader != nil {
+		geoIPReader.Close()
+	}
+}
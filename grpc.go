@@ -0,0 +1,353 @@
+// Serves the URL Shortener and Exercise Tracker over gRPC
+// using the same database operations as the HTTP handlers.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/jstlwy/fcc-go/fccpb"
+	"golang.org/x/crypto/acme/autocert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type urlShortenerServer struct {
+	fccpb.UnimplementedURLShortenerServer
+}
+
+type exerciseTrackerServer struct {
+	fccpb.UnimplementedExerciseTrackerServer
+}
+
+
+// Returns the gRPC server and its listener on GRPC_ADDR, e.g. "localhost:9090",
+// or nil if it is unset. The server uses the same certificates as the HTTP server.
+func newGRPCServer(manager *autocert.Manager) (*grpc.Server, net.Listener, error) {
+	addr := os.Getenv("GRPC_ADDR")
+	if len(addr) == 0 {
+		return nil, nil, nil
+	}
+
+	options := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(logGRPCRequests, recoverGRPCPanics, requireTokenForGRPC, requireDBForGRPC, requireAPIKeyForGRPC),
+	}
+	if manager != nil {
+		config := &tls.Config{GetCertificate: manager.GetCertificate, NextProtos: []string{"h2"}}
+		options = append(options, grpc.Creds(credentials.NewTLS(config)))
+	} else if certFile, keyFile, ok := getTLSFiles(); ok {
+		creds, err := credentials.NewServerTLSFromFile(certFile, keyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		options = append(options, grpc.Creds(creds))
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	server := grpc.NewServer(options...)
+	fccpb.RegisterURLShortenerServer(server, urlShortenerServer{})
+	fccpb.RegisterExerciseTrackerServer(server, exerciseTrackerServer{})
+	return server, listener, nil
+}
+
+
+// Stops the gRPC server once in-flight calls have finished,
+// or immediately if they are still running when the context ends.
+func stopGRPCServer(ctx context.Context, server *grpc.Server) error {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		server.Stop()
+		return ctx.Err()
+	}
+}
+
+
+// Tags each call with a request ID, taken from the x-request-id metadata if it has
+// a reasonable one, and logs the method, status code, and duration once it completes.
+func logGRPCRequests(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("x-request-id"); len(values) > 0 {
+			id = values[0]
+		}
+	}
+	if !isValidRequestID(id) {
+		id = newRequestID()
+	}
+	ctx = withRequestID(ctx, id)
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-id", id))
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	loggerFrom(ctx).Info("gRPC call handled.",
+		"method", info.FullMethod,
+		"code", status.Code(err).String(),
+		"duration", time.Since(start),
+	)
+	return resp, err
+}
+
+
+// Turns a panic in a gRPC handler into an Internal error
+// rather than letting it crash the whole app.
+func recoverGRPCPanics(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			loggerFrom(ctx).Error("Panic while handling gRPC call.", "method", info.FullMethod, "panic", p)
+			err = status.Error(codes.Internal, "internal server error")
+		}
+	}()
+	return handler(ctx, req)
+}
+
+
+// The gRPC methods that write to the database,
+// which need a bearer token when JWT authentication is enabled.
+var grpcWriteMethods = map[string]bool{
+	fccpb.URLShortener_CreateShortURL_FullMethodName: true,
+	fccpb.ExerciseTracker_CreateUser_FullMethodName:  true,
+	fccpb.ExerciseTracker_AddExercise_FullMethodName: true,
+}
+
+
+// Rejects calls to write methods with Unauthenticated unless their
+// authorization metadata holds a valid bearer token.
+func requireTokenForGRPC(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if !jwtEnabled() || !grpcWriteMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+	var authorization string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+	}
+	ctx, err := authenticateGRPC(ctx, authorization)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return handler(ctx, req)
+}
+
+
+// Rejects calls without an API key in their x-api-key metadata that has the scope
+// of the service being called, if API keys are required.
+func requireAPIKeyForGRPC(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if !apiKeysRequired() {
+		return handler(ctx, req)
+	}
+	scope := scopeExercise
+	if strings.HasPrefix(info.FullMethod, "/"+fccpb.URLShortener_ServiceDesc.ServiceName+"/") {
+		scope = scopeShortURL
+	}
+	var key string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("x-api-key"); len(values) > 0 {
+			key = values[0]
+		}
+	}
+	record, err := authorizeAPIKey(ctx, key, scope)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	ctx = withLoggerAttrs(ctx, "api_key", record.ID.Hex())
+	ctx = context.WithValue(ctx, apiKeyIDKey, record.ID.Hex())
+	return handler(ctx, req)
+}
+
+
+// Returns the IP address of the client that made the call, or "" if it isn't known.
+func grpcPeerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+		return host
+	}
+	return p.Addr.String()
+}
+
+
+// Rejects calls with Unavailable until the database connection has been established.
+func requireDBForGRPC(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if !storageAvailable() {
+		return nil, status.Error(codes.Unavailable, "database unavailable")
+	}
+	return handler(ctx, req)
+}
+
+
+// Converts an error from the database layer into a gRPC status
+// whose code matches the HTTP status the error would have been sent with.
+func grpcError(err error) error {
+	var msg *ErrorMessage
+	var storeErr *StoreError
+	if !errors.As(err, &msg) && !errors.As(err, &storeErr) {
+		slog.Error("Unexpected error.", "func", "grpcError", "err", err)
+		return status.Error(codes.Internal, "internal server error")
+	}
+	msg = toErrorMessage(err)
+	var code codes.Code
+	switch msg.Code {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusNotFound, http.StatusGone:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.AlreadyExists
+	case http.StatusPreconditionFailed:
+		code = codes.Aborted
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusTooManyRequests, http.StatusRequestEntityTooLarge:
+		code = codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	default:
+		code = codes.Internal
+	}
+	return status.Error(code, msg.Content)
+}
+
+
+func (urlShortenerServer) CreateShortURL(ctx context.Context, req *fccpb.CreateShortURLRequest) (*fccpb.ShortURL, error) {
+	originalURL, err := validateURL(ctx, req.GetUrl())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	client := creationClientOf(ctx, grpcPeerIP(ctx))
+	if _, err := takeCreationQuotaOf(ctx, client, 1); err != nil {
+		return nil, grpcError(err)
+	}
+	newURL := NewURL{OriginalURL: originalURL, Owner: ownerFrom(ctx)}
+	// There is no field for a warning in the reply, but the status is still recorded
+	probeNewURL(ctx, &newURL)
+	receipt, err := urlStore.InsertURL(ctx, newURL)
+	if err != nil {
+		giveBackCreationQuotaOf(client, 1)
+		return nil, grpcError(err)
+	}
+	return &fccpb.ShortURL{OriginalUrl: receipt.OriginalURL, ShortUrl: receipt.ShortURL}, nil
+}
+
+
+func (urlShortenerServer) ResolveShortURL(ctx context.Context, req *fccpb.ResolveShortURLRequest) (*fccpb.ShortURL, error) {
+	if len(req.GetShortUrl()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "short_url is required")
+	}
+	originalURL, _, err := urlStore.ResolveURL(ctx, req.GetShortUrl())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if urlBlocked(originalURL) {
+		blockedURLsTotal.inc("redirect")
+		return nil, grpcError(errBlockedLink)
+	}
+	if err := checkVisitRate(ctx, req.GetShortUrl()); err != nil {
+		return nil, grpcError(err)
+	}
+	if err := urlStore.AddVisits(ctx, map[string]int64{req.GetShortUrl(): 1}); err != nil {
+		loggerFrom(ctx).Warn("Unable to count the visit.", "short_url", req.GetShortUrl(), "err", err)
+	}
+	return &fccpb.ShortURL{OriginalUrl: originalURL, ShortUrl: req.GetShortUrl()}, nil
+}
+
+
+func (exerciseTrackerServer) CreateUser(ctx context.Context, req *fccpb.CreateUserRequest) (*fccpb.User, error) {
+	if len(req.GetUsername()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "username is required")
+	}
+	user, err := createExerciseUser(ctx, req.GetUsername())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &fccpb.User{Id: user.ID, Username: user.Username}, nil
+}
+
+
+func (exerciseTrackerServer) ListUsers(ctx context.Context, req *fccpb.ListUsersRequest) (*fccpb.ListUsersResponse, error) {
+	users, err := exerciseStore.GetAllUsers(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &fccpb.ListUsersResponse{Users: make([]*fccpb.UserLog, len(users))}
+	for i, user := range users {
+		resp.Users[i] = userLogToProto(user)
+	}
+	return resp, nil
+}
+
+
+func (exerciseTrackerServer) AddExercise(ctx context.Context, req *fccpb.AddExerciseRequest) (*fccpb.AddExerciseResponse, error) {
+	duration := strconv.Itoa(int(req.GetDuration()))
+	exercise, err := parseExercise(req.GetDescription(), duration, "", req.GetDate())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	receipt, err := exerciseStore.AddExercise(ctx, req.GetUserId(), exercise)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &fccpb.AddExerciseResponse{
+		UserId:   receipt.ID,
+		Username: receipt.Username,
+		Exercise: &fccpb.Exercise{
+			Description: receipt.Description,
+			Duration:    int32(receipt.Duration),
+			Date:        timestamppb.New(receipt.Date),
+		},
+	}, nil
+}
+
+
+func (exerciseTrackerServer) GetExerciseLog(ctx context.Context, req *fccpb.GetExerciseLogRequest) (*fccpb.UserLog, error) {
+	filter := parseExerciseLogFilter(req.GetFrom(), req.GetTo(), strconv.Itoa(int(req.GetLimit())), "")
+	record, err := exerciseStore.GetExerciseLog(ctx, req.GetUserId(), filter)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return userLogToProto(record), nil
+}
+
+
+// Converts a user and their exercise log into its protobuf message.
+func userLogToProto(record ExerciseUserRecord) *fccpb.UserLog {
+	userLog := &fccpb.UserLog{
+		Id:       record.ID,
+		Username: record.Username,
+		Log:      make([]*fccpb.Exercise, len(record.Log)),
+	}
+	for i, exercise := range record.Log {
+		userLog.Log[i] = &fccpb.Exercise{
+			Description: exercise.Description,
+			Duration:    int32(exercise.Duration),
+			Date:        timestamppb.New(exercise.Date),
+		}
+	}
+	return userLog
+}
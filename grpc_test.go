@@ -0,0 +1,57 @@
+// Tests how gRPC calls are authorized.
+package main
+
+import (
+	"context"
+	"github.com/jstlwy/fcc-go/fccpb"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"testing"
+)
+
+// An API key store that knows a single key, which has every scope.
+type fakeAPIKeyStore struct {
+	APIKeyStore
+	key    string
+	record APIKey
+}
+
+func (store *fakeAPIKeyStore) UseAPIKey(ctx context.Context, key string) (APIKey, error) {
+	if key != store.key {
+		return APIKey{}, errInvalidAPIKey
+	}
+	return store.record, nil
+}
+
+
+func TestRequireAPIKeyForGRPCSetsOwner(t *testing.T) {
+	t.Setenv("API_KEYS_REQUIRED", "true")
+	store := &fakeAPIKeyStore{
+		key:    "secret",
+		record: APIKey{ID: primitive.NewObjectID(), Scopes: []string{scopeShortURL, scopeExercise}},
+	}
+	previous := apiKeyStore
+	apiKeyStore = store
+	defer func() { apiKeyStore = previous }()
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/" + fccpb.URLShortener_ServiceDesc.ServiceName + "/CreateShortURL"}
+	var owner string
+	handler := func(ctx context.Context, req any) (any, error) {
+		owner = ownerFrom(ctx)
+		return nil, nil
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", "secret"))
+	if _, err := requireAPIKeyForGRPC(ctx, nil, info, handler); err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	if want := "key:" + store.record.ID.Hex(); owner != want {
+		t.Errorf("owner = %q, want %q", owner, want)
+	}
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", "wrong"))
+	if _, err := requireAPIKeyForGRPC(ctx, nil, info, handler); err == nil {
+		t.Error("a wrong key was let through")
+	}
+}
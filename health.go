@@ -0,0 +1,52 @@
+// Liveness and readiness endpoints for orchestrators and load balancers.
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+type HealthStatus struct {
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+}
+
+// How long a health check waits for the database to respond
+const readyTimeout = 2 * time.Second
+
+
+// Reports that the process is up and able to handle requests.
+func getHealth(w http.ResponseWriter, r *http.Request) {
+	writeHealthStatus(w, http.StatusOK, HealthStatus{Status: "ok"})
+}
+
+
+// Reports whether the app is ready to serve API requests,
+// i.e. whether the database passed its latest background health check.
+func getReadiness(w http.ResponseWriter, r *http.Request) {
+	if !dbReady.Load() {
+		writeHealthStatus(w, http.StatusServiceUnavailable, HealthStatus{
+			Status: "unavailable",
+			Error:  "database not connected yet",
+		})
+		return
+	}
+
+	health := latestStorageHealth.Load()
+	if !health.healthy {
+		writeHealthStatus(w, http.StatusServiceUnavailable, HealthStatus{
+			Status:    "unavailable",
+			Error:     "database unreachable",
+			CheckedAt: &health.checkedAt,
+		})
+		return
+	}
+	writeHealthStatus(w, http.StatusOK, HealthStatus{Status: "ok", CheckedAt: &health.checkedAt})
+}
+
+
+func writeHealthStatus(w http.ResponseWriter, code int, status HealthStatus) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, code, status)
+}
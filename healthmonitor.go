@@ -0,0 +1,90 @@
+// Watches the connection to the storage backend in the background,
+// so that an outage is noticed before a visitor's request runs into it.
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// The outcome of the latest health check.
+type storageHealth struct {
+	healthy   bool
+	checkedAt time.Time
+	// When the backend last went up or down
+	since time.Time
+}
+
+var latestStorageHealth atomic.Pointer[storageHealth]
+
+// Closed to stop the health monitor at shutdown
+var stopHealthMonitor = make(chan struct{})
+
+// How often the backend is pinged while it is unreachable
+const unhealthyCheckInterval = time.Second
+
+
+// Marks the storage as ready to serve requests, starts checking its health
+// every DB_HEALTH_INTERVAL (default 10s), and starts the retention job.
+func markStorageReady() {
+	now := time.Now()
+	latestStorageHealth.Store(&storageHealth{healthy: true, checkedAt: now, since: now})
+	dbReady.Store(true)
+	go monitorStorage(getEnvDuration("DB_HEALTH_INTERVAL", 10*time.Second))
+	startRetentionJob()
+}
+
+
+// Reports whether requests that need the database can be served,
+// i.e. whether it was connected and passed its latest health check.
+func storageAvailable() bool {
+	if !dbReady.Load() {
+		return false
+	}
+	health := latestStorageHealth.Load()
+	return health == nil || health.healthy
+}
+
+
+// Pings the backend periodically and records the result.
+// While it is unreachable, it is pinged more often, which for MongoDB
+// also makes the driver keep trying to reconnect to the cluster.
+func monitorStorage(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-stopHealthMonitor:
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), readyTimeout)
+		err := pingStorage(ctx)
+		cancel()
+
+		now := time.Now()
+		previous := latestStorageHealth.Load()
+		health := &storageHealth{healthy: err == nil, checkedAt: now, since: previous.since}
+		if health.healthy != previous.healthy {
+			health.since = now
+			if err != nil {
+				slog.Error("Lost connection to the database.", "err", err)
+			} else {
+				slog.Info("Reconnected to the database.", "down_for", now.Sub(previous.since).Round(time.Second))
+			}
+		}
+		latestStorageHealth.Store(health)
+
+		if err != nil {
+			timer.Reset(unhealthyCheckInterval)
+		} else {
+			timer.Reset(interval)
+		}
+	}
+}
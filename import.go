@@ -0,0 +1,257 @@
+// Restores URLs and exercise users from the newline-delimited JSON
+// produced by the export endpoints.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// The outcome of an import, with an entry in Errors for each line that wasn't imported.
+type ImportReport struct {
+	Imported int           `json:"imported"`
+	Failed   int           `json:"failed"`
+	Errors   []ImportError `json:"errors"`
+}
+
+// Why a line of an import wasn't imported.
+type ImportError struct {
+	Line  int    `json:"line"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error"`
+}
+
+// How many records are passed to the store at once
+const importBatchSize = 500
+
+// The largest import that is accepted, which can be changed with MAX_IMPORT_BYTES
+var maxImportSize int64
+
+
+// Imports short URLs from an export.
+// Those that already exist are reported as errors, unless ?replace=true is given.
+func postAdminURLImport(w http.ResponseWriter, r *http.Request) {
+	importRecords(w, r, "urls", validateURLExport,
+		func(urls []URLExport, replace bool) ([]error, error) {
+			return urlStore.ImportURLs(r.Context(), urls, replace)
+		},
+		func(u URLExport) string { return u.ShortURL })
+}
+
+
+// Imports exercise users along with their logs from an export.
+// Those that already exist are reported as errors, unless ?replace=true is given.
+func postAdminUserImport(w http.ResponseWriter, r *http.Request) {
+	importRecords(w, r, "users", validateUserExport,
+		func(users []ExerciseUserExport, replace bool) ([]error, error) {
+			return exerciseStore.ImportUsers(r.Context(), users, replace)
+		},
+		func(u ExerciseUserExport) string { return u.ID })
+}
+
+
+// Reads records of type T from an NDJSON body, validates each,
+// and passes the valid ones to store in batches.
+// store returns an error for each record it was given, which is nil if it was imported,
+// or an error if none of them could be.
+// Every line that isn't imported is listed in the report.
+func importRecords[T any](w http.ResponseWriter, r *http.Request, kind string, validate func(T) error,
+	store func([]T, bool) ([]error, error), id func(T) string) {
+	logger := loggerFrom(r.Context())
+	replace, err := strconv.ParseBool(r.URL.Query().Get("replace"))
+	if err != nil && len(r.URL.Query().Get("replace")) > 0 {
+		writeError(w, r, newErrorMessage(http.StatusBadRequest, "replace must be true or false"))
+		return
+	}
+
+	report := ImportReport{Errors: []ImportError{}}
+	fail := func(line int, id string, err error) {
+		report.Failed++
+		report.Errors = append(report.Errors, ImportError{Line: line, ID: id, Error: toErrorMessage(err).Content})
+	}
+
+	var batch []T
+	var batchLines []int
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		results, err := store(batch, replace)
+		if err != nil {
+			return err
+		}
+		for i, err := range results {
+			if err != nil {
+				fail(batchLines[i], id(batch[i]), err)
+			} else {
+				report.Imported++
+			}
+		}
+		batch, batchLines = batch[:0], batchLines[:0]
+		return nil
+	}
+
+	// A single line can be as large as the whole import
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64<<10), int(maxImportSize))
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		var record T
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&record); err != nil {
+			fail(line, "", newErrorMessage(http.StatusBadRequest, "invalid json: " + err.Error()))
+			continue
+		}
+		if err := validate(record); err != nil {
+			fail(line, id(record), err)
+			continue
+		}
+
+		batch = append(batch, record)
+		batchLines = append(batchLines, line)
+		if len(batch) >= importBatchSize {
+			if err := flush(); err != nil {
+				logger.Error("Import failed partway.", "kind", kind, "imported", report.Imported, "err", err)
+				writeError(w, r, err)
+				return
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) || errors.Is(err, bufio.ErrTooLong) {
+			writeError(w, r, errBodyTooLarge)
+		} else {
+			writeError(w, r, newErrorMessage(http.StatusBadRequest, "unable to read body"))
+		}
+		return
+	}
+	if err := flush(); err != nil {
+		logger.Error("Import failed partway.", "kind", kind, "imported", report.Imported, "err", err)
+		writeError(w, r, err)
+		return
+	}
+
+	logger.Info("Imported data.", "kind", kind, "imported", report.Imported, "failed", report.Failed, "replace", replace)
+	writeJSON(w, http.StatusOK, report)
+}
+
+
+// Converts an exported short URL back into the record that is stored.
+func importURL(u URLExport) urlDBRecord {
+	record := urlDBRecord{
+		// Older exports have URLs without their scheme
+		OriginalURL: withScheme(u.OriginalURL),
+		ShortURL: u.ShortURL,
+		TimesVisited: u.TimesVisited,
+		Version: u.Version,
+		Owner: u.Owner,
+		UTM: u.UTM,
+		RedirectStatus: u.RedirectStatus,
+		VisitLimit: u.VisitLimit,
+	}
+	// Stored dates have millisecond precision, as in MongoDB
+	if u.ExpiresAt != nil {
+		record.ExpiresAt = u.ExpiresAt.UTC().Truncate(time.Millisecond)
+	}
+	// Without a creation date, the retention policy counts from the import
+	record.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	if u.CreatedAt != nil {
+		record.CreatedAt = u.CreatedAt.UTC().Truncate(time.Millisecond)
+	}
+	if u.LastVisitedAt != nil {
+		record.LastVisitedAt = u.LastVisitedAt.UTC().Truncate(time.Millisecond)
+	}
+	if u.ProbedAt != nil {
+		record.ProbeStatus = u.ProbeStatus
+		record.ProbedAt = u.ProbedAt.UTC().Truncate(time.Millisecond)
+	}
+	if u.Disabled != nil {
+		disabled := *u.Disabled
+		disabled.DisabledAt = disabled.DisabledAt.UTC().Truncate(time.Millisecond)
+		record.Disabled = &disabled
+	}
+	return record
+}
+
+
+// Checks that an exported short URL can be restored.
+func validateURLExport(u URLExport) error {
+	if len(u.ShortURL) == 0 {
+		return newStoreError(ErrInvalidInput, "short_url is required")
+	}
+	if len(u.OriginalURL) == 0 {
+		return newStoreError(ErrInvalidInput, "original_url is required")
+	}
+	parsed, err := url.Parse(withScheme(u.OriginalURL))
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return newStoreError(ErrInvalidInput, "invalid original_url")
+	}
+	if u.TimesVisited < 0 || u.Version < 0 {
+		return newStoreError(ErrInvalidInput, "times_visited and version must not be negative")
+	}
+	if u.UTM != nil && u.UTM.validate() != nil {
+		return newStoreError(ErrInvalidInput, "invalid utm")
+	}
+	if u.RedirectStatus != 0 && !validRedirectStatus(u.RedirectStatus) {
+		return newStoreError(ErrInvalidInput, "redirect_status must be 301, 302, or 307")
+	}
+	if u.VisitLimit < 0 {
+		return newStoreError(ErrInvalidInput, "visit_limit must not be negative")
+	}
+	return nil
+}
+
+
+// Checks that an exported exercise user can be restored.
+func validateUserExport(u ExerciseUserExport) error {
+	if !primitive.IsValidObjectID(u.ID) {
+		return newStoreError(ErrInvalidInput, "invalid _id")
+	}
+	if len(u.Username) == 0 {
+		return newStoreError(ErrInvalidInput, "username is required")
+	}
+	if u.Version < 0 {
+		return newStoreError(ErrInvalidInput, "version must not be negative")
+	}
+	if len(u.DisplayName) > maxDisplayNameLength {
+		return newStoreError(ErrInvalidInput, "display_name is too long")
+	}
+	if _, err := parseTimeZone(u.TimeZone); err != nil {
+		return newStoreError(ErrInvalidInput, "invalid timezone")
+	}
+	if u.WeeklyGoal < 0 || u.WeeklyGoal > maxWeeklyGoal {
+		return newStoreError(ErrInvalidInput, "invalid weekly_goal")
+	}
+	for i, exercise := range u.Log {
+		if len(exercise.Description) == 0 || exercise.Duration <= 0 || exercise.Date.IsZero() {
+			return newStoreError(ErrInvalidInput, "exercise " + strconv.Itoa(i) + " needs a description, a positive duration, and a date")
+		}
+		if category, err := parseExerciseCategory(exercise.Category); err != nil || category != exercise.Category {
+			return newStoreError(ErrInvalidInput, "exercise " + strconv.Itoa(i) + " has an invalid category")
+		}
+		if tags, err := parseExerciseTags(exercise.Tags); err != nil || !slices.Equal(tags, exercise.Tags) {
+			return newStoreError(ErrInvalidInput, "exercise " + strconv.Itoa(i) + " has invalid tags")
+		}
+		// Exercises added before they had IDs have none
+		if len(exercise.ID) > 0 && !primitive.IsValidObjectID(exercise.ID) {
+			return newStoreError(ErrInvalidInput, "exercise " + strconv.Itoa(i) + " has an invalid _id")
+		}
+	}
+	return nil
+}
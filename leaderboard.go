@@ -0,0 +1,182 @@
+// Ranks exercise users against each other over the current week, the current month,
+// or all time, caching the rankings as working them out reads every user's log.
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// What users can be ranked by, chosen by the "by" query parameter
+const (
+	leaderboardByMinutes   = "minutes"
+	leaderboardByExercises = "exercises"
+)
+
+// The periods that users can be ranked over, chosen by the "window" query parameter.
+// Weeks start on Monday and months on the 1st, in UTC.
+const (
+	leaderboardWeek    = "week"
+	leaderboardMonth   = "month"
+	leaderboardAllTime = "all"
+)
+
+// How many users are ranked by default, and at most
+const (
+	defaultLeaderboardLimit = 10
+	maxLeaderboardLimit = 100
+)
+
+var (
+	errInvalidLeaderboardBy     = newErrorMessage(http.StatusBadRequest, "by must be minutes or exercises")
+	errInvalidLeaderboardWindow = newErrorMessage(http.StatusBadRequest, "window must be week, month, or all")
+	errInvalidLeaderboardLimit  = newErrorMessage(http.StatusBadRequest, "limit must be from 1 to " + strconv.Itoa(maxLeaderboardLimit))
+)
+
+// The users with the most exercise over a period, the most first.
+type Leaderboard struct {
+	XMLName xml.Name           `json:"-" xml:"leaderboard"`
+	By      string             `json:"by" xml:"by"`
+	Window  string             `json:"window" xml:"window"`
+	// Missing for all time
+	Since   *time.Time         `json:"since,omitempty" xml:"since,omitempty"`
+	Users   []LeaderboardEntry `json:"users" xml:"user"`
+}
+
+// A user's place on a leaderboard. Users with the same totals are ranked by ID.
+type LeaderboardEntry struct {
+	Rank        int    `json:"rank" bson:"-" xml:"rank"`
+	ID          string `json:"_id" bson:"_id" xml:"_id"`
+	Username    string `json:"username" bson:"username" xml:"username"`
+	// Missing unless the user has set one in their profile
+	DisplayName string `json:"display_name,omitempty" bson:"display_name,omitempty" xml:"display_name,omitempty"`
+	Minutes     int    `json:"minutes" bson:"minutes" xml:"minutes"`
+	Exercises   int    `json:"exercises" bson:"exercises" xml:"exercises"`
+}
+
+// The rankings that were worked out recently, keyed by what they ranked and since when.
+// Each holds the top maxLeaderboardLimit users, so that every limit can share it.
+type leaderboardCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cachedLeaderboard
+}
+
+type cachedLeaderboard struct {
+	users     []LeaderboardEntry
+	expiresAt time.Time
+}
+
+// The cache, set by initLeaderboard
+var leaderboards *leaderboardCache
+
+
+// Reads how long rankings are cached for from LEADERBOARD_CACHE_TTL (default 1m),
+// where 0 works them out for every request.
+func initLeaderboard() {
+	ttl := getEnvDuration("LEADERBOARD_CACHE_TTL", time.Minute)
+	if ttl < 0 {
+		slog.Warn("LEADERBOARD_CACHE_TTL must not be negative, so not caching leaderboards.", "value", ttl)
+		ttl = 0
+	}
+	leaderboards = &leaderboardCache{ttl: ttl, entries: make(map[string]cachedLeaderboard)}
+}
+
+
+// Sends the top "limit" users (default 10, at most 100) by the "minutes" or "exercises"
+// that they logged, as "by" chooses (default minutes), over the "window" of this "week",
+// this "month", or "all" time (default week).
+func getExerciseLeaderboard(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	by := q.Get("by")
+	if len(by) == 0 {
+		by = leaderboardByMinutes
+	} else if by != leaderboardByMinutes && by != leaderboardByExercises {
+		writeError(w, r, errInvalidLeaderboardBy)
+		return
+	}
+	window := q.Get("window")
+	if len(window) == 0 {
+		window = leaderboardWeek
+	}
+	since, err := leaderboardSince(window, time.Now())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	limit := defaultLeaderboardLimit
+	if value := q.Get("limit"); len(value) > 0 {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxLeaderboardLimit {
+			writeError(w, r, errInvalidLeaderboardLimit)
+			return
+		}
+	}
+
+	users, err := leaderboards.get(r.Context(), by, since)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// The users are copied, as the cache holds on to them
+	board := Leaderboard{By: by, Window: window, Users: append([]LeaderboardEntry{}, users[:min(limit, len(users))]...)}
+	if !since.IsZero() {
+		board.Since = &since
+	}
+	writeResponse(w, r, http.StatusOK, board)
+}
+
+
+// Returns when the window that a leaderboard covers started, which is a zero time for all time.
+func leaderboardSince(window string, now time.Time) (time.Time, error) {
+	today := now.UTC().Truncate(24 * time.Hour)
+	switch window {
+	case leaderboardWeek:
+		return today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7)), nil
+	case leaderboardMonth:
+		return today.AddDate(0, 0, 1-today.Day()), nil
+	case leaderboardAllTime:
+		return time.Time{}, nil
+	default:
+		return time.Time{}, errInvalidLeaderboardWindow
+	}
+}
+
+
+// Returns the top users by minutes or exercises since a time, working them out
+// if they weren't cached or have expired. Concurrent requests that miss may each work them out.
+func (c *leaderboardCache) get(ctx context.Context, by string, since time.Time) ([]LeaderboardEntry, error) {
+	key := by + " " + since.Format(time.RFC3339)
+	c.mu.Lock()
+	cached, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.users, nil
+	}
+
+	users, err := exerciseStore.GetLeaderboard(ctx, since, by, maxLeaderboardLimit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range users {
+		users[i].Rank = i + 1
+	}
+	if c.ttl > 0 {
+		c.mu.Lock()
+		// Rankings of windows that have ended are no longer asked for
+		for key, cached := range c.entries {
+			if !time.Now().Before(cached.expiresAt) {
+				delete(c.entries, key)
+			}
+		}
+		c.entries[key] = cachedLeaderboard{users: users, expiresAt: time.Now().Add(c.ttl)}
+		c.mu.Unlock()
+	}
+	return users, nil
+}
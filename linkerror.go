@@ -0,0 +1,63 @@
+// Explains to visitors who follow a short URL in a browser why it didn't lead
+// anywhere, rather than showing them a JSON error.
+package main
+
+import (
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+)
+
+var errNoSuchShortURL = newErrorMessage(http.StatusNotFound, "no such short url")
+
+// The page shown instead of an error, with the error's status code and message
+var linkErrorPage = template.Must(template.New("linkerror").Parse(`<!DOCTYPE html>
+<html>
+  <head>
+    <title>{{.Title}} | freeCodeCamp.org</title>
+    <meta name="robots" content="noindex" />
+    <link href="/shorturl/style.css" rel="stylesheet" type="text/css" />
+  </head>
+  <body>
+    <h1>{{.Title}}</h1>
+    <main>
+      <section>
+        <p>{{.Message}}</p>
+        <p><a href="/shorturl/">Shorten a URL</a></p>
+      </section>
+    </main>
+  </body>
+</html>
+`))
+
+
+// Reports an error with a short URL that a visitor tried to follow: as a page if
+// the visitor is a browser and the link doesn't exist, has expired, or has been blocked,
+// and as JSON or XML otherwise. A disabled link is always reported as JSON or XML,
+// along with why it was disabled.
+func writeLinkError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrDisabled) {
+		writeError(w, r, err)
+		return
+	}
+	errMsg := toErrorMessage(err)
+	page := struct{ Title, Message string }{}
+	switch errMsg.Code {
+	case http.StatusNotFound:
+		page.Title, page.Message = "Link not found", "There is no short URL at this address. Check that it was copied in full."
+	case http.StatusGone:
+		page.Title, page.Message = "Link expired", "This short URL has expired, so it no longer leads anywhere."
+	case http.StatusForbidden:
+		page.Title, page.Message = "Link blocked", "This short URL leads to a site that has been blocked."
+	}
+	if len(page.Title) == 0 || !acceptsHTML(r) {
+		writeError(w, r, errMsg)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(errMsg.Code)
+	if err := linkErrorPage.Execute(w, page); err != nil {
+		slog.Error("Template.Execute failed", "func", "writeLinkError", "err", err)
+	}
+}
@@ -0,0 +1,169 @@
+// Sets up the HTTP servers and the listeners they accept connections on.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Returns a server for the handler with timeouts taken from the environment,
+// so that slow or idle clients cannot hold connections open indefinitely.
+func newHTTPServer(addr string, handler http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: getEnvDuration("SERVER_READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       getEnvDuration("SERVER_READ_TIMEOUT", 15*time.Second),
+		WriteTimeout:      getEnvDuration("SERVER_WRITE_TIMEOUT", 30*time.Second),
+		IdleTimeout:       getEnvDuration("SERVER_IDLE_TIMEOUT", 120*time.Second),
+	}
+	slog.Debug("Created HTTP server.",
+		"addr", addr,
+		"read_header_timeout", server.ReadHeaderTimeout,
+		"read_timeout", server.ReadTimeout,
+		"write_timeout", server.WriteTimeout,
+		"idle_timeout", server.IdleTimeout,
+	)
+	return server
+}
+
+
+// Returns the listener that the app accepts connections on.
+// A socket passed in by systemd takes priority over everything else.
+// Otherwise, if LISTEN_SOCKET is set, this is a Unix domain socket at that path
+// whose permissions are set from SOCKET_MODE (octal, default 0660),
+// so that e.g. nginx can connect to it. Otherwise it is a TCP listener on addr.
+func newListener(addr string) (net.Listener, error) {
+	if listener, err := systemdListener(); listener != nil || err != nil {
+		return listener, err
+	}
+
+	socketPath := os.Getenv("LISTEN_SOCKET")
+	if len(socketPath) == 0 {
+		return net.Listen("tcp", addr)
+	}
+
+	mode, err := strconv.ParseUint(getEnv("SOCKET_MODE", "0660"), 8, 32)
+	if err != nil {
+		return nil, errors.New("invalid SOCKET_MODE: " + err.Error())
+	}
+
+	// A socket file left behind by a previous run would make Listen fail
+	if info, err := os.Stat(socketPath); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, errors.New(socketPath + " exists and is not a socket")
+		}
+		if err := os.Remove(socketPath); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socketPath, fs.FileMode(mode)); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	slog.Info("Listening on Unix socket.", "path", socketPath, "mode", fs.FileMode(mode))
+	return listener, nil
+}
+
+
+// The first file descriptor that systemd passes to an activated service.
+const systemdFirstFD = 3
+
+
+// Returns the listening socket passed in by systemd socket activation,
+// or nil if the app wasn't started that way.
+// See sd_listen_fds(3) for the protocol.
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	numFDs, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || numFDs < 1 {
+		return nil, nil
+	}
+	if numFDs > 1 {
+		slog.Warn("systemd passed more than one socket, so only the first will be used.", "count", numFDs)
+	}
+
+	// Don't let any child processes think the sockets were meant for them
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	file := os.NewFile(systemdFirstFD, "systemd-socket")
+	listener, err := net.FileListener(file)
+	// FileListener duplicates the descriptor, so the original can be closed
+	file.Close()
+	if err != nil {
+		return nil, errors.New("unable to use socket from systemd: " + err.Error())
+	}
+	slog.Info("Using socket passed in by systemd.", "addr", listener.Addr())
+	return listener, nil
+}
+
+
+// A server together with the calls that start it serving and shut it down.
+type managedServer struct {
+	name     string
+	serve    func() error
+	shutdown func(context.Context) error
+}
+
+
+// Returns a managedServer for an HTTP server.
+func managedHTTPServer(name string, server *http.Server, serve func() error) managedServer {
+	return managedServer{name: name, serve: serve, shutdown: server.Shutdown}
+}
+
+
+// Runs every server until the process receives SIGINT or SIGTERM
+// or one of them fails, then shuts all of them down gracefully,
+// giving in-flight requests up to SHUTDOWN_TIMEOUT (default 10s) to finish.
+// Returns the error that stopped the servers, if any.
+func runUntilShutdown(servers ...managedServer) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, len(servers))
+	for _, s := range servers {
+		go func() {
+			if err := s.serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs <- fmt.Errorf("%s server: %w", s.name, err)
+			}
+		}()
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+		slog.Info("Received signal, so shutting down.")
+	case err = <-errs:
+		slog.Error("Server failed, so shutting down.", "err", err)
+	}
+
+	timeout := getEnvDuration("SHUTDOWN_TIMEOUT", 10*time.Second)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, s := range servers {
+		if err := s.shutdown(shutdownCtx); err != nil {
+			slog.Error("Server did not shut down cleanly.", "func", "runUntilShutdown", "server", s.name, "err", err)
+		}
+	}
+	return err
+}
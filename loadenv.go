@@ -3,20 +3,29 @@ package main
 
 import (
 	"bufio"
-	"log"
+	"errors"
+	"io/fs"
+	"log/slog"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const filename string = ".env"
 
 func loadEnvVars() {
-	log.Println("Loading environment variables.")
+	slog.Info("Loading environment variables.")
 
 	// Open the .env file
 	file, openErr := os.Open(filename)
+	// The file is optional, as the variables can be set in the environment instead
+	if errors.Is(openErr, fs.ErrNotExist) {
+		slog.Info("No .env file, so using the environment as it is.")
+		return
+	}
     if openErr != nil {
-		log.Fatal("Error when opening .env file: %s\n", openErr)
+		fatal("Error when opening .env file.", "err", openErr)
     }
     defer file.Close()
 
@@ -34,11 +43,89 @@ func loadEnvVars() {
 		// Save the key and value in the environment variables
 		setEnvErr := os.Setenv(key, value)
 		if setEnvErr != nil {
-			log.Fatal("Error when adding environment variable: %s\n", setEnvErr)
+			fatal("Error when adding environment variable.", "err", setEnvErr)
 		}
     }
     if scanErr := scanner.Err(); scanErr != nil {
-		log.Fatal("Error when scanning .env file: %s\n", scanErr)
+		fatal("Error when scanning .env file.", "err", scanErr)
+	}
+}
+
+
+
+// Returns the value of the given environment variable,
+// or the fallback value if it is unset or empty.
+func getEnv(key string, fallback string) string {
+	if value := os.Getenv(key); len(value) > 0 {
+		return value
+	}
+	return fallback
+}
+
+
+// Returns the comma-separated values of the given environment variable,
+// with surrounding whitespace and empty entries removed,
+// or the fallback values if it is unset or empty.
+func getEnvList(key string, fallback ...string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); len(value) > 0 {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		return fallback
+	}
+	return values
+}
+
+
+// Returns the value of the given environment variable parsed as a duration
+// (e.g. "5s" or "1m30s"), or the fallback value if it is unset or invalid.
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if len(value) == 0 {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Warn("Invalid duration in environment variable, so using the default.",
+			"key", key, "value", value, "default", fallback)
+		return fallback
 	}
+	return duration
 }
 
+
+// Returns the value of the given environment variable parsed as an integer,
+// or the fallback value if it is unset or invalid.
+func getEnvInt(key string, fallback int64) int64 {
+	value := os.Getenv(key)
+	if len(value) == 0 {
+		return fallback
+	}
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		slog.Warn("Invalid integer in environment variable, so using the default.",
+			"key", key, "value", value, "default", fallback)
+		return fallback
+	}
+	return number
+}
+
+
+// Returns the value of the given environment variable parsed as a boolean
+// (e.g. "true", "1", "false"), or the fallback value if it is unset or invalid.
+func getEnvBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if len(value) == 0 {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("Invalid boolean in environment variable, so using the default.",
+			"key", key, "value", value, "default", fallback)
+		return fallback
+	}
+	return b
+}
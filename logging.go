@@ -0,0 +1,155 @@
+// Sets up structured logging for the whole app.
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Replaces the default logger with one configured by the environment.
+// LOG_LEVEL may be debug, info, warn, or error (default info),
+// and LOG_FORMAT may be text or json (default text).
+func initLogger() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(getEnv("LOG_LEVEL", "info"))); err != nil {
+		slog.Warn("Invalid LOG_LEVEL, so defaulting to info.", "err", err)
+		level = slog.LevelInfo
+	}
+	options := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if strings.EqualFold(os.Getenv("LOG_FORMAT"), "json") {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, options)
+	}
+	slog.SetDefault(slog.New(&errorRecorder{Handler: handler}))
+}
+
+
+// How many of the most recent errors are kept for the admin API.
+const recentErrorsSize = 50
+
+// An error that was logged, as reported by the admin API.
+type LoggedError struct {
+	Time    time.Time         `json:"time"`
+	Message string            `json:"message"`
+	Attrs   map[string]string `json:"attrs,omitempty"`
+}
+
+var (
+	recentErrorsMu sync.Mutex
+	recentErrors   []LoggedError
+)
+
+
+// A log handler that also remembers the most recent errors
+// so that they can be inspected without access to the logs.
+type errorRecorder struct {
+	slog.Handler
+	attrs []slog.Attr
+}
+
+func (h *errorRecorder) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level >= slog.LevelError {
+		entry := LoggedError{Time: record.Time, Message: record.Message, Attrs: map[string]string{}}
+		for _, attr := range h.attrs {
+			entry.Attrs[attr.Key] = attr.Value.String()
+		}
+		record.Attrs(func(attr slog.Attr) bool {
+			entry.Attrs[attr.Key] = attr.Value.String()
+			return true
+		})
+		recentErrorsMu.Lock()
+		recentErrors = append(recentErrors, entry)
+		if len(recentErrors) > recentErrorsSize {
+			recentErrors = recentErrors[len(recentErrors)-recentErrorsSize:]
+		}
+		recentErrorsMu.Unlock()
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *errorRecorder) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &errorRecorder{
+		Handler: h.Handler.WithAttrs(attrs),
+		attrs:   append(append([]slog.Attr(nil), h.attrs...), attrs...),
+	}
+}
+
+func (h *errorRecorder) WithGroup(name string) slog.Handler {
+	return &errorRecorder{Handler: h.Handler.WithGroup(name), attrs: h.attrs}
+}
+
+
+// Returns a copy of the most recent errors, newest first.
+func getRecentErrors() []LoggedError {
+	recentErrorsMu.Lock()
+	defer recentErrorsMu.Unlock()
+	errs := make([]LoggedError, len(recentErrors))
+	for i, entry := range recentErrors {
+		errs[len(errs)-1-i] = entry
+	}
+	return errs
+}
+
+
+// Logs a message at the error level, then exits the program.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+
+// Wraps a ResponseWriter to remember the status code and size of the response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.size += n
+	return n, err
+}
+
+// Allows http.ResponseController to reach the underlying ResponseWriter.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+
+// Logs the method, path, status, and duration of every request once it completes.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		loggerFrom(r.Context()).Info("Request handled.",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration", time.Since(start),
+			"client_ip", clientIP(r),
+		)
+	})
+}
@@ -0,0 +1,100 @@
+// Keeps the most recently used short URLs in memory, for servers without Redis.
+package main
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+var urlCacheEvictionsTotal = newCounterVec("url_cache_evictions_total",
+	"Total number of short URLs evicted from the in-memory cache to make room for others.")
+
+// A cache of at most a fixed number of entries, which evicts the least recently used
+// entry to make room for a new one. Entries also expire after their TTL.
+// It has the same methods as redisClient, so that either can be behind a cachedURLStore.
+type lruCache struct {
+	capacity int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// The entries, most recently used first
+	order   *list.List
+}
+
+type lruEntry struct {
+	key       string
+	value     string
+	expiresAt time.Time
+}
+
+
+// Returns an empty cache with room for the given number of entries.
+func newLRUCache(capacity int) *lruCache {
+	return &lruCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element, capacity),
+		order:    list.New(),
+	}
+}
+
+
+// Returns the value of a key, or errRedisNil if it isn't cached or has expired.
+func (c *lruCache) Get(ctx context.Context, key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return "", errRedisNil
+	}
+	entry := element.Value.(*lruEntry)
+	if !time.Now().Before(entry.expiresAt) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return "", errRedisNil
+	}
+	c.order.MoveToFront(element)
+	return entry.value, nil
+}
+
+
+// Sets the value of a key that expires after the TTL,
+// evicting the least recently used key if the cache is full.
+func (c *lruCache) SetEx(ctx context.Context, key string, value string, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expiresAt := time.Now().Add(ttl)
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*lruEntry)
+		entry.value, entry.expiresAt = value, expiresAt
+		c.order.MoveToFront(element)
+		return nil
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, expiresAt: expiresAt})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+		urlCacheEvictionsTotal.inc()
+	}
+	return nil
+}
+
+
+// Deletes keys.
+func (c *lruCache) Del(ctx context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if element, ok := c.entries[key]; ok {
+			c.order.Remove(element)
+			delete(c.entries, key)
+		}
+	}
+	return nil
+}
+
+
+// Does nothing, as there is nothing to close.
+func (c *lruCache) Close() {}
@@ -0,0 +1,265 @@
+// Collects request and database metrics and exposes them
+// in the Prometheus text exposition format.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/event"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Anything that can write itself out in the exposition format.
+type metric interface {
+	writeTo(w io.Writer)
+}
+
+// Every metric, in the order in which they are written out.
+var metricsRegistry []metric
+
+// The default latency buckets, in seconds.
+var defaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var (
+	httpRequestsTotal = newCounterVec("http_requests_total",
+		"Total number of HTTP requests handled.", "method", "route", "status")
+	httpRequestDuration = newHistogramVec("http_request_duration_seconds",
+		"Time taken to handle HTTP requests.", defaultBuckets, "route")
+	httpRequestsInFlight = newGauge("http_requests_in_flight",
+		"Number of HTTP requests currently being handled.")
+	mongoCommandsTotal = newCounterVec("mongodb_commands_total",
+		"Total number of commands sent to MongoDB.", "command", "outcome")
+)
+
+
+// A counter partitioned by a set of labels.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name string, help string, labels ...string) *counterVec {
+	c := &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	metricsRegistry = append(metricsRegistry, c)
+	return c
+}
+
+// Adds to the counter with the given label values,
+// which must be in the same order as the labels.
+func (c *counterVec) add(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	c.values[key] += value
+	c.mu.Unlock()
+}
+
+func (c *counterVec) inc(labelValues ...string) {
+	c.add(1, labelValues...)
+}
+
+// Returns the totals of the counter for each value of one of its labels.
+func (c *counterVec) sumBy(label string) map[string]float64 {
+	index := -1
+	for i, name := range c.labels {
+		if name == label {
+			index = i
+		}
+	}
+	totals := make(map[string]float64)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, value := range c.values {
+		if index >= 0 {
+			totals[strings.Split(key, "\xff")[index]] += value
+		}
+	}
+	return totals
+}
+
+func (c *counterVec) writeTo(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, key, ""), formatFloat(c.values[key]))
+	}
+}
+
+
+// A single value that can go up and down.
+type gauge struct {
+	name  string
+	help  string
+	value atomic.Int64
+}
+
+func newGauge(name string, help string) *gauge {
+	g := &gauge{name: name, help: help}
+	metricsRegistry = append(metricsRegistry, g)
+	return g
+}
+
+func (g *gauge) writeTo(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	fmt.Fprintf(w, "%s %d\n", g.name, g.value.Load())
+}
+
+
+// A histogram partitioned by a set of labels.
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogramVec(name string, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{name: name, help: help, labels: labels, buckets: buckets,
+		series: make(map[string]*histogramSeries)}
+	metricsRegistry = append(metricsRegistry, h)
+	return h
+}
+
+// Records one observation for the series with the given label values.
+func (h *histogramVec) observe(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h *histogramVec) writeTo(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		for i, bound := range h.buckets {
+			le := `le="` + formatFloat(bound) + `"`
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, le), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, `le="+Inf"`), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, key, ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, key, ""), s.count)
+	}
+}
+
+
+// Returns the keys of a map in sorted order so the output is stable.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+
+// Formats label names and joined label values as {name="value",...},
+// optionally followed by one extra pre-formatted label.
+func formatLabels(names []string, joinedValues string, extra string) string {
+	var pairs []string
+	if len(names) > 0 {
+		values := strings.Split(joinedValues, "\xff")
+		for i, name := range names {
+			pairs = append(pairs, name+"="+strconv.Quote(values[i]))
+		}
+	}
+	if len(extra) > 0 {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+
+// Returns middleware that records the count and latency of requests
+// for each route registered on the mux.
+func newMetricsMiddleware(mux *http.ServeMux) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			httpRequestsInFlight.value.Add(1)
+			defer httpRequestsInFlight.value.Add(-1)
+
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+
+			// Label by the registered pattern rather than the raw path
+			// so that IDs and short codes don't each create a new series
+			_, route := mux.Handler(r)
+			httpRequestsTotal.inc(r.Method, route, strconv.Itoa(rec.status))
+			httpRequestDuration.observe(time.Since(start).Seconds(), route)
+		})
+	}
+}
+
+
+// Returns a monitor that counts every command sent to MongoDB and logs the slow ones.
+func newMongoCommandMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, e *event.CommandStartedEvent) {
+			commandStarted(e)
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			mongoCommandsTotal.inc(e.CommandName, "success")
+			commandFinished(ctx, e.CommandFinishedEvent, nil)
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			mongoCommandsTotal.inc(e.CommandName, "failure")
+			commandFinished(ctx, e.CommandFinishedEvent, errors.New(e.Failure))
+		},
+	}
+}
+
+
+// Writes out every metric in the Prometheus text exposition format.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("Request for metrics.")
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, m := range metricsRegistry {
+		m.writeTo(w)
+	}
+}
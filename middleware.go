@@ -0,0 +1,52 @@
+// Middleware shared by every route in the app.
+package main
+
+import (
+	"net/http"
+	"runtime/debug"
+)
+
+// A function that wraps an http.Handler to add behavior before or after it.
+type Middleware func(http.Handler) http.Handler
+
+
+// Wraps the handler in each of the given middleware functions.
+// The first middleware in the list is the outermost one,
+// i.e. it sees the request first and the response last.
+// Nil entries are skipped so that optional middleware can be passed unconditionally.
+func chain(handler http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		if middlewares[i] != nil {
+			handler = middlewares[i](handler)
+		}
+	}
+	return handler
+}
+
+
+// Registers a handler function on the mux wrapped in route-specific middleware.
+func handleWith(mux *http.ServeMux, pattern string, handler http.HandlerFunc, middlewares ...Middleware) {
+	mux.Handle(pattern, chain(handler, middlewares...))
+}
+
+
+
+// Recovers from a panic in any handler further down the chain,
+// logs the stack trace, and sends the visitor a JSON error.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			// The server uses this value to abort a response on purpose
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			loggerFrom(r.Context()).Error("Panic while handling request.", "method", r.Method, "path", r.URL.Path, "panic", err, "stack", string(debug.Stack()))
+			writeError(w, r, newErrorMessage(http.StatusInternalServerError, "internal server error"))
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,270 @@
+// Applies versioned changes to the stored data at startup,
+// so that each backend is brought up to the shape the code expects.
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// A change to the stored data, with a step for each backend.
+// A nil step means the backend needs no change.
+// Steps must be idempotent, because a step that fails partway is run again in full.
+type migration struct {
+	version     int
+	description string
+	mongo       func(ctx context.Context, db *mongo.Database) error
+	bolt        func(tx *bolt.Tx) error
+}
+
+// A record of an applied migration.
+type migrationRecord struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// Every migration, in the order in which they are applied.
+// Append new ones with the next version number and never change old ones.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "Set times_visited on URLs that lack it",
+		mongo: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection(os.Getenv("COLLECTION_U")).UpdateMany(ctx,
+				bson.M{"times_visited": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"times_visited": 0}})
+			return err
+		},
+	},
+	{
+		version:     2,
+		description: "Add a version to URLs and exercise users",
+		mongo: func(ctx context.Context, db *mongo.Database) error {
+			for _, name := range []string{os.Getenv("COLLECTION_U"), os.Getenv("COLLECTION_E")} {
+				_, err := db.Collection(name).UpdateMany(ctx,
+					bson.M{"version": bson.M{"$exists": false}},
+					bson.M{"$set": bson.M{"version": 0}})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     3,
+		description: "Set created_at on URLs that lack it",
+		// The creation time is part of MongoDB's IDs
+		mongo: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection(os.Getenv("COLLECTION_U")).UpdateMany(ctx,
+				bson.M{"created_at": bson.M{"$exists": false}},
+				mongo.Pipeline{{{Key: "$set", Value: bson.M{"created_at": bson.M{"$toDate": "$_id"}}}}})
+			return err
+		},
+		// bbolt has no record of it, so count from now
+		bolt: func(tx *bolt.Tx) error {
+			urls := tx.Bucket(boltURLsBucket)
+			now := time.Now().UTC().Truncate(time.Millisecond)
+			var updated []urlDBRecord
+			err := urls.ForEach(func(k, v []byte) error {
+				var record urlDBRecord
+				if err := bson.Unmarshal(v, &record); err != nil {
+					return err
+				}
+				if record.CreatedAt.IsZero() {
+					record.CreatedAt = now
+					updated = append(updated, record)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, record := range updated {
+				if err := putBoltRecord(urls, []byte(record.ShortURL), record); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     4,
+		description: "Start the short URL counter after the existing short URLs",
+		mongo:       seedShortURLCounter,
+	},
+	{
+		version:     5,
+		description: "Put http:// in front of URLs stored without a scheme",
+		mongo: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection(os.Getenv("COLLECTION_U")).UpdateMany(ctx,
+				bson.M{"original_url": bson.M{"$not": bson.M{"$regex": urlSchemePattern.String()}}},
+				mongo.Pipeline{{{Key: "$set", Value: bson.M{"original_url": bson.M{"$concat": bson.A{"http://", "$original_url"}}}}}})
+			return err
+		},
+		// The original URLs are also keys, which have to be moved
+		bolt: func(tx *bolt.Tx) error {
+			urls := tx.Bucket(boltURLsBucket)
+			originals := tx.Bucket(boltOriginalURLsBucket)
+			var updated []urlDBRecord
+			err := urls.ForEach(func(k, v []byte) error {
+				var record urlDBRecord
+				if err := bson.Unmarshal(v, &record); err != nil {
+					return err
+				}
+				if withScheme(record.OriginalURL) != record.OriginalURL {
+					updated = append(updated, record)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, record := range updated {
+				if err := originals.Delete([]byte(record.OriginalURL)); err != nil {
+					return err
+				}
+				record.OriginalURL = withScheme(record.OriginalURL)
+				if err := originals.Put([]byte(record.OriginalURL), []byte(record.ShortURL)); err != nil {
+					return err
+				}
+				if err := putBoltRecord(urls, []byte(record.ShortURL), record); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     6,
+		description: "Start the short URL counter after the highest existing short URL",
+		// Migration 4 once started it at the number of URLs, which is too low once any have been deleted
+		mongo:       seedShortURLCounter,
+	},
+}
+
+// Holds a migrationRecord for each applied migration, keyed by version
+var boltMigrationsBucket = []byte("migrations")
+
+// How long all the MongoDB migrations together may take
+const migrationTimeout = 5 * time.Minute
+
+
+// Moves the short URL counter past the highest short URL written as the counter writes them,
+// as ImportURLs does, so that new sequential short URLs don't collide with existing ones.
+// Counting the URLs isn't enough, as some may have been deleted. $max makes it idempotent.
+// Random short URLs don't use the counter, so nothing is scanned unless SHORT_URL_STYLE is "sequential".
+func seedShortURLCounter(ctx context.Context, db *mongo.Database) error {
+	if !sequentialShortURLs {
+		return nil
+	}
+	cursor, err := db.Collection(os.Getenv("COLLECTION_U")).Find(ctx, bson.D{},
+		options.Find().SetProjection(bson.M{"short_url": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var highest int64 = -1
+	for cursor.Next(ctx) {
+		var record struct {
+			ShortURL string `bson:"short_url"`
+		}
+		if err := cursor.Decode(&record); err != nil {
+			return err
+		}
+		highest = highestSequentialShortURL(highest, record.ShortURL)
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if highest < 0 {
+		return nil
+	}
+	_, err = db.Collection(getEnv("COLLECTION_C", "counters")).UpdateOne(ctx,
+		bson.M{"_id": shortURLCounterID},
+		bson.M{"$max": bson.M{"seq": highest + 1}},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+
+// Applies the migrations that haven't been applied to the MongoDB database yet,
+// recording each in the collection named by COLLECTION_M (default "migrations").
+func migrateMongo(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancel()
+	collection := db.Collection(getEnv("COLLECTION_M", "migrations"))
+
+	cursor, err := collection.Find(ctx, bson.D{}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	var applied []migrationRecord
+	if err := cursor.All(ctx, &applied); err != nil {
+		return err
+	}
+	done := make(map[int]bool, len(applied))
+	for _, record := range applied {
+		done[record.Version] = true
+	}
+
+	for _, m := range migrations {
+		if done[m.version] {
+			continue
+		}
+		slog.Info("Applying migration.", "version", m.version, "description", m.description)
+		if m.mongo != nil {
+			if err := m.mongo(ctx, db); err != nil {
+				return fmt.Errorf("migration %d: %w", m.version, err)
+			}
+		}
+		record := migrationRecord{Version: m.version, Description: m.description, AppliedAt: time.Now().UTC()}
+		// Another instance may have applied it at the same time, which is harmless
+		if _, err := collection.InsertOne(ctx, record); err != nil && !mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("migration %d: %w", m.version, err)
+		}
+	}
+	return nil
+}
+
+
+// Applies the migrations that haven't been applied to the bbolt file yet.
+// Each migration runs in its own transaction along with its record,
+// so it is either applied and recorded or not at all.
+func migrateBolt(db *bolt.DB) error {
+	for _, m := range migrations {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(m.version))
+		err := db.Update(func(tx *bolt.Tx) error {
+			bucket, err := tx.CreateBucketIfNotExists(boltMigrationsBucket)
+			if err != nil {
+				return err
+			}
+			if bucket.Get(key) != nil {
+				return nil
+			}
+			slog.Info("Applying migration.", "version", m.version, "description", m.description)
+			if m.bolt != nil {
+				if err := m.bolt(tx); err != nil {
+					return err
+				}
+			}
+			record := migrationRecord{Version: m.version, Description: m.description, AppliedAt: time.Now().UTC()}
+			return putBoltRecord(bucket, key, record)
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d: %w", m.version, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,887 @@
+// Describes the APIs in an OpenAPI 3 document, served at /openapi.json,
+// and serves a Swagger UI page for browsing it at /docs.
+package main
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A path, query, header, or form parameter of an API operation.
+type apiParam struct {
+	Name        string
+	Description string
+	Required    bool
+	Type        string // "string" unless stated otherwise
+}
+
+// A single endpoint as it appears in the OpenAPI document.
+type apiOperation struct {
+	Method      string
+	Path        string
+	Tag         string
+	Summary     string
+	PathParams  []apiParam
+	QueryParams []apiParam
+	HeaderParams []apiParam
+	FormParams  []apiParam
+	Multipart   bool
+	JSONBody    bool // Whether the form params can also be sent as a JSON object
+	Status      int // The status code returned on success
+	Response    any // A value of the type returned on success, or nil for none
+	ContentType string // Defaults to application/json when there is a response
+	Security    [][]string // Alternative sets of security schemes, all of a set being required
+}
+
+// The ways in which a client can authenticate, keyed by the names used in apiOperation.Security.
+var apiSecuritySchemes = map[string]any{
+	"adminToken": map[string]any{"type": "http", "scheme": "bearer", "description": "The value of ADMIN_TOKEN"},
+	"adminBasic": map[string]any{"type": "http", "scheme": "basic", "description": "ADMIN_USERNAME and ADMIN_PASSWORD"},
+	"bearerToken": map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT",
+		"description": "A token from /auth/token, only required if JWT_SECRET is set"},
+	"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key",
+		"description": "A key with the scope of the API being called, only required if API_KEYS_REQUIRED is true"},
+}
+
+var (
+	adminSecurity = [][]string{{"adminToken"}, {"adminBasic"}}
+	writeSecurity = [][]string{{"bearerToken", "apiKey"}}
+	readSecurity  = [][]string{{"apiKey"}}
+)
+
+// Every API endpoint, in the order in which they appear in the document.
+var apiOperations = []apiOperation{
+	{
+		Method: "GET", Path: "/whoami/", Tag: "Header Parser",
+		Summary: "Returns the visitor's IP address, preferred language, and user agent",
+		Status:  http.StatusOK, Response: WhoamiStruct{},
+	},
+	{
+		Method: "GET", Path: "/date/", Tag: "Timestamp",
+		Summary: "Returns the current date",
+		Status:  http.StatusOK, Response: DateStruct{},
+	},
+	{
+		Method: "GET", Path: "/date/{date}", Tag: "Timestamp",
+		Summary: "Returns the given date, or the current date if it is invalid",
+		PathParams: []apiParam{
+			{Name: "date", Description: "A date in YYYY-MM-DD format or seconds since the epoch", Required: true},
+		},
+		Status: http.StatusOK, Response: DateStruct{},
+	},
+	{
+		Method: "POST", Path: "/file/analyze", Tag: "File Metadata",
+		Summary: "Returns the name, type, and size of the uploaded file",
+		FormParams: []apiParam{
+			{Name: "upfile", Description: "The file to analyze", Required: true, Type: "file"},
+		},
+		Multipart: true,
+		Status:    http.StatusOK, Response: FileMetadataStruct{},
+	},
+	{
+		Method: "POST", Path: "/shorturl/new", Tag: "URL Shortener",
+		Summary: "Creates a short URL for the given URL, sent as form data or as a JSON object with the same fields. A URL that was already shortened gets its existing short URL, or a 409 if it was shortened with another expiry, redirect status, or owner",
+		FormParams: []apiParam{
+			{Name: "url", Description: "The URL to shorten", Required: true},
+			{Name: "alias", Description: "The short URL to use instead of a generated one: 3 to 32 letters, digits, dashes, or underscores"},
+			{Name: "expires_in", Description: "How long until the short URL expires, in seconds or as a duration such as 36h"},
+			{Name: "expires_at", Description: "When the short URL expires, as an RFC 3339 time"},
+			{Name: "redirect_status", Description: "The status code that visitors are redirected with: 301, 302, or 307, defaulting to the server's choice"},
+		},
+		JSONBody: true, Status: http.StatusCreated, Response: urlReceipt{}, Security: writeSecurity,
+	},
+	{
+		Method: "POST", Path: "/shorturl/bulk", Tag: "URL Shortener",
+		Summary: "Creates short URLs for a JSON array of URLs and returns the result for each, in the same order",
+		Status: http.StatusOK, Response: []BulkURLResult{}, Security: writeSecurity,
+	},
+	{
+		Method: "GET", Path: "/shorturl/go/{code}", Tag: "URL Shortener",
+		Summary: "Redirects to the original URL for the short code with the status code chosen for it, or sends a 404 if there is none, as a page for browsers",
+		PathParams: []apiParam{
+			{Name: "code", Description: "The short code, followed by \"+\" to preview where it leads instead of following it", Required: true},
+		},
+		QueryParams: []apiParam{
+			{Name: "preview", Description: "1 to show where the short URL leads instead of redirecting, as a page for browsers and JSON or XML otherwise", Type: "boolean"},
+		},
+		Status: http.StatusTemporaryRedirect,
+	},
+	{
+		Method: "GET", Path: "/shorturl/qr/{code}", Tag: "URL Shortener",
+		Summary: "Returns a QR code that leads to the short URL's redirect",
+		PathParams: []apiParam{
+			{Name: "code", Description: "The short code", Required: true},
+		},
+		QueryParams: []apiParam{
+			{Name: "format", Description: "png (the default) or svg"},
+			{Name: "size", Description: "Width and height in pixels, from 64 to 1024 (default 256)", Type: "integer"},
+		},
+		Status: http.StatusOK, Response: "", ContentType: "image/png",
+	},
+	{
+		Method: "GET", Path: "/shorturl/list", Tag: "URL Shortener",
+		Summary: "Returns a page of short URLs along with their statistics",
+		QueryParams: []apiParam{
+			{Name: "sort", Description: "created (the default) for the newest first, or visits for the most visited first"},
+			{Name: "order", Description: "asc to reverse the order"},
+			{Name: "limit", Description: "How many short URLs to return, at most 100 (default 20)", Type: "integer"},
+			{Name: "offset", Description: "How many short URLs to skip", Type: "integer"},
+		},
+		Status: http.StatusOK, Response: URLList{}, Security: readSecurity,
+	},
+	{
+		Method: "GET", Path: "/shorturl/stats/{code}", Tag: "URL Shortener",
+		Summary: "Returns how often and when a short URL has been visited",
+		PathParams: []apiParam{
+			{Name: "code", Description: "The short code", Required: true},
+		},
+		Status: http.StatusOK, Response: URLStats{}, Security: readSecurity,
+	},
+	{
+		Method: "GET", Path: "/shorturl/stats/{code}/clicks", Tag: "URL Shortener",
+		Summary: "Returns a short URL's clicks for each day, its top referrers, and the countries they came from",
+		PathParams: []apiParam{
+			{Name: "code", Description: "The short code", Required: true},
+		},
+		QueryParams: []apiParam{
+			{Name: "days", Description: "How many days to count, including today, from 1 to 365 (default 30)", Type: "integer"},
+		},
+		Status: http.StatusOK, Response: ClickStats{}, Security: readSecurity,
+	},
+	{
+		Method: "GET", Path: "/shorturl/stats/{code}/export.csv", Tag: "URL Shortener",
+		Summary: "Streams a short URL's clicks as CSV, one row for each click or for each day",
+		PathParams: []apiParam{
+			{Name: "code", Description: "The short code", Required: true},
+		},
+		QueryParams: []apiParam{
+			{Name: "type", Description: "clicks for a row for each click (default), or daily for each day's clicks and unique visitors"},
+			{Name: "days", Description: "How many days to export, including today, from 1 to 365 (default 30)", Type: "integer"},
+		},
+		Status: http.StatusOK, Response: "", ContentType: "text/csv", Security: readSecurity,
+	},
+	{
+		Method: "PUT", Path: "/shorturl/{code}", Tag: "URL Shortener",
+		Summary: "Points a short URL at a different URL, keeping its visit count",
+		PathParams: []apiParam{
+			{Name: "code", Description: "The short code", Required: true},
+		},
+		HeaderParams: []apiParam{
+			{Name: "If-Match", Description: "Only update the short URL if it is still at this version"},
+		},
+		FormParams: []apiParam{
+			{Name: "url", Description: "The new URL", Required: true},
+		},
+		Status: http.StatusOK, Response: URLExport{}, Security: writeSecurity,
+	},
+	{
+		Method: "PATCH", Path: "/shorturl/{code}", Tag: "URL Shortener",
+		Summary: "Points a short URL at a different URL, keeping its visit count",
+		PathParams: []apiParam{
+			{Name: "code", Description: "The short code", Required: true},
+		},
+		HeaderParams: []apiParam{
+			{Name: "If-Match", Description: "Only update the short URL if it is still at this version"},
+		},
+		FormParams: []apiParam{
+			{Name: "url", Description: "The new URL", Required: true},
+		},
+		Status: http.StatusOK, Response: URLExport{}, Security: writeSecurity,
+	},
+	{
+		Method: "DELETE", Path: "/shorturl/{code}", Tag: "URL Shortener",
+		Summary: "Deletes a short URL and returns what was stored about it",
+		PathParams: []apiParam{
+			{Name: "code", Description: "The short code", Required: true},
+		},
+		Status: http.StatusOK, Response: URLExport{}, Security: writeSecurity,
+	},
+	{
+		Method: "PUT", Path: "/shorturl/utm/{code}", Tag: "URL Shortener",
+		Summary: "Sets the campaign parameters added to the original URL's query string whenever a short URL is visited",
+		PathParams: []apiParam{
+			{Name: "code", Description: "The short code", Required: true},
+		},
+		FormParams: []apiParam{
+			{Name: "utm_source", Description: "The campaign's source, e.g. newsletter"},
+			{Name: "utm_medium", Description: "The campaign's medium, e.g. email"},
+			{Name: "utm_campaign", Description: "The campaign's name, e.g. spring_sale"},
+		},
+		Status: http.StatusOK, Response: LinkUTMParams{}, Security: writeSecurity,
+	},
+	{
+		Method: "DELETE", Path: "/shorturl/utm/{code}", Tag: "URL Shortener",
+		Summary: "Stops adding campaign parameters to visits to a short URL",
+		PathParams: []apiParam{
+			{Name: "code", Description: "The short code", Required: true},
+		},
+		Status: http.StatusNoContent, Security: writeSecurity,
+	},
+	{
+		Method: "GET", Path: "/shorturl/webhook/{code}", Tag: "URL Shortener",
+		Summary: "Returns the webhook that visits to a short URL are reported to, without its secret",
+		PathParams: []apiParam{
+			{Name: "code", Description: "The short code", Required: true},
+		},
+		Status: http.StatusOK, Response: LinkWebhook{}, Security: writeSecurity,
+	},
+	{
+		Method: "PUT", Path: "/shorturl/webhook/{code}", Tag: "URL Shortener",
+		Summary: "Reports visits to a short URL to a webhook, returning the secret that notifications are signed with",
+		PathParams: []apiParam{
+			{Name: "code", Description: "The short code", Required: true},
+		},
+		FormParams: []apiParam{
+			{Name: "url", Description: "The URL that notifications are POSTed to", Required: true},
+			{Name: "every", Description: "How many visits each notification reports, from 1 to 1000 (default 1)"},
+		},
+		Status: http.StatusOK, Response: LinkWebhook{}, Security: writeSecurity,
+	},
+	{
+		Method: "DELETE", Path: "/shorturl/webhook/{code}", Tag: "URL Shortener",
+		Summary: "Stops reporting visits to a short URL",
+		PathParams: []apiParam{
+			{Name: "code", Description: "The short code", Required: true},
+		},
+		Status: http.StatusNoContent, Security: writeSecurity,
+	},
+	{
+		Method: "GET", Path: "/exercise/users", Tag: "Exercise Tracker",
+		Summary: "Returns every user along with their exercise logs, oldest first, streamed as they are read",
+		Status:  http.StatusOK, Response: ExerciseUserList{}, Security: readSecurity,
+	},
+	{
+		Method: "GET", Path: "/exercise/users/search", Tag: "Exercise Tracker",
+		Summary: "Returns the users whose usernames start with or contain a search, without their logs, in order of username",
+		QueryParams: []apiParam{
+			{Name: "q", Description: "What to search usernames for, with case", Required: true},
+			{Name: "match", Description: "prefix to match usernames that start with q (default), or substring to match those that contain it"},
+			{Name: "limit", Description: "How many users to include, from 1 to 100 (default 20)", Type: "integer"},
+		},
+		Status: http.StatusOK, Response: UserSearchResults{}, Security: readSecurity,
+	},
+	{
+		Method: "GET", Path: "/exercise/leaderboard", Tag: "Exercise Tracker",
+		Summary: "Returns the users who logged the most exercise this week, this month, or of all time",
+		QueryParams: []apiParam{
+			{Name: "by", Description: "What to rank users by: minutes or exercises (default minutes)"},
+			{Name: "window", Description: "week, month, or all, where weeks start on Monday and months on the 1st in UTC (default week)"},
+			{Name: "limit", Description: "How many users to include, from 1 to 100 (default 10)", Type: "integer"},
+		},
+		Status: http.StatusOK, Response: Leaderboard{}, Security: readSecurity,
+	},
+	{
+		Method: "POST", Path: "/exercise/users", Tag: "Exercise Tracker",
+		Summary: "Creates a user, or returns the existing user with the same username, sent as form data or as a JSON object with the same fields",
+		FormParams: []apiParam{
+			{Name: "username", Description: "The new user's username", Required: true},
+		},
+		JSONBody: true, Status: http.StatusCreated, Response: ExerciseUser{}, Security: writeSecurity,
+	},
+	{
+		Method: "PATCH", Path: "/exercise/users/{id}", Tag: "Exercise Tracker",
+		Summary: "Changes the user's profile, leaving out the fields that aren't given",
+		PathParams: []apiParam{
+			{Name: "id", Description: "The user's ID", Required: true},
+		},
+		HeaderParams: []apiParam{
+			{Name: "If-Match", Description: "Only update the user if they are still at this version, e.g. the ETag of their log"},
+		},
+		FormParams: []apiParam{
+			{Name: "display_name", Description: "The name to show for the user, or empty to remove it"},
+			{Name: "timezone", Description: "The user's IANA time zone, e.g. America/New_York, or empty to remove it"},
+			{Name: "weekly_goal", Description: "How many minutes of exercise the user aims for each week, or 0 to remove the goal", Type: "integer"},
+		},
+		Status: http.StatusOK, Response: ExerciseUser{}, Security: writeSecurity,
+	},
+	{
+		Method: "PUT", Path: "/exercise/users/{id}/username", Tag: "Exercise Tracker",
+		Summary: "Changes the user's username, keeping their ID and log, or fails with a 409 if another user has it",
+		PathParams: []apiParam{
+			{Name: "id", Description: "The user's ID", Required: true},
+		},
+		HeaderParams: []apiParam{
+			{Name: "If-Match", Description: "Only update the user if they are still at this version, e.g. the ETag of their log"},
+		},
+		FormParams: []apiParam{
+			{Name: "username", Description: "The user's new username", Required: true},
+		},
+		JSONBody: true, Status: http.StatusOK, Response: ExerciseUser{}, Security: writeSecurity,
+	},
+	{
+		Method: "DELETE", Path: "/exercise/users/{id}", Tag: "Exercise Tracker",
+		Summary: "Deletes a user along with their exercise log, and returns what was deleted",
+		PathParams: []apiParam{
+			{Name: "id", Description: "The user's ID", Required: true},
+		},
+		HeaderParams: []apiParam{
+			{Name: "If-Match", Description: "Only delete the user if they are still at this version, e.g. the ETag of their log"},
+		},
+		Status: http.StatusOK, Response: ExerciseUserRecord{}, Security: writeSecurity,
+	},
+	{
+		Method: "POST", Path: "/exercise/users/{id}/exercises", Tag: "Exercise Tracker",
+		Summary: "Adds an exercise to the user's log, sent as form data or as a JSON object with the same fields",
+		PathParams: []apiParam{
+			{Name: "id", Description: "The user's ID", Required: true},
+		},
+		FormParams: []apiParam{
+			{Name: "description", Description: "What the exercise was", Required: true},
+			{Name: "duration", Description: "How long it took, in whole minutes or in unit, coming to a whole number of minutes up to a week", Required: true, Type: "number"},
+			{Name: "unit", Description: "The unit of duration: minutes, hours, or seconds (default minutes)"},
+			{Name: "date", Description: "When it happened, in YYYY-MM-DD format (default today)"},
+			{Name: "category", Description: "What kind of exercise it was, e.g. run, lift, or yoga, ignoring case"},
+			{Name: "tags", Description: "Comma-separated labels such as outdoors, which the log can be filtered by, ignoring case (at most 10)"},
+		},
+		JSONBody: true, Status: http.StatusCreated, Response: ExerciseAddedReceipt{}, Security: writeSecurity,
+	},
+	{
+		Method: "POST", Path: "/exercise/users/{id}/exercises/bulk", Tag: "Exercise Tracker",
+		Summary: "Adds a JSON array of exercises, each with the same fields as one added on its own, to the user's log and returns the result for each, in the same order",
+		PathParams: []apiParam{
+			{Name: "id", Description: "The user's ID", Required: true},
+		},
+		Status: http.StatusOK, Response: []BulkExerciseResult{}, Security: writeSecurity,
+	},
+	{
+		Method: "POST", Path: "/exercise/users/{id}/import", Tag: "Exercise Tracker",
+		Summary: "Adds the exercises in a CSV, GPX, or TCX file to the user's log, and reports those that couldn't be added",
+		PathParams: []apiParam{
+			{Name: "id", Description: "The user's ID", Required: true},
+		},
+		FormParams: []apiParam{
+			{Name: "file", Description: "A CSV file with a header row naming description, duration, and optionally date and category columns, or a GPX or TCX file from a fitness tracker", Required: true, Type: "file"},
+			{Name: "format", Description: "csv, gpx, or tcx (default from the file's extension, or else csv)"},
+		},
+		Multipart: true,
+		Status: http.StatusOK, Response: ImportReport{}, Security: writeSecurity,
+	},
+	{
+		Method: "PUT", Path: "/exercise/users/{id}/exercises/{exerciseId}", Tag: "Exercise Tracker",
+		Summary: "Replaces the description, duration, and date of an exercise in the user's log",
+		PathParams: []apiParam{
+			{Name: "id", Description: "The user's ID", Required: true},
+			{Name: "exerciseId", Description: "The exercise's ID, as given when it was added", Required: true},
+		},
+		FormParams: []apiParam{
+			{Name: "description", Description: "What the exercise was", Required: true},
+			{Name: "duration", Description: "How long it took, in whole minutes or in unit, coming to a whole number of minutes up to a week", Required: true, Type: "number"},
+			{Name: "unit", Description: "The unit of duration: minutes, hours, or seconds (default minutes)"},
+			{Name: "date", Description: "When it happened, in YYYY-MM-DD format (default today)"},
+		},
+		Status: http.StatusOK, Response: ExerciseRecord{}, Security: writeSecurity,
+	},
+	{
+		Method: "PATCH", Path: "/exercise/users/{id}/exercises/{exerciseId}", Tag: "Exercise Tracker",
+		Summary: "Changes only the given fields of an exercise in the user's log",
+		PathParams: []apiParam{
+			{Name: "id", Description: "The user's ID", Required: true},
+			{Name: "exerciseId", Description: "The exercise's ID, as given when it was added", Required: true},
+		},
+		FormParams: []apiParam{
+			{Name: "description", Description: "What the exercise was"},
+			{Name: "duration", Description: "How long it took, in whole minutes or in unit, coming to a whole number of minutes up to a week", Type: "number"},
+			{Name: "unit", Description: "The unit of duration: minutes, hours, or seconds (default minutes)"},
+			{Name: "date", Description: "When it happened, in YYYY-MM-DD format"},
+		},
+		Status: http.StatusOK, Response: ExerciseRecord{}, Security: writeSecurity,
+	},
+	{
+		Method: "DELETE", Path: "/exercise/users/{id}/exercises/{exerciseId}", Tag: "Exercise Tracker",
+		Summary: "Removes an exercise from the user's log",
+		PathParams: []apiParam{
+			{Name: "id", Description: "The user's ID", Required: true},
+			{Name: "exerciseId", Description: "The exercise's ID, as given when it was added", Required: true},
+		},
+		Status: http.StatusNoContent, Security: writeSecurity,
+	},
+	{
+		Method: "GET", Path: "/exercise/users/{id}/logs", Tag: "Exercise Tracker",
+		Summary: "Returns the user's exercise log",
+		PathParams: []apiParam{
+			{Name: "id", Description: "The user's ID", Required: true},
+		},
+		QueryParams: []apiParam{
+			{Name: "from", Description: "Only include exercises on or after this date (YYYY-MM-DD)"},
+			{Name: "to", Description: "Only include exercises on or before this date (YYYY-MM-DD)"},
+			{Name: "limit", Description: "The maximum number of exercises to include", Type: "integer"},
+			{Name: "category", Description: "Only include exercises of this category, ignoring case"},
+			{Name: "tags", Description: "Comma-separated tags, of which exercises must have every one to be included, ignoring case"},
+			{Name: "q", Description: "Only include exercises whose descriptions contain this, ignoring case, or match it as a regular expression if it is between slashes"},
+			{Name: "description", Description: "Another name for q"},
+			{Name: "offset", Description: "How many of the exercises that match to skip, for paging through them", Type: "integer"},
+			{Name: "skip", Description: "Another name for offset", Type: "integer"},
+			{Name: "sort", Description: "What to sort the log by: date or duration (default date, if the log is narrowed down)"},
+			{Name: "order", Description: "asc for the earliest or shortest exercise first (default), or desc for the latest or longest"},
+			{Name: "units", Description: "The unit to give durations in: minutes, hours, or seconds (default minutes)"},
+		},
+		Status: http.StatusOK, Response: ExerciseUserRecord{}, Security: readSecurity,
+	},
+	{
+		Method: "GET", Path: "/exercise/users/{id}/stats", Tag: "Exercise Tracker",
+		Summary: "Returns the totals of the user's exercise log and of each week in it, along with the user's streaks of days with exercises and how many exercises have each tag",
+		PathParams: []apiParam{
+			{Name: "id", Description: "The user's ID", Required: true},
+		},
+		QueryParams: []apiParam{
+			{Name: "tz", Description: "The IANA time zone that days and weeks are in, e.g. America/New_York (default UTC)"},
+		},
+		Status: http.StatusOK, Response: ExerciseStats{}, Security: readSecurity,
+	},
+	{
+		Method: "GET", Path: "/exercise/users/{id}/progress", Tag: "Exercise Tracker",
+		Summary: "Returns the minutes the user has exercised this week, and how far that is towards their weekly goal",
+		PathParams: []apiParam{
+			{Name: "id", Description: "The user's ID", Required: true},
+		},
+		QueryParams: []apiParam{
+			{Name: "tz", Description: "The IANA time zone that the week is in (default the user's, or else UTC)"},
+		},
+		Status: http.StatusOK, Response: ExerciseProgress{}, Security: readSecurity,
+	},
+	{
+		Method: "POST", Path: "/api/users", Tag: "Exercise Tracker",
+		Summary: "Creates a user, as freeCodeCamp's specification has it",
+		FormParams: []apiParam{
+			{Name: "username", Description: "The new user's username", Required: true},
+		},
+		JSONBody: true, Status: http.StatusOK, Response: ExerciseUser{}, Security: writeSecurity,
+	},
+	{
+		Method: "GET", Path: "/api/users", Tag: "Exercise Tracker",
+		Summary: "Returns the username and _id of every user, as freeCodeCamp's specification has it",
+		Status:  http.StatusOK, Response: []ExerciseUser{}, Security: readSecurity,
+	},
+	{
+		Method: "POST", Path: "/api/users/{_id}/exercises", Tag: "Exercise Tracker",
+		Summary: "Adds an exercise to the user's log and returns the user along with it, as freeCodeCamp's specification has it",
+		PathParams: []apiParam{
+			{Name: "_id", Description: "The user's ID", Required: true},
+		},
+		FormParams: []apiParam{
+			{Name: "description", Description: "What the exercise was", Required: true},
+			{Name: "duration", Description: "How long it took, in whole minutes or in unit, coming to a whole number of minutes up to a week", Required: true, Type: "number"},
+			{Name: "unit", Description: "The unit of duration: minutes, hours, or seconds (default minutes)"},
+			{Name: "date", Description: "When it happened, in YYYY-MM-DD format (default today)"},
+		},
+		JSONBody: true, Status: http.StatusOK, Response: FCCExerciseReceipt{}, Security: writeSecurity,
+	},
+	{
+		Method: "GET", Path: "/api/users/{_id}/logs", Tag: "Exercise Tracker",
+		Summary: "Returns the user's exercise log with its count and dates such as \"Mon Jan 01 1990\", as freeCodeCamp's specification has it",
+		PathParams: []apiParam{
+			{Name: "_id", Description: "The user's ID", Required: true},
+		},
+		QueryParams: []apiParam{
+			{Name: "from", Description: "Only include exercises on or after this date (YYYY-MM-DD)"},
+			{Name: "to", Description: "Only include exercises on or before this date (YYYY-MM-DD)"},
+			{Name: "limit", Description: "The maximum number of exercises to include", Type: "integer"},
+		},
+		Status: http.StatusOK, Response: FCCExerciseLog{}, Security: readSecurity,
+	},
+	{
+		Method: "GET", Path: "/healthz", Tag: "Operations",
+		Summary: "Reports whether the process is alive",
+		Status:  http.StatusOK, Response: HealthStatus{},
+	},
+	{
+		Method: "GET", Path: "/readyz", Tag: "Operations",
+		Summary: "Reports whether the database is reachable",
+		Status:  http.StatusOK, Response: HealthStatus{},
+	},
+	{
+		Method: "GET", Path: "/metrics", Tag: "Operations",
+		Summary: "Returns metrics in the Prometheus text exposition format",
+		Status:  http.StatusOK, Response: "", ContentType: "text/plain",
+	},
+	{
+		Method: "GET", Path: "/events", Tag: "Operations",
+		Summary: "Streams a summary of every request as server-sent events (only if EVENTS_ENABLED is true)",
+		Status:  http.StatusOK, Response: RequestEvent{}, ContentType: "text/event-stream",
+	},
+	{
+		Method: "POST", Path: "/auth/token", Tag: "Auth",
+		Summary: "Issues a bearer token to a client listed in JWT_CLIENTS (only if JWT_SECRET is set)",
+		FormParams: []apiParam{
+			{Name: "client_id", Description: "The client's ID, unless sent via basic auth", Required: true},
+			{Name: "client_secret", Description: "The client's secret, unless sent via basic auth", Required: true},
+		},
+		Status: http.StatusOK, Response: TokenResponse{},
+	},
+	{
+		Method: "GET", Path: "/admin/api/stats", Tag: "Admin",
+		Summary:  "Returns the number of short URLs and exercise users",
+		Status:   http.StatusOK, Response: AdminStats{}, Security: adminSecurity,
+	},
+	{
+		Method: "GET", Path: "/admin/api/errors", Tag: "Admin",
+		Summary:  "Returns the most recently logged errors, newest first",
+		Status:   http.StatusOK, Response: []LoggedError{}, Security: adminSecurity,
+	},
+	{
+		Method: "GET", Path: "/admin/api/routes", Tag: "Admin",
+		Summary:  "Returns the number of requests handled for each route since the app started",
+		Status:   http.StatusOK, Response: []RouteCount{}, Security: adminSecurity,
+	},
+	{
+		Method: "DELETE", Path: "/admin/api/urls/{code}", Tag: "Admin",
+		Summary:    "Deletes a short URL",
+		PathParams: []apiParam{{Name: "code", Description: "The short URL", Required: true}},
+		Status:     http.StatusNoContent, Security: adminSecurity,
+	},
+	{
+		Method: "PUT", Path: "/admin/api/urls/{code}/disabled", Tag: "Admin",
+		Summary:    "Disables a short URL, so that visits to it get a 410 explaining why instead of being redirected",
+		PathParams: []apiParam{{Name: "code", Description: "The short URL", Required: true}},
+		FormParams: []apiParam{
+			{Name: "reason", Description: "Why the short URL was disabled, which visitors are shown (default \"disabled by an admin\")"},
+		},
+		Status: http.StatusOK, Response: DisabledLink{}, Security: adminSecurity,
+	},
+	{
+		Method: "DELETE", Path: "/admin/api/urls/{code}/disabled", Tag: "Admin",
+		Summary:    "Lets a short URL that was disabled, by an admin or for having too many visits, redirect again",
+		PathParams: []apiParam{{Name: "code", Description: "The short URL", Required: true}},
+		Status:     http.StatusNoContent, Security: adminSecurity,
+	},
+	{
+		Method: "PUT", Path: "/admin/api/urls/{code}/visit-limit", Tag: "Admin",
+		Summary:    "Sets how many visits a minute a short URL may have before it is disabled, in place of the default",
+		PathParams: []apiParam{{Name: "code", Description: "The short URL", Required: true}},
+		FormParams: []apiParam{
+			{Name: "visits_per_minute", Description: "The most visits allowed in a minute", Required: true, Type: "integer"},
+		},
+		Status: http.StatusOK, Response: LinkVisitLimit{}, Security: adminSecurity,
+	},
+	{
+		Method: "DELETE", Path: "/admin/api/urls/{code}/visit-limit", Tag: "Admin",
+		Summary:    "Gives a short URL the default visit limit again",
+		PathParams: []apiParam{{Name: "code", Description: "The short URL", Required: true}},
+		Status:     http.StatusNoContent, Security: adminSecurity,
+	},
+	{
+		Method: "DELETE", Path: "/admin/api/users/{id}", Tag: "Admin",
+		Summary:    "Deletes an exercise user along with their log",
+		PathParams: []apiParam{{Name: "id", Description: "The user's ID", Required: true}},
+		Status:     http.StatusNoContent, Security: adminSecurity,
+	},
+	{
+		Method: "GET", Path: "/admin/api/keys", Tag: "Admin",
+		Summary:  "Returns every API key, without the keys themselves",
+		Status:   http.StatusOK, Response: []APIKey{}, Security: adminSecurity,
+	},
+	{
+		Method: "POST", Path: "/admin/api/keys", Tag: "Admin",
+		Summary:  "Creates an API key from a JSON body and returns it; the key can't be retrieved again",
+		Status:   http.StatusCreated, Response: NewAPIKey{}, Security: adminSecurity,
+	},
+	{
+		Method: "DELETE", Path: "/admin/api/keys/{id}", Tag: "Admin",
+		Summary:    "Revokes an API key",
+		PathParams: []apiParam{{Name: "id", Description: "The key's ID", Required: true}},
+		Status:     http.StatusNoContent, Security: adminSecurity,
+	},
+	{
+		Method: "GET", Path: "/admin/api/export/urls", Tag: "Admin",
+		Summary:  "Streams every short URL as newline-delimited JSON, for backups",
+		Status:   http.StatusOK, Response: URLExport{}, ContentType: "application/x-ndjson", Security: adminSecurity,
+	},
+	{
+		Method: "GET", Path: "/admin/api/export/users", Tag: "Admin",
+		Summary:  "Streams every exercise user along with their log as newline-delimited JSON, for backups",
+		Status:   http.StatusOK, Response: ExerciseUserExport{}, ContentType: "application/x-ndjson", Security: adminSecurity,
+	},
+	{
+		Method: "GET", Path: "/admin/api/export/clicks.csv", Tag: "Admin",
+		Summary:  "Streams the clicks on every short URL as CSV, one row for each click or for each short URL and day",
+		QueryParams: []apiParam{
+			{Name: "type", Description: "clicks for a row for each click (default), or daily for each day's clicks and unique visitors"},
+			{Name: "days", Description: "How many days to export, including today, from 1 to 365 (default 30)", Type: "integer"},
+		},
+		Status:   http.StatusOK, Response: "", ContentType: "text/csv", Security: adminSecurity,
+	},
+	{
+		Method: "POST", Path: "/admin/api/import/urls", Tag: "Admin",
+		Summary:     "Imports short URLs from an NDJSON export, replacing existing ones only if replace is true",
+		QueryParams: []apiParam{{Name: "replace", Description: "Whether to overwrite short URLs that already exist", Type: "boolean"}},
+		Status:      http.StatusOK, Response: ImportReport{}, Security: adminSecurity,
+	},
+	{
+		Method: "POST", Path: "/admin/api/import/users", Tag: "Admin",
+		Summary:     "Imports exercise users from an NDJSON export, replacing existing ones only if replace is true",
+		QueryParams: []apiParam{{Name: "replace", Description: "Whether to overwrite users that already exist", Type: "boolean"}},
+		Status:      http.StatusOK, Response: ImportReport{}, Security: adminSecurity,
+	},
+}
+
+var (
+	openAPIOnce     sync.Once
+	openAPIDocument map[string]any
+)
+
+
+// Serves the OpenAPI document describing every API endpoint.
+func getOpenAPIDocument(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("Request for OpenAPI document.")
+	openAPIOnce.Do(func() {
+		openAPIDocument = buildOpenAPIDocument(apiOperations)
+	})
+	writeJSON(w, http.StatusOK, openAPIDocument)
+}
+
+
+// Builds the OpenAPI document, deriving the response schemas from the Go structs.
+func buildOpenAPIDocument(operations []apiOperation) map[string]any {
+	schemas := map[string]any{}
+	paths := map[string]map[string]any{}
+
+	// Every error is returned in the same envelope
+	errorSchema := schemaFor(reflect.TypeOf(ErrorMessage{}), schemas)
+
+	for _, op := range operations {
+		operation := map[string]any{
+			"summary":     op.Summary,
+			"tags":        []string{op.Tag},
+			"operationId": operationID(op),
+		}
+
+		var parameters []map[string]any
+		for _, p := range op.PathParams {
+			parameters = append(parameters, parameterFor(p, "path"))
+		}
+		for _, p := range op.QueryParams {
+			parameters = append(parameters, parameterFor(p, "query"))
+		}
+		for _, p := range op.HeaderParams {
+			parameters = append(parameters, parameterFor(p, "header"))
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+
+		if len(op.FormParams) > 0 {
+			properties := map[string]any{}
+			var required []string
+			for _, p := range op.FormParams {
+				properties[p.Name] = paramSchema(p)
+				if p.Required {
+					required = append(required, p.Name)
+				}
+			}
+			contentType := "application/x-www-form-urlencoded"
+			if op.Multipart {
+				contentType = "multipart/form-data"
+			}
+			body := map[string]any{"type": "object", "properties": properties}
+			if len(required) > 0 {
+				body["required"] = required
+			}
+			content := map[string]any{contentType: map[string]any{"schema": body}}
+			if op.JSONBody {
+				content["application/json"] = map[string]any{"schema": body}
+			}
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content":  content,
+			}
+		}
+
+		success := map[string]any{"description": http.StatusText(op.Status)}
+		if op.Response != nil {
+			contentType := op.ContentType
+			if len(contentType) == 0 {
+				contentType = "application/json"
+			}
+			success["content"] = map[string]any{
+				contentType: map[string]any{"schema": schemaFor(reflect.TypeOf(op.Response), schemas)},
+			}
+		}
+		operation["responses"] = map[string]any{
+			strconv.Itoa(op.Status): success,
+			"default": map[string]any{
+				"description": "Error",
+				"content":     map[string]any{"application/json": map[string]any{"schema": errorSchema}},
+			},
+		}
+
+		if len(op.Security) > 0 {
+			var security []map[string][]string
+			for _, schemes := range op.Security {
+				requirement := map[string][]string{}
+				for _, scheme := range schemes {
+					requirement[scheme] = []string{}
+				}
+				security = append(security, requirement)
+			}
+			operation["security"] = security
+		}
+
+		if paths[op.Path] == nil {
+			paths[op.Path] = map[string]any{}
+		}
+		paths[op.Path][strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "freeCodeCamp Back End Projects in Pure Go",
+			"description": "The freeCodeCamp back-end microservices bundled into a single app.",
+			"version":     "1.0.0",
+		},
+		"paths":      paths,
+		"components": map[string]any{"schemas": schemas, "securitySchemes": apiSecuritySchemes},
+	}
+}
+
+
+// Returns a unique operation ID such as "getExerciseUsersIdLogs".
+func operationID(op apiOperation) string {
+	id := strings.ToLower(op.Method)
+	for _, part := range strings.FieldsFunc(op.Path, func(c rune) bool {
+		return c == '/' || c == '{' || c == '}' || c == '-' || c == '.'
+	}) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+
+func parameterFor(p apiParam, in string) map[string]any {
+	param := map[string]any{
+		"name":   p.Name,
+		"in":     in,
+		"schema": paramSchema(p),
+	}
+	if len(p.Description) > 0 {
+		param["description"] = p.Description
+	}
+	// Path parameters are always required
+	if p.Required || in == "path" {
+		param["required"] = true
+	}
+	return param
+}
+
+
+func paramSchema(p apiParam) map[string]any {
+	switch p.Type {
+	case "file":
+		return map[string]any{"type": "string", "format": "binary", "description": p.Description}
+	case "":
+		return map[string]any{"type": "string", "description": p.Description}
+	default:
+		return map[string]any{"type": p.Type, "description": p.Description}
+	}
+}
+
+
+// Returns the schema for a Go type. Named structs are added to the
+// component schemas and referred to by name.
+func schemaFor(t reflect.Type, schemas map[string]any) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	// Encoded as a hexadecimal string rather than as the bytes themselves
+	if t == reflect.TypeOf(primitive.ObjectID{}) {
+		return map[string]any{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case reflect.Struct:
+		name := t.Name()
+		if len(name) > 0 {
+			if _, exists := schemas[name]; !exists {
+				// Reserve the name first in case the struct refers to itself
+				schemas[name] = map[string]any{}
+				schemas[name] = structSchema(t, schemas)
+			}
+			return map[string]any{"$ref": "#/components/schemas/" + name}
+		}
+		return structSchema(t, schemas)
+	default:
+		return map[string]any{}
+	}
+}
+
+
+// Returns an object schema with a property for every JSON-encoded field of the struct.
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	properties := map[string]any{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		// Like encoding/json, promote the fields of untagged embedded structs
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && len(field.Tag.Get("json")) == 0 {
+			for name, property := range structSchema(field.Type, schemas)["properties"].(map[string]any) {
+				properties[name] = property
+			}
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+		// Fields that the type's MarshalJSON encodes as some other type, e.g. "string"
+		if schemaType := field.Tag.Get("openapi"); len(schemaType) > 0 {
+			properties[name] = map[string]any{"type": schemaType}
+			continue
+		}
+		properties[name] = schemaFor(field.Type, schemas)
+	}
+	return map[string]any{"type": "object", "properties": properties}
+}
+
+
+// A minimal page that renders the OpenAPI document with Swagger UI.
+const docsPage = `<!DOCTYPE html>
+<html>
+  <head>
+    <title>API Documentation</title>
+    <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css" />
+  </head>
+  <body>
+    <div id="swagger-ui"></div>
+    <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+    <script>
+      window.onload = () => {
+        window.ui = SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui" });
+      };
+    </script>
+  </body>
+</html>
+`
+
+
+// Serves a page for browsing the API documentation.
+func getDocsPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(docsPage))
+}
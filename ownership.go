@@ -0,0 +1,74 @@
+// Ties each short URL to the client that created it, so that other clients
+// can't change, delete, or see the analytics of links that aren't theirs.
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+var errNotOwner = newErrorMessage(http.StatusForbidden, "only the owner of this short url may do that")
+
+
+// Returns who the request was authenticated as, which is "key:" followed by the ID
+// of its API key if API keys are required, or "client:" followed by the subject of
+// its token otherwise, or "" if it wasn't authenticated at all.
+func ownerFrom(ctx context.Context) string {
+	if id := apiKeyIDFrom(ctx); len(id) > 0 {
+		return "key:" + id
+	}
+	if subject := subjectFrom(ctx); len(subject) > 0 {
+		return "client:" + subject
+	}
+	return ""
+}
+
+
+// Returns who made the request, as ownerFrom does. Routes that only read aren't
+// behind the token middleware, so a bearer token is checked here if one was sent.
+func requestOwner(r *http.Request) string {
+	if owner := ownerFrom(r.Context()); len(owner) > 0 || !jwtEnabled() {
+		return owner
+	}
+	token, ok := bearerToken(r.Header.Get("Authorization"))
+	if !ok {
+		return ""
+	}
+	claims, err := verifyToken(token, time.Now())
+	if err != nil {
+		return ""
+	}
+	return "client:" + claims.Subject
+}
+
+
+// Checks that the request may manage the short URL, i.e. that it was made by
+// the short URL's owner or an admin. Short URLs created without authentication
+// have no owner, so anyone who may write can manage them, as before.
+func authorizeOwner(r *http.Request, stored StoredURL) error {
+	if len(stored.Owner) == 0 || stored.Owner == requestOwner(r) || isAdminRequest(r) {
+		return nil
+	}
+	loggerFrom(r.Context()).Warn("Refused request for short URL owned by another client.",
+		"short_url", stored.ShortURL, "owner", stored.Owner)
+	return errNotOwner
+}
+
+
+// Looks up the short URL in the path and checks that the request may manage it.
+func authorizeShortURL(r *http.Request) (StoredURL, error) {
+	stored, err := urlStore.LookupURL(r.Context(), r.PathValue("code"))
+	if err != nil {
+		return StoredURL{}, err
+	}
+	return stored, authorizeOwner(r, stored)
+}
+
+
+// Narrows a listing of short URLs down to those that the request may see,
+// unless it was made by an admin.
+func restrictURLListQuery(r *http.Request, query *URLListQuery) {
+	query.AnyOwner = isAdminRequest(r)
+	query.Owner = requestOwner(r)
+}
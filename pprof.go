@@ -0,0 +1,32 @@
+// Optional profiling endpoints for diagnosing a running instance.
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"os"
+)
+
+// Serves the net/http/pprof handlers on the admin address in PPROF_ADDR,
+// e.g. "localhost:6060". Nothing is started if it is unset.
+// These are kept off the main port so that they are never exposed publicly by accident.
+func startPprofServer() {
+	addr := os.Getenv("PPROF_ADDR")
+	if len(addr) == 0 {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	go func() {
+		slog.Info("Starting pprof server.", "addr", addr)
+		err := http.ListenAndServe(addr, mux)
+		slog.Error("http.ListenAndServe failed", "func", "startPprofServer", "err", err)
+	}()
+}
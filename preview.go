@@ -0,0 +1,117 @@
+// Shows where a short URL leads without following it, so that a link
+// from someone unknown can be checked before it is visited.
+package main
+
+import (
+	"encoding/xml"
+	"html/template"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Where a short URL leads, as shown instead of redirecting.
+type LinkPreview struct {
+	XMLName     xml.Name   `json:"-" xml:"preview"`
+	ShortURL    string     `json:"short_url" xml:"short_url"`
+	OriginalURL string     `json:"original_url" xml:"original_url"`
+	// The host that the original URL is on, which is what to look at to tell if it is genuine
+	Host        string     `json:"host" xml:"host"`
+	CreatedAt   time.Time  `json:"created_at" xml:"created_at"`
+	// Missing if the short URL never expires
+	ExpiresAt   *time.Time `json:"expires_at,omitempty" xml:"expires_at,omitempty"`
+	// Missing if the URL wasn't probed, or couldn't be reached
+	ProbeStatus int        `json:"probe_status,omitempty" xml:"probe_status,omitempty"`
+}
+
+var previewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+  <head>
+    <title>Link preview | freeCodeCamp.org</title>
+    <meta name="robots" content="noindex" />
+    <link href="/shorturl/style.css" rel="stylesheet" type="text/css" />
+  </head>
+  <body>
+    <h1>Link preview</h1>
+    <main>
+      <section>
+        <p>This short link leads to <strong>{{.Host}}</strong>:</p>
+        <p><code>{{.OriginalURL}}</code></p>
+        {{if .ExpiresAt}}<p>It stops working on {{.ExpiresAt.Format "2 January 2006 at 15:04 MST"}}.</p>{{end}}
+        <p><a href="{{.OriginalURL}}" rel="noopener noreferrer nofollow">Continue to {{.Host}}</a></p>
+      </section>
+    </main>
+  </body>
+</html>
+`))
+
+
+// Reports whether the visitor asked to see where a short URL leads
+// instead of following it, with a "preview" query parameter such as "?preview=1".
+func previewRequested(r *http.Request) bool {
+	preview, err := strconv.ParseBool(r.URL.Query().Get("preview"))
+	return err == nil && preview
+}
+
+
+// Sends where the short URL leads without counting a visit: as a page if the
+// visitor is a browser, i.e. its Accept header names HTML, and as JSON or XML otherwise.
+func previewShortURL(w http.ResponseWriter, r *http.Request, shortURL string) {
+	logger := loggerFrom(r.Context())
+
+	stored, err := urlStore.LookupURL(r.Context(), shortURL)
+	if err != nil {
+		writeLinkError(w, r, err)
+		return
+	}
+	if urlBlocked(stored.OriginalURL) {
+		blockedURLsTotal.inc("preview")
+		logger.Warn("Refused to preview blocked URL.", "short_url", shortURL, "url", stored.OriginalURL)
+		writeLinkError(w, r, errBlockedLink)
+		return
+	}
+	if stored.Disabled != nil {
+		writeLinkError(w, r, disabledLinkError(stored.Disabled))
+		return
+	}
+
+	preview := LinkPreview{
+		ShortURL:    stored.ShortURL,
+		OriginalURL: withUTMParams(withScheme(stored.OriginalURL), stored.UTM),
+		CreatedAt:   stored.CreatedAt,
+		ProbeStatus: stored.ProbeStatus,
+	}
+	if urlObject, err := url.Parse(preview.OriginalURL); err == nil {
+		preview.Host = urlObject.Hostname()
+	}
+	if !stored.ExpiresAt.IsZero() {
+		preview.ExpiresAt = &stored.ExpiresAt
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	if !acceptsHTML(r) {
+		writeResponse(w, r, http.StatusOK, preview)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := previewPage.Execute(w, preview); err != nil {
+		slog.Error("Template.Execute failed", "func", "previewShortURL", "err", err)
+	}
+}
+
+
+// Reports whether the Accept header names HTML, as browsers' do.
+// Wildcards don't count, as API clients such as curl send "*/*".
+func acceptsHTML(r *http.Request) bool {
+	for _, mediaRange := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err == nil && mediaType == "text/html" && params["q"] != "0" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+// Renders QR codes for short URLs, so that they can be printed
+// and scanned to reach the redirect.
+package main
+
+import (
+	"fmt"
+	"github.com/skip2/go-qrcode"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The width and height of QR codes in pixels by default, at least, and at most
+const (
+	defaultQRCodeSize = 256
+	minQRCodeSize     = 64
+	maxQRCodeSize     = 1024
+)
+
+
+// Returns the absolute URL at which the short URL redirects, and whether it was made
+// from the request's own host. PUBLIC_BASE_URL, such as "https://short.example.com",
+// sets where the app can be reached from outside, which is needed behind a proxy.
+// Otherwise, the request's own host is used, which is whatever the client says it is.
+func shortURLLink(r *http.Request, shortURL string) (string, bool) {
+	base := strings.TrimSuffix(getEnv("PUBLIC_BASE_URL", ""), "/")
+	fromRequest := len(base) == 0
+	if fromRequest {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		base = scheme + "://" + r.Host
+	}
+	return base + "/shorturl/go/" + shortURL, fromRequest
+}
+
+
+// Sends a QR code for the redirect URL of the short URL in the path,
+// as a PNG or, if the "format" query parameter is "svg", as an SVG.
+// The "size" query parameter sets its width and height in pixels.
+func getShortURLQRCode(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFrom(r.Context())
+	funcName := "getShortURLQRCode"
+	shortURL := r.PathValue("code")
+	q := r.URL.Query()
+
+	size := defaultQRCodeSize
+	if value := q.Get("size"); len(value) > 0 {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < minQRCodeSize || n > maxQRCodeSize {
+			writeError(w, r, newErrorMessage(http.StatusBadRequest,
+				fmt.Sprintf("size must be between %d and %d", minQRCodeSize, maxQRCodeSize)))
+			return
+		}
+		size = n
+	}
+	format := q.Get("format")
+	if len(format) == 0 {
+		format = "png"
+	}
+	if format != "png" && format != "svg" {
+		writeError(w, r, newErrorMessage(http.StatusBadRequest, "format must be png or svg"))
+		return
+	}
+
+	// Only make codes for short URLs that work
+	if _, err := urlStore.LookupURL(r.Context(), shortURL); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	link, fromRequest := shortURLLink(r, shortURL)
+	code, err := qrcode.New(link, qrcode.Medium)
+	if err != nil {
+		logger.Error("qrcode.New failed", "func", funcName, "err", err)
+		writeError(w, r, newErrorMessage(http.StatusInternalServerError, "failed to make qr code"))
+		return
+	}
+
+	// A short URL always redirects from the same address, so the code never changes.
+	// Unless that address is configured, though, a client could put one of its own
+	// in the Host header, and shared caches mustn't hand that code to anyone else
+	if fromRequest {
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+	if format == "svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.WriteHeader(http.StatusOK)
+		writeQRCodeSVG(w, code.Bitmap(), size)
+		return
+	}
+	png, err := code.PNG(size)
+	if err != nil {
+		logger.Error("QRCode.PNG failed", "func", funcName, "err", err)
+		writeError(w, r, newErrorMessage(http.StatusInternalServerError, "failed to make qr code"))
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.WriteHeader(http.StatusOK)
+	w.Write(png)
+}
+
+
+// Writes a QR code as an SVG image of the given size, with one rectangle
+// for each run of dark modules in a row so that the file stays small.
+func writeQRCodeSVG(w http.ResponseWriter, bitmap [][]bool, size int) {
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, size, len(bitmap), len(bitmap))
+	fmt.Fprintf(w, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, len(bitmap), len(bitmap))
+	for y, row := range bitmap {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(w, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+	fmt.Fprint(w, `"/></svg>`)
+}
@@ -0,0 +1,50 @@
+// Tests whether QR codes for short URLs may be kept by shared caches.
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// A URL store in which every short URL exists.
+type fakeQRCodeURLStore struct {
+	URLStore
+}
+
+func (store fakeQRCodeURLStore) LookupURL(ctx context.Context, shortURL string) (StoredURL, error) {
+	return StoredURL{}, nil
+}
+
+
+func TestShortURLQRCodeCaching(t *testing.T) {
+	previous := urlStore
+	urlStore = fakeQRCodeURLStore{}
+	defer func() { urlStore = previous }()
+
+	tests := []struct {
+		name        string
+		baseURL     string
+		wantControl string
+	}{
+		{"configured base url", "https://short.example.com", "public, max-age=86400"},
+		{"host from the request", "", "private, max-age=86400"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("PUBLIC_BASE_URL", test.baseURL)
+			r := httptest.NewRequest(http.MethodGet, "/shorturl/qr/abc", nil)
+			r.Host = "evil.example.com"
+			r.SetPathValue("code", "abc")
+			w := httptest.NewRecorder()
+			getShortURLQRCode(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+			}
+			if got := w.Header().Get("Cache-Control"); got != test.wantControl {
+				t.Errorf("Cache-Control = %q, want %q", got, test.wantControl)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+// Configures where MongoDB reads are served from and how durable writes must be,
+// so that a replica set's secondaries can take the load of read-heavy endpoints.
+package main
+
+import (
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+)
+
+// The read concern levels that DB_READ_CONCERN accepts
+var readConcernLevels = []string{"local", "available", "majority", "linearizable", "snapshot"}
+
+
+// Returns a handle on the collection for read-heavy operations, such as redirects
+// and exercise logs, which reads with the preference in DB_READ_PREFERENCE.
+// Every other read stays on the primary, so that e.g. a new user can be found right away.
+// Reads from a secondary may lag behind, so a URL that was just shortened
+// can briefly fail to redirect.
+func forReads(collection *mongo.Collection) *mongo.Collection {
+	value := os.Getenv("DB_READ_PREFERENCE")
+	if len(value) == 0 {
+		return collection
+	}
+	mode, err := readpref.ModeFromString(value)
+	if err != nil {
+		slog.Warn("Invalid DB_READ_PREFERENCE, so reading from the primary.", "value", value)
+		return collection
+	}
+	pref, err := readpref.New(mode)
+	if err != nil {
+		slog.Warn("Invalid DB_READ_PREFERENCE, so reading from the primary.", "value", value, "err", err)
+		return collection
+	}
+	reads, err := collection.Clone(options.Collection().SetReadPreference(pref))
+	if err != nil {
+		slog.Warn("Unable to set read preference, so reading from the primary.", "err", err)
+		return collection
+	}
+	slog.Info("Using read preference for read-heavy operations.", "collection", collection.Name(), "mode", mode)
+	return reads
+}
+
+
+// Applies the write concern in DB_WRITE_CONCERN ("majority" or a number of nodes)
+// and the read concern in DB_READ_CONCERN to every operation.
+// Either one, when unset, is left to the URI or the server's default.
+func applyConcerns(clientOptions *options.ClientOptions) {
+	if value := os.Getenv("DB_WRITE_CONCERN"); len(value) > 0 {
+		if value == "majority" {
+			clientOptions.SetWriteConcern(writeconcern.New(writeconcern.WMajority()))
+		} else if w, err := strconv.Atoi(value); err == nil && w >= 0 {
+			clientOptions.SetWriteConcern(writeconcern.New(writeconcern.W(w)))
+		} else {
+			slog.Warn("Invalid DB_WRITE_CONCERN, so using the default.", "value", value)
+		}
+	}
+	if value := os.Getenv("DB_READ_CONCERN"); len(value) > 0 {
+		if slices.Contains(readConcernLevels, value) {
+			clientOptions.SetReadConcern(readconcern.New(readconcern.Level(value)))
+		} else {
+			slog.Warn("Invalid DB_READ_CONCERN, so using the default.", "value", value)
+		}
+	}
+}
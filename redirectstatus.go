@@ -0,0 +1,56 @@
+// Lets each short URL choose how it redirects, since a permanent redirect lets browsers
+// cache a stable destination, while one that may be edited needs a temporary redirect.
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+var errInvalidRedirectStatus = newErrorMessage(http.StatusBadRequest, "redirect_status must be 301, 302, or 307")
+
+// The status code of redirects from short URLs that don't have their own, set by initRedirectStatus
+var defaultRedirectStatus = http.StatusTemporaryRedirect
+
+
+// Reads the status code of redirects from short URLs that don't choose one
+// from SHORTURL_REDIRECT_STATUS (default 307).
+func initRedirectStatus() {
+	status := int(getEnvInt("SHORTURL_REDIRECT_STATUS", http.StatusTemporaryRedirect))
+	if !validRedirectStatus(status) {
+		slog.Warn("SHORTURL_REDIRECT_STATUS must be 301, 302, or 307, so using the default.", "value", status)
+		return
+	}
+	defaultRedirectStatus = status
+}
+
+
+// Reports whether short URLs may redirect with the status code.
+func validRedirectStatus(status int) bool {
+	return status == http.StatusMovedPermanently || status == http.StatusFound || status == http.StatusTemporaryRedirect
+}
+
+
+// Parses the status code that a new short URL should redirect with,
+// returning 0, meaning the default, if none was given.
+func parseRedirectStatus(value string) (int, error) {
+	if len(value) == 0 {
+		return 0, nil
+	}
+	status, err := strconv.Atoi(value)
+	if err != nil || !validRedirectStatus(status) {
+		return 0, errInvalidRedirectStatus
+	}
+	return status, nil
+}
+
+
+// Returns the status code that a short URL redirects with, given the one
+// that was stored for it, which is 0 if it uses the default.
+func redirectStatus(status int) int {
+	if status == 0 {
+		return defaultRedirectStatus
+	}
+	return status
+}
@@ -0,0 +1,258 @@
+// A minimal Redis client that speaks just enough of the RESP protocol
+// for caching, so that no third-party driver is needed.
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Returned by GET when the key doesn't exist.
+var errRedisNil = errors.New("redis: nil")
+
+// An error reply sent by the server, e.g. "WRONGTYPE ...".
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// A pool of connections to one Redis server.
+type redisClient struct {
+	addr     string
+	password string
+	username string
+	db       int
+	useTLS   bool
+	timeout  time.Duration
+	conns    chan *redisConn
+}
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+
+// Returns a client for a URL such as redis://:password@localhost:6379/0,
+// or rediss:// for TLS. At most poolSize connections are kept open when idle.
+func newRedisClient(rawURL string, poolSize int, timeout time.Duration) (*redisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, errors.New("redis url must start with redis:// or rediss://")
+	}
+	client := &redisClient{
+		addr:    u.Host,
+		useTLS:  u.Scheme == "rediss",
+		timeout: timeout,
+		conns:   make(chan *redisConn, poolSize),
+	}
+	if u.Port() == "" {
+		client.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		client.username = u.User.Username()
+		client.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); len(db) > 0 {
+		if client.db, err = strconv.Atoi(db); err != nil {
+			return nil, errors.New("invalid redis database number " + db)
+		}
+	}
+	return client, nil
+}
+
+
+// Opens a new connection, then authenticates and selects the database if needed.
+func (c *redisClient) dial(ctx context.Context) (*redisConn, error) {
+	dialer := &net.Dialer{Timeout: c.timeout}
+	var conn net.Conn
+	var err error
+	if c.useTLS {
+		host, _, _ := net.SplitHostPort(c.addr)
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", c.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", c.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{conn: conn, reader: bufio.NewReader(conn), writer: bufio.NewWriter(conn)}
+
+	if len(c.password) > 0 {
+		args := []string{"AUTH", c.password}
+		if len(c.username) > 0 {
+			args = []string{"AUTH", c.username, c.password}
+		}
+		if _, err := rc.do(args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := rc.do("SELECT", strconv.Itoa(c.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+
+// Sends a command and returns the server's reply, which is nil, a string,
+// an int64, or a []any of those. Error replies are returned as a redisError.
+func (c *redisClient) Do(ctx context.Context, args ...string) (any, error) {
+	var rc *redisConn
+	select {
+	case rc = <-c.conns:
+	default:
+		var err error
+		if rc, err = c.dial(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	deadline := time.Now().Add(c.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	rc.conn.SetDeadline(deadline)
+
+	reply, err := rc.do(args...)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		// The connection may be left in an unknown state, so don't reuse it
+		rc.conn.Close()
+		return nil, err
+	}
+
+	select {
+	case c.conns <- rc:
+	default:
+		rc.conn.Close()
+	}
+	return reply, err
+}
+
+
+// Returns the value of a key, or errRedisNil if it doesn't exist.
+func (c *redisClient) Get(ctx context.Context, key string) (string, error) {
+	reply, err := c.Do(ctx, "GET", key)
+	if err != nil {
+		return "", err
+	}
+	value, ok := reply.(string)
+	if !ok {
+		return "", errRedisNil
+	}
+	return value, nil
+}
+
+
+// Sets the value of a key that expires after the TTL.
+func (c *redisClient) SetEx(ctx context.Context, key string, value string, ttl time.Duration) error {
+	_, err := c.Do(ctx, "SET", key, value, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+
+// Deletes keys.
+func (c *redisClient) Del(ctx context.Context, keys ...string) error {
+	_, err := c.Do(ctx, append([]string{"DEL"}, keys...)...)
+	return err
+}
+
+
+// Closes every idle connection.
+func (c *redisClient) Close() {
+	for {
+		select {
+		case rc := <-c.conns:
+			rc.conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+
+// Writes a command as an array of bulk strings and reads the reply.
+func (rc *redisConn) do(args ...string) (any, error) {
+	fmt.Fprintf(rc.writer, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(rc.writer, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := rc.writer.Flush(); err != nil {
+		return nil, err
+	}
+	return rc.readReply()
+}
+
+
+func (rc *redisConn) readReply() (any, error) {
+	line, err := rc.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, errors.New("redis: malformed reply")
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rc.reader, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = rc.readReply(); err != nil {
+				var replyErr redisError
+				if !errors.As(err, &replyErr) {
+					return nil, err
+				}
+				items[i] = replyErr
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+	}
+}
@@ -0,0 +1,88 @@
+// Tags every request with an ID so that log entries can be traced back to it.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	loggerKey
+	apiKeyIDKey
+	subjectKey
+)
+
+
+// Assigns each request an ID, taken from its X-Request-ID header if it has
+// a reasonable one and generated otherwise. The ID is echoed back in the response
+// and attached to a logger stored in the request's context.
+func assignRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !isValidRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(withRequestID(r.Context(), id)))
+	})
+}
+
+
+// Returns a copy of the context that carries the request ID
+// and a logger that includes it in every entry.
+func withRequestID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey, id)
+	return context.WithValue(ctx, loggerKey, slog.Default().With("request_id", id))
+}
+
+
+// Returns a random 128-bit ID in hexadecimal.
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+
+// Reports whether an ID sent by the client is safe to use,
+// i.e. short and made of nothing but letters, digits, and dashes.
+func isValidRequestID(id string) bool {
+	if len(id) == 0 || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+
+// Returns a copy of the context whose logger adds the given attributes to every entry.
+func withLoggerAttrs(ctx context.Context, args ...any) context.Context {
+	return context.WithValue(ctx, loggerKey, loggerFrom(ctx).With(args...))
+}
+
+
+// Returns the ID of the request that the context belongs to, if any.
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+
+// Returns the logger for the request that the context belongs to,
+// or the default logger if the context isn't tied to a request.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,120 @@
+// Helpers for sending JSON responses and errors to the visitor.
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// An error to report to the visitor along with the HTTP status code
+// that goes with it, e.g. { "error": "invalid url", "code": 400 }
+type ErrorMessage struct {
+	XMLName xml.Name `json:"-" xml:"error"`
+	Content string   `json:"error" xml:"message"`
+	Code    int      `json:"code" xml:"code"`
+}
+
+func (e *ErrorMessage) Error() string {
+	return e.Content
+}
+
+
+// Returns an error that will be reported to the visitor with the given status code.
+func newErrorMessage(code int, content string) *ErrorMessage {
+	return &ErrorMessage{Content: content, Code: code}
+}
+
+
+// Encodes the value as JSON and sends it with the given status code.
+func writeJSON(w http.ResponseWriter, code int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		slog.Error("json.Encoder.Encode failed", "func", "writeJSON", "err", err)
+	}
+}
+
+
+// Sends the value as XML if the visitor's Accept header prefers it,
+// and as JSON otherwise.
+func writeResponse(w http.ResponseWriter, r *http.Request, code int, value any) {
+	if !prefersXML(r) {
+		writeJSON(w, code, value)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(value); err != nil {
+		slog.Error("xml.Encoder.Encode failed", "func", "writeResponse", "err", err)
+	}
+}
+
+
+// Reports whether the Accept header ranks XML above JSON.
+// JSON wins ties, and is the default when there is no Accept header.
+func prefersXML(r *http.Request) bool {
+	jsonQuality, xmlQuality := 0.0, 0.0
+	for _, mediaRange := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "application/json", "*/*", "application/*":
+			jsonQuality = max(jsonQuality, quality)
+		case "application/xml", "text/xml":
+			xmlQuality = max(xmlQuality, quality)
+		}
+	}
+	return xmlQuality > jsonQuality
+}
+
+
+// Sends the error to the visitor in the format they asked for.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	errMsg := toErrorMessage(err)
+	writeResponse(w, r, errMsg.Code, errMsg)
+}
+
+
+// Returns the message and status code to report for an error.
+// Errors from the stores get the status code that goes with their kind,
+// and errors that are neither those nor an *ErrorMessage are reported
+// as internal server errors without revealing their details.
+func toErrorMessage(err error) *ErrorMessage {
+	var errMsg *ErrorMessage
+	if errors.As(err, &errMsg) {
+		return errMsg
+	}
+	var storeErr *StoreError
+	if !errors.As(err, &storeErr) {
+		return newErrorMessage(http.StatusInternalServerError, "internal server error")
+	}
+	switch storeErr.Kind {
+	case ErrNotFound:
+		return newErrorMessage(http.StatusNotFound, storeErr.Message)
+	case ErrDuplicate:
+		return newErrorMessage(http.StatusConflict, storeErr.Message)
+	case ErrInvalidInput:
+		return newErrorMessage(http.StatusBadRequest, storeErr.Message)
+	case ErrConflict:
+		return newErrorMessage(http.StatusPreconditionFailed, storeErr.Message)
+	case ErrExpired, ErrDisabled:
+		return newErrorMessage(http.StatusGone, storeErr.Message)
+	default:
+		return newErrorMessage(http.StatusInternalServerError, storeErr.Message)
+	}
+}
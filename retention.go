@@ -0,0 +1,88 @@
+// Deletes old data in the background according to a retention policy,
+// so that small databases, such as free-tier clusters, don't fill up.
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+var (
+	retentionRecordsTotal = newCounterVec("retention_records_total",
+		"Total number of records deleted by the retention job, or that would have been in a dry run.", "kind", "action")
+	retentionRunsTotal = newCounterVec("retention_runs_total",
+		"Total number of runs of the retention job.", "outcome")
+)
+
+// Closed to stop the retention job at shutdown
+var stopRetention = make(chan struct{})
+
+// How long a single run of the retention job may take
+const retentionTimeout = 5 * time.Minute
+
+
+// Starts deleting short URLs that haven't been visited for RETENTION_URL_UNVISITED_DAYS
+// and exercises older than RETENTION_EXERCISE_DAYS, every RETENTION_INTERVAL (default 1h).
+// Either policy is disabled when its number of days is 0, which is the default.
+// If RETENTION_DRY_RUN is true, what would be deleted is only logged and counted.
+func startRetentionJob() {
+	urlDays := getEnvInt("RETENTION_URL_UNVISITED_DAYS", 0)
+	exerciseDays := getEnvInt("RETENTION_EXERCISE_DAYS", 0)
+	if urlDays <= 0 && exerciseDays <= 0 {
+		return
+	}
+	interval := getEnvDuration("RETENTION_INTERVAL", time.Hour)
+	dryRun := getEnvBool("RETENTION_DRY_RUN", false)
+	slog.Info("Enforcing retention policy.", "url_unvisited_days", urlDays, "exercise_days", exerciseDays,
+		"interval", interval, "dry_run", dryRun)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-stopRetention:
+				return
+			}
+			if storageAvailable() {
+				enforceRetention(urlDays, exerciseDays, dryRun)
+			}
+		}
+	}()
+}
+
+
+// Runs the retention policy once.
+func enforceRetention(urlDays int64, exerciseDays int64, dryRun bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), retentionTimeout)
+	defer cancel()
+	action := "deleted"
+	if dryRun {
+		action = "would_delete"
+	}
+	outcome := "success"
+
+	if urlDays > 0 {
+		cutoff := time.Now().AddDate(0, 0, -int(urlDays))
+		pruned, err := urlStore.PruneURLs(ctx, cutoff, dryRun)
+		if err != nil {
+			outcome = "error"
+		} else {
+			retentionRecordsTotal.add(float64(len(pruned)), "urls", action)
+			slog.Info("Pruned unvisited short URLs.", "cutoff", cutoff, "count", len(pruned), "dry_run", dryRun)
+		}
+	}
+	if exerciseDays > 0 {
+		cutoff := time.Now().AddDate(0, 0, -int(exerciseDays))
+		count, err := exerciseStore.PruneExercises(ctx, cutoff, dryRun)
+		if err != nil {
+			outcome = "error"
+		} else {
+			retentionRecordsTotal.add(float64(count), "exercises", action)
+			slog.Info("Pruned old exercises.", "cutoff", cutoff, "count", count, "dry_run", dryRun)
+		}
+	}
+	retentionRunsTotal.inc(outcome)
+}
@@ -0,0 +1,106 @@
+// Retries MongoDB operations that fail for transient reasons, such as
+// a replica set failover, so that they don't reach clients as errors.
+package main
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"math/rand/v2"
+	"time"
+)
+
+// Server error codes that mean the command was rejected without being applied
+// because the node isn't, or is no longer, the primary.
+var notPrimaryErrorCodes = []int{
+	91,    // ShutdownInProgress
+	189,   // PrimarySteppedDown
+	10107, // NotWritablePrimary
+	11600, // InterruptedAtShutdown
+	11602, // InterruptedDueToReplStateChange
+	13435, // NotPrimaryNoSecondaryOk
+	13436, // NotPrimaryOrSecondary
+}
+
+// Server error codes for failures between the server and the rest of the cluster.
+var transientErrorCodes = []int{
+	6,    // HostUnreachable
+	7,    // HostNotFound
+	89,   // NetworkTimeout
+	9001, // SocketException
+}
+
+const maxRetryBackoff = 2 * time.Second
+
+
+// Reports whether the error means the command was certainly not applied,
+// so that even an operation that isn't idempotent can be retried.
+func isNotPrimaryError(err error) bool {
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+	for _, code := range notPrimaryErrorCodes {
+		if serverErr.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
+
+
+// Reports whether the error is likely to go away if the operation is tried again.
+// Errors such as duplicate keys or missing documents are permanent.
+func isTransientError(err error) bool {
+	if isNotPrimaryError(err) || mongo.IsNetworkError(err) {
+		return true
+	}
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+	if serverErr.HasErrorLabel("RetryableWriteError") || serverErr.HasErrorLabel("TransientTransactionError") {
+		return true
+	}
+	for _, code := range transientErrorCodes {
+		if serverErr.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
+
+
+// Runs a MongoDB operation, retrying it with exponential backoff and full jitter
+// up to DB_RETRY_ATTEMPTS times in total (default 3) while it fails transiently.
+// An operation that isn't idempotent, e.g. an $inc, may already have been applied
+// when a network error occurs, so it is only retried when the server rejected it.
+// Operations that are part of a transaction aren't retried on their own.
+func retryDB(ctx context.Context, funcName string, idempotent bool, op func() error) error {
+	// Inside a transaction, the transaction as a whole is retried instead
+	if mongo.SessionFromContext(ctx) != nil {
+		return op()
+	}
+	attempts := getEnvInt("DB_RETRY_ATTEMPTS", 3)
+	backoff := getEnvDuration("DB_RETRY_INITIAL_BACKOFF", 100*time.Millisecond)
+
+	for attempt := int64(1); ; attempt++ {
+		err := op()
+		if err == nil || attempt >= attempts || ctx.Err() != nil {
+			return err
+		}
+		if !isNotPrimaryError(err) && !(idempotent && isTransientError(err)) {
+			return err
+		}
+
+		wait := rand.N(backoff) + 1
+		loggerFrom(ctx).Warn("Transient database error, so retrying.",
+			"func", funcName, "attempt", attempt, "retry_in", wait, "err", err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+}
@@ -5,97 +5,274 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
-    "go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
-	"log"
+	"log/slog"
+	"mime"
 	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 )
 
-type ErrorMessage struct {
-	Content string `json:"error"`
-}
-
 type WhoamiStruct struct {
-	IpAddress string `json:"ipaddress"`
-	Language  string `json:"language"`
-	UserAgent string `json:"software"`
+	XMLName   xml.Name `json:"-" xml:"whoami"`
+	IpAddress string   `json:"ipaddress" xml:"ipaddress"`
+	Language  string   `json:"language" xml:"language"`
+	UserAgent string   `json:"software" xml:"software"`
 }
 
 type DateStruct struct {
-	UNIXDate int64  `json:"unix"`
-	UTCDate  string `json:"utc"`
+	XMLName  xml.Name `json:"-" xml:"date"`
+	UNIXDate int64    `json:"unix" xml:"unix"`
+	UTCDate  string   `json:"utc" xml:"utc"`
 }
 
+// The largest file that can be uploaded to the file metadata API
+var maxUploadSize int64
+
 type FileMetadataStruct struct {
-	Name string `json:"name"`
-	Type string `json:"type"`
-	Size int64 `json:"size"`
+	XMLName xml.Name `json:"-" xml:"file"`
+	Name    string   `json:"name" xml:"name"`
+	Type    string   `json:"type" xml:"type"`
+	Size    int64    `json:"size" xml:"size"`
 }
 
-var mongoClient *mongo.Client
-
-
 func init() {
 	loadEnvVars()
-	var err error
-	mongoClient, err = mongo.Connect(context.TODO(), options.Client().ApplyURI(os.Getenv("DB_URI")))
-	if err != nil {
-		log.Fatal("Error when connecting to MongoDB: %s\n", err)
-	}
-	initURLCollection()
-	initExerciseCollection()
+	initLogger()
+	initTrustedProxies()
+	initJWTAuth()
 }
 
 
 func main() {
+	// Connect to the database, possibly in the background
+	initStorage()
+
 	mux := http.NewServeMux()
 
 	fs := http.FileServer(http.Dir("./static"))
 	//mux.Handle("/static/", http.StripPrefix("/static", fs))
-	mux.Handle("/", fs)
+	mux.Handle("GET /", chain(fs, newStaticCacheMiddleware("./static")))
 
 	// Simple APIs that only return JSON
-	mux.HandleFunc("/request/", getRequestInfo)
-	mux.HandleFunc("/whoami/", getVisitorInfo)
-	mux.HandleFunc("/hello/", sendJSONGreeting)
-	mux.HandleFunc("/date/", getDate)
+	mux.HandleFunc("GET /request/", getRequestInfo)
+	mux.HandleFunc("POST /request/", getRequestInfo)
+	mux.HandleFunc("GET /whoami/", getVisitorInfo)
+	mux.HandleFunc("GET /hello/", sendJSONGreeting)
+	mux.HandleFunc("GET /date/", getDate)
+	mux.HandleFunc("GET /date/{date}", getDate)
 
 	// File metadata API
-	mux.HandleFunc("/file/analyze/", getFileMetadata)
+	mux.HandleFunc("POST /file/analyze", getFileMetadata)
+
+	// Tokens for the endpoints that write to the database, if enabled
+	requireToken := newJWTMiddleware()
+	if requireToken != nil {
+		mux.HandleFunc("POST /auth/token", postAuthToken)
+	}
+
+	// API keys with per-key quotas, if required
+	requireKey := newAPIKeyMiddleware()
 
 	// URL shortener API
-	mux.HandleFunc("/shorturl/new/", createShortURL)
-	mux.HandleFunc("/shorturl/go/", openShortURL)
+	initExerciseUsers()
+	initLeaderboard()
+	initURLValidation()
+	initURLProbe()
+	initURLBlocklist()
+	initClickAnalytics()
+	initBulkURLs()
+	initBulkExercises()
+	initRedirectStatus()
+	initWebhooks()
+	initAbuseDetection()
+	creationQuota := newCreationQuotaMiddleware()
+	handleWith(mux, "POST /shorturl/new", createShortURL, requireToken, requireDB, requireKey(scopeShortURL), creationQuota)
+	handleWith(mux, "POST /shorturl/bulk", postBulkShortURLs, requireToken, requireDB, requireKey(scopeShortURL))
+	handleWith(mux, "GET /shorturl/go/{code}", openShortURL, requireDB)
+	// Without a code there is nothing to look up, so the database isn't needed
+	handleWith(mux, "GET /shorturl/go/{$}", openShortURL)
+	handleWith(mux, "GET /shorturl/qr/{code}", getShortURLQRCode, requireDB)
+	handleWith(mux, "GET /shorturl/list", getShortURLList, requireDB, requireKey(scopeShortURL))
+	handleWith(mux, "GET /shorturl/stats/{code}", getShortURLStats, requireDB, requireKey(scopeShortURL))
+	handleWith(mux, "GET /shorturl/stats/{code}/clicks", getShortURLClicks, requireDB, requireKey(scopeShortURL))
+	handleWith(mux, "GET /shorturl/stats/{code}/export.csv", getShortURLClickExport, requireDB, requireKey(scopeShortURL))
+	handleWith(mux, "PUT /shorturl/{code}", updateShortURL, requireToken, requireDB, requireKey(scopeShortURL))
+	handleWith(mux, "PATCH /shorturl/{code}", updateShortURL, requireToken, requireDB, requireKey(scopeShortURL))
+	handleWith(mux, "DELETE /shorturl/{code}", deleteShortURL, requireToken, requireDB, requireKey(scopeShortURL))
+	handleWith(mux, "PUT /shorturl/utm/{code}", putShortURLUTM, requireToken, requireDB, requireKey(scopeShortURL))
+	handleWith(mux, "DELETE /shorturl/utm/{code}", deleteShortURLUTM, requireToken, requireDB, requireKey(scopeShortURL))
+	if webhooks != nil {
+		handleWith(mux, "GET /shorturl/webhook/{code}", getShortURLWebhook, requireToken, requireDB, requireKey(scopeShortURL))
+		handleWith(mux, "PUT /shorturl/webhook/{code}", putShortURLWebhook, requireToken, requireDB, requireKey(scopeShortURL))
+		handleWith(mux, "DELETE /shorturl/webhook/{code}", deleteShortURLWebhook, requireToken, requireDB, requireKey(scopeShortURL))
+	}
 
 	// Exercise tracker API
-	mux.HandleFunc("/exercise/users/", handleExerciseUsersPath)
+	handleWith(mux, "GET /exercise/users", getExerciseUsers, requireDB, requireKey(scopeExercise))
+	handleWith(mux, "GET /exercise/users/search", searchExerciseUsers, requireDB, requireKey(scopeExercise))
+	handleWith(mux, "GET /exercise/leaderboard", getExerciseLeaderboard, requireDB, requireKey(scopeExercise))
+	handleWith(mux, "POST /exercise/users", postExerciseUser, requireToken, requireDB, requireKey(scopeExercise))
+	handleWith(mux, "POST /exercise/users/{id}/exercises", postExercise, requireToken, requireDB, requireKey(scopeExercise))
+	handleWith(mux, "POST /exercise/users/{id}/exercises/bulk", postBulkExercises, requireToken, requireDB, requireKey(scopeExercise))
+	handleWith(mux, "POST /exercise/users/{id}/import", postExerciseImport, requireToken, requireDB, requireKey(scopeExercise))
+	handleWith(mux, "GET /exercise/users/{id}/logs", getExerciseLog, requireDB, requireKey(scopeExercise))
+	handleWith(mux, "GET /exercise/users/{id}/stats", getExerciseStats, requireDB, requireKey(scopeExercise))
+	handleWith(mux, "GET /exercise/users/{id}/progress", getExerciseProgress, requireDB, requireKey(scopeExercise))
+	handleWith(mux, "DELETE /exercise/users/{id}", deleteExerciseUser, requireToken, requireDB, requireKey(scopeExercise))
+	handleWith(mux, "PATCH /exercise/users/{id}", patchExerciseUser, requireToken, requireDB, requireKey(scopeExercise))
+	handleWith(mux, "PUT /exercise/users/{id}/username", putExerciseUsername, requireToken, requireDB, requireKey(scopeExercise))
+	handleWith(mux, "PUT /exercise/users/{id}/exercises/{exerciseId}", updateExercise, requireToken, requireDB, requireKey(scopeExercise))
+	handleWith(mux, "PATCH /exercise/users/{id}/exercises/{exerciseId}", updateExercise, requireToken, requireDB, requireKey(scopeExercise))
+	handleWith(mux, "DELETE /exercise/users/{id}/exercises/{exerciseId}", deleteExercise, requireToken, requireDB, requireKey(scopeExercise))
+
+	// The same, with the routes and responses in freeCodeCamp's specification
+	handleWith(mux, "POST /api/users", postFCCUser, requireToken, requireDB, requireKey(scopeExercise))
+	handleWith(mux, "GET /api/users", getFCCUsers, requireDB, requireKey(scopeExercise))
+	handleWith(mux, "POST /api/users/{_id}/exercises", postFCCExercise, requireToken, requireDB, requireKey(scopeExercise))
+	handleWith(mux, "GET /api/users/{_id}/logs", getFCCExerciseLog, requireDB, requireKey(scopeExercise))
+
+	// Prometheus metrics
+	mux.HandleFunc("GET /metrics", serveMetrics)
+
+	// Liveness and readiness checks
+	mux.HandleFunc("GET /healthz", getHealth)
+	mux.HandleFunc("GET /readyz", getReadiness)
+
+	// Live feed of request activity, if enabled
+	eventsMiddleware := newEventsMiddleware()
+	if eventsMiddleware != nil {
+		mux.HandleFunc("GET /events", getEvents)
+	}
 
-	// Ensure that the program closes the database connection when shutting down
-	defer func() {
-		log.Printf("Closing connection to MongoDB.\n")
-		err := mongoClient.Disconnect(context.TODO())
-		if err != nil {
-			log.Printf("error when disconnecting from MongoDB: %s\n", err)
+	// Admin API, if credentials were configured
+	if adminAuth := newAdminAuthMiddleware(); adminAuth != nil {
+		handleWith(mux, "GET /admin/api/stats", getAdminStats, adminAuth, requireDB)
+		handleWith(mux, "GET /admin/api/errors", getAdminErrors, adminAuth)
+		handleWith(mux, "GET /admin/api/routes", getAdminRoutes, adminAuth)
+		handleWith(mux, "DELETE /admin/api/urls/{code}", deleteAdminURL, adminAuth, requireDB)
+		handleWith(mux, "PUT /admin/api/urls/{code}/disabled", putAdminURLDisabled, adminAuth, requireDB)
+		handleWith(mux, "DELETE /admin/api/urls/{code}/disabled", deleteAdminURLDisabled, adminAuth, requireDB)
+		handleWith(mux, "PUT /admin/api/urls/{code}/visit-limit", putAdminURLVisitLimit, adminAuth, requireDB)
+		handleWith(mux, "DELETE /admin/api/urls/{code}/visit-limit", deleteAdminURLVisitLimit, adminAuth, requireDB)
+		handleWith(mux, "DELETE /admin/api/users/{id}", deleteAdminUser, adminAuth, requireDB)
+		handleWith(mux, "GET /admin/api/keys", getAdminAPIKeys, adminAuth, requireDB)
+		handleWith(mux, "POST /admin/api/keys", postAdminAPIKey, adminAuth, requireDB)
+		handleWith(mux, "DELETE /admin/api/keys/{id}", deleteAdminAPIKey, adminAuth, requireDB)
+		handleWith(mux, "GET /admin/api/export/urls", getAdminURLExport, adminAuth, requireDB)
+		handleWith(mux, "GET /admin/api/export/users", getAdminUserExport, adminAuth, requireDB)
+		handleWith(mux, "GET /admin/api/export/clicks.csv", getAdminClickExport, adminAuth, requireDB)
+		handleWith(mux, "POST /admin/api/import/urls", postAdminURLImport, adminAuth, requireDB)
+		handleWith(mux, "POST /admin/api/import/users", postAdminUserImport, adminAuth, requireDB)
+	}
+
+	// API documentation
+	mux.HandleFunc("GET /openapi.json", getOpenAPIDocument)
+	mux.HandleFunc("GET /docs", getDocsPage)
+
+	// Middleware applied to every request
+	middlewares := []Middleware{assignRequestID, logRequests, newMetricsMiddleware(mux)}
+	if accessLog := newAccessLogMiddleware(); accessLog != nil {
+		middlewares = append(middlewares, accessLog)
+	}
+	if eventsMiddleware != nil {
+		middlewares = append(middlewares, eventsMiddleware)
+	}
+	middlewares = append(middlewares, recoverPanics, newCORSMiddleware(), compressResponses)
+	maxUploadSize = getEnvInt("MAX_UPLOAD_BYTES", 1<<20)
+	maxImportSize = getEnvInt("MAX_IMPORT_BYTES", 64<<20)
+	middlewares = append(middlewares, newBodyLimitMiddleware(mux, map[string]int64{
+		// Leave some room for the multipart encoding around the file
+		"POST /file/analyze": maxUploadSize + 4<<10,
+		"POST /exercise/users/{id}/import": maxUploadSize + 4<<10,
+		"POST /admin/api/import/urls": maxImportSize,
+		"POST /admin/api/import/users": maxImportSize,
+	}))
+
+	// Obtain certificates automatically if domains were provided,
+	// or else serve over HTTPS if a certificate and key were provided
+	manager := getACMEManager()
+	certFile, keyFile, haveTLSFiles := getTLSFiles()
+	useTLS := manager != nil || haveTLSFiles
+	if useTLS {
+		if hsts := newHSTSMiddleware(); hsts != nil {
+			middlewares = append([]Middleware{hsts}, middlewares...)
+		}
+	}
+	handler := chain(mux, middlewares...)
+
+	// Profiling endpoints on a separate admin port, if enabled
+	startPprofServer()
+
+	host := getEnv("HOST", "localhost")
+	port := getEnv("PORT", "8000")
+	if manager != nil {
+		port = getEnv("PORT", "443")
+	}
+	server := newHTTPServer(net.JoinHostPort(host, port), handler)
+	// Event streams never finish on their own, so end them when shutting down
+	server.RegisterOnShutdown(requestEvents.closeAll)
+
+	// Listen on a Unix socket or TCP port
+	listener, err := newListener(server.Addr)
+	if err != nil {
+		fatal("Error when creating listener.", "err", err)
+	}
+
+	var servers []managedServer
+	if !useTLS {
+		slog.Info("Starting app.", "addr", listener.Addr())
+		servers = append(servers, managedHTTPServer("app", server, func() error {
+			return server.Serve(listener)
+		}))
+	} else {
+		if manager != nil {
+			useACMECertificates(server, manager)
+			certFile, keyFile = "", ""
 		}
-	}()
+		slog.Info("Starting app with TLS.", "addr", listener.Addr())
+		servers = append(servers, managedHTTPServer("app", server, func() error {
+			return server.ServeTLS(listener, certFile, keyFile)
+		}))
+		if redirectServer := newRedirectServer(host, port, manager); redirectServer != nil {
+			servers = append(servers, managedHTTPServer("redirect", redirectServer, redirectServer.ListenAndServe))
+		}
+	}
+
+	// gRPC API on a second port, if enabled
+	grpcServer, grpcListener, err := newGRPCServer(manager)
+	if err != nil {
+		fatal("Error when creating gRPC server.", "err", err)
+	}
+	if grpcServer != nil {
+		slog.Info("Starting gRPC server.", "addr", grpcListener.Addr())
+		servers = append(servers, managedServer{
+			name:     "grpc",
+			serve:    func() error { return grpcServer.Serve(grpcListener) },
+			shutdown: func(ctx context.Context) error { return stopGRPCServer(ctx, grpcServer) },
+		})
+	}
+	err = runUntilShutdown(servers...)
 
-	port := "8000"
-	log.Printf("Starting app on port %s.\n", port)
-	err := http.ListenAndServe("localhost:" + port, mux)
-	log.Fatal(err)
+	// Close the database connection only once every request has finished with it
+	closeWebhooks()
+	closeAbuseDetection()
+	closeStorage()
+	if err != nil {
+		os.Exit(1)
+	}
 }
 
 
 // Prints everything in the HTTP request object.
 func getRequestInfo(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Request for HTTP request object headers.\n")
+	logger := loggerFrom(r.Context())
+	logger.Debug("Request for HTTP request object headers.")
 	//w.Header().Set("Content-Type", "application/json")
 	//w.WriteHeader(http.StatusCreated)
 
@@ -110,7 +287,7 @@ func getRequestInfo(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "RemoteAddr: %q\n", r.RemoteAddr)
 
 	if err := r.ParseForm(); err != nil {
-		log.Print(err)
+		logger.Error("Request.ParseForm failed", "func", "getRequestInfo", "err", err)
 	}
 	fmt.Fprintf(w, "\nFORM VALUES\n")
 	for key, value := range r.Form {
@@ -123,33 +300,26 @@ func getRequestInfo(w http.ResponseWriter, r *http.Request) {
 
 // Responds with a simple greeting in JSON format.
 func sendJSONGreeting(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Request for JSON greeting.\n")
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	fmt.Fprintf(w, `{"greeting":"Hello, world!"}`)
+	loggerFrom(r.Context()).Debug("Request for JSON greeting.")
+	writeJSON(w, http.StatusOK, map[string]string{"greeting": "Hello, world!"})
 }
 
 
 // Returns a JSON object containing the visitor's
 // IP address, accept-language, and user-agent
 func getVisitorInfo(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Request for visitor's info.\n")
+	logger := loggerFrom(r.Context())
+	logger.Debug("Request for visitor's info.")
 
 	// Extract all relevant info from the request object
-	ipAddr, _, _ := net.SplitHostPort(r.RemoteAddr)
 	var response WhoamiStruct
-	response.IpAddress = ipAddr
+	response.IpAddress = clientIP(r)
 	response.Language = r.Header.Get("Accept-Language")
 	response.UserAgent = r.Header.Get("User-Agent")
-	fmt.Printf("%+v\n", response)
+	logger.Debug("Visitor info.", "response", response)
 
-	// Encode it in JSON and send it back to the user
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	err := json.NewEncoder(w).Encode(response)
-	if err != nil {
-		log.Printf("Error in getVisitorInfo when encoding JSON: %s\n", err)
-	}
+	// Encode it in JSON or XML and send it back to the user
+	writeResponse(w, r, http.StatusOK, response)
 }
 
 
@@ -159,10 +329,11 @@ func getVisitorInfo(w http.ResponseWriter, r *http.Request) {
 // { "unix": 1451001600000,
 //    "utc": "Fri, 25 Dec 2015 00:00:00 GMT" }
 func getDate(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Request for the time in JSON.\n")
+	logger := loggerFrom(r.Context())
+	logger.Debug("Request for the time in JSON.")
 	funcName := "getDate"
 
-	dateParam := strings.TrimPrefix(r.URL.Path, "/date/")
+	dateParam := r.PathValue("date")
 	var response DateStruct
 	dateCouldBeParsed := false
 
@@ -177,7 +348,7 @@ func getDate(w http.ResponseWriter, r *http.Request) {
 			// Successfully converted to int, so this should be seconds since epoch
 			parsedTime := time.Unix(seconds, 0)
 			if err != nil {
-				log.Printf("Error in %s: %s\n", funcName, err)
+				logger.Error("time.Unix failed", "func", funcName, "err", err)
 			} else {
 				response.UNIXDate = parsedTime.Unix()
 				response.UTCDate = parsedTime.Format(time.RFC1123)
@@ -187,7 +358,7 @@ func getDate(w http.ResponseWriter, r *http.Request) {
 			// Failed at converting to int, so this might be a %Y-%m-%d date
 			parsedTime, err := time.Parse("2006-01-02", dateParam)
 			if err != nil {
-				log.Printf("Error in %s: %s\n", funcName, err)
+				logger.Error("time.Parse failed", "func", funcName, "err", err)
 			} else {
 				response.UNIXDate = parsedTime.Unix()
 				response.UTCDate = parsedTime.Format(time.RFC1123)
@@ -206,41 +377,36 @@ func getDate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Print to the console for debug purposes
-	log.Printf("%+v\n", response)
+	logger.Debug("Date response.", "response", response)
 
-	// Finally, send it to the user as JSON
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	err := json.NewEncoder(w).Encode(response)
-	if err != nil {
-		log.Printf("Error in %s: %s\n", funcName, err)
-	}
+	// Finally, send it to the user as JSON or XML
+	writeResponse(w, r, http.StatusOK, response)
 }
 
 
 // Processes a file uploaded by the user and returns a JSON object
 // with the file's original name, [MIME] type, and size.
 func getFileMetadata(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		http.Error(w, "Access denied", http.StatusMethodNotAllowed)
-	}
-
-	log.Printf("Request for file metadata.\n")
+	logger := loggerFrom(r.Context())
+	logger.Debug("Request for file metadata.")
 	funcName := "getFileMetadata"
 
-	// Load the body of the request
-	const maxUploadSize = 1024 * 1024
-	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	// Load the body of the request,
+	// whose size is limited by the body limit middleware
 	err := r.ParseMultipartForm(maxUploadSize)
 	if err != nil {
-		log.Printf("Error in %s: %s\n", funcName, err)
+		logger.Error("Request.ParseMultipartForm failed", "func", funcName, "err", err)
+		writeError(w, r, formError(err))
+		return
 	}
 
 	// Extract the uploaded file from the request body
 	filename := "upfile"
 	file, fileHeader, err := r.FormFile(filename)
 	if err != nil {
-		log.Printf("Error in %s: %s\n", funcName, err)
+		logger.Error("Request.FormFile failed", "func", funcName, "err", err)
+		writeError(w, r, newErrorMessage(http.StatusBadRequest, "no file uploaded"))
+		return
 	}
 	defer file.Close()
 
@@ -258,168 +424,680 @@ func getFileMetadata(w http.ResponseWriter, r *http.Request) {
 	fileInfo.Name = fileHeader.Filename
 	fileInfo.Type = contentType
 	fileInfo.Size = fileHeader.Size
-	log.Printf("%+v\n", fileInfo)
+	logger.Debug("File metadata.", "file", fileInfo)
 
-	// Send the metadata to the visitor as JSON
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	err = json.NewEncoder(w).Encode(fileInfo)
-	if err != nil {
-		log.Printf("Error in %s: %s\n", funcName, err)
+	// Send the metadata to the visitor as JSON or XML
+	writeResponse(w, r, http.StatusOK, fileInfo)
+}
+
+
+// Works out when a new short URL should expire from either expires_in,
+// as a number of seconds or a duration such as "36h", or expires_at, as an RFC 3339 time.
+// Returns a zero time if neither was given, meaning it never expires.
+func parseURLExpiry(expiresIn string, expiresAt string) (time.Time, error) {
+	if len(expiresIn) > 0 && len(expiresAt) > 0 {
+		return time.Time{}, newErrorMessage(http.StatusBadRequest, "give either expires_in or expires_at, not both")
 	}
+	var expiry time.Time
+	if len(expiresIn) > 0 {
+		lifetime, err := time.ParseDuration(expiresIn)
+		if seconds, convErr := strconv.ParseInt(expiresIn, 10, 64); convErr == nil {
+			lifetime, err = time.Duration(seconds) * time.Second, nil
+		}
+		if err != nil {
+			return time.Time{}, newErrorMessage(http.StatusBadRequest, "invalid expires_in")
+		}
+		expiry = time.Now().Add(lifetime)
+	} else if len(expiresAt) > 0 {
+		var err error
+		expiry, err = time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			return time.Time{}, newErrorMessage(http.StatusBadRequest, "invalid expires_at")
+		}
+	} else {
+		return time.Time{}, nil
+	}
+	if !expiry.After(time.Now()) {
+		return time.Time{}, newErrorMessage(http.StatusBadRequest, "expiry must be in the future")
+	}
+	return expiry.UTC(), nil
 }
 
 
-// Given a URL, creates a short URL and sends it to the user in a JSON object
-func createShortURL(w http.ResponseWriter, r *http.Request) {
-	log.Println("Request to create short URL.")
-	funcName := "createShortURL"
+// What a client sends to create a short URL, as form fields or a JSON object
+// such as { "url": "https://example.com", "alias": "example" }.
+type NewURLRequest struct {
+	URL       string `json:"url"`
+	Alias     string `json:"alias"`
+	// A number of seconds, which JSON clients may send as a number, or a duration such as "36h"
+	ExpiresIn any    `json:"expires_in"`
+	ExpiresAt string `json:"expires_at"`
+	// 301, 302, or 307, which JSON clients may send as a number, or missing for the default
+	RedirectStatus any `json:"redirect_status"`
+}
 
-	// Prepare to send the results back to the visitor as JSON
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
 
-	// Read in the HTML form data
-	if err := r.ParseForm(); err != nil {
-		log.Printf("Error in %s: %s\n", funcName, err)
-		fmt.Fprintf(w, `{"error":"unable to parse form"}`)
-		return
+// Reads a request to create a short URL from a JSON body if its Content-Type says so,
+// and from the form data otherwise.
+func readNewURLRequest(r *http.Request) (NewURLRequest, error) {
+	if !isJSONRequest(r) {
+		if err := r.ParseForm(); err != nil {
+			loggerFrom(r.Context()).Error("Request.ParseForm failed", "func", "readNewURLRequest", "err", err)
+			return NewURLRequest{}, formError(err)
+		}
+		return NewURLRequest{
+			URL: r.Form.Get("url"),
+			Alias: r.Form.Get("alias"),
+			ExpiresIn: r.Form.Get("expires_in"),
+			ExpiresAt: r.Form.Get("expires_at"),
+			RedirectStatus: r.Form.Get("redirect_status"),
+		}, nil
 	}
 
-	// Get the URL from the form data
-	originalURL := r.Form.Get("url")
-	log.Printf("Before formatting: %s\n", originalURL)
-	// The URL needs to start with "http://" in order to be parsed correctly,
-	// and "https://" causes errors.
-	originalURL = strings.TrimPrefix(originalURL, "https://")
-	if !strings.HasPrefix(originalURL, "http://") {
-		originalURL = "http://" + originalURL
+	var req NewURLRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		return NewURLRequest{}, err
 	}
-	log.Printf("After formatting: %s\n", originalURL)
+	return req, nil
+}
+
+
+// Reports whether a request's Content-Type says that its body is JSON rather than form data.
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/json"
+}
 
-	// Check if the format of the URL is valid
-	urlObject, err := url.Parse(originalURL)
+
+// Decodes a request's JSON body into v, rejecting fields that v doesn't have.
+func decodeJSONBody(r *http.Request, v any) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return errBodyTooLarge
+		}
+		return newErrorMessage(http.StatusBadRequest, "invalid json body")
+	}
+	return nil
+}
+
+
+// Returns expires_in as a string, whether it was sent as one or as a number.
+func (req NewURLRequest) expiresIn() string {
+	return jsonString(req.ExpiresIn)
+}
+
+
+// Returns redirect_status as a string, whether it was sent as one or as a number.
+func (req NewURLRequest) redirectStatus() string {
+	return jsonString(req.RedirectStatus)
+}
+
+
+// Returns a value decoded from JSON that may be either a string or a number as a string.
+func jsonString(value any) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		// Anything else fails to parse
+		return fmt.Sprint(value)
+	}
+}
+
+
+// Given a URL, creates a short URL and sends it to the user in a JSON object.
+// The URL can be sent as form data or as JSON, optionally along with an alias
+// to use as the short URL instead of a generated one.
+func createShortURL(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFrom(r.Context())
+	logger.Debug("Request to create short URL.")
+
+	req, err := readNewURLRequest(r)
 	if err != nil {
-		log.Printf("Error in %s: %s\n", funcName, err)
-		fmt.Fprintf(w, `{"error":"invalid url"}`)
+		writeError(w, r, err)
 		return
 	}
-	log.Println("Successfully parsed URL.")
 
-	// See if the hostname is valid by trying to look it up via DNS
-	addresses, err := net.LookupHost(urlObject.Hostname())
+	originalURL, err := validateURL(r.Context(), req.URL)
 	if err != nil {
-		log.Printf("Error in %s: %s\n", funcName, err)
-		fmt.Fprintf(w, `{"error":"invalid hostname"}`)
+		writeError(w, r, err)
+		return
+	}
+	if err := validateAlias(req.Alias); err != nil {
+		writeError(w, r, err)
 		return
 	}
-	log.Printf("Found addresses for %s: %v\n", urlObject.Hostname(), addresses)
 
-	// Dial the original URL
-	/*
-	conn, err := net.Dial("tcp", urlObject.Hostname() + ":http")
+	expiresAt, err := parseURLExpiry(req.expiresIn(), req.ExpiresAt)
 	if err != nil {
-		log.Printf("Error in %s: %s\n", funcName, err)
-	} else {
-		conn.Close()
-		log.Println("Got a response from the server when dialing the URL.")
+		writeError(w, r, err)
+		return
+	}
+	status, err := parseRedirectStatus(req.redirectStatus())
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
-	*/
+
+	newURL := NewURL{
+		OriginalURL: originalURL,
+		ExpiresAt: expiresAt,
+		Owner: requestOwner(r),
+		Alias: req.Alias,
+		RedirectStatus: status,
+	}
+	warning := probeNewURL(r.Context(), &newURL)
 
 	// Attempt to add it to the database
-	resultJSON := insertURL(strings.TrimPrefix(originalURL, "http://"))
-	w.Write(resultJSON)
+	resultJSON, err := urlStore.InsertURL(r.Context(), newURL)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	resultJSON.Warning = warning
+	writeResponse(w, r, http.StatusCreated, resultJSON)
 }
 
 
-// Given a short URL, finds the corresponding original URL and redirects to it
+// Given a short URL, finds the corresponding original URL and redirects to it,
+// or only shows where it leads if a preview is asked for
 func openShortURL(w http.ResponseWriter, r *http.Request) {
-	shortURL := strings.TrimPrefix(r.URL.Path, "/shorturl/go/")
-	log.Printf("Request for short URL: %s\n", shortURL)
+	logger := loggerFrom(r.Context())
+	shortURL := r.PathValue("code")
+	logger.Debug("Request for short URL.", "short_url", shortURL)
 
 	// Return if no URL was passed
 	if len(shortURL) == 0 {
-		http.NotFound(w, r)
+		writeLinkError(w, r, errNoSuchShortURL)
+		return
 	}
 
-	originalURL := getOriginalURL(shortURL)
-	log.Printf("Redirecting to: %s\n", originalURL)
-	if !strings.HasPrefix(originalURL, "http://") {
-		http.Redirect(w, r, "http://" + originalURL, 307)
-	} else {
-		http.Redirect(w, r, originalURL, 307)
+	// A "+" after the short URL, as some other shorteners use, also asks for a preview
+	if code, ok := strings.CutSuffix(shortURL, "+"); ok || previewRequested(r) {
+		previewShortURL(w, r, code)
+		return
 	}
+
+	originalURL, status, err := urlStore.ResolveURL(r.Context(), shortURL)
+	if err != nil {
+		writeLinkError(w, r, err)
+		return
+	}
+	// The domain may have been blocked after the link was made
+	if urlBlocked(originalURL) {
+		blockedURLsTotal.inc("redirect")
+		logger.Warn("Refused to redirect to blocked URL.", "short_url", shortURL, "url", originalURL)
+		writeLinkError(w, r, errBlockedLink)
+		return
+	}
+	if err := checkVisitRate(r.Context(), shortURL); err != nil {
+		writeLinkError(w, r, err)
+		return
+	}
+	// Only visits that are let through count
+	if err := urlStore.AddVisits(r.Context(), map[string]int64{shortURL: 1}); err != nil {
+		logger.Warn("Unable to count the visit.", "short_url", shortURL, "err", err)
+	}
+	recordClick(r, shortURL)
+	notifyWebhooks(r, shortURL)
+	status = redirectStatus(status)
+	logger.Debug("Redirecting.", "url", originalURL, "status", status)
+	http.Redirect(w, r, withScheme(originalURL), status)
+}
+
+
+// Sends a page of short URLs along with their statistics, chosen by the "sort"
+// ("created" or "visits"), "order" ("desc" or "asc"), "limit", and "offset" query parameters.
+// Short URLs owned by other clients are left out unless an admin is asking.
+func getShortURLList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := parseURLListQuery(q.Get("sort"), q.Get("order"), q.Get("limit"), q.Get("offset"))
+	restrictURLListQuery(r, &query)
+	urls, total, err := urlStore.ListURLs(r.Context(), query)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	list := URLList{Total: total, Limit: query.Limit, Offset: query.Offset, URLs: make([]URLStats, len(urls))}
+	for i, stored := range urls {
+		list.URLs[i] = stored.stats()
+	}
+	writeResponse(w, r, http.StatusOK, list)
 }
 
 
-// For the Exercise Tracker API,
-// process requests to add a user,
-// add an exercise to a user's log,
-// get exercise logs for a specific user,
-// or get all the data in the database.
-func handleExerciseUsersPath(w http.ResponseWriter, r *http.Request) {
-	log.Println("Exercise API accessed.")
-	funcName := "handleExerciseUsersPath"
+// Sends how many times the short URL in the path has been visited, and when it last was.
+// Only its owner or an admin may see them.
+func getShortURLStats(w http.ResponseWriter, r *http.Request) {
+	stored, err := authorizeShortURL(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeResponse(w, r, http.StatusOK, stored.stats())
+}
+
+
+// Returns the version that an If-Match header requires a record to be at,
+// or anyVersion if there is no such header or it is "*".
+// Both weak and strong ETags are accepted, as the versions are only ever sent as weak ones.
+func parseIfMatch(header string) (int64, error) {
+	if len(header) == 0 || header == "*" {
+		return anyVersion, nil
+	}
+	tag := strings.Trim(strings.TrimPrefix(header, "W/"), `"`)
+	version, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil || version < 0 {
+		return 0, newErrorMessage(http.StatusPreconditionFailed, "If-Match must be the ETag of a version")
+	}
+	return version, nil
+}
+
 
-	// Prepare to send JSON back to the visitor
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
+// Points the short URL in the path at the URL in the form data, keeping its visit count.
+// If an If-Match header is given, the change is only made if the short URL
+// is still at that version, so that two clients can't overwrite each other's changes.
+// Only the short URL's owner or an admin may change it.
+func updateShortURL(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFrom(r.Context())
+	funcName := "updateShortURL"
 
-	//log.Printf("User's request URI: %s\n", r.URL.Path)
-	requestDestination := strings.TrimPrefix(r.URL.Path, "/exercise/users/")
-	log.Printf("User's request: %s %s\n", r.Method, requestDestination)
+	if err := r.ParseForm(); err != nil {
+		logger.Error("Request.ParseForm failed", "func", funcName, "err", err)
+		writeError(w, r, formError(err))
+		return
+	}
+	version, err := parseIfMatch(r.Header.Get("If-Match"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	originalURL, err := validateURL(r.Context(), r.Form.Get("url"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-	if len(requestDestination) == 0 && r.Method == "GET" {
-		// Get all user info
-		userData := getAllExerciseData()
-		w.Write(userData)
+	if _, err := authorizeShortURL(r); err != nil {
+		writeError(w, r, err)
 		return
 	}
+	updated, err := urlStore.UpdateURL(r.Context(), r.PathValue("code"), originalURL, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", `W/"` + strconv.FormatInt(updated.Version, 10) + `"`)
+	writeJSON(w, http.StatusOK, updated)
+}
 
-	// For every other option, the form data must be parsed.
-	err := r.ParseForm()
+
+// Deletes the short URL in the path and sends back what was stored about it,
+// so that a mistaken or abusive link can be retired. Only its owner or an admin may.
+func deleteShortURL(w http.ResponseWriter, r *http.Request) {
+	if _, err := authorizeShortURL(r); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	deleted, err := urlStore.DeleteURL(r.Context(), r.PathValue("code"))
 	if err != nil {
-		log.Printf("Error in %s: %s\n", funcName, err)
-	}
-
-	if len(requestDestination) == 0 && r.Method == "POST" {
-		// Add a new user
-		username := r.Form.Get("username")
-		log.Println("Request to add new exercise user.")
-		newUserRecord := createExerciseUser(username)
-		w.Write(newUserRecord)
-	} else if len(requestDestination) > 0 && r.Method == "GET" {
-		var logUpdatedReceipt []byte
-		// Get exercise logs for a specific user
-		// First, check if there were any query parameters
-		slashIndex := strings.Index(requestDestination, "/")
-		if slashIndex == -1 {
-			// No query parameters, so pass empty strings
-			logUpdatedReceipt = getExerciseLogsFromUser(requestDestination, "", "", "")
+		writeError(w, r, err)
+		return
+	}
+	forgetLinkWebhook(deleted.ShortURL)
+	forgetLinkVisitLimit(deleted.ShortURL)
+	writeJSON(w, http.StatusOK, deleted)
+}
+
+
+// Sends the records of every exercise user in the database, oldest first, as a JSON array
+// or in XML as the Accept header prefers. Users are written as they are read from the store
+// rather than all being loaded first. The status code has already been sent by the time
+// a store error can occur, so the response is then cut off, as in streamExport.
+func getExerciseUsers(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFrom(r.Context())
+	logger.Debug("Request for all exercise user data.")
+
+	// Sending every user can take longer than the server's write timeout allows
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		logger.Warn("Unable to clear write deadline.", "err", err)
+	}
+
+	asXML := prefersXML(r)
+	xmlEncoder := xml.NewEncoder(w)
+	started := false
+	start := func() {
+		if asXML {
+			w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(xml.Header + "<users>"))
 		} else {
-			// The user ID comes before the slash, so extract it
-			id := requestDestination[:slashIndex]
-			// Now extract whatever search query parameters were passed
-			q := r.URL.Query()
-			fromDate := q.Get("from")
-			toDate := q.Get("to")
-			numRecordsToReturn := q.Get("limit")
-			logUpdatedReceipt = getExerciseLogsFromUser(id, fromDate, toDate, numRecordsToReturn)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("["))
+		}
+		started = true
+	}
+
+	count := 0
+	err := exerciseStore.StreamUsers(r.Context(), func(record ExerciseUserRecord) error {
+		if !started {
+			start()
+		} else if !asXML {
+			if _, err := w.Write([]byte(",")); err != nil {
+				return err
+			}
+		}
+		count++
+		if asXML {
+			return xmlEncoder.Encode(record)
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
 		}
-		w.Write(logUpdatedReceipt)
-	} else if len(requestDestination) > 0 && r.Method == "POST" {
-		// Add an exercise to a specific user's log
-		// First, get the data from the form that the user posted
-		id := r.Form.Get(":_id")
-		description := r.Form.Get("description")
-		duration := r.Form.Get("duration")
-		date := r.Form.Get("date")
-		log.Println("Request to add exercise to specific user's log:")
-		log.Printf("{_id: %s, description: %s, duration: %s, date: %s}\n", id, description, duration, date)
-		logAddedReceipt := addExerciseToUser(id, description, duration, date)
-		w.Write(logAddedReceipt)
+		_, err = w.Write(data)
+		return err
+	})
+	if err != nil && !started {
+		writeError(w, r, err)
+		return
+	} else if err != nil {
+		logger.Error("Sending exercise users failed partway.", "sent", count, "err", err)
+		panic(http.ErrAbortHandler)
+	} else if !started {
+		// There were no users
+		start()
+	}
+	if asXML {
+		w.Write([]byte("</users>"))
 	} else {
-		http.NotFound(w, r)
+		w.Write([]byte("]\n"))
+	}
+	logger.Debug("Sent exercise user records.", "count", count)
+}
+
+
+// What a client sends to create or rename an exercise user, as form fields or a JSON object
+// such as { "username": "alice" }.
+type NewExerciseUserRequest struct {
+	Username string `json:"username"`
+}
+
+
+// Reads a request to create or rename an exercise user from a JSON body if its Content-Type says so,
+// and from the form data otherwise.
+func readNewExerciseUserRequest(r *http.Request) (NewExerciseUserRequest, error) {
+	if !isJSONRequest(r) {
+		if err := r.ParseForm(); err != nil {
+			loggerFrom(r.Context()).Error("Request.ParseForm failed", "func", "readNewExerciseUserRequest", "err", err)
+			return NewExerciseUserRequest{}, formError(err)
+		}
+		return NewExerciseUserRequest{Username: r.Form.Get("username")}, nil
 	}
+
+	var req NewExerciseUserRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		return NewExerciseUserRequest{}, err
+	}
+	return req, nil
 }
 
+
+// Creates a new exercise user with the username sent as form data or JSON.
+func postExerciseUser(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFrom(r.Context())
+
+	req, err := readNewExerciseUserRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	username := req.Username
+	if len(username) == 0 {
+		writeError(w, r, newErrorMessage(http.StatusBadRequest, "username is required"))
+		return
+	}
+	logger.Debug("Request to add new exercise user.", "username", username)
+	newUserRecord, err := createExerciseUser(r.Context(), username)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeResponse(w, r, http.StatusCreated, newUserRecord)
+}
+
+
+// Deletes the user whose ID is in the path along with their log,
+// and sends back what was deleted. If an If-Match header is given,
+// the user is only deleted if they are still at that version.
+func deleteExerciseUser(w http.ResponseWriter, r *http.Request) {
+	version, err := parseIfMatch(r.Header.Get("If-Match"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	deleted, err := deleteExerciseUserAtVersion(r.Context(), r.PathValue("id"), version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, deleted)
+}
+
+
+// Deletes a user along with their log, provided they are still at the given version
+// or the version is anyVersion. The user is checked and deleted in one transaction,
+// so that changes made in between can't be deleted unseen.
+func deleteExerciseUserAtVersion(ctx context.Context, userID string, version int64) (ExerciseUserRecord, error) {
+	var deleted ExerciseUserRecord
+	err := exerciseStore.WithTransaction(ctx, func(ctx context.Context) error {
+		if version != anyVersion {
+			user, err := exerciseStore.GetUser(ctx, userID)
+			if err != nil {
+				return err
+			}
+			if user.Version != version {
+				return newVersionConflict(version)
+			}
+		}
+		var err error
+		deleted, err = exerciseStore.DeleteUser(ctx, userID)
+		return err
+	})
+	return deleted, err
+}
+
+
+// What a client sends to add an exercise, as form fields or a JSON object
+// such as { "description": "Run", "duration": 30, "date": "2024-01-01" }.
+type NewExerciseRequest struct {
+	Description string   `json:"description"`
+	// A number of minutes, which JSON clients may send as a number or a string
+	Duration    any      `json:"duration"`
+	// The unit of the duration: minutes, hours, or seconds (default minutes)
+	Unit        string   `json:"unit"`
+	Date        string   `json:"date"`
+	Category    string   `json:"category"`
+	// Sent as a JSON array, or as form fields that are each a comma-separated list
+	Tags        []string `json:"tags"`
+}
+
+
+// Reads a request to add an exercise from a JSON body if its Content-Type says so,
+// and from the form data otherwise.
+func readNewExerciseRequest(r *http.Request) (NewExerciseRequest, error) {
+	if !isJSONRequest(r) {
+		if err := r.ParseForm(); err != nil {
+			loggerFrom(r.Context()).Error("Request.ParseForm failed", "func", "readNewExerciseRequest", "err", err)
+			return NewExerciseRequest{}, formError(err)
+		}
+		return NewExerciseRequest{
+			Description: r.Form.Get("description"),
+			Duration: r.Form.Get("duration"),
+			Unit: r.Form.Get("unit"),
+			Date: r.Form.Get("date"),
+			Category: r.Form.Get("category"),
+			Tags: r.Form["tags"],
+		}, nil
+	}
+
+	var req NewExerciseRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		return NewExerciseRequest{}, err
+	}
+	return req, nil
+}
+
+
+// Returns duration as a string, whether it was sent as one or as a number.
+func (req NewExerciseRequest) duration() string {
+	return jsonString(req.Duration)
+}
+
+
+// Adds an exercise to the log of the user whose ID is in the path, sent as form data or JSON,
+// with an optional "category" such as "run" and "tags" that the log can be filtered by,
+// and an optional "unit" that the duration is in, which is stored in minutes.
+func postExercise(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFrom(r.Context())
+
+	req, err := readNewExerciseRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	id := r.PathValue("id")
+	description := req.Description
+	duration := req.duration()
+	date := req.Date
+	logger.Debug("Request to add exercise to specific user's log.",
+		"id", id, "description", description, "duration", duration, "date", date)
+	exercise, err := parseExercise(description, duration, req.Unit, date)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	exercise.Category, err = parseExerciseCategory(req.Category)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	exercise.Tags, err = parseExerciseTags(req.Tags)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	logAddedReceipt, err := exerciseStore.AddExercise(r.Context(), id, exercise)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeResponse(w, r, http.StatusCreated, logAddedReceipt)
+}
+
+
+// Changes the exercise whose ID is in the path, in the log of the user whose ID is,
+// to the description, duration, and date in the form data, and sends it back.
+// PUT replaces all of them, while PATCH changes only those that are given.
+func updateExercise(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFrom(r.Context())
+	funcName := "updateExercise"
+
+	if err := r.ParseForm(); err != nil {
+		logger.Error("Request.ParseForm failed", "func", funcName, "err", err)
+		writeError(w, r, formError(err))
+		return
+	}
+	update, err := parseExerciseUpdate(r.Form, r.Method == http.MethodPatch)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	exercise, err := exerciseStore.UpdateExercise(r.Context(), r.PathValue("id"), r.PathValue("exerciseId"), update)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeResponse(w, r, http.StatusOK, exercise)
+}
+
+
+// Removes the exercise whose ID is in the path from the log of the user whose ID is,
+// e.g. because it was logged by mistake.
+func deleteExercise(w http.ResponseWriter, r *http.Request) {
+	if err := exerciseStore.DeleteExercise(r.Context(), r.PathValue("id"), r.PathValue("exerciseId")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+
+// Returns the exercise log of the user whose ID is in the path, optionally filtered
+// by the "from", "to", "limit", "category", and "tags" query parameters and by description with "q",
+// sorted by "sort" and "order", and paged through with "offset",
+// along with how many exercises there are in the date range and matching "q" as "total".
+// Durations are in minutes, or in the "units" query parameter.
+func getExerciseLog(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	q := r.URL.Query()
+	fromDate := q.Get("from")
+	toDate := q.Get("to")
+	numRecordsToReturn := q.Get("limit")
+	// "skip" is accepted as another name for "offset"
+	numRecordsToSkip := q.Get("offset")
+	if len(numRecordsToSkip) == 0 {
+		numRecordsToSkip = q.Get("skip")
+	}
+	filter := parseExerciseLogFilter(fromDate, toDate, numRecordsToReturn, numRecordsToSkip)
+	filter.Sort, filter.Descending = parseExerciseLogSort(q.Get("sort"), q.Get("order"))
+	// Categories are compared as they are stored, so one that is too long matches nothing
+	filter.Category = strings.ToLower(strings.TrimSpace(q.Get("category")))
+	// As are tags, of which exercises must have every one
+	filter.Tags = splitExerciseTags(q["tags"])
+	// "description" is accepted as another name for "q"
+	search := q.Get("q")
+	if len(search) == 0 {
+		search = q.Get("description")
+	}
+	description, err := parseExerciseLogSearch(search)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	filter.Description = description
+	var unit string
+	if units := q.Get("units"); len(units) > 0 {
+		if unit, err = parseDurationUnit(units); err != nil {
+			writeError(w, r, newErrorMessage(http.StatusBadRequest, "units must be minutes, hours, or seconds"))
+			return
+		}
+	}
+	logReceipt, err := exerciseStore.GetExerciseLog(r.Context(), id, filter)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	for i := range logReceipt.Log {
+		logReceipt.Log[i].Unit = unit
+	}
+
+	// The log only changes along with the user's version,
+	// so clients that already have this version can skip the body
+	etag := `W/"` + strconv.FormatInt(logReceipt.Version, 10) + `"`
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeResponse(w, r, http.StatusOK, logReceipt)
+}
@@ -0,0 +1,82 @@
+// Tests how visits to short URLs that lead nowhere are answered.
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A URL store that knows no short URLs, and counts how often it is asked about them.
+// Its other methods are left to the nil URLStore, so calling them panics.
+type fakeURLStore struct {
+	URLStore
+	calls int
+}
+
+func (store *fakeURLStore) ResolveURL(ctx context.Context, shortURL string) (string, int, error) {
+	store.calls++
+	return "", 0, newStoreError(ErrNotFound, "no such short url")
+}
+
+func (store *fakeURLStore) LookupURL(ctx context.Context, shortURL string) (StoredURL, error) {
+	store.calls++
+	return StoredURL{}, newStoreError(ErrNotFound, "no such short url")
+}
+
+
+func TestOpenShortURLNotFound(t *testing.T) {
+	tests := []struct {
+		name            string
+		path            string
+		accept          string
+		wantContentType string
+		wantStoreCalls  bool
+	}{
+		{"empty code", "/shorturl/go/", "application/json", "application/json", false},
+		{"unknown code as json", "/shorturl/go/zzzz", "application/json", "application/json", true},
+		{"unknown code as html", "/shorturl/go/zzzz", "text/html", "text/html", true},
+		{"unknown code without accept", "/shorturl/go/zzzz", "", "application/json", true},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /shorturl/go/{code}", openShortURL)
+	mux.HandleFunc("GET /shorturl/go/{$}", openShortURL)
+
+	previous := urlStore
+	defer func() { urlStore = previous }()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := &fakeURLStore{}
+			urlStore = store
+
+			r := httptest.NewRequest(http.MethodGet, test.path, nil)
+			if len(test.accept) > 0 {
+				r.Header.Set("Accept", test.accept)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+
+			if w.Code != http.StatusNotFound {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+			}
+			if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, test.wantContentType) {
+				t.Errorf("Content-Type = %q, want %q", contentType, test.wantContentType)
+			}
+			if location := w.Header().Get("Location"); len(location) > 0 {
+				t.Errorf("Location = %q, want none", location)
+			}
+			if test.wantStoreCalls && store.calls == 0 {
+				t.Error("the store wasn't asked about the short URL")
+			} else if !test.wantStoreCalls && store.calls > 0 {
+				t.Errorf("the store was asked %d times, want none", store.calls)
+			}
+			if test.wantContentType == "text/html" && !strings.Contains(w.Body.String(), "Link not found") {
+				t.Errorf("body = %q, want the link error page", w.Body.String())
+			}
+		})
+	}
+}
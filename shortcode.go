@@ -0,0 +1,129 @@
+// Generates the short codes that stand for original URLs.
+package main
+
+import (
+	"crypto/rand"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The characters that random short URLs are made of
+const shortURLAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// How many random short URLs to try before giving up, should each one be taken
+const maxShortURLAttempts = 5
+
+// What a short URL chosen by the client may look like
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{3,32}$`)
+
+var (
+	errInvalidAlias  = newErrorMessage(http.StatusBadRequest, "alias must be 3 to 32 letters, digits, dashes, or underscores")
+	errReservedAlias = newErrorMessage(http.StatusBadRequest, "alias is reserved")
+)
+
+// Words that short URLs may not be, in lowercase, as they are or may become
+// the names of routes, e.g. /shorturl/list, or would be confusing if they were.
+// SHORTURL_RESERVED_ALIASES adds to them.
+var reservedShortURLs = map[string]bool{
+	"new": true, "bulk": true, "list": true, "go": true, "qr": true, "stats": true,
+	"clicks": true, "preview": true, "admin": true, "api": true, "auth": true,
+	"docs": true, "openapi": true, "metrics": true, "healthz": true, "readyz": true,
+	"events": true, "static": true, "shorturl": true, "exercise": true, "file": true,
+	"webhook": true, "utm": true,
+}
+
+var (
+	// Whether short URLs count up in base 36 rather than being random
+	sequentialShortURLs bool
+	// How many characters random short URLs have
+	shortURLLength int
+)
+
+
+// Reads the short URL settings. SHORT_URL_STYLE is "random" (the default) for
+// random base62 codes of SHORT_URL_LENGTH characters (default 7), which can't be guessed,
+// or "sequential" for codes that count up from 0 in base 36 as they once did.
+func initShortURLs() {
+	switch style := getEnv("SHORT_URL_STYLE", "random"); style {
+	case "random":
+	case "sequential":
+		sequentialShortURLs = true
+	default:
+		slog.Warn("Invalid SHORT_URL_STYLE, so using random short URLs.", "value", style)
+	}
+
+	shortURLLength = int(getEnvInt("SHORT_URL_LENGTH", 7))
+	if shortURLLength < 4 || shortURLLength > 32 {
+		slog.Warn("SHORT_URL_LENGTH must be between 4 and 32, so using the default.", "value", shortURLLength)
+		shortURLLength = 7
+	}
+
+	for _, word := range getEnvList("SHORTURL_RESERVED_ALIASES") {
+		reservedShortURLs[strings.ToLower(word)] = true
+	}
+}
+
+
+// Returns a random base62 short URL of SHORT_URL_LENGTH characters.
+func randomShortURL() string {
+	code := make([]byte, shortURLLength)
+	var b [1]byte
+	for i := range code {
+		// Rejecting the bytes past the last multiple of 62 keeps every character equally likely
+		for {
+			rand.Read(b[:])
+			if b[0] < 248 {
+				code[i] = shortURLAlphabet[b[0]%62]
+				break
+			}
+		}
+	}
+	return string(code)
+}
+
+
+// Checks a short URL chosen by the client, which may be empty if it didn't choose one.
+func validateAlias(alias string) error {
+	if len(alias) == 0 {
+		return nil
+	}
+	if !aliasPattern.MatchString(alias) {
+		return errInvalidAlias
+	}
+	if isReservedShortURL(alias) {
+		return errReservedAlias
+	}
+	return nil
+}
+
+
+// Returns the number that a short URL stands for if it is written as the counter
+// behind sequential short URLs writes them: in lowercase base 36 without leading zeros.
+// Random short URLs and aliases that ParseInt would also accept, such as "ABC", aren't.
+func sequentialShortURLValue(shortURL string) (int64, bool) {
+	n, err := strconv.ParseInt(shortURL, 36, 64)
+	if err != nil || n < 0 || strconv.FormatInt(n, 36) != shortURL {
+		return 0, false
+	}
+	return n, true
+}
+
+
+// Returns the number that the short URL stands for if it is a sequential one
+// higher than highest, and highest otherwise. The counter must be moved past
+// the highest of them, starting from -1 for none.
+func highestSequentialShortURL(highest int64, shortURL string) int64 {
+	if n, ok := sequentialShortURLValue(shortURL); ok && n > highest {
+		return n
+	}
+	return highest
+}
+
+
+// Reports whether a short URL is one of the reserved words, whatever its case.
+func isReservedShortURL(shortURL string) bool {
+	return reservedShortURLs[strings.ToLower(shortURL)]
+}
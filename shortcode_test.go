@@ -0,0 +1,67 @@
+// Tests which short URLs count as sequential ones, and where the counter is seeded from them.
+package main
+
+import (
+	"testing"
+)
+
+
+func TestSequentialShortURLValue(t *testing.T) {
+	tests := []struct {
+		shortURL string
+		want     int64
+		wantOK   bool
+	}{
+		{"0", 0, true},
+		{"z", 35, true},
+		{"10", 36, true},
+		{"zik0zj", 2147483647, true},
+		// Random codes and aliases that ParseInt would also accept
+		{"Z", 0, false},
+		{"aBc", 0, false},
+		{"007", 0, false},
+		{"", 0, false},
+		{"-1", 0, false},
+		{"+1", 0, false},
+		{"my-link", 0, false},
+		{"zzzzzzzzzzzzzzzzzzzz", 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.shortURL, func(t *testing.T) {
+			got, ok := sequentialShortURLValue(test.shortURL)
+			if got != test.want || ok != test.wantOK {
+				t.Errorf("sequentialShortURLValue(%q) = %d, %t, want %d, %t", test.shortURL, got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
+
+
+func TestHighestSequentialShortURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		shortURLs []string
+		want      int64
+	}{
+		{"none", nil, -1},
+		{"only aliases", []string{"my-link", "ABC", "007"}, -1},
+		{"zero", []string{"0"}, 0},
+		{"highest of several", []string{"a", "10", "z"}, 36},
+		// "zz" would be the highest if the alias counted, and ParseInt accepts it as 1295
+		{"alias beside codes", []string{"ZZ", "1a", "b"}, 46},
+		{"random codes", []string{"Xk9pQ2", "z0", "AbCdEf"}, 1260},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var highest int64 = -1
+			for _, shortURL := range test.shortURLs {
+				highest = highestSequentialShortURL(highest, shortURL)
+			}
+			if highest != test.want {
+				t.Errorf("highest = %d, want %d", highest, test.want)
+			}
+		})
+	}
+}
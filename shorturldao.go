@@ -3,146 +3,1047 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"log"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
-var urlCollection *mongo.Collection
+// Keeps short URLs in a MongoDB collection.
+type mongoURLStore struct {
+	collection *mongo.Collection
+	// The same collection with the read preference for read-heavy operations
+	reads      *mongo.Collection
+	// Holds the counter behind sequential short URLs
+	counters   *mongo.Collection
+}
+
+// The ID of the counter document behind sequential short URLs
+const shortURLCounterID = "short_url"
+
+type counterRecord struct {
+	ID  string `bson:"_id"`
+	Seq int64  `bson:"seq"`
+}
 
 type urlDBRecord struct {
 	ID			 primitive.ObjectID `bson:"_id,omitempty"`
 	OriginalURL  string             `bson:"original_url"`
 	ShortURL     string             `bson:"short_url"`
 	TimesVisited int                `bson:"times_visited"`
+	// Incremented whenever the URL is changed, but not when it is visited
+	Version      int64              `bson:"version"`
+	// Missing if the URL never expires, in which case the TTL index ignores it
+	ExpiresAt    time.Time          `bson:"expires_at,omitempty"`
+	CreatedAt    time.Time          `bson:"created_at,omitempty"`
+	// Missing if the URL has never been visited
+	LastVisitedAt time.Time         `bson:"last_visited_at,omitempty"`
+	// Missing if the URL wasn't probed, or couldn't be reached
+	ProbeStatus  int                `bson:"probe_status,omitempty"`
+	// Missing if the URL wasn't probed
+	ProbedAt     time.Time          `bson:"probed_at,omitempty"`
+	// Missing if nobody was authenticated when it was created
+	Owner        string             `bson:"owner,omitempty"`
+	// Missing if visits aren't reported
+	Webhook      *Webhook           `bson:"webhook,omitempty"`
+	// Missing if no campaign parameters are added to visits
+	UTM          *UTMParams         `bson:"utm,omitempty"`
+	// Missing if the short URL redirects with the default status code
+	RedirectStatus int              `bson:"redirect_status,omitempty"`
+	// Missing unless the short URL has been disabled
+	Disabled     *LinkDisabled      `bson:"disabled,omitempty"`
+	// Missing if the short URL has the default visit limit
+	VisitLimit   int64              `bson:"visit_limit,omitempty"`
+}
+
+// Returns what is stored about the short URL, in the form that the stores share.
+func (record urlDBRecord) stored() StoredURL {
+	return StoredURL{
+		ShortURL: record.ShortURL,
+		OriginalURL: record.OriginalURL,
+		TimesVisited: record.TimesVisited,
+		ExpiresAt: record.ExpiresAt,
+		CreatedAt: record.CreatedAt,
+		LastVisitedAt: record.LastVisitedAt,
+		ProbeStatus: record.ProbeStatus,
+		ProbedAt: record.ProbedAt,
+		Owner: record.Owner,
+		Webhook: record.Webhook,
+		UTM: record.UTM,
+		RedirectStatus: record.RedirectStatus,
+		Disabled: record.Disabled,
+		VisitLimit: record.VisitLimit,
+	}
 }
 
 type urlReceipt struct {
-	OriginalURL string `json:"original_url" bson:"original_url"`
-	ShortURL    string `json:"short_url" bson:"short_url"`
+	XMLName     xml.Name   `json:"-" bson:"-" xml:"url"`
+	OriginalURL string     `json:"original_url" bson:"original_url" xml:"original_url"`
+	ShortURL    string     `json:"short_url" bson:"short_url" xml:"short_url"`
+	// Missing if the short URL never expires
+	ExpiresAt   *time.Time `json:"expires_at,omitempty" bson:"-" xml:"expires_at,omitempty"`
+	// Missing if the URL wasn't probed, or couldn't be reached
+	ProbeStatus int        `json:"probe_status,omitempty" bson:"-" xml:"probe_status,omitempty"`
+	// Set if the probe found that the URL may not work
+	Warning     string     `json:"warning,omitempty" bson:"-" xml:"warning,omitempty"`
+	// Missing if the short URL redirects with the default status code
+	RedirectStatus int     `json:"redirect_status,omitempty" bson:"-" xml:"redirect_status,omitempty"`
+}
+
+
+// How much a short URL has been used.
+type URLStats struct {
+	XMLName       xml.Name   `json:"-" xml:"stats"`
+	OriginalURL   string     `json:"original_url" xml:"original_url"`
+	ShortURL      string     `json:"short_url" xml:"short_url"`
+	CreatedAt     time.Time  `json:"created_at" xml:"created_at"`
+	TimesVisited  int        `json:"times_visited" xml:"times_visited"`
+	// Null if the short URL has never been visited
+	LastVisitedAt *time.Time `json:"last_visited_at" xml:"last_visited_at,omitempty"`
+	// Missing if the short URL never expires
+	ExpiresAt     *time.Time `json:"expires_at,omitempty" xml:"expires_at,omitempty"`
+	// Missing if the URL wasn't probed, or couldn't be reached
+	ProbeStatus   int        `json:"probe_status,omitempty" xml:"probe_status,omitempty"`
+	// Missing if the URL wasn't probed
+	ProbedAt      *time.Time `json:"probed_at,omitempty" xml:"probed_at,omitempty"`
+	// Missing if nobody was authenticated when it was created
+	Owner         string     `json:"owner,omitempty" xml:"owner,omitempty"`
+	// Missing if no campaign parameters are added to visits
+	UTM           *UTMParams `json:"utm,omitempty" xml:"utm,omitempty"`
+	// Missing if the short URL redirects with the default status code
+	RedirectStatus int       `json:"redirect_status,omitempty" xml:"redirect_status,omitempty"`
+	// Missing unless the short URL has been disabled
+	Disabled      *LinkDisabled `json:"disabled,omitempty" xml:"disabled,omitempty"`
+	// Missing if the short URL has the default visit limit
+	VisitLimit    int64      `json:"visit_limit,omitempty" xml:"visit_limit,omitempty"`
+}
+
+
+// Returns the statistics of a stored short URL.
+func (stored StoredURL) stats() URLStats {
+	stats := URLStats{
+		OriginalURL: stored.OriginalURL,
+		ShortURL: stored.ShortURL,
+		CreatedAt: stored.CreatedAt,
+		TimesVisited: stored.TimesVisited,
+		Owner: stored.Owner,
+		UTM: stored.UTM,
+		RedirectStatus: stored.RedirectStatus,
+		Disabled: stored.Disabled,
+		VisitLimit: stored.VisitLimit,
+	}
+	if !stored.LastVisitedAt.IsZero() {
+		stats.LastVisitedAt = &stored.LastVisitedAt
+	}
+	if !stored.ExpiresAt.IsZero() {
+		stats.ExpiresAt = &stored.ExpiresAt
+	}
+	if !stored.ProbedAt.IsZero() {
+		stats.ProbeStatus = stored.ProbeStatus
+		stats.ProbedAt = &stored.ProbedAt
+	}
+	return stats
+}
+
+
+// A page of short URLs, along with how many there are in all.
+type URLList struct {
+	XMLName xml.Name   `json:"-" xml:"urls"`
+	Total   int64      `json:"total" xml:"total"`
+	Limit   int64      `json:"limit" xml:"limit"`
+	Offset  int64      `json:"offset" xml:"offset"`
+	URLs    []URLStats `json:"urls" xml:"stats"`
+}
+
+
+// Returns a receipt for the record, showing its expiry if it has one.
+func (record urlDBRecord) receipt() urlReceipt {
+	receipt := urlReceipt{
+		OriginalURL: record.OriginalURL,
+		ShortURL: record.ShortURL,
+		ProbeStatus: record.ProbeStatus,
+		RedirectStatus: record.RedirectStatus,
+	}
+	if !record.ExpiresAt.IsZero() {
+		receipt.ExpiresAt = &record.ExpiresAt
+	}
+	return receipt
 }
 
 
-// Get a pointer to the URL collection
-func initURLCollection() {
-	log.Println("Getting reference to URL collection.")
-	urlCollection = mongoClient.Database(os.Getenv("DB_NAME")).Collection(os.Getenv("COLLECTION_U"))
-	if urlCollection == nil {
-		log.Fatal("Failed to get pointer to URL collection.\n")
+// Fails with ErrDuplicate if the record's URL was asked to be shortened again with an expiry,
+// redirect status, or owner other than its own, since handing over the existing short URL
+// would silently drop them, or give the client a link that it can't manage.
+func (record urlDBRecord) checkDuplicate(newURL NewURL) error {
+	switch {
+	case !newURL.ExpiresAt.Truncate(time.Millisecond).Equal(record.ExpiresAt.Truncate(time.Millisecond)):
+		return newStoreError(ErrDuplicate, "url already has the short url " + record.ShortURL + " with another expiry")
+	case newURL.RedirectStatus != record.RedirectStatus:
+		return newStoreError(ErrDuplicate, "url already has the short url " + record.ShortURL + " with another redirect status")
+	case newURL.Owner != record.Owner:
+		return newStoreError(ErrDuplicate, "url already has the short url " + record.ShortURL + " with another owner")
+	}
+	return nil
+}
+
+
+// Get a pointer to the URL collection and make sure that
+// neither original nor short URLs can be stored twice.
+func newMongoURLStore(db *mongo.Database) *mongoURLStore {
+	slog.Info("Getting reference to URL collection.")
+	collection := db.Collection(os.Getenv("COLLECTION_U"))
+	if collection == nil {
+		fatal("Failed to get pointer to URL collection.")
+	}
+	// Existing duplicates make this fail, but the app can still run without the indexes
+	if err := ensureUniqueIndexes(collection, "original_url", "short_url"); err != nil {
+		slog.Error("Failed to create indexes on URL collection. Remove any duplicate URLs and restart.", "err", err)
+	}
+	if err := ensureTTLIndex(collection, "expires_at"); err != nil {
+		slog.Error("Failed to create TTL index on URL collection, so expired URLs won't be deleted.", "err", err)
+	}
+	if err := ensureSortIndexes(collection, "created_at", "times_visited"); err != nil {
+		slog.Error("Failed to create sort indexes on URL collection, so listing URLs will be slow.", "err", err)
+	}
+	if err := ensureSparseIndex(collection, "webhook.url"); err != nil {
+		slog.Error("Failed to create webhook index on URL collection, so loading webhooks will be slow.", "err", err)
+	}
+	if err := ensureSparseIndex(collection, "visit_limit"); err != nil {
+		slog.Error("Failed to create visit limit index on URL collection, so loading visit limits will be slow.", "err", err)
+	}
+	return &mongoURLStore{
+		collection: collection,
+		reads: forReads(collection),
+		counters: db.Collection(getEnv("COLLECTION_C", "counters")),
 	}
 }
 
 
 // Takes a pre-verified URL, creates a short URL for it,
 // and inserts both into the database.
-// Returns a JSON object containing both, e.g.: 
+// Returns a receipt containing both, e.g.: 
 // { original_url: "https://freeCodeCamp.org",
-//      short_url: 1 }
-func insertURL(newURL string) []byte {
-	funcName := "insertURL"
+//      short_url: "aZ3kQ9x" }
+func (store *mongoURLStore) InsertURL(ctx context.Context, newURL NewURL) (urlReceipt, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	logger := loggerFrom(ctx)
+	funcName := "InsertURL"
+
+	for attempt := 1; ; attempt++ {
+		shortURL := newURL.Alias
+		if len(shortURL) == 0 {
+			var err error
+			if shortURL, err = store.newShortURL(ctx); err != nil {
+				return urlReceipt{}, err
+			}
+		}
+
+		// Now add the new record to the database.
+		newDoc := urlDBRecord{
+			OriginalURL: newURL.OriginalURL,
+			ShortURL: shortURL,
+			TimesVisited: 0,
+			CreatedAt: time.Now().UTC(),
+			ExpiresAt: newURL.ExpiresAt,
+			ProbeStatus: newURL.ProbeStatus,
+			ProbedAt: newURL.ProbedAt,
+			Owner: newURL.Owner,
+			RedirectStatus: newURL.RedirectStatus,
+		}
+		logger.Debug("Attempting to add URL record to the database.", "record", newDoc)
+		// Inserting again is harmless, as the unique index turns it into a duplicate
+		var insertResult *mongo.InsertOneResult
+		err := retryDB(ctx, funcName, true, func() error {
+			var err error
+			insertResult, err = store.collection.InsertOne(ctx, newDoc)
+			return err
+		})
+
+		// Check whether the insert operation was successful
+		if err != nil && mongo.IsDuplicateKeyError(err) {
+			// This URL is already in the database, so find its record
+			var oldDoc urlDBRecord
+			err = retryDB(ctx, funcName, true, func() error {
+				return store.collection.FindOne(ctx, bson.M{"original_url":newURL.OriginalURL}).Decode(&oldDoc)
+			})
+			if err == mongo.ErrNoDocuments {
+				// It was the short URL that clashed, e.g. because another URL was given
+				// the same random one, or the sequential one was imported
+				if attempt < maxShortURLAttempts && len(newURL.Alias) == 0 {
+					logger.Debug("Short URL is taken, so trying another.", "short_url", shortURL)
+					continue
+				}
+				return urlReceipt{}, newStoreError(ErrDuplicate, "short url " + shortURL + " is already taken")
+			} else if err != nil {
+				logger.Error("Collection.FindOne failed", "func", funcName, "err", err)
+				return urlReceipt{}, newStoreError(ErrStorage, "failed when finding duplicate url")
+			}
+			if isExpired(oldDoc.ExpiresAt) {
+				// MongoDB hasn't removed it yet, so do that now and shorten the URL afresh
+				err = retryDB(ctx, funcName, true, func() error {
+					_, err := store.collection.DeleteOne(ctx, bson.M{"_id": oldDoc.ID})
+					return err
+				})
+				if err != nil {
+					logger.Error("Collection.DeleteOne failed", "func", funcName, "err", err)
+					return urlReceipt{}, newStoreError(ErrStorage, "failed when deleting expired url")
+				}
+				continue
+			}
+			logger.Debug("Duplicate URL.", "short_url", oldDoc.ShortURL)
+			if len(newURL.Alias) > 0 && oldDoc.ShortURL != newURL.Alias {
+				return urlReceipt{}, newStoreError(ErrDuplicate, "url already has the short url " + oldDoc.ShortURL)
+			}
+			if err := oldDoc.checkDuplicate(newURL); err != nil {
+				return urlReceipt{}, err
+			}
+			return oldDoc.receipt(), nil
+		} else if err != nil {
+			// Handle any other errors that may have occurred
+			logger.Error("Collection.InsertOne failed", "func", funcName, "err", err)
+			return urlReceipt{}, newStoreError(ErrStorage, "failed when inserting into database")
+		}
 
-	// Get the current size of the database
-	dbSize, err := urlCollection.CountDocuments(context.TODO(), bson.D{})
+		logger.Info("New URL document inserted.", "id", insertResult.InsertedID)
+
+		// Finally, return a receipt showing original and short URLs
+		return newDoc.receipt(), nil
+	}
+}
+
+
+// Stores several URLs with a single bulk insert, returning a receipt for each
+// and an error for each that is nil if it was stored. As with InsertURL,
+// URLs that were already stored get their existing receipts, unless they were asked for differently.
+func (store *mongoURLStore) InsertURLs(ctx context.Context, newURLs []NewURL) ([]urlReceipt, []error, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	logger := loggerFrom(ctx)
+	funcName := "InsertURLs"
+	receipts := make([]urlReceipt, len(newURLs))
+	results := make([]error, len(newURLs))
+
+	// Find the URLs that have short URLs already
+	originals := make(bson.A, len(newURLs))
+	for i, newURL := range newURLs {
+		originals[i] = newURL.OriginalURL
+	}
+	var existing []urlDBRecord
+	err := retryDB(ctx, funcName, true, func() error {
+		cursor, err := store.collection.Find(ctx, bson.M{"original_url": bson.M{"$in": originals}})
+		if err != nil {
+			return err
+		}
+		existing = nil
+		return cursor.All(ctx, &existing)
+	})
 	if err != nil {
-		log.Printf("Error in %s with Collection.CountDocuments: %s\n", funcName, err)
-		errMsg := ErrorMessage{Content: "failed when counting database"}
-		errMsgJSON, err := json.Marshal(errMsg)
+		logger.Error("Collection.Find failed", "func", funcName, "err", err)
+		return nil, nil, newStoreError(ErrStorage, "failed when searching database")
+	}
+	found := make(map[string]urlDBRecord, len(existing))
+	var expiredIDs bson.A
+	for _, record := range existing {
+		if isExpired(record.ExpiresAt) {
+			expiredIDs = append(expiredIDs, record.ID)
+		} else {
+			found[record.OriginalURL] = record
+		}
+	}
+	if len(expiredIDs) > 0 {
+		// MongoDB hasn't removed them yet, so do that now and shorten the URLs afresh
+		err = retryDB(ctx, funcName, true, func() error {
+			_, err := store.collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": expiredIDs}})
+			return err
+		})
 		if err != nil {
-			log.Printf("Error in %s with json.Marshal: %s\n", funcName, err)
+			logger.Error("Collection.DeleteMany failed", "func", funcName, "err", err)
+			return nil, nil, newStoreError(ErrStorage, "failed when deleting expired url")
 		}
-		return errMsgJSON
 	}
-	// Now convert the database size to base 36.
-	// This value will serve as the short URL.
-	shortURL := strconv.FormatInt(dbSize, 36)
 
-	// Now add the new record to the database.
-	newDoc := urlDBRecord{
-		OriginalURL: newURL,
-		ShortURL: shortURL,
-		TimesVisited: 0,
+	// Insert each of the other URLs once, even if it was given more than once
+	var docs []urlDBRecord
+	var docIndexes []int
+	firstIndex := make(map[string]int)
+	for i, newURL := range newURLs {
+		if record, ok := found[newURL.OriginalURL]; ok {
+			if results[i] = record.checkDuplicate(newURL); results[i] == nil {
+				receipts[i] = record.receipt()
+			}
+		} else if _, ok := firstIndex[newURL.OriginalURL]; !ok {
+			firstIndex[newURL.OriginalURL] = i
+			docs = append(docs, urlDBRecord{
+				OriginalURL: newURL.OriginalURL,
+				CreatedAt: time.Now().UTC(),
+				ExpiresAt: newURL.ExpiresAt,
+				ProbeStatus: newURL.ProbeStatus,
+				ProbedAt: newURL.ProbedAt,
+				Owner: newURL.Owner,
+				RedirectStatus: newURL.RedirectStatus,
+			})
+			docIndexes = append(docIndexes, i)
+		}
 	}
-	log.Printf("Attempting to add this record to the database:\n%+v\n", newDoc)
-	insertResult, err := urlCollection.InsertOne(context.TODO(), newDoc)
-
-	// Check whether the insert operation was successful
-	if err != nil && mongo.IsDuplicateKeyError(err) {
-		// This URL is already in the database, so find its record
-		var oldDoc urlReceipt
-		err = urlCollection.FindOne(context.TODO(), bson.M{"original_url":newURL}).Decode(&oldDoc)
+	if len(docs) > 0 {
+		shortURLs, err := store.newShortURLs(ctx, len(docs))
 		if err != nil {
-			log.Printf("Error in %s with Collection.FindOne: %s\n", funcName, err)
+			return nil, nil, err
 		}
-		log.Printf("Duplicate URL. Short URL: %s\n", oldDoc.ShortURL)
-		// Convert it to JSON and return it
-		oldDocJSON, err := json.Marshal(oldDoc)
+		models := make([]any, len(docs))
+		for j := range docs {
+			docs[j].ShortURL = shortURLs[j]
+			models[j] = docs[j]
+		}
+		// Inserting again is harmless, as the unique indexes turn the repeats into duplicates
+		err = retryDB(ctx, funcName, true, func() error {
+			_, err := store.collection.InsertMany(ctx, models, options.InsertMany().SetOrdered(false))
+			return err
+		})
+		writeErrs, err := bulkWriteErrors(ctx, funcName, err, len(docs), func(int, string) error {
+			return newStoreError(ErrDuplicate, "duplicate")
+		})
 		if err != nil {
-			log.Printf("Error in %s with json.Marshal: %s\n", funcName, err)
+			return nil, nil, err
+		}
+		for j, doc := range docs {
+			i := docIndexes[j]
+			switch {
+			case writeErrs[j] == nil:
+				receipts[i] = doc.receipt()
+			case errors.Is(writeErrs[j], ErrDuplicate):
+				// Either the short URL clashed or the URL was stored in the meantime,
+				// both of which InsertURL sorts out
+				receipts[i], results[i] = store.InsertURL(ctx, newURLs[i])
+			default:
+				results[i] = writeErrs[j]
+			}
+		}
+		logger.Info("New URL documents inserted.", "count", len(docs))
+	}
+
+	// The URLs given more than once get the same receipt each time
+	for i, newURL := range newURLs {
+		if first, ok := firstIndex[newURL.OriginalURL]; ok && first != i {
+			receipts[i], results[i] = receipts[first], results[first]
 		}
-		return oldDocJSON
+	}
+	return receipts, results, nil
+}
+
+
+// Returns a random short URL, or, if SHORT_URL_STYLE is "sequential",
+// the next value of the counter in base 36.
+func (store *mongoURLStore) newShortURL(ctx context.Context) (string, error) {
+	shortURLs, err := store.newShortURLs(ctx, 1)
+	if err != nil {
+		return "", err
+	}
+	return shortURLs[0], nil
+}
+
+
+// Returns n random short URLs, or, if SHORT_URL_STYLE is "sequential",
+// the next n values of the counter in base 36, skipping any that are reserved.
+// The counter is incremented atomically, so concurrent inserts can't get the same ones.
+func (store *mongoURLStore) newShortURLs(ctx context.Context, n int) ([]string, error) {
+	shortURLs := make([]string, 0, n)
+	for len(shortURLs) < n {
+		if !sequentialShortURLs {
+			if shortURL := randomShortURL(); !isReservedShortURL(shortURL) {
+				shortURLs = append(shortURLs, shortURL)
+			}
+			continue
+		}
+
+		count := n - len(shortURLs)
+		var counter counterRecord
+		opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+		// Repeating the $inc would only skip some numbers, but isn't idempotent either
+		err := retryDB(ctx, "InsertURL", false, func() error {
+			return store.counters.FindOneAndUpdate(ctx,
+				bson.M{"_id": shortURLCounterID},
+				bson.M{"$inc": bson.M{"seq": count}}, opts).Decode(&counter)
+		})
+		if err != nil {
+			loggerFrom(ctx).Error("Collection.FindOneAndUpdate failed", "func", "InsertURL", "err", err)
+			return nil, newStoreError(ErrStorage, "failed when updating counter")
+		}
+		// The counter starts at 1, but short URLs start at 0 as they did before it existed
+		for i := range count {
+			if shortURL := strconv.FormatInt(counter.Seq-int64(count)+int64(i), 36); !isReservedShortURL(shortURL) {
+				shortURLs = append(shortURLs, shortURL)
+			}
+		}
+	}
+	return shortURLs, nil
+}
+
+
+// Search for a short URL and return its corresponding original URL
+// along with the status code to redirect with.
+func (store *mongoURLStore) ResolveURL(ctx context.Context, sURL string) (string, int, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	logger := loggerFrom(ctx)
+	logger.Debug("Attempting to retrieve original URL.", "short_url", sURL)
+	funcName := "ResolveURL"
+
+	// Execute the search for the URL
+	var foundDoc urlDBRecord
+	err := retryDB(ctx, funcName, true, func() error {
+		return store.reads.FindOne(ctx, bson.M{"short_url": sURL}).Decode(&foundDoc)
+	})
+	if err == mongo.ErrNoDocuments {
+		return "", 0, newStoreError(ErrNotFound, "no such short url")
+	} else if err == nil && isExpired(foundDoc.ExpiresAt) {
+		// MongoDB only removes expired documents about once a minute
+		return "", 0, newStoreError(ErrExpired, "short url has expired")
+	} else if err == nil && foundDoc.Disabled != nil {
+		return "", 0, disabledLinkError(foundDoc.Disabled)
+	} else if err != nil {
+		logger.Error("Collection.FindOne failed", "func", funcName, "err", err)
+		return "", 0, newStoreError(ErrStorage, "failed when searching database")
+	}
+
+	//logger.Debug("Found document.", "doc", foundDoc)
+
+	return withUTMParams(foundDoc.OriginalURL, foundDoc.UTM), foundDoc.RedirectStatus, nil
+}
+
+
+// Search for a short URL and return what is stored about it,
+// without incrementing its "times_visited" parameter.
+func (store *mongoURLStore) LookupURL(ctx context.Context, sURL string) (StoredURL, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	var foundDoc urlDBRecord
+	err := retryDB(ctx, "LookupURL", true, func() error {
+		return store.reads.FindOne(ctx, bson.M{"short_url": sURL}).Decode(&foundDoc)
+	})
+	if err == mongo.ErrNoDocuments {
+		return StoredURL{}, newStoreError(ErrNotFound, "no such short url")
+	} else if err == nil && isExpired(foundDoc.ExpiresAt) {
+		return StoredURL{}, newStoreError(ErrExpired, "short url has expired")
 	} else if err != nil {
-		// Handle any other errors that may have occurred
-		log.Printf("Error in %s with Collection.InsertOne: %s\n", funcName, err)
-		errMsg := ErrorMessage{Content: "failed when inserting into database"}
-		errMsgJSON, err := json.Marshal(errMsg)
+		loggerFrom(ctx).Error("Collection.FindOne failed", "func", "LookupURL", "err", err)
+		return StoredURL{}, newStoreError(ErrStorage, "failed when searching database")
+	}
+	return foundDoc.stored(), nil
+}
+
+
+// Sets or, given a zero time, clears the time at which a short URL expires.
+func (store *mongoURLStore) SetURLExpiry(ctx context.Context, sURL string, expiresAt time.Time) error {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	update := bson.M{"$inc": bson.M{"version": 1}}
+	if expiresAt.IsZero() {
+		update["$unset"] = bson.M{"expires_at": ""}
+	} else {
+		update["$set"] = bson.M{"expires_at": expiresAt}
+	}
+
+	var result *mongo.UpdateResult
+	err := retryDB(ctx, "SetURLExpiry", false, func() error {
+		var err error
+		result, err = store.collection.UpdateOne(ctx, bson.M{"short_url": sURL}, update)
+		return err
+	})
+	if err != nil {
+		loggerFrom(ctx).Error("Collection.UpdateOne failed", "func", "SetURLExpiry", "err", err)
+		return newStoreError(ErrStorage, "failed when updating database")
+	}
+	if result.MatchedCount == 0 {
+		return newStoreError(ErrNotFound, "no such short url")
+	}
+	return nil
+}
+
+
+// Sets or, given nil, removes the campaign parameters added to visits to a short URL.
+func (store *mongoURLStore) SetURLUTM(ctx context.Context, sURL string, utm *UTMParams) error {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	update := bson.M{"$inc": bson.M{"version": 1}}
+	if utm == nil {
+		update["$unset"] = bson.M{"utm": ""}
+	} else {
+		update["$set"] = bson.M{"utm": utm}
+	}
+
+	var result *mongo.UpdateResult
+	err := retryDB(ctx, "SetURLUTM", false, func() error {
+		var err error
+		result, err = store.collection.UpdateOne(ctx, bson.M{"short_url": sURL}, update)
+		return err
+	})
+	if err != nil {
+		loggerFrom(ctx).Error("Collection.UpdateOne failed", "func", "SetURLUTM", "err", err)
+		return newStoreError(ErrStorage, "failed when updating database")
+	}
+	if result.MatchedCount == 0 {
+		return newStoreError(ErrNotFound, "no such short url")
+	}
+	return nil
+}
+
+
+// Sets or, given nil, removes the webhook that visits to a short URL are reported to.
+func (store *mongoURLStore) SetURLWebhook(ctx context.Context, sURL string, webhook *Webhook) error {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	update := bson.M{"$unset": bson.M{"webhook": ""}}
+	if webhook != nil {
+		update = bson.M{"$set": bson.M{"webhook": webhook}}
+	}
+
+	var result *mongo.UpdateResult
+	err := retryDB(ctx, "SetURLWebhook", true, func() error {
+		var err error
+		result, err = store.collection.UpdateOne(ctx, bson.M{"short_url": sURL}, update)
+		return err
+	})
+	if err != nil {
+		loggerFrom(ctx).Error("Collection.UpdateOne failed", "func", "SetURLWebhook", "err", err)
+		return newStoreError(ErrStorage, "failed when updating database")
+	}
+	if result.MatchedCount == 0 {
+		return newStoreError(ErrNotFound, "no such short url")
+	}
+	return nil
+}
+
+
+// Returns the webhooks of the short URLs that have one, which the sparse index finds
+// without scanning the whole collection.
+func (store *mongoURLStore) GetURLWebhooks(ctx context.Context) (map[string]Webhook, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	logger := loggerFrom(ctx)
+	funcName := "GetURLWebhooks"
+
+	filter := bson.M{"webhook.url": bson.M{"$exists": true}}
+	opts := options.Find().SetProjection(bson.M{"short_url": 1, "expires_at": 1, "webhook": 1})
+	var records []urlDBRecord
+	err := retryDB(ctx, funcName, true, func() error {
+		cursor, err := store.reads.Find(ctx, filter, opts)
 		if err != nil {
-			log.Printf("Error in %s with json.Marshal: %s\n", funcName, err)
+			return err
+		}
+		return cursor.All(ctx, &records)
+	})
+	if err != nil {
+		logger.Error("Collection.Find failed", "func", funcName, "err", err)
+		return nil, newStoreError(ErrStorage, "failed when reading from database")
+	}
+	webhooks := make(map[string]Webhook, len(records))
+	for _, record := range records {
+		if record.Webhook != nil && !isExpired(record.ExpiresAt) {
+			webhooks[record.ShortURL] = *record.Webhook
 		}
-		return errMsgJSON
 	}
+	return webhooks, nil
+}
 
-	log.Printf("New URL document inserted: %v\n", insertResult.InsertedID)
+// Stops a short URL from redirecting, recording why, or lets it redirect again given nil.
+func (store *mongoURLStore) SetURLDisabled(ctx context.Context, sURL string, disabled *LinkDisabled) error {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	update := bson.M{"$unset": bson.M{"disabled": ""}}
+	if disabled != nil {
+		update = bson.M{"$set": bson.M{"disabled": disabled}}
+	}
 
-	// Finally, return JSON object showing original and short URLs
-	receipt := urlReceipt{
-		OriginalURL: newURL,
-		ShortURL: shortURL,
+	var result *mongo.UpdateResult
+	err := retryDB(ctx, "SetURLDisabled", true, func() error {
+		var err error
+		result, err = store.collection.UpdateOne(ctx, bson.M{"short_url": sURL}, update)
+		return err
+	})
+	if err != nil {
+		loggerFrom(ctx).Error("Collection.UpdateOne failed", "func", "SetURLDisabled", "err", err)
+		return newStoreError(ErrStorage, "failed when updating database")
+	}
+	if result.MatchedCount == 0 {
+		return newStoreError(ErrNotFound, "no such short url")
 	}
-	receiptJSON, err := json.Marshal(receipt)
+	return nil
+}
+
+
+// Sets how many visits a minute a short URL may have before it is disabled,
+// or, given 0, removes its own limit so that it has the default.
+func (store *mongoURLStore) SetURLVisitLimit(ctx context.Context, sURL string, limit int64) error {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	update := bson.M{"$unset": bson.M{"visit_limit": ""}}
+	if limit > 0 {
+		update = bson.M{"$set": bson.M{"visit_limit": limit}}
+	}
+
+	var result *mongo.UpdateResult
+	err := retryDB(ctx, "SetURLVisitLimit", true, func() error {
+		var err error
+		result, err = store.collection.UpdateOne(ctx, bson.M{"short_url": sURL}, update)
+		return err
+	})
 	if err != nil {
-		log.Printf("Error in %s with json.Marshal: %s\n", funcName, err)
-		errMsg := ErrorMessage{Content: "failed when marshaling to JSON"}
-		errMsgJSON, err := json.Marshal(errMsg)
+		loggerFrom(ctx).Error("Collection.UpdateOne failed", "func", "SetURLVisitLimit", "err", err)
+		return newStoreError(ErrStorage, "failed when updating database")
+	}
+	if result.MatchedCount == 0 {
+		return newStoreError(ErrNotFound, "no such short url")
+	}
+	return nil
+}
+
+
+// Returns the visit limits of the short URLs that have their own, which the sparse index
+// finds without scanning the whole collection.
+func (store *mongoURLStore) GetURLVisitLimits(ctx context.Context) (map[string]int64, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	logger := loggerFrom(ctx)
+	funcName := "GetURLVisitLimits"
+
+	filter := bson.M{"visit_limit": bson.M{"$exists": true}}
+	opts := options.Find().SetProjection(bson.M{"short_url": 1, "expires_at": 1, "visit_limit": 1})
+	var records []urlDBRecord
+	err := retryDB(ctx, funcName, true, func() error {
+		cursor, err := store.reads.Find(ctx, filter, opts)
 		if err != nil {
-			log.Printf("Error in %s with json.Marshal: %s\n", funcName, err)
+			return err
+		}
+		return cursor.All(ctx, &records)
+	})
+	if err != nil {
+		logger.Error("Collection.Find failed", "func", funcName, "err", err)
+		return nil, newStoreError(ErrStorage, "failed when reading from database")
+	}
+	limits := make(map[string]int64, len(records))
+	for _, record := range records {
+		if record.VisitLimit > 0 && !isExpired(record.ExpiresAt) {
+			limits[record.ShortURL] = record.VisitLimit
 		}
-		return errMsgJSON
 	}
-	return receiptJSON
+	return limits, nil
 }
 
 
-// Search for a short URL and return its corresponding original URL.
-func getOriginalURL(sURL string) string {
-	log.Printf("Attempting to retrieve original URL for: %s\n", sURL)
-	funcName := "getOriginalURL"
 
-	// Execute the search for the URL
-	var foundDoc urlDBRecord
-	err := urlCollection.FindOne(context.TODO(), bson.M{"short_url": sURL}).Decode(&foundDoc)
+// Points a short URL at a different original URL and returns the updated record.
+func (store *mongoURLStore) UpdateURL(ctx context.Context, sURL string, newURL string, version int64) (URLExport, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	logger := loggerFrom(ctx)
+	funcName := "UpdateURL"
+
+	filter := bson.M{"short_url": sURL}
+	if version != anyVersion {
+		filter["version"] = version
+	}
+	update := bson.M{"$set": bson.M{"original_url": newURL}, "$inc": bson.M{"version": 1}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	// Not idempotent, as a repeat after a lost reply would look like a conflict
+	var updatedDoc urlDBRecord
+	err := retryDB(ctx, funcName, false, func() error {
+		return store.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updatedDoc)
+	})
+	if err != nil && mongo.IsDuplicateKeyError(err) {
+		return URLExport{}, newStoreError(ErrDuplicate, "original url " + newURL + " already has a short url")
+	} else if err == mongo.ErrNoDocuments && version == anyVersion {
+		return URLExport{}, newStoreError(ErrNotFound, "no such short url")
+	} else if err == mongo.ErrNoDocuments {
+		// Either the short URL is gone or its version has moved on
+		var count int64
+		err = retryDB(ctx, funcName, true, func() error {
+			var err error
+			count, err = store.collection.CountDocuments(ctx, bson.M{"short_url": sURL})
+			return err
+		})
+		if err != nil {
+			logger.Error("Collection.CountDocuments failed", "func", funcName, "err", err)
+			return URLExport{}, newStoreError(ErrStorage, "failed when searching database")
+		} else if count == 0 {
+			return URLExport{}, newStoreError(ErrNotFound, "no such short url")
+		}
+		return URLExport{}, newVersionConflict(version)
+	} else if err != nil {
+		logger.Error("Collection.FindOneAndUpdate failed", "func", funcName, "err", err)
+		return URLExport{}, newStoreError(ErrStorage, "failed when updating database")
+	}
+	logger.Info("URL updated.", "short_url", sURL, "original_url", newURL)
+	return exportURL(updatedDoc), nil
+}
+
+
+// Increment the "times_visited" parameter of each short URL by its number of visits.
+func (store *mongoURLStore) AddVisits(ctx context.Context, visits map[string]int64) error {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	if len(visits) == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	models := make([]mongo.WriteModel, 0, len(visits))
+	for sURL, count := range visits {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"short_url": sURL}).
+			SetUpdate(bson.M{"$inc": bson.M{"times_visited": count}, "$set": bson.M{"last_visited_at": now}}))
+	}
+	err := retryDB(ctx, "AddVisits", false, func() error {
+		_, err := store.collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		return err
+	})
 	if err != nil {
-		log.Printf("Error in %s with Collection.FindOne: %s\n", funcName, err)
-		return ""
+		loggerFrom(ctx).Error("Collection.BulkWrite failed", "func", "AddVisits", "err", err)
+		return newStoreError(ErrStorage, "failed when updating database")
 	}
+	return nil
+}
 
-	//log.Printf("Found document: %+v\n", foundDoc)
 
-	// Increment this URL's "times_visited" parameter
-	filter := bson.M{"_id": foundDoc.ID}
-	command := bson.M{"$inc": bson.M{"times_visited": 1}}
-	//result, err := urlCollection.UpdateOne(context.TODO(), filter, command)
-	_, err = urlCollection.UpdateOne(context.TODO(), filter, command)
+// Returns the number of short URLs in the database.
+func (store *mongoURLStore) CountURLs(ctx context.Context) (int64, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	var count int64
+	err := retryDB(ctx, "CountURLs", true, func() error {
+		var err error
+		count, err = store.collection.CountDocuments(ctx, bson.D{})
+		return err
+	})
 	if err != nil {
-		log.Printf("Error in %s with Collection.UpdateOne: %s\n", funcName, err)
-	} else {
-		log.Println("Successfully incremented its times_visited counter.")
-		//log.Printf("matched = %d, modified = %d", result.MatchedCount, result.ModifiedCount)
+		loggerFrom(ctx).Error("Collection.CountDocuments failed", "func", "CountURLs", "err", err)
+		return 0, newStoreError(ErrStorage, "failed when counting database")
 	}
+	return count, nil
+}
+
+
+// Deletes the record of a short URL and returns it.
+func (store *mongoURLStore) DeleteURL(ctx context.Context, sURL string) (URLExport, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	logger := loggerFrom(ctx)
+	logger.Debug("Attempting to delete URL.", "short_url", sURL)
 
-	return foundDoc.OriginalURL
+	var deletedDoc urlDBRecord
+	err := retryDB(ctx, "DeleteURL", false, func() error {
+		return store.collection.FindOneAndDelete(ctx, bson.M{"short_url": sURL}).Decode(&deletedDoc)
+	})
+	if err == mongo.ErrNoDocuments {
+		return URLExport{}, newStoreError(ErrNotFound, "no such short url")
+	} else if err != nil {
+		logger.Error("Collection.FindOneAndDelete failed", "func", "DeleteURL", "err", err)
+		return URLExport{}, newStoreError(ErrStorage, "failed when deleting from database")
+	}
+	logger.Info("URL deleted.", "short_url", sURL)
+	return exportURL(deletedDoc), nil
+}
+
+
+// Returns a page of the short URLs that haven't expired, sorted as the query asks,
+// with ties broken by the order in which they were created.
+func (store *mongoURLStore) ListURLs(ctx context.Context, query URLListQuery) ([]StoredURL, int64, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	logger := loggerFrom(ctx)
+	funcName := "ListURLs"
+	// MongoDB only removes expired documents about once a minute
+	filter := bson.M{"$or": bson.A{
+		bson.M{"expires_at": bson.M{"$exists": false}},
+		bson.M{"expires_at": bson.M{"$gt": time.Now()}},
+	}}
+	if !query.AnyOwner {
+		// Null also matches short URLs that have no owner
+		filter["owner"] = bson.M{"$in": bson.A{query.Owner, nil}}
+	}
+	direction := -1
+	if query.Ascending {
+		direction = 1
+	}
+	field := "created_at"
+	if query.Sort == sortURLsByVisits {
+		field = "times_visited"
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: field, Value: direction}, {Key: "_id", Value: direction}}).
+		SetSkip(query.Offset).
+		SetLimit(query.Limit)
+
+	var total int64
+	var records []urlDBRecord
+	err := retryDB(ctx, funcName, true, func() error {
+		var err error
+		total, err = store.reads.CountDocuments(ctx, filter)
+		if err != nil {
+			return err
+		}
+		cursor, err := store.reads.Find(ctx, filter, opts)
+		if err != nil {
+			return err
+		}
+		records = nil
+		return cursor.All(ctx, &records)
+	})
+	if err != nil {
+		logger.Error("Collection.Find failed", "func", funcName, "err", err)
+		return nil, 0, newStoreError(ErrStorage, "failed when searching database")
+	}
+	urls := make([]StoredURL, len(records))
+	for i, record := range records {
+		urls[i] = record.stored()
+	}
+	return urls, total, nil
+}
+
+
+// Passes every short URL to fn, in the order in which they were created.
+// The export can take as long as it needs, so it isn't subject to DB_OP_TIMEOUT,
+// and it isn't retried, as fn may already have been given some of the URLs.
+func (store *mongoURLStore) ExportURLs(ctx context.Context, fn func(URLExport) error) error {
+	logger := loggerFrom(ctx)
+	cursor, err := store.reads.Find(ctx, bson.D{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		logger.Error("Collection.Find failed", "func", "ExportURLs", "err", err)
+		return newStoreError(ErrStorage, "failed when reading from database")
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var record urlDBRecord
+		if err := cursor.Decode(&record); err != nil {
+			logger.Error("Cursor.Decode failed", "func", "ExportURLs", "err", err)
+			return newStoreError(ErrStorage, "failed when reading from database")
+		}
+		if err := fn(exportURL(record)); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		logger.Error("Cursor.Next failed", "func", "ExportURLs", "err", err)
+		return newStoreError(ErrStorage, "failed when reading from database")
+	}
+	return nil
+}
+
+
+// Restores exported short URLs in a single unordered bulk write,
+// so that a URL that clashes doesn't stop the others.
+// Repeating it could turn URLs that were imported into duplicates, so it is only retried if rejected.
+func (store *mongoURLStore) ImportURLs(ctx context.Context, urls []URLExport, replace bool) ([]error, error) {
+	ctx, cancel := withDBTimeout(ctx)
+	defer cancel()
+	funcName := "ImportURLs"
+
+	models := make([]mongo.WriteModel, 0, len(urls))
+	for _, u := range urls {
+		doc := importURL(u)
+		if replace {
+			models = append(models, mongo.NewReplaceOneModel().
+				SetFilter(bson.M{"short_url": u.ShortURL}).
+				SetReplacement(doc).
+				SetUpsert(true))
+		} else {
+			models = append(models, mongo.NewInsertOneModel().SetDocument(doc))
+		}
+	}
+	err := retryDB(ctx, funcName, false, func() error {
+		_, err := store.collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		return err
+	})
+	if sequentialShortURLs {
+		store.advanceCounter(ctx, urls)
+	}
+	return bulkWriteErrors(ctx, funcName, err, len(urls), func(i int, message string) error {
+		if strings.Contains(message, "original_url") {
+			return newStoreError(ErrDuplicate, "original url " + urls[i].OriginalURL + " already has a short url")
+		}
+		return newStoreError(ErrDuplicate, "short url " + urls[i].ShortURL + " already exists")
+	})
 }
 
+
+// Moves the counter behind sequential short URLs past any of the given short URLs
+// that look like sequential ones, so that InsertURL doesn't hand them out again.
+// A failure is only logged, as InsertURL skips short URLs that are taken anyway.
+// There is nothing to do unless SHORT_URL_STYLE is "sequential".
+func (store *mongoURLStore) advanceCounter(ctx context.Context, urls []URLExport) {
+	if !sequentialShortURLs {
+		return
+	}
+	var highest int64 = -1
+	for _, u := range urls {
+		highest = highestSequentialShortURL(highest, u.ShortURL)
+	}
+	if highest < 0 {
+		return
+	}
+	// $max makes this idempotent
+	err := retryDB(ctx, "ImportURLs", true, func() error {
+		_, err := store.counters.UpdateOne(ctx,
+			bson.M{"_id": shortURLCounterID},
+			bson.M{"$max": bson.M{"seq": highest + 1}},
+			options.Update().SetUpsert(true))
+		return err
+	})
+	if err != nil {
+		loggerFrom(ctx).Error("Collection.UpdateOne failed", "func", "ImportURLs", "err", err)
+	}
+}
+
+
+// Deletes the short URLs that haven't been visited since the cutoff,
+// or that were created before it and never visited, and returns them.
+// It can take as long as it needs, so it isn't subject to DB_OP_TIMEOUT.
+func (store *mongoURLStore) PruneURLs(ctx context.Context, cutoff time.Time, dryRun bool) ([]string, error) {
+	logger := loggerFrom(ctx)
+	funcName := "PruneURLs"
+	filter := bson.M{"$or": bson.A{
+		bson.M{"last_visited_at": bson.M{"$lt": cutoff}},
+		bson.M{"last_visited_at": bson.M{"$exists": false}, "created_at": bson.M{"$lt": cutoff}},
+	}}
+
+	var records []urlDBRecord
+	err := retryDB(ctx, funcName, true, func() error {
+		cursor, err := store.collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"short_url": 1}))
+		if err != nil {
+			return err
+		}
+		records = nil
+		return cursor.All(ctx, &records)
+	})
+	if err != nil {
+		logger.Error("Collection.Find failed", "func", funcName, "err", err)
+		return nil, newStoreError(ErrStorage, "failed when searching database")
+	}
+	pruned := make([]string, len(records))
+	for i, record := range records {
+		pruned[i] = record.ShortURL
+	}
+	if dryRun || len(pruned) == 0 {
+		return pruned, nil
+	}
+
+	// Check the filter again, in case any were visited in the meantime
+	err = retryDB(ctx, funcName, true, func() error {
+		_, err := store.collection.DeleteMany(ctx, bson.M{"$and": bson.A{filter, bson.M{"short_url": bson.M{"$in": pruned}}}})
+		return err
+	})
+	if err != nil {
+		logger.Error("Collection.DeleteMany failed", "func", funcName, "err", err)
+		return nil, newStoreError(ErrStorage, "failed when deleting from database")
+	}
+	return pruned, nil
+}
@@ -0,0 +1,144 @@
+// Bounds how long each database operation may take,
+// and logs the MongoDB commands that take longer than expected.
+package main
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/event"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long a single database operation may take, from DB_OP_TIMEOUT.
+var dbOpTimeout time.Duration
+
+// Commands that take longer than this are logged, unless it is 0.
+var slowQueryThreshold time.Duration
+
+// How long MongoDB may spend on an exercise log searched by description, from DB_SEARCH_MAX_TIME.
+// MongoDB runs the patterns clients send with its own regular expression engine,
+// which can take far longer than Go's on some of them.
+var searchMaxTime time.Duration
+
+// The code MongoDB fails a command with when it runs longer than its maxTimeMS.
+const maxTimeMSExpiredCode = 50
+
+// The details of each command in flight that are needed to describe it
+// if it turns out to be slow, keyed by the driver's request ID.
+var startedCommands sync.Map
+
+type startedCommand struct {
+	collection string
+	shape      string
+}
+
+
+// Reads DB_OP_TIMEOUT (default 10s), DB_SLOW_QUERY_THRESHOLD (default 500ms)
+// and DB_SEARCH_MAX_TIME (default 2s).
+func initDBTimeouts() {
+	dbOpTimeout = getEnvDuration("DB_OP_TIMEOUT", 10*time.Second)
+	slowQueryThreshold = getEnvDuration("DB_SLOW_QUERY_THRESHOLD", 500*time.Millisecond)
+	searchMaxTime = getEnvDuration("DB_SEARCH_MAX_TIME", 2*time.Second)
+}
+
+
+// Returns a context for one database operation, which is cancelled after DB_OP_TIMEOUT
+// unless the caller's context ends sooner.
+func withDBTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if dbOpTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, dbOpTimeout)
+}
+
+
+// Remembers the collection and filter shape of a command, in case it is slow.
+func commandStarted(e *event.CommandStartedEvent) {
+	if slowQueryThreshold <= 0 {
+		return
+	}
+	elements, err := e.Command.Elements()
+	if err != nil || len(elements) == 0 {
+		return
+	}
+	// The first element names the command and holds the collection, e.g. { find: "urls", ... }
+	collection, _ := elements[0].Value().StringValueOK()
+	startedCommands.Store(e.RequestID, startedCommand{
+		collection: collection,
+		shape:      commandShape(e.Command),
+	})
+}
+
+
+// Logs a command if it took longer than DB_SLOW_QUERY_THRESHOLD.
+func commandFinished(ctx context.Context, e event.CommandFinishedEvent, err error) {
+	if slowQueryThreshold <= 0 {
+		return
+	}
+	value, ok := startedCommands.LoadAndDelete(e.RequestID)
+	duration := time.Duration(e.DurationNanos)
+	if !ok || duration < slowQueryThreshold {
+		return
+	}
+	started := value.(startedCommand)
+	args := []any{
+		"command", e.CommandName,
+		"collection", started.collection,
+		"filter", started.shape,
+		"duration", duration,
+	}
+	if err != nil {
+		args = append(args, "err", err)
+	}
+	loggerFrom(ctx).Warn("Slow database command.", args...)
+}
+
+
+// Returns the shape of the filter or pipeline of a command,
+// i.e. its field names and operators with every value replaced by "?",
+// so that it can be logged without revealing any data.
+func commandShape(command bson.Raw) string {
+	for _, key := range []string{"filter", "query", "pipeline"} {
+		if value, err := command.LookupErr(key); err == nil {
+			return valueShape(value)
+		}
+	}
+	// Updates and deletes hold a list of statements, each with a filter in "q"
+	for _, key := range []string{"updates", "deletes"} {
+		if value, err := command.LookupErr(key, "0", "q"); err == nil {
+			return valueShape(value)
+		}
+	}
+	return ""
+}
+
+
+func valueShape(value bson.RawValue) string {
+	switch value.Type {
+	case bsontype.EmbeddedDocument:
+		elements, err := value.Document().Elements()
+		if err != nil {
+			return "?"
+		}
+		fields := make([]string, 0, len(elements))
+		for _, element := range elements {
+			fields = append(fields, element.Key()+": "+valueShape(element.Value()))
+		}
+		return "{" + strings.Join(fields, ", ") + "}"
+	case bsontype.Array:
+		values, err := value.Array().Values()
+		if err != nil {
+			return "?"
+		}
+		items := make([]string, 0, len(values))
+		for _, item := range values {
+			items = append(items, valueShape(item))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	default:
+		return "?"
+	}
+}
@@ -0,0 +1,103 @@
+// Caching support for the static front-end files.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A previously computed ETag, which stays valid
+// as long as the file's size and modification time don't change.
+type cachedETag struct {
+	modTime time.Time
+	size    int64
+	etag    string
+}
+
+type staticCache struct {
+	dir          string
+	cacheControl string
+	mu           sync.Mutex
+	etags        map[string]cachedETag
+}
+
+
+// Returns middleware for a file server rooted at dir that adds an ETag
+// and the Cache-Control header from STATIC_CACHE_CONTROL (default "no-cache",
+// i.e. browsers must revalidate, which is cheap thanks to the ETag).
+// http.FileServer then answers If-None-Match and If-Modified-Since with 304.
+func newStaticCacheMiddleware(dir string) Middleware {
+	cache := &staticCache{
+		dir:          dir,
+		cacheControl: getEnv("STATIC_CACHE_CONTROL", "no-cache"),
+		etags:        make(map[string]cachedETag),
+	}
+	return cache.middleware
+}
+
+
+func (cache *staticCache) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if etag, ok := cache.etagFor(r.URL.Path); ok {
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Cache-Control", cache.cacheControl)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+
+// Returns the ETag of the file that the URL path refers to,
+// which is its index.html if the path is a directory.
+func (cache *staticCache) etagFor(urlPath string) (string, bool) {
+	name := filepath.Join(cache.dir, filepath.FromSlash(path.Clean("/"+urlPath)))
+	info, err := os.Stat(name)
+	if err == nil && info.IsDir() {
+		name = filepath.Join(name, "index.html")
+		info, err = os.Stat(name)
+	}
+	if err != nil {
+		return "", false
+	}
+
+	cache.mu.Lock()
+	cached, ok := cache.etags[name]
+	cache.mu.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.etag, true
+	}
+
+	etag, err := hashFile(name)
+	if err != nil {
+		slog.Error("Error when hashing static file.", "file", name, "err", err)
+		return "", false
+	}
+	cache.mu.Lock()
+	cache.etags[name] = cachedETag{modTime: info.ModTime(), size: info.Size(), etag: etag}
+	cache.mu.Unlock()
+	return etag, true
+}
+
+
+// Returns a strong ETag derived from the SHA-256 hash of the file's contents.
+func hashFile(name string) (string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`, nil
+}
@@ -0,0 +1,617 @@
+// Defines where the data behind the URL Shortener and Exercise Tracker is kept,
+// so that the storage backend can be chosen at startup.
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The kinds of error that the stores return.
+// The handlers choose the status code to report for each.
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrDuplicate    = errors.New("duplicate")
+	ErrInvalidInput = errors.New("invalid input")
+	// The document was changed by another request since it was read
+	ErrConflict = errors.New("conflict")
+	// The record has expired, but hasn't been deleted yet
+	ErrExpired = errors.New("expired")
+	// The short URL has been disabled, so it no longer redirects
+	ErrDisabled = errors.New("disabled")
+	// The backend failed, e.g. because the database couldn't be reached
+	ErrStorage = errors.New("storage failure")
+)
+
+// An error returned by a store, with a message that is safe to show to clients.
+// errors.Is matches it against its kind, e.g. ErrNotFound.
+type StoreError struct {
+	Kind    error
+	Message string
+}
+
+func (e *StoreError) Error() string {
+	return e.Message
+}
+
+func (e *StoreError) Unwrap() error {
+	return e.Kind
+}
+
+
+// Returns an error of the given kind with a message for clients.
+func newStoreError(kind error, message string) *StoreError {
+	return &StoreError{Kind: kind, Message: message}
+}
+
+
+// The version to give an update that should be made whatever the record's version
+const anyVersion int64 = -1
+
+
+// Returns the error for an update that expected a document to still be at a version
+// that has since been replaced by another request's change.
+func newVersionConflict(expected int64) *StoreError {
+	return newStoreError(ErrConflict, "version " + strconv.FormatInt(expected, 10) + " is out of date, as it was changed by another request")
+}
+
+
+// What is stored about a short URL.
+type StoredURL struct {
+	ShortURL     string
+	OriginalURL  string
+	TimesVisited int
+	// When the short URL stops working, or zero if it never does
+	ExpiresAt    time.Time
+	CreatedAt    time.Time
+	// Zero if the short URL has never been visited
+	LastVisitedAt time.Time
+	// The status code that the URL answered a probe with, or 0 if it couldn't be reached
+	ProbeStatus  int
+	// Zero if the URL wasn't probed
+	ProbedAt     time.Time
+	// Who created the short URL, or "" if nobody was authenticated
+	Owner        string
+	// Where visits are reported, or nil if they aren't
+	Webhook      *Webhook
+	// Added to the original URL when it is visited, or nil if there are none
+	UTM          *UTMParams
+	// The status code of redirects from the short URL, or 0 for the default
+	RedirectStatus int
+	// Why the short URL stopped redirecting, or nil if it still does
+	Disabled     *LinkDisabled
+	// How many visits a minute the short URL may have before it is disabled, or 0 for the default
+	VisitLimit   int64
+}
+
+// A URL to be shortened, along with what is stored about it from the start.
+type NewURL struct {
+	OriginalURL string
+	// When the short URL stops working, or zero if it never does
+	ExpiresAt   time.Time
+	// The status code that the URL answered a probe with, or 0 if it couldn't be reached
+	ProbeStatus int
+	// Zero if the URL wasn't probed
+	ProbedAt    time.Time
+	// Who is creating the short URL, or "" if nobody was authenticated
+	Owner       string
+	// The short URL chosen by the client, or "" for a generated one
+	Alias       string
+	// The status code of redirects from the short URL, or 0 for the default
+	RedirectStatus int
+}
+
+
+// Creates short URLs and looks up the URLs they stand for.
+type URLStore interface {
+	// Stores a URL under a new short URL. If the URL was already stored,
+	// the existing receipt is returned instead, failing with ErrDuplicate if it was
+	// stored with another expiry, redirect status, or owner than the new one asks for.
+	// A new URL with an alias is stored under that, failing with ErrDuplicate if it is
+	// taken or the URL is already stored under another short URL.
+	InsertURL(ctx context.Context, newURL NewURL) (urlReceipt, error)
+	// Stores several URLs at once, as InsertURL does, returning a receipt for each
+	// and an error for each that is nil if it was stored.
+	InsertURLs(ctx context.Context, newURLs []NewURL) ([]urlReceipt, []error, error)
+	// Returns the URL that a visit to a short URL goes to, which is its original URL
+	// with any campaign parameters added, along with the status code to redirect with,
+	// or 0 for the default. The visit isn't counted, so that it can still be refused;
+	// AddVisits counts it.
+	// Fails with ErrExpired if it has expired but hasn't been deleted yet,
+	// and with ErrDisabled, explaining why, if it has been disabled.
+	ResolveURL(ctx context.Context, shortURL string) (string, int, error)
+	// Returns what is stored about a short URL without counting a visit.
+	LookupURL(ctx context.Context, shortURL string) (StoredURL, error)
+	// Adds to the visit counts of several short URLs at once.
+	AddVisits(ctx context.Context, visits map[string]int64) error
+	CountURLs(ctx context.Context) (int64, error)
+	// Returns a page of the short URLs that haven't expired and that the query's owner may see,
+	// along with how many there are in all.
+	ListURLs(ctx context.Context, query URLListQuery) ([]StoredURL, int64, error)
+	// Deletes a short URL, returning everything that was stored about it.
+	DeleteURL(ctx context.Context, shortURL string) (URLExport, error)
+	// Makes a short URL stop working at the given time, after which it is deleted.
+	// A zero time makes it work forever again.
+	SetURLExpiry(ctx context.Context, shortURL string, expiresAt time.Time) error
+	// Points a short URL at a different original URL, keeping its visit history,
+	// provided it is still at the given version or the version is anyVersion.
+	// Fails with ErrDuplicate if the new URL already has a short URL of its own.
+	UpdateURL(ctx context.Context, shortURL string, originalURL string, version int64) (URLExport, error)
+	// Passes every short URL to fn in turn, stopping at the first error it returns.
+	ExportURLs(ctx context.Context, fn func(URLExport) error) error
+	// Restores exported short URLs, returning an error for each of them that is nil if it was restored.
+	// A short URL that already exists fails with ErrDuplicate unless replace is set,
+	// as does one whose original URL already has a different short URL.
+	ImportURLs(ctx context.Context, urls []URLExport, replace bool) ([]error, error)
+	// Sets the campaign parameters added to a short URL's original URL when it is visited,
+	// or stops adding them given nil.
+	SetURLUTM(ctx context.Context, shortURL string, utm *UTMParams) error
+	// Sets where visits to a short URL are reported, or stops reporting them given nil.
+	SetURLWebhook(ctx context.Context, shortURL string, webhook *Webhook) error
+	// Returns the webhook of every short URL that has one and hasn't expired, by short URL.
+	GetURLWebhooks(ctx context.Context) (map[string]Webhook, error)
+	// Stops a short URL from redirecting, recording why, or lets it redirect again given nil.
+	SetURLDisabled(ctx context.Context, shortURL string, disabled *LinkDisabled) error
+	// Sets how many visits a minute a short URL may have before it is disabled, or 0 for the default.
+	SetURLVisitLimit(ctx context.Context, shortURL string, limit int64) error
+	// Returns the visit limit of every short URL that has its own and hasn't expired, by short URL.
+	GetURLVisitLimits(ctx context.Context) (map[string]int64, error)
+	// Deletes the short URLs that haven't been visited since the cutoff,
+	// or that were created before it and never visited, and returns them.
+	// With dryRun, they are only returned.
+	PruneURLs(ctx context.Context, cutoff time.Time, dryRun bool) ([]string, error)
+}
+
+// Keeps exercise users and their logs.
+type ExerciseStore interface {
+	// Creates a user or, if the username is taken, fails with ErrDuplicate
+	// while returning the user who has it.
+	CreateUser(ctx context.Context, username string) (ExerciseUser, error)
+	GetAllUsers(ctx context.Context) (ExerciseUserList, error)
+	// Passes every user along with their log to fn in turn, oldest first, stopping at the first error
+	// it returns, so that they needn't all be held in memory at once.
+	StreamUsers(ctx context.Context, fn func(ExerciseUserRecord) error) error
+	// Returns a user along with their profile, but without their log.
+	GetUser(ctx context.Context, userID string) (ExerciseUser, error)
+	// Returns up to limit users whose usernames start with the search or, with substring,
+	// contain it, without their logs and in order of username.
+	SearchUsers(ctx context.Context, search string, substring bool, limit int) ([]ExerciseUser, error)
+	AddExercise(ctx context.Context, userID string, exercise ExerciseRecord) (ExerciseAddedReceipt, error)
+	// Adds exercises to a user's log all at once, giving each of them an ID.
+	AddExercises(ctx context.Context, userID string, exercises []ExerciseRecord) error
+	GetExerciseLog(ctx context.Context, userID string, filter ExerciseLogFilter) (ExerciseUserRecord, error)
+	// Summarizes a user's whole log, with days and weeks in the time zone.
+	// Only the weeks with exercises are listed, oldest first.
+	GetExerciseStats(ctx context.Context, userID string, loc *time.Location) (ExerciseStats, error)
+	// Returns the users with the most minutes or exercises, as by chooses, dated since a time,
+	// or ever if it is zero, the most first. Users without any are left out.
+	GetLeaderboard(ctx context.Context, since time.Time, by string, limit int) ([]LeaderboardEntry, error)
+	CountUsers(ctx context.Context) (int64, error)
+	// Deletes a user along with their exercise log, returning both.
+	DeleteUser(ctx context.Context, userID string) (ExerciseUserRecord, error)
+	// Removes the exercise with the given ID from a user's log.
+	DeleteExercise(ctx context.Context, userID string, exerciseID string) error
+	// Changes the exercise with the given ID in a user's log, returning it as it now is.
+	UpdateExercise(ctx context.Context, userID string, exerciseID string, update ExerciseUpdate) (ExerciseRecord, error)
+	// Changes a user's profile, returning the user as they now are, without their log,
+	// provided they are still at the given version or the version is anyVersion.
+	UpdateUserProfile(ctx context.Context, userID string, update ExerciseProfileUpdate, version int64) (ExerciseUser, error)
+	// Changes a user's username, returning the user as they now are, without their log,
+	// provided they are still at the given version or the version is anyVersion.
+	// Fails with ErrDuplicate if another user has the username.
+	RenameUser(ctx context.Context, userID string, username string, version int64) (ExerciseUser, error)
+	// Passes every user to fn in turn, stopping at the first error it returns.
+	ExportUsers(ctx context.Context, fn func(ExerciseUserExport) error) error
+	// Restores exported users, returning an error for each of them that is nil if it was restored.
+	// A user whose ID already exists fails with ErrDuplicate unless replace is set,
+	// as does one whose username belongs to a different user.
+	ImportUsers(ctx context.Context, users []ExerciseUserExport, replace bool) ([]error, error)
+	// Removes the exercises dated before the cutoff from every log
+	// and returns how many there were. With dryRun, they are only counted.
+	PruneExercises(ctx context.Context, cutoff time.Time, dryRun bool) (int64, error)
+	// Runs fn in a transaction, so that the operations that fn performs on the stores
+	// either all take effect or none do. The stores only take part if they are given
+	// the context passed to fn. fn must return any error that a store returns to it,
+	// as the failed operation may have left changes behind that only aborting undoes.
+	// fn may be run more than once if MongoDB reports a transient conflict, and a standalone
+	// MongoDB server has no transactions, so there a failure can leave changes behind.
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// Keeps API keys and counts their use.
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, name string, scopes []string, dailyQuota int) (NewAPIKey, error)
+	// Returns every key, newest first.
+	GetAllAPIKeys(ctx context.Context) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+	// Counts one use of an active key, failing with errInvalidAPIKey if there
+	// is no such key or errQuotaExceeded if it has used up today's quota.
+	UseAPIKey(ctx context.Context, key string) (APIKey, error)
+}
+
+// Records every visit to a short URL, for analytics.
+type ClickStore interface {
+	RecordClicks(ctx context.Context, clicks []ClickEvent) error
+	// Summarizes the clicks on a short URL since the given time.
+	GetClickStats(ctx context.Context, shortURL string, since time.Time) (ClickStats, error)
+	// Passes the clicks on a short URL since the given time to fn in turn, oldest first,
+	// stopping at the first error it returns. Given an empty short URL, the clicks on
+	// every short URL are passed, ordered by short URL and then by time.
+	ExportClicks(ctx context.Context, shortURL string, since time.Time, fn func(ClickEvent) error) error
+}
+
+// Changes to an exercise in a user's log. Nil fields are left as they are.
+type ExerciseUpdate struct {
+	Description *string
+	Duration    *int
+	Date        *time.Time
+}
+
+// Narrows down the exercises returned from a user's log.
+// Zero values mean that there is no bound or limit.
+type ExerciseLogFilter struct {
+	From   time.Time
+	To     time.Time
+	Limit  int
+	// How many of the exercises that match to skip, for paging through them
+	Offset int
+	// The only category of exercise to include, or empty for all of them
+	Category string
+	// The tags that every exercise included must have, or empty for any
+	Tags     []string
+	// A case-insensitive regular expression that descriptions must match, or empty for any
+	Description string
+	// What to sort the log by, or empty to sort it by date only if it is narrowed down
+	Sort   string
+	// Sorts the log from the latest or longest exercise down
+	Descending bool
+}
+
+// What an exercise log can be sorted by
+const (
+	sortExercisesByDate = "date"
+	sortExercisesByDuration = "duration"
+)
+
+
+// Reports whether the filter narrows down or sorts the log, in which case
+// the log is sorted, by date unless the filter says otherwise.
+func (filter ExerciseLogFilter) narrows() bool {
+	return !filter.From.IsZero() || !filter.To.IsZero() || filter.Limit > 0 || filter.Offset > 0 ||
+		len(filter.Category) > 0 || len(filter.Tags) > 0 || len(filter.Description) > 0 || len(filter.Sort) > 0
+}
+
+// The longest category that an exercise can have
+const maxExerciseCategoryLength = 30
+
+var errInvalidExerciseCategory = newErrorMessage(http.StatusBadRequest,
+	"category can be at most " + strconv.Itoa(maxExerciseCategoryLength) + " characters")
+
+// The most tags that an exercise can have, and the longest that each can be
+const (
+	maxExerciseTags = 10
+	maxExerciseTagLength = 30
+)
+
+var errInvalidExerciseTags = newErrorMessage(http.StatusBadRequest,
+	"an exercise can have at most " + strconv.Itoa(maxExerciseTags) + " tags of at most " + strconv.Itoa(maxExerciseTagLength) + " characters each")
+
+// The range of years that exercises can be dated in
+const (
+	minExerciseYear = 1900
+	maxExerciseYear = 2100
+)
+
+var errInvalidExerciseDate = newErrorMessage(http.StatusBadRequest,
+	"date must be in the form 2006-01-02, from " + strconv.Itoa(minExerciseYear) + " to " + strconv.Itoa(maxExerciseYear))
+
+var (
+	errExerciseDescriptionRequired = newErrorMessage(http.StatusBadRequest, "description is required")
+	errExerciseDurationNotPositive = newErrorMessage(http.StatusBadRequest, "duration must be a positive number of minutes")
+)
+
+// The longest search of an exercise log by description
+const maxExerciseSearchLength = 100
+
+var errInvalidExerciseSearch = newErrorMessage(http.StatusBadRequest,
+	"q can be at most " + strconv.Itoa(maxExerciseSearchLength) + " characters, and must be a valid regular expression if it is between slashes")
+
+// The orders in which short URLs can be listed
+const (
+	// Newest first
+	sortURLsByCreated = "created"
+	// Most visited first
+	sortURLsByVisits = "visits"
+)
+
+// How many short URLs are listed at once by default, and at most
+const (
+	defaultURLListLimit = 20
+	maxURLListLimit = 100
+)
+
+// Chooses which page of short URLs to list, and in what order.
+type URLListQuery struct {
+	Sort      string
+	// Reverses the order, i.e. lists the oldest or least visited first
+	Ascending bool
+	Limit     int64
+	Offset    int64
+	// Unless AnyOwner is set, only the short URLs with this owner or none are listed
+	Owner     string
+	AnyOwner  bool
+}
+
+// The stores used by the handlers. These are set once the backend
+// is ready, which is signalled by dbReady.
+var (
+	urlStore      URLStore
+	exerciseStore ExerciseStore
+	apiKeyStore   APIKeyStore
+	clickStore    ClickStore
+)
+
+
+// Sets up the storage backend named by STORAGE_BACKEND, which is either
+// "mongo" for MongoDB or "bolt" for an embedded bbolt file.
+func initStorage() {
+	initShortURLs()
+	switch backend := getEnv("STORAGE_BACKEND", "mongo"); backend {
+	case "mongo":
+		initDatabase()
+	case "bolt":
+		initBolt()
+	default:
+		fatal("Unknown STORAGE_BACKEND.", "backend", backend)
+	}
+}
+
+
+// Makes the backend's stores available to the handlers, wrapped so that
+// their operations are measured and short URLs are cached if configured.
+func setStores(urls URLStore, exercises ExerciseStore, keys APIKeyStore, clicks ClickStore) {
+	urlStore = withURLCache(instrumentedURLStore{store: urls})
+	exerciseStore = instrumentedExerciseStore{store: exercises}
+	apiKeyStore = instrumentedAPIKeyStore{store: keys}
+	clickStore = instrumentedClickStore{store: clicks}
+}
+
+
+// Checks that the storage backend is still reachable.
+func pingStorage(ctx context.Context) error {
+	if boltDB != nil {
+		return pingBolt(ctx)
+	}
+	return mongoClient.Ping(ctx, nil)
+}
+
+
+// Writes back anything still buffered, such as clicks and cached visit counts,
+// and closes the connections to the storage backend.
+func closeStorage() {
+	closeClickAnalytics()
+	if cache, ok := urlStore.(*cachedURLStore); ok {
+		cache.Close()
+	}
+	close(stopHealthMonitor)
+	close(stopExpirySweep)
+	close(stopRetention)
+	closeDatabase()
+	closeBolt()
+}
+
+
+// Converts the exercise details sent by a client into a record,
+// with the duration in the unit (default minutes) and the date defaulting to today,
+// checking it as validateExercise does.
+func parseExercise(description string, duration string, unit string, date string) (ExerciseRecord, error) {
+	durationValue, err := parseExerciseDuration(duration, unit)
+	if err != nil {
+		return ExerciseRecord{}, err
+	}
+
+	dateValue := time.Now()
+	if len(date) > 0 {
+		dateValue, err = parseExerciseDate(date)
+		if err != nil {
+			return ExerciseRecord{}, err
+		}
+	}
+	exercise := ExerciseRecord{Description: description, Duration: durationValue, Date: dateValue}
+	if err := validateExercise(exercise); err != nil {
+		return ExerciseRecord{}, err
+	}
+	return exercise, nil
+}
+
+
+// Checks that an exercise has a description and lasted some time,
+// whichever way it is added or changed.
+func validateExercise(exercise ExerciseRecord) error {
+	if len(exercise.Description) == 0 {
+		return errExerciseDescriptionRequired
+	}
+	if exercise.Duration <= 0 {
+		return errExerciseDurationNotPositive
+	}
+	return nil
+}
+
+
+// Converts the date of an exercise sent by a client, which must be within
+// minExerciseYear and maxExerciseYear so that summaries of the log stay a sensible size.
+func parseExerciseDate(date string) (time.Time, error) {
+	dateValue, err := time.Parse("2006-01-02", date)
+	if err != nil || dateValue.Year() < minExerciseYear || dateValue.Year() > maxExerciseYear {
+		return time.Time{}, errInvalidExerciseDate
+	}
+	return dateValue, nil
+}
+
+
+// Converts the category of an exercise sent by a client into the form in which it is
+// stored and filtered on, ignoring case and surrounding spaces so that "Run" and "run " match.
+func parseExerciseCategory(category string) (string, error) {
+	category = strings.ToLower(strings.TrimSpace(category))
+	if len(category) > maxExerciseCategoryLength {
+		return "", errInvalidExerciseCategory
+	}
+	return category, nil
+}
+
+
+// Converts tags sent by a client, each of which may be a comma-separated list of them,
+// into the form in which they are stored and filtered on, as with categories.
+// Empty and repeated tags are left out, and nil is returned if there are none.
+func splitExerciseTags(values []string) []string {
+	var tags []string
+	for _, value := range values {
+		for _, tag := range strings.Split(value, ",") {
+			tag = strings.ToLower(strings.TrimSpace(tag))
+			if len(tag) > 0 && !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+
+// Converts the tags of an exercise sent by a client as splitExerciseTags does,
+// checking that there aren't too many of them and that none is too long.
+func parseExerciseTags(values []string) ([]string, error) {
+	tags := splitExerciseTags(values)
+	if len(tags) > maxExerciseTags {
+		return nil, errInvalidExerciseTags
+	}
+	for _, tag := range tags {
+		if len(tag) > maxExerciseTagLength {
+			return nil, errInvalidExerciseTags
+		}
+	}
+	return tags, nil
+}
+
+
+// Converts the changes to an exercise sent by a client as form data into an update.
+// Unless partial is set, as it is for PATCH, the description and duration are required
+// and the date defaults to today, as when adding an exercise. The duration can be given in a unit.
+func parseExerciseUpdate(form url.Values, partial bool) (ExerciseUpdate, error) {
+	if !partial {
+		exercise, err := parseExercise(form.Get("description"), form.Get("duration"), form.Get("unit"), form.Get("date"))
+		if err != nil {
+			return ExerciseUpdate{}, err
+		}
+		return ExerciseUpdate{Description: &exercise.Description, Duration: &exercise.Duration, Date: &exercise.Date}, nil
+	}
+
+	var update ExerciseUpdate
+	if form.Has("description") {
+		description := form.Get("description")
+		if len(description) == 0 {
+			return ExerciseUpdate{}, errExerciseDescriptionRequired
+		}
+		update.Description = &description
+	}
+	if form.Has("duration") {
+		duration, err := parseExerciseDuration(form.Get("duration"), form.Get("unit"))
+		if err != nil {
+			return ExerciseUpdate{}, err
+		}
+		if duration <= 0 {
+			return ExerciseUpdate{}, errExerciseDurationNotPositive
+		}
+		update.Duration = &duration
+	}
+	if form.Has("date") {
+		date, err := parseExerciseDate(form.Get("date"))
+		if err != nil {
+			return ExerciseUpdate{}, err
+		}
+		update.Date = &date
+	}
+	if update == (ExerciseUpdate{}) {
+		return ExerciseUpdate{}, newErrorMessage(http.StatusBadRequest, "at least one of description, duration, and date is required")
+	}
+	return update, nil
+}
+
+
+// Converts the "from", "to", "limit", and "offset" parameters sent by a client into a filter.
+// As before, parameters that can't be parsed are ignored.
+func parseExerciseLogFilter(from string, to string, limit string, offset string) ExerciseLogFilter {
+	var filter ExerciseLogFilter
+	if date, err := time.Parse("2006-01-02", from); err == nil {
+		filter.From = date
+	}
+	if date, err := time.Parse("2006-01-02", to); err == nil {
+		filter.To = date
+	}
+	if n, err := strconv.Atoi(limit); err == nil && n > 0 {
+		filter.Limit = n
+	}
+	if n, err := strconv.Atoi(offset); err == nil && n > 0 {
+		filter.Offset = n
+	}
+	return filter
+}
+
+
+// Converts the "q" parameter of an exercise log into the regular expression that
+// descriptions must match. Text between slashes, e.g. "/^morning (run|swim)/", is used
+// as a regular expression, and anything else matches descriptions that contain it.
+func parseExerciseLogSearch(q string) (string, error) {
+	if len(q) > maxExerciseSearchLength {
+		return "", errInvalidExerciseSearch
+	}
+	if len(q) < 2 || !strings.HasPrefix(q, "/") || !strings.HasSuffix(q, "/") {
+		return regexp.QuoteMeta(q), nil
+	}
+	pattern := q[1 : len(q)-1]
+	if _, err := regexp.Compile(pattern); err != nil {
+		return "", errInvalidExerciseSearch
+	}
+	return pattern, nil
+}
+
+
+// Converts the "sort" and "order" parameters of an exercise log into what to sort it by
+// and whether to reverse it. Asking for the log in descending order without saying
+// what to sort it by sorts it by date. As with the filter, invalid values are ignored.
+func parseExerciseLogSort(sortBy string, order string) (string, bool) {
+	descending := order == "desc"
+	switch {
+	case sortBy == sortExercisesByDate || sortBy == sortExercisesByDuration:
+		return sortBy, descending
+	case descending:
+		return sortExercisesByDate, true
+	default:
+		return "", false
+	}
+}
+
+
+// Reads the query parameters of a short URL listing.
+// As with the exercise log, invalid values are ignored in favour of the defaults:
+// the newest first, defaultURLListLimit at a time, starting from the first.
+func parseURLListQuery(sortBy string, order string, limit string, offset string) URLListQuery {
+	query := URLListQuery{Sort: sortURLsByCreated, Limit: defaultURLListLimit}
+	if sortBy == sortURLsByVisits {
+		query.Sort = sortURLsByVisits
+	}
+	query.Ascending = order == "asc"
+	if n, err := strconv.ParseInt(limit, 10, 64); err == nil && n > 0 {
+		query.Limit = min(n, maxURLListLimit)
+	}
+	if n, err := strconv.ParseInt(offset, 10, 64); err == nil && n > 0 {
+		query.Offset = n
+	}
+	return query
+}
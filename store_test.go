@@ -0,0 +1,36 @@
+// Tests how the exercise details sent by clients are checked.
+package main
+
+import (
+	"testing"
+)
+
+
+func TestParseExercise(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		duration    string
+		date        string
+		wantErr     error
+	}{
+		{"valid", "run", "30", "2024-01-01", nil},
+		{"no date", "run", "30", "", nil},
+		{"no description", "", "30", "", errExerciseDescriptionRequired},
+		{"zero duration", "run", "0", "", errExerciseDurationNotPositive},
+		{"negative duration", "run", "-5", "", errExerciseDurationNotPositive},
+		{"too long", "run", "10081", "", errInvalidDuration},
+		{"invalid date", "run", "30", "2024-13-01", errInvalidExerciseDate},
+		{"too early", "run", "30", "0001-01-01", errInvalidExerciseDate},
+		{"too late", "run", "30", "9999-12-31", errInvalidExerciseDate},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseExercise(test.description, test.duration, "", test.date)
+			if err != test.wantErr {
+				t.Errorf("err = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
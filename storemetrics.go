@@ -0,0 +1,388 @@
+// Counts and times every operation on the stores, whichever backend is in use,
+// so that the time spent in the database can be told apart from the handlers.
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	storeOperationsTotal = newCounterVec("store_operations_total",
+		"Total number of operations on the URL, exercise, and API key stores.", "operation", "outcome")
+	storeOperationDuration = newHistogramVec("store_operation_duration_seconds",
+		"Time taken by operations on the URL, exercise, and API key stores.", defaultBuckets, "operation")
+)
+
+
+// Records one store operation that started at the given time.
+// Errors that the client caused, such as ErrNotFound, are counted apart from failures.
+func observeStoreOperation(operation string, start time.Time, err error) {
+	storeOperationDuration.observe(time.Since(start).Seconds(), operation)
+	outcome := "success"
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrNotFound):
+		outcome = "not_found"
+	case errors.Is(err, ErrDuplicate):
+		outcome = "duplicate"
+	case errors.Is(err, ErrInvalidInput):
+		outcome = "invalid_input"
+	case errors.Is(err, ErrConflict):
+		outcome = "conflict"
+	case errors.Is(err, ErrExpired):
+		outcome = "expired"
+	case errors.Is(err, ErrDisabled):
+		outcome = "disabled"
+	case errors.Is(err, errInvalidAPIKey), errors.Is(err, errQuotaExceeded):
+		outcome = "rejected"
+	default:
+		outcome = "error"
+	}
+	storeOperationsTotal.inc(operation, outcome)
+}
+
+
+// A URLStore that records metrics for every operation.
+type instrumentedURLStore struct {
+	store URLStore
+}
+
+func (s instrumentedURLStore) InsertURL(ctx context.Context, newURL NewURL) (urlReceipt, error) {
+	start := time.Now()
+	result, err := s.store.InsertURL(ctx, newURL)
+	observeStoreOperation("InsertURL", start, err)
+	return result, err
+}
+
+func (s instrumentedURLStore) InsertURLs(ctx context.Context, newURLs []NewURL) ([]urlReceipt, []error, error) {
+	start := time.Now()
+	receipts, results, err := s.store.InsertURLs(ctx, newURLs)
+	observeStoreOperation("InsertURLs", start, err)
+	return receipts, results, err
+}
+
+func (s instrumentedURLStore) ResolveURL(ctx context.Context, shortURL string) (string, int, error) {
+	start := time.Now()
+	result, status, err := s.store.ResolveURL(ctx, shortURL)
+	observeStoreOperation("ResolveURL", start, err)
+	return result, status, err
+}
+
+func (s instrumentedURLStore) LookupURL(ctx context.Context, shortURL string) (StoredURL, error) {
+	start := time.Now()
+	result, err := s.store.LookupURL(ctx, shortURL)
+	observeStoreOperation("LookupURL", start, err)
+	return result, err
+}
+
+func (s instrumentedURLStore) AddVisits(ctx context.Context, visits map[string]int64) error {
+	start := time.Now()
+	err := s.store.AddVisits(ctx, visits)
+	observeStoreOperation("AddVisits", start, err)
+	return err
+}
+
+func (s instrumentedURLStore) CountURLs(ctx context.Context) (int64, error) {
+	start := time.Now()
+	result, err := s.store.CountURLs(ctx)
+	observeStoreOperation("CountURLs", start, err)
+	return result, err
+}
+
+func (s instrumentedURLStore) ListURLs(ctx context.Context, query URLListQuery) ([]StoredURL, int64, error) {
+	start := time.Now()
+	result, total, err := s.store.ListURLs(ctx, query)
+	observeStoreOperation("ListURLs", start, err)
+	return result, total, err
+}
+
+func (s instrumentedURLStore) DeleteURL(ctx context.Context, shortURL string) (URLExport, error) {
+	start := time.Now()
+	result, err := s.store.DeleteURL(ctx, shortURL)
+	observeStoreOperation("DeleteURL", start, err)
+	return result, err
+}
+
+func (s instrumentedURLStore) UpdateURL(ctx context.Context, shortURL string, originalURL string, version int64) (URLExport, error) {
+	start := time.Now()
+	result, err := s.store.UpdateURL(ctx, shortURL, originalURL, version)
+	observeStoreOperation("UpdateURL", start, err)
+	return result, err
+}
+
+func (s instrumentedURLStore) SetURLExpiry(ctx context.Context, shortURL string, expiresAt time.Time) error {
+	start := time.Now()
+	err := s.store.SetURLExpiry(ctx, shortURL, expiresAt)
+	observeStoreOperation("SetURLExpiry", start, err)
+	return err
+}
+
+func (s instrumentedURLStore) SetURLUTM(ctx context.Context, shortURL string, utm *UTMParams) error {
+	start := time.Now()
+	err := s.store.SetURLUTM(ctx, shortURL, utm)
+	observeStoreOperation("SetURLUTM", start, err)
+	return err
+}
+
+func (s instrumentedURLStore) SetURLWebhook(ctx context.Context, shortURL string, webhook *Webhook) error {
+	start := time.Now()
+	err := s.store.SetURLWebhook(ctx, shortURL, webhook)
+	observeStoreOperation("SetURLWebhook", start, err)
+	return err
+}
+
+func (s instrumentedURLStore) GetURLWebhooks(ctx context.Context) (map[string]Webhook, error) {
+	start := time.Now()
+	result, err := s.store.GetURLWebhooks(ctx)
+	observeStoreOperation("GetURLWebhooks", start, err)
+	return result, err
+}
+
+func (s instrumentedURLStore) SetURLDisabled(ctx context.Context, shortURL string, disabled *LinkDisabled) error {
+	start := time.Now()
+	err := s.store.SetURLDisabled(ctx, shortURL, disabled)
+	observeStoreOperation("SetURLDisabled", start, err)
+	return err
+}
+
+func (s instrumentedURLStore) SetURLVisitLimit(ctx context.Context, shortURL string, limit int64) error {
+	start := time.Now()
+	err := s.store.SetURLVisitLimit(ctx, shortURL, limit)
+	observeStoreOperation("SetURLVisitLimit", start, err)
+	return err
+}
+
+func (s instrumentedURLStore) GetURLVisitLimits(ctx context.Context) (map[string]int64, error) {
+	start := time.Now()
+	result, err := s.store.GetURLVisitLimits(ctx)
+	observeStoreOperation("GetURLVisitLimits", start, err)
+	return result, err
+}
+
+func (s instrumentedURLStore) ExportURLs(ctx context.Context, fn func(URLExport) error) error {
+	start := time.Now()
+	err := s.store.ExportURLs(ctx, fn)
+	observeStoreOperation("ExportURLs", start, err)
+	return err
+}
+
+func (s instrumentedURLStore) PruneURLs(ctx context.Context, cutoff time.Time, dryRun bool) ([]string, error) {
+	start := time.Now()
+	result, err := s.store.PruneURLs(ctx, cutoff, dryRun)
+	observeStoreOperation("PruneURLs", start, err)
+	return result, err
+}
+
+func (s instrumentedURLStore) ImportURLs(ctx context.Context, urls []URLExport, replace bool) ([]error, error) {
+	start := time.Now()
+	result, err := s.store.ImportURLs(ctx, urls, replace)
+	observeStoreOperation("ImportURLs", start, err)
+	return result, err
+}
+
+
+// An ExerciseStore that records metrics for every operation.
+type instrumentedExerciseStore struct {
+	store ExerciseStore
+}
+
+func (s instrumentedExerciseStore) CreateUser(ctx context.Context, username string) (ExerciseUser, error) {
+	start := time.Now()
+	result, err := s.store.CreateUser(ctx, username)
+	observeStoreOperation("CreateUser", start, err)
+	return result, err
+}
+
+func (s instrumentedExerciseStore) GetAllUsers(ctx context.Context) (ExerciseUserList, error) {
+	start := time.Now()
+	result, err := s.store.GetAllUsers(ctx)
+	observeStoreOperation("GetAllUsers", start, err)
+	return result, err
+}
+
+func (s instrumentedExerciseStore) StreamUsers(ctx context.Context, fn func(ExerciseUserRecord) error) error {
+	start := time.Now()
+	err := s.store.StreamUsers(ctx, fn)
+	observeStoreOperation("StreamUsers", start, err)
+	return err
+}
+
+func (s instrumentedExerciseStore) GetUser(ctx context.Context, userID string) (ExerciseUser, error) {
+	start := time.Now()
+	result, err := s.store.GetUser(ctx, userID)
+	observeStoreOperation("GetUser", start, err)
+	return result, err
+}
+
+func (s instrumentedExerciseStore) SearchUsers(ctx context.Context, search string, substring bool, limit int) ([]ExerciseUser, error) {
+	start := time.Now()
+	result, err := s.store.SearchUsers(ctx, search, substring, limit)
+	observeStoreOperation("SearchUsers", start, err)
+	return result, err
+}
+
+func (s instrumentedExerciseStore) AddExercise(ctx context.Context, userID string, exercise ExerciseRecord) (ExerciseAddedReceipt, error) {
+	start := time.Now()
+	result, err := s.store.AddExercise(ctx, userID, exercise)
+	observeStoreOperation("AddExercise", start, err)
+	return result, err
+}
+
+func (s instrumentedExerciseStore) AddExercises(ctx context.Context, userID string, exercises []ExerciseRecord) error {
+	start := time.Now()
+	err := s.store.AddExercises(ctx, userID, exercises)
+	observeStoreOperation("AddExercises", start, err)
+	return err
+}
+
+func (s instrumentedExerciseStore) GetExerciseLog(ctx context.Context, userID string, filter ExerciseLogFilter) (ExerciseUserRecord, error) {
+	start := time.Now()
+	result, err := s.store.GetExerciseLog(ctx, userID, filter)
+	observeStoreOperation("GetExerciseLog", start, err)
+	return result, err
+}
+
+func (s instrumentedExerciseStore) GetExerciseStats(ctx context.Context, userID string, loc *time.Location) (ExerciseStats, error) {
+	start := time.Now()
+	result, err := s.store.GetExerciseStats(ctx, userID, loc)
+	observeStoreOperation("GetExerciseStats", start, err)
+	return result, err
+}
+
+func (s instrumentedExerciseStore) GetLeaderboard(ctx context.Context, since time.Time, by string, limit int) ([]LeaderboardEntry, error) {
+	start := time.Now()
+	result, err := s.store.GetLeaderboard(ctx, since, by, limit)
+	observeStoreOperation("GetLeaderboard", start, err)
+	return result, err
+}
+
+func (s instrumentedExerciseStore) CountUsers(ctx context.Context) (int64, error) {
+	start := time.Now()
+	result, err := s.store.CountUsers(ctx)
+	observeStoreOperation("CountUsers", start, err)
+	return result, err
+}
+
+// Isn't measured itself, as the operations within it are.
+func (s instrumentedExerciseStore) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return s.store.WithTransaction(ctx, fn)
+}
+
+func (s instrumentedExerciseStore) DeleteUser(ctx context.Context, userID string) (ExerciseUserRecord, error) {
+	start := time.Now()
+	result, err := s.store.DeleteUser(ctx, userID)
+	observeStoreOperation("DeleteUser", start, err)
+	return result, err
+}
+
+func (s instrumentedExerciseStore) DeleteExercise(ctx context.Context, userID string, exerciseID string) error {
+	start := time.Now()
+	err := s.store.DeleteExercise(ctx, userID, exerciseID)
+	observeStoreOperation("DeleteExercise", start, err)
+	return err
+}
+
+func (s instrumentedExerciseStore) UpdateExercise(ctx context.Context, userID string, exerciseID string, update ExerciseUpdate) (ExerciseRecord, error) {
+	start := time.Now()
+	result, err := s.store.UpdateExercise(ctx, userID, exerciseID, update)
+	observeStoreOperation("UpdateExercise", start, err)
+	return result, err
+}
+
+func (s instrumentedExerciseStore) UpdateUserProfile(ctx context.Context, userID string, update ExerciseProfileUpdate, version int64) (ExerciseUser, error) {
+	start := time.Now()
+	result, err := s.store.UpdateUserProfile(ctx, userID, update, version)
+	observeStoreOperation("UpdateUserProfile", start, err)
+	return result, err
+}
+
+func (s instrumentedExerciseStore) RenameUser(ctx context.Context, userID string, username string, version int64) (ExerciseUser, error) {
+	start := time.Now()
+	result, err := s.store.RenameUser(ctx, userID, username, version)
+	observeStoreOperation("RenameUser", start, err)
+	return result, err
+}
+
+func (s instrumentedExerciseStore) ExportUsers(ctx context.Context, fn func(ExerciseUserExport) error) error {
+	start := time.Now()
+	err := s.store.ExportUsers(ctx, fn)
+	observeStoreOperation("ExportUsers", start, err)
+	return err
+}
+
+func (s instrumentedExerciseStore) PruneExercises(ctx context.Context, cutoff time.Time, dryRun bool) (int64, error) {
+	start := time.Now()
+	result, err := s.store.PruneExercises(ctx, cutoff, dryRun)
+	observeStoreOperation("PruneExercises", start, err)
+	return result, err
+}
+
+func (s instrumentedExerciseStore) ImportUsers(ctx context.Context, users []ExerciseUserExport, replace bool) ([]error, error) {
+	start := time.Now()
+	result, err := s.store.ImportUsers(ctx, users, replace)
+	observeStoreOperation("ImportUsers", start, err)
+	return result, err
+}
+
+
+// An APIKeyStore that records metrics for every operation.
+type instrumentedAPIKeyStore struct {
+	store APIKeyStore
+}
+
+func (s instrumentedAPIKeyStore) CreateAPIKey(ctx context.Context, name string, scopes []string, dailyQuota int) (NewAPIKey, error) {
+	start := time.Now()
+	result, err := s.store.CreateAPIKey(ctx, name, scopes, dailyQuota)
+	observeStoreOperation("CreateAPIKey", start, err)
+	return result, err
+}
+
+func (s instrumentedAPIKeyStore) GetAllAPIKeys(ctx context.Context) ([]APIKey, error) {
+	start := time.Now()
+	result, err := s.store.GetAllAPIKeys(ctx)
+	observeStoreOperation("GetAllAPIKeys", start, err)
+	return result, err
+}
+
+func (s instrumentedAPIKeyStore) RevokeAPIKey(ctx context.Context, id string) error {
+	start := time.Now()
+	err := s.store.RevokeAPIKey(ctx, id)
+	observeStoreOperation("RevokeAPIKey", start, err)
+	return err
+}
+
+func (s instrumentedAPIKeyStore) UseAPIKey(ctx context.Context, key string) (APIKey, error) {
+	start := time.Now()
+	result, err := s.store.UseAPIKey(ctx, key)
+	observeStoreOperation("UseAPIKey", start, err)
+	return result, err
+}
+
+
+// A ClickStore that records metrics for every operation.
+type instrumentedClickStore struct {
+	store ClickStore
+}
+
+func (s instrumentedClickStore) RecordClicks(ctx context.Context, clicks []ClickEvent) error {
+	start := time.Now()
+	err := s.store.RecordClicks(ctx, clicks)
+	observeStoreOperation("RecordClicks", start, err)
+	return err
+}
+
+func (s instrumentedClickStore) GetClickStats(ctx context.Context, shortURL string, since time.Time) (ClickStats, error) {
+	start := time.Now()
+	result, err := s.store.GetClickStats(ctx, shortURL, since)
+	observeStoreOperation("GetClickStats", start, err)
+	return result, err
+}
+
+func (s instrumentedClickStore) ExportClicks(ctx context.Context, shortURL string, since time.Time, fn func(ClickEvent) error) error {
+	start := time.Now()
+	err := s.store.ExportClicks(ctx, shortURL, since, fn)
+	observeStoreOperation("ExportClicks", start, err)
+	return err
+}
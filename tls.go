@@ -0,0 +1,110 @@
+// Handles serving the app over HTTPS.
+package main
+
+import (
+	"crypto/tls"
+	"golang.org/x/crypto/acme/autocert"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Returns the certificate and key paths from the environment.
+// TLS is only enabled when both are set.
+func getTLSFiles() (certFile string, keyFile string, ok bool) {
+	certFile = os.Getenv("TLS_CERT_FILE")
+	keyFile = os.Getenv("TLS_KEY_FILE")
+	return certFile, keyFile, len(certFile) > 0 && len(keyFile) > 0
+}
+
+
+// Returns a handler that permanently redirects every request
+// to the same host and path on the HTTPS port.
+func newRedirectHandler(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			// No port was given in the Host header
+			host = r.Host
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+
+// Returns a server on the plain HTTP port (HTTP_REDIRECT_PORT)
+// whose only job is to send clients over to HTTPS.
+// If a certificate manager is given, it also answers ACME HTTP-01 challenges,
+// which is why the port then defaults to 80. Otherwise, binding to port 80 would
+// usually need root, so there is no redirect server unless a port was chosen.
+func newRedirectServer(host string, httpsPort string, manager *autocert.Manager) *http.Server {
+	defaultPort := ""
+	if manager != nil {
+		defaultPort = "80"
+	}
+	redirectPort := getEnv("HTTP_REDIRECT_PORT", defaultPort)
+	if len(redirectPort) == 0 {
+		return nil
+	}
+	addr := net.JoinHostPort(host, redirectPort)
+	var handler http.Handler = newRedirectHandler(httpsPort)
+	if hsts := newHSTSMiddleware(); hsts != nil {
+		handler = hsts(handler)
+	}
+	if manager != nil {
+		handler = manager.HTTPHandler(handler)
+	}
+	slog.Info("Redirecting HTTP requests to HTTPS.", "addr", addr)
+	return newHTTPServer(addr, handler)
+}
+
+
+// Returns a middleware that sets the Strict-Transport-Security header,
+// telling browsers to only ever contact this host over HTTPS.
+// The policy lasts for HSTS_MAX_AGE seconds (default one year) and covers subdomains
+// if HSTS_INCLUDE_SUBDOMAINS is true. Returns nil if HSTS_MAX_AGE is 0.
+func newHSTSMiddleware() Middleware {
+	maxAge := getEnvInt("HSTS_MAX_AGE", 365*24*60*60)
+	if maxAge <= 0 {
+		return nil
+	}
+	value := "max-age=" + strconv.FormatInt(maxAge, 10)
+	if getEnvBool("HSTS_INCLUDE_SUBDOMAINS", false) {
+		value += "; includeSubDomains"
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Strict-Transport-Security", value)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+
+// Returns a certificate manager that obtains and renews certificates
+// from Let's Encrypt for the domains listed in ACME_DOMAINS.
+// Returns nil if no domains were configured.
+func getACMEManager() *autocert.Manager {
+	domains := getEnvList("ACME_DOMAINS")
+	if len(domains) == 0 {
+		return nil
+	}
+	slog.Info("Using ACME certificates.", "domains", domains)
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(getEnv("ACME_CACHE_DIR", "certs")),
+		Email:      os.Getenv("ACME_EMAIL"),
+	}
+}
+
+
+// Configures the server to use the certificates obtained by the manager.
+func useACMECertificates(server *http.Server, manager *autocert.Manager) {
+	server.TLSConfig = &tls.Config{GetCertificate: manager.GetCertificate}
+}
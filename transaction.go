@@ -0,0 +1,118 @@
+// Runs several store operations as one, so that a compound operation,
+// such as deleting a user along with everything they own, can't be left half done.
+package main
+
+import (
+	"context"
+	"errors"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log/slog"
+)
+
+// Whether the MongoDB deployment supports multi-document transactions,
+// which only replica sets and sharded clusters do.
+// Set once when connecting, before the stores are made available.
+var mongoSupportsTransactions bool
+
+// The context key under which an open bbolt transaction is passed to the stores
+type boltTxKey struct{}
+
+
+// Runs fn in a MongoDB transaction, as ExerciseStore.WithTransaction describes.
+func (store *mongoExerciseStore) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return mongoTransaction(ctx, fn)
+}
+
+
+// Runs fn in a bbolt transaction, as ExerciseStore.WithTransaction describes.
+func (store *boltExerciseStore) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return boltTransaction(ctx, store.db, fn)
+}
+
+
+// Runs fn in a MongoDB transaction, which the driver retries as a whole
+// if it fails with a transient error. A standalone server has no transactions,
+// so there fn runs on its own and a failure can leave some of its changes behind.
+func mongoTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !mongoSupportsTransactions {
+		return fn(ctx)
+	}
+	session, err := mongoClient.StartSession()
+	if err != nil {
+		loggerFrom(ctx).Error("Client.StartSession failed", "func", "mongoTransaction", "err", err)
+		return newStoreError(ErrStorage, "failed when starting transaction")
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (any, error) {
+		return nil, fn(sc)
+	})
+	if err != nil {
+		// Errors from the stores are already logged and safe for clients
+		var storeErr *StoreError
+		var errMsg *ErrorMessage
+		if errors.As(err, &storeErr) || errors.As(err, &errMsg) {
+			return err
+		}
+		loggerFrom(ctx).Error("Session.WithTransaction failed", "func", "mongoTransaction", "err", err)
+		return newStoreError(ErrStorage, "failed when committing transaction")
+	}
+	return nil
+}
+
+
+// Checks whether the MongoDB deployment supports transactions,
+// i.e. whether it is a replica set or a sharded cluster rather than a standalone server.
+func detectMongoTransactions(ctx context.Context) bool {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	// "isMaster" rather than "hello", which older servers don't understand
+	err := mongoClient.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&hello)
+	if err != nil {
+		slog.Warn("Unable to check whether MongoDB supports transactions, so assuming not.", "err", err)
+		return false
+	}
+	return len(hello.SetName) > 0 || hello.Msg == "isdbgrid"
+}
+
+
+// Runs fn in a single bbolt read-write transaction, which the stores join
+// through the context, so that it is committed only if fn succeeds.
+// Only one read-write transaction can be open at a time, so fn should be quick.
+func boltTransaction(ctx context.Context, db *bolt.DB, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(boltTxKey{}).(*bolt.Tx); ok {
+		// Already in a transaction, which a nested one would deadlock on
+		return fn(ctx)
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		return fn(context.WithValue(ctx, boltTxKey{}, tx))
+	})
+	if err != nil {
+		return boltError(ctx, "boltTransaction", err, "failed when committing transaction")
+	}
+	return nil
+}
+
+
+// Runs fn in a read-write transaction, or in the one the context carries
+// if the operation is part of a larger transaction.
+func boltUpdate(ctx context.Context, db *bolt.DB, fn func(tx *bolt.Tx) error) error {
+	if tx, ok := ctx.Value(boltTxKey{}).(*bolt.Tx); ok {
+		return fn(tx)
+	}
+	return db.Update(fn)
+}
+
+
+// Runs fn in a read-only transaction, or in the one the context carries
+// if the operation is part of a larger transaction, so that it sees its changes.
+func boltView(ctx context.Context, db *bolt.DB, fn func(tx *bolt.Tx) error) error {
+	if tx, ok := ctx.Value(boltTxKey{}).(*bolt.Tx); ok {
+		return fn(tx)
+	}
+	return db.View(fn)
+}
@@ -0,0 +1,114 @@
+// Tests that compound operations on the bbolt stores take effect all together or not at all.
+package main
+
+import (
+	"context"
+	"errors"
+	bolt "go.etcd.io/bbolt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// Opens a bbolt file in a temporary directory with every bucket created,
+// and makes its exercise store the one that the handlers use until the test ends.
+func newTestBoltExerciseStore(t *testing.T) *boltExerciseStore {
+	t.Helper()
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := createBoltBuckets(db); err != nil {
+		t.Fatal(err)
+	}
+	store := &boltExerciseStore{db: db}
+	previous := exerciseStore
+	exerciseStore = store
+	t.Cleanup(func() { exerciseStore = previous })
+	return store
+}
+
+
+func TestBoltWithTransactionRollsBack(t *testing.T) {
+	store := newTestBoltExerciseStore(t)
+	ctx := context.Background()
+	user, err := store.CreateUser(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Errors meant for the client are passed on, as those from the stores are
+	errAbort := newVersionConflict(0)
+	err = store.WithTransaction(ctx, func(ctx context.Context) error {
+		if _, err := store.DeleteUser(ctx, user.ID); err != nil {
+			return err
+		}
+		// The deletion is visible within the transaction
+		if _, err := store.GetUser(ctx, user.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetUser within the transaction: err = %v, want ErrNotFound", err)
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("err = %v, want %v", err, errAbort)
+	}
+	if _, err := store.GetUser(ctx, user.ID); err != nil {
+		t.Errorf("the user was deleted although the transaction was aborted: %v", err)
+	}
+	if existing, err := store.CreateUser(ctx, "alice"); !errors.Is(err, ErrDuplicate) || existing.ID != user.ID {
+		t.Errorf("the username was freed although the transaction was aborted: %v", err)
+	}
+
+	err = store.WithTransaction(ctx, func(ctx context.Context) error {
+		_, err := store.DeleteUser(ctx, user.ID)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetUser(ctx, user.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("after the transaction: err = %v, want ErrNotFound", err)
+	}
+}
+
+
+func TestDeleteExerciseUserIfMatch(t *testing.T) {
+	store := newTestBoltExerciseStore(t)
+	ctx := context.Background()
+	user, err := store.CreateUser(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Moves the user to version 1
+	if _, err := store.RenameUser(ctx, user.ID, "robert", anyVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /exercise/users/{id}", deleteExerciseUser)
+	tests := []struct {
+		name       string
+		ifMatch    string
+		wantStatus int
+	}{
+		{"stale version", `W/"0"`, http.StatusPreconditionFailed},
+		{"not a version", `"abc"`, http.StatusPreconditionFailed},
+		{"current version", `W/"1"`, http.StatusOK},
+		{"already deleted", "", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodDelete, "/exercise/users/"+user.ID, nil)
+			if len(test.ifMatch) > 0 {
+				r.Header.Set("If-Match", test.ifMatch)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			if w.Code != test.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, test.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
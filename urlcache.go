@@ -0,0 +1,343 @@
+// Caches short URL lookups in Redis or in memory so that redirects don't need
+// a database round trip, and writes visit counts back in batches.
+package main
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prefixed to every short URL to make its key in Redis.
+const urlCacheKeyPrefix = "fccgo:url:"
+
+var urlCacheRequestsTotal = newCounterVec("url_cache_requests_total",
+	"Total number of short URL lookups in the cache.", "result")
+
+// Where cached short URLs are kept, which is either Redis or an LRU cache in memory.
+// Get fails with errRedisNil for a key that isn't cached, as Redis does.
+type urlCacheBackend interface {
+	Get(ctx context.Context, key string) (string, error)
+	SetEx(ctx context.Context, key string, value string, ttl time.Duration) error
+	Del(ctx context.Context, keys ...string) error
+	Close()
+}
+
+// A URLStore whose lookups go through a cache.
+// Visits are counted in memory and added to the underlying store periodically,
+// so counts from the last interval are lost if the process is killed.
+type cachedURLStore struct {
+	URLStore
+	backend urlCacheBackend
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	pending map[string]int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+
+// Wraps the store in a Redis cache if REDIS_URL is set, or else in an in-memory
+// LRU cache of URL_CACHE_SIZE short URLs if that is set, and returns it unchanged otherwise.
+// URLs cached in Redis expire after REDIS_CACHE_TTL (default 1h), and visit counts are
+// written back every REDIS_VISIT_FLUSH_INTERVAL (default 10s).
+// URLs cached in memory expire after URL_CACHE_TTL (default 1m), which is kept short as
+// other instances of the server can't remove URLs that they change from this one's cache,
+// and visit counts are written back every URL_CACHE_VISIT_FLUSH_INTERVAL (default 10s).
+func withURLCache(store URLStore) URLStore {
+	redisURL := getEnv("REDIS_URL", "")
+	size := int(getEnvInt("URL_CACHE_SIZE", 0))
+	if len(redisURL) == 0 && size <= 0 {
+		return store
+	}
+
+	cache := &cachedURLStore{
+		URLStore: store,
+		pending:  make(map[string]int64),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	var interval time.Duration
+	if len(redisURL) > 0 {
+		client, err := newRedisClient(redisURL,
+			int(getEnvInt("REDIS_POOL_SIZE", 8)),
+			getEnvDuration("REDIS_TIMEOUT", 500*time.Millisecond))
+		if err != nil {
+			fatal("Invalid REDIS_URL.", "err", err)
+		}
+		if size > 0 {
+			slog.Warn("URL_CACHE_SIZE is ignored, as short URLs are cached in Redis.")
+		}
+		cache.backend = client
+		cache.ttl = getEnvDuration("REDIS_CACHE_TTL", time.Hour)
+		interval = getEnvDuration("REDIS_VISIT_FLUSH_INTERVAL", 10*time.Second)
+		slog.Info("Caching short URLs in Redis.", "addr", client.addr, "ttl", cache.ttl, "flush_interval", interval)
+	} else {
+		cache.backend = newLRUCache(size)
+		cache.ttl = getEnvDuration("URL_CACHE_TTL", time.Minute)
+		interval = getEnvDuration("URL_CACHE_VISIT_FLUSH_INTERVAL", 10*time.Second)
+		slog.Info("Caching short URLs in memory.", "size", size, "ttl", cache.ttl, "flush_interval", interval)
+	}
+	go cache.flushPeriodically(interval)
+	return cache
+}
+
+
+// Returns the URL that the visit goes to, and the status code to redirect with, from the cache,
+// or from the underlying store if it isn't cached yet. If Redis can't be reached, the store is used directly.
+func (c *cachedURLStore) ResolveURL(ctx context.Context, sURL string) (string, int, error) {
+	logger := loggerFrom(ctx)
+	key := urlCacheKeyPrefix + sURL
+
+	cached, err := c.backend.Get(ctx, key)
+	if err == nil {
+		urlCacheRequestsTotal.inc("hit")
+		destination, status := decodeCachedURL(cached)
+		return destination, status, nil
+	}
+	if err != errRedisNil {
+		urlCacheRequestsTotal.inc("error")
+		logger.Warn("Unable to read from Redis, so using the database.", "err", err)
+		return c.URLStore.ResolveURL(ctx, sURL)
+	}
+
+	urlCacheRequestsTotal.inc("miss")
+	stored, err := c.URLStore.LookupURL(ctx, sURL)
+	if err != nil {
+		return "", 0, err
+	}
+	// Disabled short URLs aren't cached, so they are refused until they are enabled again
+	if stored.Disabled != nil {
+		return "", 0, disabledLinkError(stored.Disabled)
+	}
+	// A short URL that expires mustn't outlive its expiry in the cache
+	ttl := c.ttl
+	if !stored.ExpiresAt.IsZero() {
+		ttl = min(ttl, time.Until(stored.ExpiresAt))
+	}
+	destination := withUTMParams(stored.OriginalURL, stored.UTM)
+	if ttl > 0 {
+		if err := c.backend.SetEx(ctx, key, encodeCachedURL(destination, stored.RedirectStatus), ttl); err != nil {
+			logger.Warn("Unable to write to Redis.", "err", err)
+		}
+	}
+	return destination, stored.RedirectStatus, nil
+}
+
+
+// Returns what is cached for a short URL: the status code to redirect with,
+// then a space, then the URL that visits go to.
+func encodeCachedURL(destination string, status int) string {
+	return strconv.Itoa(status) + " " + destination
+}
+
+
+// Splits what is cached for a short URL into the URL that visits go to and the
+// status code to redirect with. Entries cached before short URLs had their own
+// status codes are only URLs, which can't contain spaces, so they get the default.
+func decodeCachedURL(cached string) (string, int) {
+	prefix, destination, ok := strings.Cut(cached, " ")
+	if !ok {
+		return cached, 0
+	}
+	status, err := strconv.Atoi(prefix)
+	if err != nil {
+		return cached, 0
+	}
+	return destination, status
+}
+
+
+// Looks up the short URL in the underlying store, counting the visits
+// that haven't been written back to it yet.
+func (c *cachedURLStore) LookupURL(ctx context.Context, sURL string) (StoredURL, error) {
+	stored, err := c.URLStore.LookupURL(ctx, sURL)
+	if err != nil {
+		return stored, err
+	}
+	c.mu.Lock()
+	stored.TimesVisited += int(c.pending[sURL])
+	c.mu.Unlock()
+	return stored, nil
+}
+
+
+// Deletes the short URL from the store and the cache.
+func (c *cachedURLStore) DeleteURL(ctx context.Context, sURL string) (URLExport, error) {
+	deleted, err := c.URLStore.DeleteURL(ctx, sURL)
+	if err != nil {
+		return deleted, err
+	}
+	c.mu.Lock()
+	// Visits that weren't written back yet still count
+	deleted.TimesVisited += int(c.pending[sURL])
+	delete(c.pending, sURL)
+	c.mu.Unlock()
+	if err := c.backend.Del(ctx, urlCacheKeyPrefix+sURL); err != nil {
+		loggerFrom(ctx).Warn("Unable to delete from Redis, so the URL will be cached until it expires.", "err", err)
+	}
+	return deleted, nil
+}
+
+
+// Sets when the short URL expires, and removes it from the cache
+// so that it is cached again with the new expiry.
+func (c *cachedURLStore) SetURLExpiry(ctx context.Context, sURL string, expiresAt time.Time) error {
+	if err := c.URLStore.SetURLExpiry(ctx, sURL, expiresAt); err != nil {
+		return err
+	}
+	if err := c.backend.Del(ctx, urlCacheKeyPrefix+sURL); err != nil {
+		loggerFrom(ctx).Warn("Unable to delete from Redis, so the URL will be cached until it expires.", "err", err)
+	}
+	return nil
+}
+
+
+// Sets the campaign parameters added to visits to the short URL, and removes it
+// from the cache so that visits get the new parameters straight away.
+func (c *cachedURLStore) SetURLUTM(ctx context.Context, sURL string, utm *UTMParams) error {
+	if err := c.URLStore.SetURLUTM(ctx, sURL, utm); err != nil {
+		return err
+	}
+	if err := c.backend.Del(ctx, urlCacheKeyPrefix+sURL); err != nil {
+		loggerFrom(ctx).Warn("Unable to delete from Redis, so the old parameters will be cached until it expires.", "err", err)
+	}
+	return nil
+}
+
+
+// Disables or enables the short URL, and removes it from the cache
+// so that visits are refused or redirected again straight away.
+func (c *cachedURLStore) SetURLDisabled(ctx context.Context, sURL string, disabled *LinkDisabled) error {
+	if err := c.URLStore.SetURLDisabled(ctx, sURL, disabled); err != nil {
+		return err
+	}
+	if err := c.backend.Del(ctx, urlCacheKeyPrefix+sURL); err != nil {
+		loggerFrom(ctx).Warn("Unable to delete from Redis, so the short URL will be cached until it expires.", "err", err)
+	}
+	return nil
+}
+
+
+// Changes where the short URL leads, and removes it from the cache
+// so that visits go to the new URL straight away.
+func (c *cachedURLStore) UpdateURL(ctx context.Context, sURL string, originalURL string, version int64) (URLExport, error) {
+	updated, err := c.URLStore.UpdateURL(ctx, sURL, originalURL, version)
+	if err != nil {
+		return updated, err
+	}
+	if err := c.backend.Del(ctx, urlCacheKeyPrefix+sURL); err != nil {
+		loggerFrom(ctx).Warn("Unable to delete from Redis, so the old URL will be cached until it expires.", "err", err)
+	}
+	return updated, nil
+}
+
+
+// Prunes short URLs from the store, and removes those that were deleted from the cache.
+func (c *cachedURLStore) PruneURLs(ctx context.Context, cutoff time.Time, dryRun bool) ([]string, error) {
+	pruned, err := c.URLStore.PruneURLs(ctx, cutoff, dryRun)
+	if err != nil || dryRun || len(pruned) == 0 {
+		return pruned, err
+	}
+	keys := make([]string, len(pruned))
+	for i, sURL := range pruned {
+		keys[i] = urlCacheKeyPrefix + sURL
+	}
+	if err := c.backend.Del(ctx, keys...); err != nil {
+		loggerFrom(ctx).Warn("Unable to delete from Redis, so pruned URLs will be cached until they expire.", "err", err)
+	}
+	return pruned, nil
+}
+
+
+// Imports short URLs into the store, and removes any that were replaced from the cache.
+func (c *cachedURLStore) ImportURLs(ctx context.Context, urls []URLExport, replace bool) ([]error, error) {
+	results, err := c.URLStore.ImportURLs(ctx, urls, replace)
+	if err != nil || !replace {
+		return results, err
+	}
+	var keys []string
+	for i, u := range urls {
+		if results[i] == nil {
+			keys = append(keys, urlCacheKeyPrefix+u.ShortURL)
+		}
+	}
+	if len(keys) > 0 {
+		if err := c.backend.Del(ctx, keys...); err != nil {
+			loggerFrom(ctx).Warn("Unable to delete from Redis, so replaced URLs will be cached until they expire.", "err", err)
+		}
+	}
+	return results, nil
+}
+
+
+// Counts the visits in memory until the next flush adds them to the underlying store.
+func (c *cachedURLStore) AddVisits(ctx context.Context, visits map[string]int64) error {
+	c.mu.Lock()
+	for sURL, count := range visits {
+		c.pending[sURL] += count
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+
+// Adds the visits counted since the last flush to the underlying store.
+// If that fails, they are kept for the next attempt.
+func (c *cachedURLStore) flush() {
+	c.mu.Lock()
+	visits := c.pending
+	c.pending = make(map[string]int64)
+	c.mu.Unlock()
+	if len(visits) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbPingTimeout)
+	defer cancel()
+	if err := c.URLStore.AddVisits(ctx, visits); err != nil {
+		c.mu.Lock()
+		for sURL, count := range c.pending {
+			visits[sURL] += count
+		}
+		c.pending = visits
+		c.mu.Unlock()
+		return
+	}
+	slog.Debug("Wrote visit counts back to the database.", "urls", len(visits))
+}
+
+
+func (c *cachedURLStore) flushPeriodically(interval time.Duration) {
+	defer close(c.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.flush()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+
+// Writes back any outstanding visit counts and closes the connections to Redis, if it is used.
+func (c *cachedURLStore) Close() {
+	close(c.stop)
+	<-c.done
+	c.flush()
+	c.mu.Lock()
+	if len(c.pending) > 0 {
+		slog.Error("Unable to write back visit counts.", "counts", maps.Clone(c.pending))
+	}
+	c.mu.Unlock()
+	c.backend.Close()
+}
@@ -0,0 +1,121 @@
+// Optionally checks that a URL works before it is shortened, by sending it
+// a HEAD request, so that the caller can be warned about a link that is already broken.
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+var urlProbesTotal = newCounterVec("url_probes_total",
+	"Total number of reachability probes of URLs submitted for shortening.", "result")
+
+// Probes and webhooks refuse to connect to these, so that they can't be used to reach internal services
+var errPrivateAddress = errors.New("refusing to connect to a private address")
+
+// The client that probes are sent with, or nil if probing is disabled
+var urlProbeClient *http.Client
+
+
+// Enables probing if URL_PROBE is true. Each probe may take URL_PROBE_TIMEOUT (default 5s),
+// including following at most URL_PROBE_MAX_REDIRECTS redirects (default 3).
+// Loopback, private, and link-local addresses are never probed, nor are proxies used,
+// so that the probe can't be aimed at anything that the outside world can't reach.
+func initURLProbe() {
+	if !getEnvBool("URL_PROBE", false) {
+		return
+	}
+	timeout := getEnvDuration("URL_PROBE_TIMEOUT", 5*time.Second)
+	maxRedirects := int(getEnvInt("URL_PROBE_MAX_REDIRECTS", 3))
+	slog.Info("Probing URLs before shortening them.", "timeout", timeout, "max_redirects", maxRedirects)
+
+	dialer := &net.Dialer{Timeout: timeout, Control: refusePrivateAddresses}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	urlProbeClient = &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		// The status of the last redirect is recorded rather than failing the probe
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+}
+
+
+// Fails to connect to any address that isn't publicly routable.
+// It is called with the address that a host name resolved to, so it can't be fooled by DNS.
+func refusePrivateAddresses(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+
+// Probes the new URL if probing is enabled, recording the status it answered with,
+// and returns a warning for the caller if it couldn't be reached or answered with an error.
+// The URL is shortened either way, as it may only be down for a while.
+func probeNewURL(ctx context.Context, newURL *NewURL) string {
+	if urlProbeClient == nil {
+		return ""
+	}
+	logger := loggerFrom(ctx)
+	newURL.ProbedAt = time.Now().UTC()
+
+	status, err := probeURL(ctx, http.MethodHead, newURL.OriginalURL)
+	// Some servers don't answer HEAD requests, so ask them for the page instead
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = probeURL(ctx, http.MethodGet, newURL.OriginalURL)
+	}
+	if err != nil {
+		urlProbesTotal.inc("unreachable")
+		logger.Info("URL probe failed.", "url", newURL.OriginalURL, "err", err)
+		return "the url could not be reached"
+	}
+	newURL.ProbeStatus = status
+	if status >= 400 {
+		urlProbesTotal.inc("error_status")
+		logger.Info("URL probe returned an error.", "url", newURL.OriginalURL, "status", status)
+		return "the url answered with status " + strconv.Itoa(status)
+	}
+	urlProbesTotal.inc("ok")
+	return ""
+}
+
+
+// Sends a single request to the URL and returns the status code of the response.
+// The body isn't read, even of a GET request.
+func probeURL(ctx context.Context, method string, rawURL string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "fcc-go link checker")
+	resp, err := urlProbeClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
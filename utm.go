@@ -0,0 +1,140 @@
+// Tags visits to short URLs with campaign parameters, so that marketers can tell
+// where their traffic came from without changing the URLs that they shortened.
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// The longest value that a campaign parameter can have
+const maxUTMLength = 100
+
+var (
+	errNoUTMParams     = newErrorMessage(http.StatusBadRequest, "at least one of utm_source, utm_medium, and utm_campaign is required")
+	errUTMParamTooLong = newErrorMessage(http.StatusBadRequest, "utm parameters can be at most " + strconv.Itoa(maxUTMLength) + " characters")
+)
+
+// The campaign parameters added to a short URL's original URL when it is visited.
+// Those left empty aren't added.
+type UTMParams struct {
+	Source   string `json:"utm_source,omitempty" xml:"utm_source,omitempty" bson:"source,omitempty"`
+	Medium   string `json:"utm_medium,omitempty" xml:"utm_medium,omitempty" bson:"medium,omitempty"`
+	Campaign string `json:"utm_campaign,omitempty" xml:"utm_campaign,omitempty" bson:"campaign,omitempty"`
+}
+
+// The campaign parameters of a short URL, as sent to its owner.
+type LinkUTMParams struct {
+	ShortURL string `json:"short_url"`
+	UTMParams
+}
+
+
+// Returns the parameters in the order in which they are added to the query string.
+func (utm UTMParams) params() [][2]string {
+	return [][2]string{{"utm_source", utm.Source}, {"utm_medium", utm.Medium}, {"utm_campaign", utm.Campaign}}
+}
+
+
+// Checks that there is at least one parameter and that none of them is too long.
+func (utm UTMParams) validate() error {
+	if utm == (UTMParams{}) {
+		return errNoUTMParams
+	}
+	for _, param := range utm.params() {
+		if len(param[1]) > maxUTMLength {
+			return errUTMParamTooLong
+		}
+	}
+	return nil
+}
+
+
+// Returns the URL that a visit to a short URL goes to, which is its original URL
+// with any campaign parameters added to the end of the query string.
+// Parameters of the same name that the original URL already has are replaced,
+// and the rest of its query string is kept as it is.
+func withUTMParams(originalURL string, utm *UTMParams) string {
+	if utm == nil {
+		return originalURL
+	}
+	urlObject, err := url.Parse(originalURL)
+	if err != nil {
+		return originalURL
+	}
+	var kept []string
+	for _, param := range strings.Split(urlObject.RawQuery, "&") {
+		name, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		replaced := (name == "utm_source" && len(utm.Source) > 0) ||
+			(name == "utm_medium" && len(utm.Medium) > 0) ||
+			(name == "utm_campaign" && len(utm.Campaign) > 0)
+		if len(param) > 0 && !replaced {
+			kept = append(kept, param)
+		}
+	}
+	for _, param := range utm.params() {
+		if len(param[1]) > 0 {
+			kept = append(kept, param[0] + "=" + url.QueryEscape(param[1]))
+		}
+	}
+	urlObject.RawQuery = strings.Join(kept, "&")
+	urlObject.ForceQuery = false
+	return urlObject.String()
+}
+
+
+// Adds the "utm_source", "utm_medium", and "utm_campaign" in the form data to every
+// visit to the short URL in the path, replacing any that it had before.
+// Only the short URL's owner or an admin may set them.
+func putShortURLUTM(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFrom(r.Context())
+	funcName := "putShortURLUTM"
+
+	if err := r.ParseForm(); err != nil {
+		logger.Error("Request.ParseForm failed", "func", funcName, "err", err)
+		writeError(w, r, formError(err))
+		return
+	}
+	utm := UTMParams{
+		Source:   strings.TrimSpace(r.Form.Get("utm_source")),
+		Medium:   strings.TrimSpace(r.Form.Get("utm_medium")),
+		Campaign: strings.TrimSpace(r.Form.Get("utm_campaign")),
+	}
+	if err := utm.validate(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	stored, err := authorizeShortURL(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := urlStore.SetURLUTM(r.Context(), stored.ShortURL, &utm); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	logger.Info("UTM parameters set.", "short_url", stored.ShortURL, "utm", utm)
+	writeJSON(w, http.StatusOK, LinkUTMParams{ShortURL: stored.ShortURL, UTMParams: utm})
+}
+
+
+// Stops adding campaign parameters to visits to the short URL in the path.
+// Only the short URL's owner or an admin may.
+func deleteShortURLUTM(w http.ResponseWriter, r *http.Request) {
+	stored, err := authorizeShortURL(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := urlStore.SetURLUTM(r.Context(), stored.ShortURL, nil); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
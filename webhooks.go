@@ -0,0 +1,497 @@
+// Reports visits to short URLs to webhooks, so that their owners can feed
+// click data into their own systems as it happens rather than polling for stats.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"maps"
+	randv2 "math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var webhookDeliveriesTotal = newCounterVec("webhook_deliveries_total",
+	"Total number of webhook notifications by what became of them.", "result")
+
+var (
+	errInvalidWebhookURL   = newErrorMessage(http.StatusBadRequest, "webhook url must be an absolute http or https url")
+	errInvalidWebhookEvery = newErrorMessage(http.StatusBadRequest, "every must be from 1 to " + strconv.Itoa(maxWebhookEvery))
+	errNoWebhook           = newErrorMessage(http.StatusNotFound, "short url has no webhook")
+)
+
+// The most visits that one notification can report, which bounds
+// how many are held in memory for each webhook
+const maxWebhookEvery = 1000
+
+// The longest that a retry is put off for, however many attempts have failed
+const maxWebhookBackoff = time.Minute
+
+// How long notifications that are still queued at shutdown are given to be sent
+const webhookShutdownTimeout = 10 * time.Second
+
+// Where visits are reported, and how often.
+type Webhook struct {
+	URL    string `json:"url" bson:"url"`
+	// The key that each notification is signed with, which is only sent back when the webhook is set
+	Secret string `json:"secret,omitempty" bson:"secret"`
+	// How many visits each notification reports, so 1 reports every visit as it happens
+	Every  int    `json:"every" bson:"every"`
+}
+
+// The webhook of a short URL, as sent to its owner.
+type LinkWebhook struct {
+	ShortURL string `json:"short_url"`
+	Webhook
+}
+
+// A visit as reported to a webhook. The visitor's IP address is never sent.
+type WebhookVisit struct {
+	ShortURL  string    `json:"short_url"`
+	Time      time.Time `json:"time"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	// Known only if there is a GeoIP database
+	Country   string    `json:"country,omitempty"`
+	City      string    `json:"city,omitempty"`
+}
+
+// The body of each notification, which is POSTed as JSON.
+type WebhookNotification struct {
+	Event  string         `json:"event"`
+	Visits []WebhookVisit `json:"visits"`
+}
+
+// A notification waiting to be sent.
+type webhookDelivery struct {
+	// Sent as X-Webhook-ID, which stays the same when the notification is retried
+	id      string
+	webhook Webhook
+	client  *http.Client
+	body    []byte
+}
+
+// Collects visits until each webhook is due a notification,
+// and sends the notifications from a pool of workers.
+type webhookNotifier struct {
+	// Reports the visits to every short URL, or nil if WEBHOOK_URL isn't set
+	global *Webhook
+	// The webhook of each short URL that has one. Instances of the server only
+	// learn of each other's changes when they reload them, so the map is replaced whole.
+	links atomic.Pointer[map[string]Webhook]
+
+	// Webhooks of short URLs are set by clients, so their client can't reach private addresses
+	linkClient   *http.Client
+	globalClient *http.Client
+	maxAttempts  int
+	backoff      time.Duration
+
+	mu sync.Mutex
+	// The visits that haven't been reported yet, by short URL, or "" for the global webhook
+	pending map[string][]WebhookVisit
+
+	queue   chan webhookDelivery
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// The notifier, or nil if webhooks are disabled
+var webhooks *webhookNotifier
+
+
+// Starts reporting visits to webhooks unless WEBHOOKS is false.
+// Visits to every short URL are also reported to WEBHOOK_URL, if set, signed with
+// WEBHOOK_SECRET, every WEBHOOK_EVERY visits (default 1).
+// The webhooks of short URLs are reloaded every WEBHOOK_REFRESH_INTERVAL (default 1m).
+// Each notification is sent by one of WEBHOOK_WORKERS workers (default 4), waiting at most
+// WEBHOOK_TIMEOUT (default 5s) for an answer, and is tried up to WEBHOOK_MAX_ATTEMPTS
+// times (default 5) with exponential backoff from WEBHOOK_RETRY_BACKOFF (default 1s).
+func initWebhooks() {
+	if !getEnvBool("WEBHOOKS", true) {
+		slog.Info("Webhooks are disabled.")
+		return
+	}
+	timeout := getEnvDuration("WEBHOOK_TIMEOUT", 5*time.Second)
+	workers := int(getEnvInt("WEBHOOK_WORKERS", 4))
+	if workers < 1 {
+		fatal("WEBHOOK_WORKERS must be at least 1.", "value", workers)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	notifier := &webhookNotifier{
+		maxAttempts: max(int(getEnvInt("WEBHOOK_MAX_ATTEMPTS", 5)), 1),
+		backoff:     max(getEnvDuration("WEBHOOK_RETRY_BACKOFF", time.Second), time.Millisecond),
+		pending:     make(map[string][]WebhookVisit),
+		queue:       make(chan webhookDelivery, 1000),
+		ctx:         ctx,
+		cancel:      cancel,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	notifier.links.Store(&map[string]Webhook{})
+
+	// Redirects are reported as they are, not followed
+	checkRedirect := func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	notifier.globalClient = &http.Client{Timeout: timeout, CheckRedirect: checkRedirect}
+	dialer := &net.Dialer{Timeout: timeout, Control: refusePrivateAddresses}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	notifier.linkClient = &http.Client{Transport: transport, Timeout: timeout, CheckRedirect: checkRedirect}
+
+	if globalURL := getEnv("WEBHOOK_URL", ""); len(globalURL) > 0 {
+		if !validWebhookURL(globalURL) {
+			fatal("WEBHOOK_URL must be an absolute http or https URL.", "url", globalURL)
+		}
+		secret := getEnv("WEBHOOK_SECRET", "")
+		if len(secret) == 0 {
+			fatal("WEBHOOK_SECRET must be set along with WEBHOOK_URL.")
+		}
+		every := int(getEnvInt("WEBHOOK_EVERY", 1))
+		if every < 1 || every > maxWebhookEvery {
+			fatal("WEBHOOK_EVERY must be from 1 to " + strconv.Itoa(maxWebhookEvery) + ".", "value", every)
+		}
+		notifier.global = &Webhook{URL: globalURL, Secret: secret, Every: every}
+		slog.Info("Reporting visits to every short URL to a webhook.", "url", globalURL, "every", every)
+	}
+
+	for range workers {
+		notifier.workers.Add(1)
+		go notifier.deliverQueued()
+	}
+	go notifier.reloadPeriodically(getEnvDuration("WEBHOOK_REFRESH_INTERVAL", time.Minute))
+	webhooks = notifier
+}
+
+
+// Reports whether the URL can be sent notifications.
+func validWebhookURL(rawURL string) bool {
+	urlObject, err := url.Parse(rawURL)
+	return err == nil && (urlObject.Scheme == "http" || urlObject.Scheme == "https") && len(urlObject.Host) > 0
+}
+
+
+// Counts a visit to the short URL towards its webhook and the global webhook,
+// queueing a notification for each that it makes due.
+func notifyWebhooks(r *http.Request, shortURL string) {
+	if webhooks == nil {
+		return
+	}
+	link, hasLink := (*webhooks.links.Load())[shortURL]
+	if !hasLink && webhooks.global == nil {
+		return
+	}
+	country, city := lookupGeoIP(clientIP(r))
+	visit := WebhookVisit{
+		ShortURL:  shortURL,
+		Time:      time.Now().UTC(),
+		Referrer:  truncate(r.Referer(), maxClickHeaderLength),
+		UserAgent: truncate(r.UserAgent(), maxClickHeaderLength),
+		Country:   country,
+		City:      city,
+	}
+	if hasLink {
+		webhooks.add(shortURL, link, webhooks.linkClient, visit)
+	}
+	if webhooks.global != nil {
+		webhooks.add("", *webhooks.global, webhooks.globalClient, visit)
+	}
+}
+
+
+// Holds the visit for the webhook with the given key until it has Every of them.
+func (n *webhookNotifier) add(key string, webhook Webhook, client *http.Client, visit WebhookVisit) {
+	n.mu.Lock()
+	visits := append(n.pending[key], visit)
+	if len(visits) < webhook.Every {
+		n.pending[key] = visits
+		n.mu.Unlock()
+		return
+	}
+	delete(n.pending, key)
+	n.mu.Unlock()
+	n.enqueue(webhook, client, visits)
+}
+
+
+// Queues a notification of the visits, dropping it if the queue is full,
+// as it only would be if the webhooks couldn't keep up.
+func (n *webhookNotifier) enqueue(webhook Webhook, client *http.Client, visits []WebhookVisit) {
+	body, err := json.Marshal(WebhookNotification{Event: "visits", Visits: visits})
+	if err != nil {
+		slog.Error("json.Marshal failed", "func", "enqueue", "err", err)
+		return
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	select {
+	case n.queue <- webhookDelivery{id: hex.EncodeToString(id), webhook: webhook, client: client, body: body}:
+	default:
+		webhookDeliveriesTotal.inc("dropped")
+		slog.Warn("Webhook queue is full, so dropping a notification.", "url", webhook.URL, "visits", len(visits))
+	}
+}
+
+
+func (n *webhookNotifier) deliverQueued() {
+	defer n.workers.Done()
+	for delivery := range n.queue {
+		n.deliver(delivery)
+	}
+}
+
+
+// Sends the notification, retrying with exponential backoff and full jitter
+// while the webhook can't be reached or answers with a 429 or a 5xx.
+// Any other answer outside 2xx, or a private address, means that retrying wouldn't help.
+func (n *webhookNotifier) deliver(d webhookDelivery) {
+	backoff := n.backoff
+	for attempt := 1; ; attempt++ {
+		status, err := n.send(d)
+		if err == nil && status >= 200 && status < 300 {
+			webhookDeliveriesTotal.inc("delivered")
+			return
+		}
+		retryable := (err != nil && !errors.Is(err, errPrivateAddress)) ||
+			status == http.StatusTooManyRequests || status >= 500
+		if !retryable || attempt >= n.maxAttempts {
+			webhookDeliveriesTotal.inc("failed")
+			slog.Warn("Unable to deliver webhook notification.", "url", d.webhook.URL, "id", d.id,
+				"attempts", attempt, "status", status, "err", err)
+			return
+		}
+		webhookDeliveriesTotal.inc("retried")
+		select {
+		case <-time.After(randv2.N(backoff) + 1):
+		case <-n.ctx.Done():
+			webhookDeliveriesTotal.inc("failed")
+			return
+		}
+		backoff = min(backoff*2, maxWebhookBackoff)
+	}
+}
+
+
+// POSTs the notification once and returns the status code it was answered with.
+// The body is signed with HMAC-SHA256 together with the time, so that the receiver can
+// check that it came from here and refuse old notifications being replayed:
+// X-Webhook-Signature is "sha256=" followed by the hex of the HMAC of
+// the X-Webhook-Timestamp header, a ".", and the body.
+func (n *webhookNotifier) send(d webhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(n.ctx, http.MethodPost, d.webhook.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(d.webhook.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(d.body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "fcc-go webhooks")
+	req.Header.Set("X-Webhook-ID", d.id)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256=" + hex.EncodeToString(mac.Sum(nil)))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+
+// Reloads the webhooks of short URLs every interval, so that changes made through
+// other instances of the server are picked up. Until they are first loaded,
+// they are tried again every second.
+func (n *webhookNotifier) reloadPeriodically(interval time.Duration) {
+	defer close(n.done)
+	loaded := false
+	for {
+		if storageAvailable() {
+			ctx, cancel := context.WithTimeout(context.Background(), dbPingTimeout)
+			links, err := urlStore.GetURLWebhooks(ctx)
+			cancel()
+			if err == nil {
+				n.links.Store(&links)
+				loaded = true
+			}
+		}
+		wait := unhealthyCheckInterval
+		if loaded {
+			if interval <= 0 {
+				return
+			}
+			wait = interval
+		}
+		select {
+		case <-time.After(wait):
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+
+// Records a change to the webhook of a short URL straight away,
+// rather than when the webhooks are next reloaded. A nil webhook removes it.
+func (n *webhookNotifier) setLink(shortURL string, webhook *Webhook) {
+	links := maps.Clone(*n.links.Load())
+	if webhook == nil {
+		delete(links, shortURL)
+	} else {
+		links[shortURL] = *webhook
+	}
+	n.links.Store(&links)
+	n.mu.Lock()
+	delete(n.pending, shortURL)
+	n.mu.Unlock()
+}
+
+
+// Forgets the webhook of a short URL that was deleted, so that
+// a new short URL with the same code isn't reported to it.
+func forgetLinkWebhook(shortURL string) {
+	if webhooks != nil {
+		webhooks.setLink(shortURL, nil)
+	}
+}
+
+
+// Sends the visits that haven't been reported yet, and gives the queued notifications
+// a while to be delivered before giving up on them.
+func closeWebhooks() {
+	if webhooks == nil {
+		return
+	}
+	defer webhooks.cancel()
+	close(webhooks.stop)
+	<-webhooks.done
+
+	links := *webhooks.links.Load()
+	webhooks.mu.Lock()
+	pending := webhooks.pending
+	webhooks.pending = make(map[string][]WebhookVisit)
+	webhooks.mu.Unlock()
+	for key, visits := range pending {
+		if len(key) == 0 && webhooks.global != nil {
+			webhooks.enqueue(*webhooks.global, webhooks.globalClient, visits)
+		} else if link, ok := links[key]; ok {
+			webhooks.enqueue(link, webhooks.linkClient, visits)
+		}
+	}
+	close(webhooks.queue)
+
+	finished := make(chan struct{})
+	go func() {
+		webhooks.workers.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(webhookShutdownTimeout):
+		slog.Warn("Gave up on webhook notifications that were still being sent.")
+		webhooks.cancel()
+		<-finished
+	}
+}
+
+
+// Sends the webhook of the short URL in the path, without its secret.
+// Only the short URL's owner or an admin may see it.
+func getShortURLWebhook(w http.ResponseWriter, r *http.Request) {
+	stored, err := authorizeShortURL(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if stored.Webhook == nil {
+		writeError(w, r, errNoWebhook)
+		return
+	}
+	webhook := *stored.Webhook
+	webhook.Secret = ""
+	writeJSON(w, http.StatusOK, LinkWebhook{ShortURL: stored.ShortURL, Webhook: webhook})
+}
+
+
+// Reports visits to the short URL in the path to the "url" in the form data,
+// every "every" visits (default 1). A new secret is made each time, and is only
+// sent back in this response, for the receiver to check signatures with.
+// Only the short URL's owner or an admin may set it.
+func putShortURLWebhook(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFrom(r.Context())
+	funcName := "putShortURLWebhook"
+
+	if err := r.ParseForm(); err != nil {
+		logger.Error("Request.ParseForm failed", "func", funcName, "err", err)
+		writeError(w, r, formError(err))
+		return
+	}
+	webhookURL := r.Form.Get("url")
+	if !validWebhookURL(webhookURL) {
+		writeError(w, r, errInvalidWebhookURL)
+		return
+	}
+	every := 1
+	if param := r.Form.Get("every"); len(param) > 0 {
+		n, err := strconv.Atoi(param)
+		if err != nil || n < 1 || n > maxWebhookEvery {
+			writeError(w, r, errInvalidWebhookEvery)
+			return
+		}
+		every = n
+	}
+
+	stored, err := authorizeShortURL(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	webhook := &Webhook{URL: webhookURL, Secret: hex.EncodeToString(secret), Every: every}
+	if err := urlStore.SetURLWebhook(r.Context(), stored.ShortURL, webhook); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	webhooks.setLink(stored.ShortURL, webhook)
+	logger.Info("Webhook set.", "short_url", stored.ShortURL, "url", webhookURL, "every", every)
+	writeJSON(w, http.StatusOK, LinkWebhook{ShortURL: stored.ShortURL, Webhook: *webhook})
+}
+
+
+// Stops reporting visits to the short URL in the path.
+// Only the short URL's owner or an admin may.
+func deleteShortURLWebhook(w http.ResponseWriter, r *http.Request) {
+	stored, err := authorizeShortURL(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if stored.Webhook == nil {
+		writeError(w, r, errNoWebhook)
+		return
+	}
+	if err := urlStore.SetURLWebhook(r.Context(), stored.ShortURL, nil); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	webhooks.setLink(stored.ShortURL, nil)
+	w.WriteHeader(http.StatusNoContent)
+}